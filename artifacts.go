@@ -0,0 +1,169 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/appctx"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+)
+
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+type ArtifactSettings struct {
+	Retention time.Duration `cfg:"retention" default:"72h"`
+	MaxSize   int64         `cfg:"max_size" default:"67108864"`
+}
+
+// Artifact is a file collected from a test container, e.g. the database dump of a pre delete hook.
+type Artifact struct {
+	Id            string    `json:"id"`
+	PoolId        string    `json:"pool_id"`
+	TestId        string    `json:"test_id"`
+	ClaimId       string    `json:"claim_id"`
+	ComponentType string    `json:"component_type"`
+	Name          string    `json:"name"`
+	Size          int       `json:"size"`
+	Content       []byte    `json:"content,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ArtifactBundle is a tar.gz archive of all artifacts of a test.
+type ArtifactBundle struct {
+	TestId    string      `json:"test_id"`
+	Name      string      `json:"name"`
+	Artifacts []*Artifact `json:"artifacts"`
+	Content   []byte      `json:"content"`
+}
+
+// ArtifactStore keeps the artifacts of the tests for the retention period.
+type ArtifactStore struct {
+	lck       sync.RWMutex
+	clock     clock.Clock
+	settings  *ArtifactSettings
+	artifacts []*Artifact
+}
+
+type artifactStoreKey struct{}
+
+func ProvideArtifactStore(ctx context.Context, config cfg.Config) (*ArtifactStore, error) {
+	return appctx.Provide(ctx, artifactStoreKey{}, func() (*ArtifactStore, error) {
+		settings := &ArtifactSettings{}
+		if err := config.UnmarshalKey("artifacts", settings); err != nil {
+			return nil, fmt.Errorf("could not unmarshal artifact settings: %w", err)
+		}
+
+		return &ArtifactStore{
+			clock:     clock.NewRealClock(),
+			settings:  settings,
+			artifacts: make([]*Artifact, 0),
+		}, nil
+	})
+}
+
+// MaxSize is the maximum number of bytes kept per artifact.
+func (s *ArtifactStore) MaxSize() int64 {
+	return s.settings.MaxSize
+}
+
+func (s *ArtifactStore) Put(artifact *Artifact) {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	// artifacts are appended in order, so everything before the first artifact within the retention is outdated
+	cutoff := s.clock.Now().Add(-s.settings.Retention)
+	for len(s.artifacts) > 0 && s.artifacts[0].CreatedAt.Before(cutoff) {
+		s.artifacts = s.artifacts[1:]
+	}
+
+	artifact.Size = len(artifact.Content)
+	artifact.CreatedAt = s.clock.Now()
+	s.artifacts = append(s.artifacts, artifact)
+}
+
+func (s *ArtifactStore) Get(id string) (*Artifact, error) {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+
+	for _, artifact := range s.artifacts {
+		if artifact.Id == id {
+			return artifact, nil
+		}
+	}
+
+	return nil, fmt.Errorf("there is no artifact with id %q: %w", id, ErrArtifactNotFound)
+}
+
+// List returns the artifacts of the test without their content.
+func (s *ArtifactStore) List(testId string) []*Artifact {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+
+	result := make([]*Artifact, 0)
+	for _, artifact := range s.artifacts {
+		if artifact.TestId != K8sNameString(testId) {
+			continue
+		}
+
+		listed := *artifact
+		listed.Content = nil
+		result = append(result, &listed)
+	}
+
+	return result
+}
+
+// Bundle archives all artifacts of the test, every artifact is stored below the directory of its claim.
+func (s *ArtifactStore) Bundle(testId string) (*ArtifactBundle, error) {
+	var err error
+	var artifact *Artifact
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+
+	listed := s.List(testId)
+	for _, item := range listed {
+		if artifact, err = s.Get(item.Id); err != nil {
+			// the artifact was pruned in the meantime
+			continue
+		}
+
+		header := &tar.Header{
+			Name:    fmt.Sprintf("%s-%s/%s", artifact.ComponentType, artifact.ClaimId, artifact.Name),
+			Mode:    0o644,
+			Size:    int64(len(artifact.Content)),
+			ModTime: artifact.CreatedAt,
+		}
+
+		if err = tw.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("could not write header of artifact %q: %w", artifact.Name, err)
+		}
+
+		if _, err = tw.Write(artifact.Content); err != nil {
+			return nil, fmt.Errorf("could not write artifact %q: %w", artifact.Name, err)
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return nil, fmt.Errorf("could not close tar writer: %w", err)
+	}
+
+	if err = gz.Close(); err != nil {
+		return nil, fmt.Errorf("could not close gzip writer: %w", err)
+	}
+
+	return &ArtifactBundle{
+		TestId:    K8sNameString(testId),
+		Name:      fmt.Sprintf("%s-artifacts.tar.gz", K8sNameString(testId)),
+		Artifacts: listed,
+		Content:   buf.Bytes(),
+	}, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// AutoTouchSettings extend a claim on every access, like a status poll, a heartbeat, a detail fetch or an
+// attached debug container, so it expires no earlier than the window after its last use.
+type AutoTouchSettings struct {
+	Enabled bool          `cfg:"enabled" default:"false"`
+	Window  time.Duration `cfg:"window" default:"15m"`
+}
+
+type HeartbeatOutput struct {
+	ClaimId     string `json:"claim_id"`
+	ExpireAfter string `json:"expire_after"`
+}
+
+type AutoToucher struct {
+	clock     clock.Clock
+	k8sClient *K8sClient
+	retention *RetentionPolicies
+	settings  *AutoTouchSettings
+	readOnly  *ReadOnlySettings
+}
+
+func NewAutoToucher(config cfg.Config, k8sClient *K8sClient) (*AutoToucher, error) {
+	var err error
+	var retention *RetentionPolicies
+	var readOnly *ReadOnlySettings
+
+	settings := &AutoTouchSettings{}
+	if err = config.UnmarshalKey("auto_touch", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal auto touch settings: %w", err)
+	}
+
+	if retention, err = NewRetentionPolicies(config); err != nil {
+		return nil, fmt.Errorf("could not create retention policies: %w", err)
+	}
+
+	if readOnly, err = ReadReadOnlySettings(config); err != nil {
+		return nil, err
+	}
+
+	return &AutoToucher{
+		clock:     clock.NewRealClock(),
+		k8sClient: k8sClient,
+		retention: retention,
+		settings:  settings,
+		readOnly:  readOnly,
+	}, nil
+}
+
+// Touch moves the expiry of the claimed deployment and its service to the end of the window, capped by the max
+// lifetime of the retention policy. An expiry which is already later isn't shortened, and a read-only instance
+// doesn't touch at all. The extension is passed to check first, an error of check leaves the expiry as it is. It
+// returns the expiry of the deployment afterward and the extension applied.
+func (t *AutoToucher) Touch(ctx context.Context, deployment *appsv1.Deployment, check func(extension time.Duration) error) (string, time.Duration, error) {
+	var err error
+	var current time.Time
+	var service *apiv1.Service
+
+	expireAfter := deployment.GetAnnotations()[AnnotationExpireAfter]
+	if !t.settings.Enabled || t.readOnly.Enabled || !isClaimed(deployment) || isSoftDeleted(deployment) {
+		return expireAfter, 0, nil
+	}
+
+	if current, err = time.Parse(time.RFC3339, expireAfter); err != nil {
+		return "", 0, fmt.Errorf("could not parse annotation expire after: %w", err)
+	}
+
+	policy := t.retention.For(deployment.GetAnnotations()[AnnotationComponentType])
+	touched := policy.ExpireAt(deployment.GetCreationTimestamp().Time, t.clock.Now(), t.settings.Window)
+
+	if !touched.After(current) {
+		return expireAfter, 0, nil
+	}
+
+	extension := touched.Sub(current)
+	if err = check(extension); err != nil {
+		return expireAfter, 0, err
+	}
+
+	expireAfter = touched.Format(time.RFC3339)
+	ops := []string{
+		PatchOp("replace", "annotations", AnnotationExpireAfter, expireAfter),
+	}
+
+	if _, err = t.k8sClient.PatchDeployment(ctx, deployment, ops); err != nil {
+		return "", 0, fmt.Errorf("could not patch deployment: %w", err)
+	}
+
+	if service, err = t.k8sClient.GetService(ctx, deployment.GetName()); err != nil {
+		return "", extension, fmt.Errorf("could not get service: %w", err)
+	}
+
+	if _, err = t.k8sClient.PatchService(ctx, service, ops); err != nil {
+		return "", extension, fmt.Errorf("could not patch service: %w", err)
+	}
+
+	return expireAfter, extension, nil
+}
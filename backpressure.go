@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gosoline-project/httpserver"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const minRetryAfter = time.Second
+
+// retryAfter estimates when a claim refused for missing capacity has a chance to succeed: once the next
+// deployment expired and a new one had the time to become ready. Without any expiring deployment the
+// configured retry after is used.
+func (c *CapacityChecker) retryAfter(ctx context.Context) time.Duration {
+	var err error
+	var deployments []*appsv1.Deployment
+	var pods []*apiv1.Pod
+
+	if deployments, err = c.k8sClient.ListDeployments(ctx); err != nil {
+		c.logger.Warn(ctx, "could not list deployments to compute the retry after: %s", err)
+
+		return c.settings.RetryAfter
+	}
+
+	expiresIn, ok := nextExpiry(c.clock.Now(), deployments)
+	if !ok {
+		return c.settings.RetryAfter
+	}
+
+	if pods, err = c.k8sClient.ListPods(ctx); err != nil {
+		c.logger.Warn(ctx, "could not list pods to compute the retry after: %s", err)
+
+		return clampRetryAfter(expiresIn, c.settings.MaxRetryAfter)
+	}
+
+	return clampRetryAfter(expiresIn+spawnLatency(pods), c.settings.MaxRetryAfter)
+}
+
+// nextExpiry returns the time until the first of the deployments expires. Deployments which expired already
+// are reaped by the next expiry run, which is not awaited here.
+func nextExpiry(now time.Time, deployments []*appsv1.Deployment) (time.Duration, bool) {
+	next := time.Duration(0)
+	found := false
+
+	for _, deployment := range deployments {
+		expireAfter, err := time.Parse(time.RFC3339, deployment.GetAnnotations()[AnnotationExpireAfter])
+		if err != nil {
+			continue
+		}
+
+		if in := expireAfter.Sub(now); !found || in < next {
+			next = max(in, 0)
+			found = true
+		}
+	}
+
+	return next, found
+}
+
+// spawnLatency returns the average time the ready pods needed from their creation until they became ready.
+func spawnLatency(pods []*apiv1.Pod) time.Duration {
+	total := time.Duration(0)
+	count := 0
+
+	for _, pod := range pods {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type != apiv1.PodReady || condition.Status != apiv1.ConditionTrue {
+				continue
+			}
+
+			total += condition.LastTransitionTime.Sub(pod.GetCreationTimestamp().Time)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	return total / time.Duration(count)
+}
+
+func clampRetryAfter(retryAfter time.Duration, maxRetryAfter time.Duration) time.Duration {
+	if maxRetryAfter > 0 && retryAfter > maxRetryAfter {
+		return maxRetryAfter
+	}
+
+	return max(retryAfter, minRetryAfter)
+}
+
+// newTooManyRequestsResponse tells clients to back off with a 429 and the seconds to wait in the retry after
+// header, so they don't retry right away while the cluster or the test is saturated.
+func newTooManyRequestsResponse(err error, retryAfter time.Duration) httpserver.Response {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	body := map[string]any{
+		"err":         err.Error(),
+		"retry_after": seconds,
+	}
+
+	return httpserver.NewJsonResponse(
+		body,
+		httpserver.WithStatusCode(http.StatusTooManyRequests),
+		httpserver.WithHeader("Retry-After", strconv.Itoa(seconds)),
+	)
+}
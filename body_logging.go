@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+const redacted = "[redacted]"
+
+// BodyLoggingSettings enable the logging of the request and response bodies of the handlers in the channels,
+// e.g. "services" or "pool", to diagnose malformed payloads of clients. The values of all fields whose name
+// contains one of the redacted fields are replaced, including the env variables of a spec.
+type BodyLoggingSettings struct {
+	Channels       []string `cfg:"channels"`
+	RedactedFields []string `cfg:"redacted_fields" default:"password,secret,token,credential,pool_key"`
+	MaxBytes       int      `cfg:"max_bytes" default:"16384"`
+}
+
+func ReadBodyLoggingSettings(config cfg.Config) (*BodyLoggingSettings, error) {
+	settings := &BodyLoggingSettings{}
+	if err := config.UnmarshalKey("body_logging", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal body logging settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// logBodies returns a middleware per channel, which logs the bodies of the requests if the channel is enabled.
+func logBodies(logger log.Logger, settings *BodyLoggingSettings) func(channel string) gin.HandlerFunc {
+	return func(channel string) gin.HandlerFunc {
+		if !slices.Contains(settings.Channels, channel) {
+			return func(ginCtx *gin.Context) {
+				ginCtx.Next()
+			}
+		}
+
+		channelLogger := logger.WithChannel(fmt.Sprintf("bodies-%s", channel))
+
+		return func(ginCtx *gin.Context) {
+			var err error
+			var request []byte
+
+			if ginCtx.Request.Body != nil {
+				if request, err = io.ReadAll(ginCtx.Request.Body); err != nil {
+					channelLogger.Warn(ginCtx.Request.Context(), "could not read the request body of %s %s: %s", ginCtx.Request.Method, ginCtx.Request.URL.Path, err.Error())
+				}
+
+				ginCtx.Request.Body = io.NopCloser(bytes.NewReader(request))
+			}
+
+			writer := &recordingWriter{ResponseWriter: ginCtx.Writer}
+			ginCtx.Writer = writer
+			ginCtx.Next()
+
+			channelLogger.Info(ginCtx.Request.Context(), "%s %s: request %s, response %d %s",
+				ginCtx.Request.Method,
+				ginCtx.Request.URL.RequestURI(),
+				redactBody(request, ginCtx.ContentType(), settings),
+				writer.Status(),
+				redactBody(writer.body.Bytes(), writer.Header().Get("Content-Type"), settings),
+			)
+		}
+	}
+}
+
+// redactBody renders a json body with the redacted fields replaced. Other bodies can't be redacted and are
+// only described by their size.
+func redactBody(body []byte, contentType string, settings *BodyLoggingSettings) string {
+	if len(body) == 0 {
+		return "<empty>"
+	}
+
+	var decoded any
+	if !strings.Contains(contentType, "json") || json.Unmarshal(body, &decoded) != nil {
+		return fmt.Sprintf("<%d bytes of %q>", len(body), contentType)
+	}
+
+	encoded, err := json.Marshal(redactValue(decoded, settings.RedactedFields))
+	if err != nil {
+		return fmt.Sprintf("<%d bytes of %q>", len(body), contentType)
+	}
+
+	if len(encoded) > settings.MaxBytes {
+		return fmt.Sprintf("%s... (%d bytes truncated)", encoded[:settings.MaxBytes], len(encoded)-settings.MaxBytes)
+	}
+
+	return string(encoded)
+}
+
+func redactValue(value any, fields []string) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, nested := range typed {
+			if redactedField(key, fields) {
+				typed[key] = redacted
+
+				continue
+			}
+
+			typed[key] = redactValue(nested, fields)
+		}
+	case []any:
+		for i, nested := range typed {
+			typed[i] = redactValue(nested, fields)
+		}
+	}
+
+	return value
+}
+
+// redactedField matches names like "password", "MYSQL_ROOT_PASSWORD" or "minioSecretKey".
+func redactedField(name string, fields []string) bool {
+	normalized := strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+
+	for _, field := range fields {
+		field = strings.ToLower(field)
+
+		if strings.Contains(normalized, field) || strings.Contains(strings.ReplaceAll(normalized, "_", ""), strings.ReplaceAll(field, "_", "")) {
+			return true
+		}
+	}
+
+	return false
+}
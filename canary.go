@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+const (
+	canaryPoolId        = "kubrun-canary"
+	canaryComponentType = "canary"
+
+	metricCanarySucceeded = "CanarySucceeded"
+	metricCanaryDuration  = "CanaryDuration"
+)
+
+// CanarySelfTestSettings configure the canary. The timeout has to stay below the health check timeout of the
+// kernel, which otherwise gives up on the module before the canary does.
+type CanarySelfTestSettings struct {
+	Enabled    bool          `cfg:"enabled" default:"false"`
+	Repository string        `cfg:"repository" default:"busybox"`
+	Tag        string        `cfg:"tag" default:"1.36"`
+	Timeout    time.Duration `cfg:"timeout" default:"45s"`
+}
+
+// CanaryModule spawns a tiny deployment and service on boot, waits for it to become ready and removes it
+// again. It proves that the rbac rules and the scheduling path work before the first claim arrives: the
+// module reports unhealthy until the canary passed, so a failed canary fails the startup.
+type CanaryModule struct {
+	kernel.BackgroundModule
+
+	logger      log.Logger
+	clock       clock.Clock
+	metric      metric.Writer
+	poolManager *ServicePoolManager
+	settings    *CanarySelfTestSettings
+	// skipped is set in read-only and shadow mode, in which nothing is written to the cluster
+	skipped bool
+
+	lck    sync.RWMutex
+	passed bool
+	err    error
+}
+
+func NewCanaryModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var poolManager *ServicePoolManager
+	var readOnly *ReadOnlySettings
+	var kubeSettings *KubeSettings
+
+	settings := &CanarySelfTestSettings{}
+	if err = config.UnmarshalKey("canary_self_test", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal canary self test settings: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	if readOnly, err = ReadReadOnlySettings(config); err != nil {
+		return nil, err
+	}
+
+	if kubeSettings, err = ReadSettings(config); err != nil {
+		return nil, fmt.Errorf("could not read kube settings: %w", err)
+	}
+
+	return &CanaryModule{
+		logger:      logger.WithChannel("canary"),
+		clock:       clock.NewRealClock(),
+		metric:      metric.NewWriter(),
+		poolManager: poolManager,
+		settings:    settings,
+		skipped:     readOnly.Enabled || kubeSettings.Shadow,
+	}, nil
+}
+
+// IsHealthy reports an instance in read-only or shadow mode as healthy, it never spawns the canary.
+func (m *CanaryModule) IsHealthy(ctx context.Context) (bool, error) {
+	if !m.settings.Enabled || m.skipped {
+		return true, nil
+	}
+
+	m.lck.RLock()
+	defer m.lck.RUnlock()
+
+	return m.passed, m.err
+}
+
+func (m *CanaryModule) Run(ctx context.Context) error {
+	if !m.settings.Enabled || m.skipped {
+		return nil
+	}
+
+	started := m.clock.Now()
+	err := m.poolManager.RunCanary(ctx, m.settings)
+	took := m.clock.Now().Sub(started)
+
+	m.lck.Lock()
+	m.passed, m.err = err == nil, err
+	m.lck.Unlock()
+
+	succeeded := 0.0
+	if err == nil {
+		succeeded = 1
+	}
+
+	m.metric.Write(ctx, metric.Data{
+		{
+			MetricName: metricCanarySucceeded,
+			Value:      succeeded,
+			Unit:       metric.UnitCount,
+		},
+		{
+			MetricName: metricCanaryDuration,
+			Value:      float64(took.Milliseconds()),
+			Unit:       metric.UnitMillisecondsAverage,
+		},
+	})
+
+	if err != nil {
+		m.logger.Error(ctx, "canary self test failed after %s: %w", took, err)
+
+		return nil
+	}
+
+	m.logger.Info(ctx, "canary self test passed after %s", took)
+
+	return nil
+}
+
+// RunCanary spawns a canary in its own pool like any warm deployment and removes it once it is ready.
+func (c *ServicePoolManager) RunCanary(ctx context.Context, settings *CanarySelfTestSettings) error {
+	var err error
+	var pool *ServicePool
+
+	if pool, err = c.getPool(ctx, canaryPoolId); err != nil {
+		return fmt.Errorf("could not get pool: %w", err)
+	}
+
+	return pool.Canary(ctx, settings)
+}
+
+func (c *ServicePool) Canary(ctx context.Context, settings *CanarySelfTestSettings) error {
+	var err error
+	var status string
+	var deployment *appsv1.Deployment
+
+	input := &WarmUpDeployment{
+		PoolId:        c.id,
+		ComponentType: canaryComponentType,
+		ContainerName: "main",
+		Spec: ContainerSpec{
+			Repository: settings.Repository,
+			Tag:        settings.Tag,
+			Cmd:        []string{"httpd", "-f", "-p", "8080"},
+			PortBindings: map[string]PortBinding{
+				"http": {
+					ContainerPort: 8080,
+					Protocol:      "tcp",
+				},
+			},
+			Resources: &ResourceSpec{
+				Cpu:    "10m",
+				Memory: "16Mi",
+			},
+		},
+	}
+
+	if deployment, err = c.spawnDeployment(ctx, input); err != nil {
+		return fmt.Errorf("could not spawn canary: %w", err)
+	}
+
+	status, err = waitForDeployment(ctx, c.clock, c.k8sClient, deployment.GetName(), settings.Timeout)
+
+	labels := map[string]string{
+		LabelPoolId: K8sNameString(c.id),
+		LableUid:    deployment.GetLabels()[LableUid],
+	}
+
+	if _, releaseErr := c.ReleaseServices(ctx, labels); releaseErr != nil {
+		c.logger.Warn(ctx, "could not remove canary %q: %s", deployment.GetName(), releaseErr.Error())
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not wait for canary %q: %w", deployment.GetName(), err)
+	}
+
+	if status != ClaimStatusReady {
+		return fmt.Errorf("canary %q is %s instead of ready after %s", deployment.GetName(), status, settings.Timeout)
+	}
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// unlimitedCapacity is reported if no resource quota restricts the number of instances.
+	unlimitedCapacity = -1
+
+	metricCapacityExhausted = "CapacityExhausted"
+)
+
+// CapacitySettings control the capacity check before spawning. Refused clients are asked to retry once the next
+// deployment expired and a new one could be ready, RetryAfter is used if no deployment expires at all.
+type CapacitySettings struct {
+	Enabled       bool          `cfg:"enabled" default:"false"`
+	CheckNodes    bool          `cfg:"check_nodes" default:"true"`
+	RetryAfter    time.Duration `cfg:"retry_after" default:"30s"`
+	MaxRetryAfter time.Duration `cfg:"max_retry_after" default:"5m"`
+}
+
+type CapacityExhaustedError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *CapacityExhaustedError) Error() string {
+	return fmt.Sprintf("cluster capacity exhausted: %s", e.Reason)
+}
+
+type CapacityEstimate struct {
+	ComponentType string `json:"component_type"`
+	Cpu           string `json:"cpu"`
+	Memory        string `json:"memory"`
+	QuotaLimit    int64  `json:"quota_limit"`
+	QuotaLimitBy  string `json:"quota_limit_by,omitempty"`
+	NodeLimit     int64  `json:"node_limit"`
+	Schedulable   int64  `json:"schedulable"`
+}
+
+type CapacityChecker struct {
+	logger    log.Logger
+	clock     clock.Clock
+	metric    metric.Writer
+	k8sClient *K8sClient
+	settings  *CapacitySettings
+}
+
+func NewCapacityChecker(config cfg.Config, logger log.Logger, k8sClient *K8sClient) (*CapacityChecker, error) {
+	settings := &CapacitySettings{}
+	if err := config.UnmarshalKey("capacity", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal capacity settings: %w", err)
+	}
+
+	return &CapacityChecker{
+		logger:    logger.WithChannel("capacity"),
+		clock:     clock.NewRealClock(),
+		metric:    metric.NewWriter(),
+		k8sClient: k8sClient,
+		settings:  settings,
+	}, nil
+}
+
+// CheckDeployment returns a *CapacityExhaustedError if the namespace quotas or the node headroom
+// can't fit another pod of the given deployment together with the pods of its dependencies.
+func (c *CapacityChecker) CheckDeployment(ctx context.Context, deployment *appsv1.Deployment, dependencies ...*appsv1.Deployment) error {
+	if !c.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	var snapshot *capacitySnapshot
+
+	if snapshot, err = c.takeSnapshot(ctx, c.settings.CheckNodes); err != nil {
+		return fmt.Errorf("could not take capacity snapshot: %w", err)
+	}
+
+	podSpecs := []*apiv1.PodSpec{&deployment.Spec.Template.Spec}
+	for _, dependency := range dependencies {
+		podSpecs = append(podSpecs, &dependency.Spec.Template.Spec)
+	}
+
+	requests := apiv1.ResourceList{}
+	for _, podSpec := range podSpecs {
+		addResources(requests, podRequests(podSpec))
+	}
+
+	if count, limitedBy, shortage := snapshot.quotaCapacity(requests, int64(len(podSpecs))); count == 0 {
+		c.writeExhausted(ctx, deployment, "quota")
+
+		return &CapacityExhaustedError{
+			Reason:     fmt.Sprintf("resource quota %s has no room left for %s: %s", limitedBy, deployment.GetAnnotations()[AnnotationComponentType], shortage),
+			RetryAfter: c.retryAfter(ctx),
+		}
+	}
+
+	if !c.settings.CheckNodes {
+		return nil
+	}
+
+	// the pods are scheduled on their own, so each of them has to fit onto one of the nodes
+	for _, podSpec := range podSpecs {
+		needed := podRequests(podSpec)
+
+		if snapshot.nodeCapacity(podSpec, needed) == 0 {
+			c.writeExhausted(ctx, deployment, "nodes")
+
+			return &CapacityExhaustedError{
+				Reason:     fmt.Sprintf("none of the nodes has enough headroom for cpu %s and memory %s", needed.Cpu().String(), needed.Memory().String()),
+				RetryAfter: c.retryAfter(ctx),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *CapacityChecker) writeExhausted(ctx context.Context, deployment *appsv1.Deployment, limitedBy string) {
+	datum := claimMetric(metricCapacityExhausted, deployment)
+	datum.Dimensions["LimitedBy"] = limitedBy
+
+	c.metric.WriteOne(ctx, datum)
+}
+
+// Estimate reports how many more instances of each of the given deployments could currently be
+// scheduled. The deployments are keyed by their component type.
+func (c *CapacityChecker) Estimate(ctx context.Context, deployments map[string]*appsv1.Deployment) ([]*CapacityEstimate, error) {
+	var err error
+	var snapshot *capacitySnapshot
+
+	if snapshot, err = c.takeSnapshot(ctx, true); err != nil {
+		return nil, fmt.Errorf("could not take capacity snapshot: %w", err)
+	}
+
+	estimates := make([]*CapacityEstimate, 0, len(deployments))
+
+	for componentType, deployment := range deployments {
+		podSpec := &deployment.Spec.Template.Spec
+		requests := podRequests(podSpec)
+
+		estimate := &CapacityEstimate{
+			ComponentType: componentType,
+			Cpu:           requests.Cpu().String(),
+			Memory:        requests.Memory().String(),
+			NodeLimit:     snapshot.nodeCapacity(podSpec, requests),
+		}
+
+		estimate.QuotaLimit, estimate.QuotaLimitBy, _ = snapshot.quotaCapacity(requests, 1)
+		estimate.Schedulable = estimate.NodeLimit
+
+		if estimate.QuotaLimit != unlimitedCapacity && estimate.QuotaLimit < estimate.Schedulable {
+			estimate.Schedulable = estimate.QuotaLimit
+		}
+
+		estimates = append(estimates, estimate)
+	}
+
+	return estimates, nil
+}
+
+type capacitySnapshot struct {
+	quotas    []*apiv1.ResourceQuota
+	nodes     []*apiv1.Node
+	requested map[string]apiv1.ResourceList
+	podCounts map[string]int64
+}
+
+func (c *CapacityChecker) takeSnapshot(ctx context.Context, withNodes bool) (*capacitySnapshot, error) {
+	var err error
+	var pods []*apiv1.Pod
+
+	snapshot := &capacitySnapshot{
+		requested: map[string]apiv1.ResourceList{},
+		podCounts: map[string]int64{},
+	}
+
+	if snapshot.quotas, err = c.k8sClient.ListResourceQuotas(ctx); err != nil {
+		return nil, fmt.Errorf("could not list resource quotas: %w", err)
+	}
+
+	if !withNodes {
+		return snapshot, nil
+	}
+
+	if snapshot.nodes, err = c.k8sClient.ListNodes(ctx); err != nil {
+		return nil, fmt.Errorf("could not list nodes: %w", err)
+	}
+
+	if pods, err = c.k8sClient.ListClusterPods(ctx); err != nil {
+		return nil, fmt.Errorf("could not list pods: %w", err)
+	}
+
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+
+		if _, ok := snapshot.requested[pod.Spec.NodeName]; !ok {
+			snapshot.requested[pod.Spec.NodeName] = apiv1.ResourceList{}
+		}
+
+		addResources(snapshot.requested[pod.Spec.NodeName], podRequests(&pod.Spec))
+		snapshot.podCounts[pod.Spec.NodeName]++
+	}
+
+	return snapshot, nil
+}
+
+// quotaCapacity returns how many instances with the given requests still fit into the resource quotas,
+// which quota resource is the limiting one and how much of it is requested and left. An instance consists
+// of the given number of deployments, each with one pod and one service.
+func (s *capacitySnapshot) quotaCapacity(requests apiv1.ResourceList, deployments int64) (int64, string, string) {
+	objects := *resource.NewQuantity(deployments, resource.DecimalSI)
+	demand := apiv1.ResourceList{
+		apiv1.ResourceCPU:            requests[apiv1.ResourceCPU],
+		apiv1.ResourceRequestsCPU:    requests[apiv1.ResourceCPU],
+		apiv1.ResourceMemory:         requests[apiv1.ResourceMemory],
+		apiv1.ResourceRequestsMemory: requests[apiv1.ResourceMemory],
+		apiv1.ResourcePods:           objects,
+		apiv1.ResourceServices:       objects,
+		"count/pods":                 objects,
+		"count/services":             objects,
+		"count/deployments.apps":     objects,
+	}
+
+	count := int64(unlimitedCapacity)
+	limitedBy := ""
+	shortage := ""
+
+	for _, quota := range s.quotas {
+		for name, hard := range quota.Status.Hard {
+			want, ok := demand[name]
+			if !ok || want.IsZero() {
+				continue
+			}
+
+			free := hard.DeepCopy()
+			free.Sub(quota.Status.Used[name])
+			fits := fitCount(free, want)
+
+			if count == unlimitedCapacity || fits < count {
+				count = fits
+				limitedBy = fmt.Sprintf("%s/%s", quota.Name, name)
+				shortage = fmt.Sprintf("%s requested, %s of %s free", want.String(), free.String(), hard.String())
+			}
+		}
+	}
+
+	return count, limitedBy, shortage
+}
+
+// nodeCapacity returns how many instances with the given pod spec still fit onto the schedulable nodes.
+func (s *capacitySnapshot) nodeCapacity(podSpec *apiv1.PodSpec, requests apiv1.ResourceList) int64 {
+	selector := labels.SelectorFromSet(podSpec.NodeSelector)
+	total := int64(0)
+
+	for _, node := range s.nodes {
+		if !selector.Matches(labels.Set(node.Labels)) || !isNodeSchedulable(node, podSpec.Tolerations) {
+			continue
+		}
+
+		fits := node.Status.Allocatable.Pods().Value() - s.podCounts[node.Name]
+
+		for _, name := range []apiv1.ResourceName{apiv1.ResourceCPU, apiv1.ResourceMemory} {
+			want := requests[name]
+			if want.IsZero() {
+				continue
+			}
+
+			free := node.Status.Allocatable[name].DeepCopy()
+			free.Sub(s.requested[node.Name][name])
+			fits = min(fits, fitCount(free, want))
+		}
+
+		total += max(fits, 0)
+	}
+
+	return total
+}
+
+func fitCount(free resource.Quantity, want resource.Quantity) int64 {
+	if free.Sign() <= 0 {
+		return 0
+	}
+
+	return free.MilliValue() / want.MilliValue()
+}
+
+func podRequests(podSpec *apiv1.PodSpec) apiv1.ResourceList {
+	requests := apiv1.ResourceList{}
+
+	for _, container := range podSpec.Containers {
+		addResources(requests, container.Resources.Requests)
+	}
+
+	return requests
+}
+
+func addResources(sum apiv1.ResourceList, add apiv1.ResourceList) {
+	for name, quantity := range add {
+		current := sum[name]
+		current.Add(quantity)
+		sum[name] = current
+	}
+}
+
+func isNodeSchedulable(node *apiv1.Node, tolerations []apiv1.Toleration) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == apiv1.NodeReady && condition.Status != apiv1.ConditionTrue {
+			return false
+		}
+	}
+
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == apiv1.TaintEffectPreferNoSchedule {
+			continue
+		}
+
+		tolerated := false
+		for _, toleration := range tolerations {
+			if toleration.ToleratesTaint(&taint) {
+				tolerated = true
+
+				break
+			}
+		}
+
+		if !tolerated {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+)
+
+const (
+	ClaimFallbackSpawn = "spawn"
+	ClaimFallbackWait  = "wait"
+	ClaimFallbackFail  = "fail"
+
+	ClaimPathIdle      = "idle"
+	ClaimPathWaited    = "waited"
+	ClaimPathSpawned   = "spawned"
+	ClaimPathDedicated = "dedicated"
+
+	claimPathHeader = "X-Claim-Path"
+)
+
+var ErrNoIdleDeployment = errors.New("no matching idle deployment")
+
+type ClaimFallbackSettings struct {
+	Default ClaimFallbackPolicy            `cfg:"default"`
+	Pools   map[string]ClaimFallbackPolicy `cfg:"pools"`
+}
+
+// ClaimFallbackPolicy defines what a claim does if the pool has no matching idle deployment: spawn a deployment
+// right away, wait up to Wait for an idle one to be spawned by a warm up or the replacement of another claim
+// and spawn one afterward, or fail fast.
+type ClaimFallbackPolicy struct {
+	Policy   string        `cfg:"policy"`
+	Wait     time.Duration `cfg:"wait"`
+	Interval time.Duration `cfg:"interval"`
+}
+
+type ClaimFallbacks struct {
+	base  ClaimFallbackPolicy
+	pools map[string]ClaimFallbackPolicy
+}
+
+func NewClaimFallbacks(config cfg.Config) (*ClaimFallbacks, error) {
+	var err error
+
+	settings := &ClaimFallbackSettings{}
+	if err = config.UnmarshalKey("claim_fallback", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal claim fallback settings: %w", err)
+	}
+
+	fallbacks := &ClaimFallbacks{
+		base: settings.Default.withDefaults(ClaimFallbackPolicy{
+			Policy:   ClaimFallbackSpawn,
+			Wait:     30 * time.Second,
+			Interval: time.Second,
+		}),
+		pools: map[string]ClaimFallbackPolicy{},
+	}
+
+	if err = validateClaimFallbackPolicy("default", fallbacks.base); err != nil {
+		return nil, err
+	}
+
+	for poolId, policy := range settings.Pools {
+		policy = policy.withDefaults(fallbacks.base)
+
+		if err = validateClaimFallbackPolicy(poolId, policy); err != nil {
+			return nil, err
+		}
+
+		fallbacks.pools[poolId] = policy
+	}
+
+	return fallbacks, nil
+}
+
+// For returns the fallback policy of the pool, unset fields are taken from the default policy.
+func (f *ClaimFallbacks) For(poolId string) ClaimFallbackPolicy {
+	if policy, ok := f.pools[poolId]; ok {
+		return policy
+	}
+
+	return f.base
+}
+
+func (p ClaimFallbackPolicy) withDefaults(base ClaimFallbackPolicy) ClaimFallbackPolicy {
+	if p.Policy == "" {
+		p.Policy = base.Policy
+	}
+
+	if p.Wait == 0 {
+		p.Wait = base.Wait
+	}
+
+	if p.Interval == 0 {
+		p.Interval = base.Interval
+	}
+
+	return p
+}
+
+func validateClaimFallbackPolicy(name string, policy ClaimFallbackPolicy) error {
+	switch policy.Policy {
+	case ClaimFallbackSpawn, ClaimFallbackWait, ClaimFallbackFail:
+	default:
+		return fmt.Errorf("claim fallback of %q has to be one of %q, %q or %q but is %q", name, ClaimFallbackSpawn, ClaimFallbackWait, ClaimFallbackFail, policy.Policy)
+	}
+
+	if policy.Wait < 0 || policy.Interval <= 0 {
+		return fmt.Errorf("claim fallback of %q needs a positive interval and must not wait a negative duration", name)
+	}
+
+	return nil
+}
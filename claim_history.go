@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/appctx"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+const (
+	HistoryEventClaim    = "claim"
+	HistoryEventRelease  = "release"
+	HistoryEventExpire   = "expire"
+	HistoryEventWarmUp   = "warmup"
+	HistoryEventShutdown = "shutdown"
+	HistoryEventExtend   = "extend"
+	HistoryEventTransfer = "transfer"
+	HistoryEventRestore  = "restore"
+	HistoryEventOomKill  = "oom_kill"
+	HistoryEventOomRetry = "oom_retry"
+
+	HistoryStoreMemory = "memory"
+	HistoryStoreDdb    = "ddb"
+)
+
+type ClaimHistorySettings struct {
+	Store     string        `cfg:"store" default:"memory"`
+	Retention time.Duration `cfg:"retention" default:"336h"`
+}
+
+// HistoryRecord is a single claim or pool event. Warm ups record the number of spawned deployments in Count,
+// extensions the requested extension in Duration.
+// Claims and the release or expiry of a claimed deployment share the same ClaimId, transfers record the test
+// the claim was handed over from in PreviousTestId.
+type HistoryRecord struct {
+	Event          string        `json:"event"`
+	PoolId         string        `json:"pool_id"`
+	TestId         string        `json:"test_id,omitempty"`
+	PreviousTestId string        `json:"previous_test_id,omitempty"`
+	ComponentType  string        `json:"component_type,omitempty"`
+	ClaimId        string        `json:"claim_id,omitempty"`
+	Team           string        `json:"team,omitempty"`
+	Ci             *CiMetadata   `json:"ci,omitempty"`
+	TraceId        string        `json:"trace_id,omitempty"`
+	Count          int           `json:"count,omitempty"`
+	Duration       time.Duration `json:"duration,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+// ClaimHistory records every claim and pool event, so usage and demand can be analyzed afterward.
+type ClaimHistory interface {
+	Record(ctx context.Context, record HistoryRecord) error
+	List(ctx context.Context, since time.Time) ([]HistoryRecord, error)
+}
+
+type claimHistoryKey struct{}
+
+func ProvideClaimHistory(ctx context.Context, config cfg.Config, logger log.Logger) (ClaimHistory, error) {
+	return appctx.Provide(ctx, claimHistoryKey{}, func() (ClaimHistory, error) {
+		settings := &ClaimHistorySettings{}
+		if err := config.UnmarshalKey("claim_history", settings); err != nil {
+			return nil, fmt.Errorf("could not unmarshal claim history settings: %w", err)
+		}
+
+		switch settings.Store {
+		case HistoryStoreMemory:
+			return NewInMemoryClaimHistory(settings), nil
+		case HistoryStoreDdb:
+			return NewDdbClaimHistory(ctx, config, logger, settings)
+		default:
+			return nil, fmt.Errorf("unknown claim history store %q", settings.Store)
+		}
+	})
+}
+
+type inMemoryClaimHistory struct {
+	lck      sync.RWMutex
+	clock    clock.Clock
+	settings *ClaimHistorySettings
+	records  []HistoryRecord
+}
+
+func NewInMemoryClaimHistory(settings *ClaimHistorySettings) ClaimHistory {
+	return &inMemoryClaimHistory{
+		clock:    clock.NewRealClock(),
+		settings: settings,
+		records:  make([]HistoryRecord, 0),
+	}
+}
+
+func (h *inMemoryClaimHistory) Record(_ context.Context, record HistoryRecord) error {
+	h.lck.Lock()
+	defer h.lck.Unlock()
+
+	// records are appended in order, so everything before the first record within the retention is outdated
+	cutoff := h.clock.Now().Add(-h.settings.Retention)
+	for len(h.records) > 0 && h.records[0].CreatedAt.Before(cutoff) {
+		h.records = h.records[1:]
+	}
+
+	h.records = append(h.records, record)
+
+	return nil
+}
+
+func (h *inMemoryClaimHistory) List(_ context.Context, since time.Time) ([]HistoryRecord, error) {
+	h.lck.RLock()
+	defer h.lck.RUnlock()
+
+	result := make([]HistoryRecord, 0)
+	for _, record := range h.records {
+		if record.CreatedAt.Before(since) {
+			continue
+		}
+
+		result = append(result, record)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/ddb"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/mdl"
+	"github.com/justtrackio/gosoline/pkg/uuid"
+)
+
+const (
+	historyDayLayout = "2006-01-02"
+	// historyKeyLayout has a fixed length, so the range keys sort lexicographically in time order
+	historyKeyLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+// ddbHistoryItem partitions the records by day, so a time range can be read with one query per day.
+type ddbHistoryItem struct {
+	Day string `json:"day" ddb:"key=hash"`
+	Key string `json:"key" ddb:"key=range"`
+	Ttl int64  `json:"ttl" ddb:"ttl=enabled"`
+
+	Event          string        `json:"event"`
+	PoolId         string        `json:"poolId"`
+	TestId         string        `json:"testId"`
+	PreviousTestId string        `json:"previousTestId"`
+	ComponentType  string        `json:"componentType"`
+	ClaimId        string        `json:"claimId"`
+	Team           string        `json:"team"`
+	Ci             *CiMetadata   `json:"ci,omitempty"`
+	TraceId        string        `json:"traceId"`
+	Count          int           `json:"count"`
+	Duration       time.Duration `json:"duration"`
+	CreatedAt      time.Time     `json:"createdAt"`
+}
+
+type ddbClaimHistory struct {
+	clock      clock.Clock
+	repository ddb.Repository
+	settings   *ClaimHistorySettings
+}
+
+func NewDdbClaimHistory(ctx context.Context, config cfg.Config, logger log.Logger, settings *ClaimHistorySettings) (ClaimHistory, error) {
+	var err error
+	var repository ddb.Repository
+
+	ddbSettings := &ddb.Settings{
+		ModelId: mdl.ModelId{
+			Name: "claim-history",
+		},
+		Main: ddb.MainSettings{
+			Model: &ddbHistoryItem{},
+		},
+	}
+
+	if repository, err = ddb.NewRepository(ctx, config, logger, ddbSettings); err != nil {
+		return nil, fmt.Errorf("could not create ddb repository: %w", err)
+	}
+
+	return &ddbClaimHistory{
+		clock:      clock.NewRealClock(),
+		repository: repository,
+		settings:   settings,
+	}, nil
+}
+
+func (h *ddbClaimHistory) Record(ctx context.Context, record HistoryRecord) error {
+	item := &ddbHistoryItem{
+		Day:            record.CreatedAt.UTC().Format(historyDayLayout),
+		Key:            fmt.Sprintf("%s#%s", record.CreatedAt.UTC().Format(historyKeyLayout), uuid.New().NewV4()),
+		Ttl:            record.CreatedAt.Add(h.settings.Retention).Unix(),
+		Event:          record.Event,
+		PoolId:         record.PoolId,
+		TestId:         record.TestId,
+		PreviousTestId: record.PreviousTestId,
+		ComponentType:  record.ComponentType,
+		ClaimId:        record.ClaimId,
+		Team:           record.Team,
+		Ci:             record.Ci,
+		TraceId:        record.TraceId,
+		Count:          record.Count,
+		Duration:       record.Duration,
+		CreatedAt:      record.CreatedAt,
+	}
+
+	if _, err := h.repository.PutItem(ctx, h.repository.PutItemBuilder(), item); err != nil {
+		return fmt.Errorf("could not put history item: %w", err)
+	}
+
+	return nil
+}
+
+func (h *ddbClaimHistory) List(ctx context.Context, since time.Time) ([]HistoryRecord, error) {
+	records := make([]HistoryRecord, 0)
+	since = since.UTC()
+	until := h.clock.Now().UTC()
+
+	for day := since.Truncate(24 * time.Hour); !day.After(until); day = day.Add(24 * time.Hour) {
+		items := make([]*ddbHistoryItem, 0)
+		qb := h.repository.QueryBuilder().
+			WithHash(day.Format(historyDayLayout)).
+			WithRangeGte(since.Format(historyKeyLayout))
+
+		if _, err := h.repository.Query(ctx, qb, &items); err != nil {
+			return nil, fmt.Errorf("could not query history of day %s: %w", day.Format(historyDayLayout), err)
+		}
+
+		for _, item := range items {
+			records = append(records, HistoryRecord{
+				Event:          item.Event,
+				PoolId:         item.PoolId,
+				TestId:         item.TestId,
+				PreviousTestId: item.PreviousTestId,
+				ComponentType:  item.ComponentType,
+				ClaimId:        item.ClaimId,
+				Team:           item.Team,
+				Ci:             item.Ci,
+				TraceId:        item.TraceId,
+				Count:          item.Count,
+				Duration:       item.Duration,
+				CreatedAt:      item.CreatedAt,
+			})
+		}
+	}
+
+	return records, nil
+}
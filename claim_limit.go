@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// ClaimLimitSettings cap the number of simultaneously claimed components per test. A limit of 0 disables the check.
+type ClaimLimitSettings struct {
+	MaxPerTest int `cfg:"max_per_test" default:"50"`
+}
+
+type ClaimLimitExceededError struct {
+	TestId     string
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *ClaimLimitExceededError) Error() string {
+	return fmt.Sprintf("test %q already claimed the maximum of %d components, release components before claiming new ones", e.TestId, e.Limit)
+}
+
+type ClaimLimiter struct {
+	clock     clock.Clock
+	k8sClient *K8sClient
+	settings  *ClaimLimitSettings
+}
+
+func NewClaimLimiter(config cfg.Config, k8sClient *K8sClient) (*ClaimLimiter, error) {
+	settings := &ClaimLimitSettings{}
+	if err := config.UnmarshalKey("claim_limits", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal claim limit settings: %w", err)
+	}
+
+	return &ClaimLimiter{
+		clock:     clock.NewRealClock(),
+		k8sClient: k8sClient,
+		settings:  settings,
+	}, nil
+}
+
+// Check returns a *ClaimLimitExceededError if the test already holds the maximum number of claims. The error
+// asks to retry once the first claim of the test expires.
+func (l *ClaimLimiter) Check(ctx context.Context, testId string) error {
+	if l.settings.MaxPerTest <= 0 {
+		return nil
+	}
+
+	var err error
+	var deployments []*appsv1.Deployment
+
+	if deployments, err = l.claimed(ctx, testId); err != nil {
+		return err
+	}
+
+	if len(deployments) < l.settings.MaxPerTest {
+		return nil
+	}
+
+	expiresIn, _ := nextExpiry(l.clock.Now(), deployments)
+
+	return &ClaimLimitExceededError{
+		TestId:     testId,
+		Limit:      l.settings.MaxPerTest,
+		RetryAfter: clampRetryAfter(expiresIn, 0),
+	}
+}
+
+// Remaining returns how many more components the test can claim, or -1 if the number isn't limited.
+func (l *ClaimLimiter) Remaining(ctx context.Context, testId string) (int, error) {
+	if l.settings.MaxPerTest <= 0 {
+		return -1, nil
+	}
+
+	var err error
+	var deployments []*appsv1.Deployment
+
+	if deployments, err = l.claimed(ctx, testId); err != nil {
+		return 0, err
+	}
+
+	return max(l.settings.MaxPerTest-len(deployments), 0), nil
+}
+
+// claimed returns the claims of the test. Soft deleted claims were already stopped by the test and aren't counted.
+func (l *ClaimLimiter) claimed(ctx context.Context, testId string) ([]*appsv1.Deployment, error) {
+	var err error
+	var deployments []*appsv1.Deployment
+
+	if deployments, err = l.k8sClient.ListDeployments(ctx, map[string]string{LabelTestId: K8sNameString(testId)}); err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	return slices.DeleteFunc(deployments, func(deployment *appsv1.Deployment) bool {
+		return isSoftDeleted(deployment)
+	}), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/clock"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+const (
+	ClaimStatusPending = "pending"
+	ClaimStatusReady   = "ready"
+)
+
+var ErrClaimNotFound = errors.New("claim not found")
+
+type ClaimInput struct {
+	Id      string `uri:"id"`
+	PoolKey string `header:"X-Pool-Key" json:"-"`
+}
+
+type ClaimOutput struct {
+	ClaimId       string            `json:"claim_id"`
+	Status        string            `json:"status"`
+	Reason        string            `json:"reason,omitempty"`
+	PoolId        string            `json:"pool_id"`
+	TestId        string            `json:"test_id"`
+	ComponentType string            `json:"component_type"`
+	ComponentName string            `json:"component_name"`
+	ExpireAfter   string            `json:"expire_after"`
+	Bindings      map[string]string `json:"bindings"`
+	Ci            *CiMetadata       `json:"ci,omitempty"`
+}
+
+// DeploymentClaimStatus derives the status of a claim from its deployment: a claim marked as failed stays
+// failed, otherwise it is ready as soon as its pod passed the readiness checks.
+func DeploymentClaimStatus(deployment *appsv1.Deployment) string {
+	if deployment.GetAnnotations()[AnnotationClaimStatus] == ClaimStatusFailed {
+		return ClaimStatusFailed
+	}
+
+	if deployment.Status.ReadyReplicas > 0 {
+		return ClaimStatusReady
+	}
+
+	return ClaimStatusPending
+}
+
+// waitForDeployment polls the deployment until it isn't pending anymore or the wait duration elapsed and
+// returns the last observed status.
+func waitForDeployment(ctx context.Context, clk clock.Clock, k8sClient *K8sClient, name string, wait time.Duration) (string, error) {
+	var err error
+	var deployment *appsv1.Deployment
+
+	timer := clk.NewTimer(wait)
+	defer timer.Stop()
+
+	ticker := clk.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		// a request timeout during the wait still hands out the claim, its status can be polled later on
+		if deployment, err = k8sClient.GetDeployment(ctx, name); err != nil && ctx.Err() != nil {
+			return ClaimStatusPending, nil
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("could not get deployment: %w", err)
+		}
+
+		if status := DeploymentClaimStatus(deployment); status != ClaimStatusPending {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ClaimStatusPending, nil
+		case <-timer.Chan():
+			return ClaimStatusPending, nil
+		case <-ticker.Chan():
+		}
+	}
+}
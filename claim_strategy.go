@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	ClaimStrategyOldest     = "oldest"
+	ClaimStrategyNewest     = "newest"
+	ClaimStrategyRandom     = "random"
+	ClaimStrategyBinPacking = "bin_packing"
+)
+
+type ClaimStrategySettings struct {
+	Strategy string `cfg:"strategy" default:"oldest"`
+	// SpreadNodes prefers idle deployments on nodes which don't run other components of the same test yet.
+	SpreadNodes bool `cfg:"spread_nodes" default:"false"`
+	// ZoneTopologyKey is the node label holding the zone which is matched against the zone of the runner.
+	ZoneTopologyKey string `cfg:"zone_topology_key" default:"topology.kubernetes.io/zone"`
+}
+
+// ClaimStrategy decides which idle deployment is handed out to a claim. Order sorts the deployments by
+// preference, the first one is claimed.
+type ClaimStrategy interface {
+	Order(ctx context.Context, input *RunInput, deployments []*appsv1.Deployment) error
+}
+
+func NewClaimStrategy(config cfg.Config, k8sClient *K8sClient) (ClaimStrategy, error) {
+	settings := &ClaimStrategySettings{}
+	if err := config.UnmarshalKey("claim_strategy", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal claim strategy settings: %w", err)
+	}
+
+	var strategy ClaimStrategy
+
+	switch settings.Strategy {
+	case ClaimStrategyOldest:
+		strategy = oldestFirst{}
+	case ClaimStrategyNewest:
+		strategy = newestFirst{}
+	case ClaimStrategyRandom:
+		strategy = randomOrder{}
+	case ClaimStrategyBinPacking:
+		strategy = &binPacking{k8sClient: k8sClient}
+	default:
+		return nil, fmt.Errorf("unknown claim strategy %q, it has to be one of %s, %s, %s or %s", settings.Strategy, ClaimStrategyOldest, ClaimStrategyNewest, ClaimStrategyRandom, ClaimStrategyBinPacking)
+	}
+
+	if settings.SpreadNodes {
+		strategy = &nodeSpread{
+			base:      strategy,
+			k8sClient: k8sClient,
+		}
+	}
+
+	return &sameZone{
+		base:        strategy,
+		k8sClient:   k8sClient,
+		topologyKey: settings.ZoneTopologyKey,
+	}, nil
+}
+
+// oldestFirst hands out the deployment which is warm the longest, its caches are the warmest.
+type oldestFirst struct{}
+
+func (oldestFirst) Order(_ context.Context, _ *RunInput, deployments []*appsv1.Deployment) error {
+	slices.SortStableFunc(deployments, compareCreation)
+
+	return nil
+}
+
+// newestFirst hands out the most recent deployment, so the older ones expire and their nodes drain first.
+type newestFirst struct{}
+
+func (newestFirst) Order(_ context.Context, _ *RunInput, deployments []*appsv1.Deployment) error {
+	slices.SortStableFunc(deployments, func(a, b *appsv1.Deployment) int {
+		return compareCreation(b, a)
+	})
+
+	return nil
+}
+
+type randomOrder struct{}
+
+func (randomOrder) Order(_ context.Context, _ *RunInput, deployments []*appsv1.Deployment) error {
+	rand.Shuffle(len(deployments), func(i, j int) {
+		deployments[i], deployments[j] = deployments[j], deployments[i]
+	})
+
+	return nil
+}
+
+// binPacking hands out the deployment running on the node with the most kubrun pods, so claims concentrate
+// on few nodes and the others can be scaled down once their idle deployments expire.
+type binPacking struct {
+	k8sClient *K8sClient
+}
+
+func (s *binPacking) Order(ctx context.Context, _ *RunInput, deployments []*appsv1.Deployment) error {
+	var err error
+	var pods []*apiv1.Pod
+
+	if pods, err = s.k8sClient.ListPods(ctx); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+
+	podsPerNode := map[string]int{}
+	nodeByUid := map[string]string{}
+
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+
+		podsPerNode[pod.Spec.NodeName]++
+		nodeByUid[pod.GetLabels()[LableUid]] = pod.Spec.NodeName
+	}
+
+	slices.SortStableFunc(deployments, func(a, b *appsv1.Deployment) int {
+		aPods := podsPerNode[nodeByUid[a.GetLabels()[LableUid]]]
+		bPods := podsPerNode[nodeByUid[b.GetLabels()[LableUid]]]
+
+		if aPods != bPods {
+			return bPods - aPods
+		}
+
+		return compareCreation(a, b)
+	})
+
+	return nil
+}
+
+// nodeSpread keeps the order of the base strategy, but moves deployments on nodes with the fewest components of
+// the same test to the front, so e.g. the database and the cache of a test don't compete for the same node.
+type nodeSpread struct {
+	base      ClaimStrategy
+	k8sClient *K8sClient
+}
+
+func (s *nodeSpread) Order(ctx context.Context, input *RunInput, deployments []*appsv1.Deployment) error {
+	var err error
+	var claimed []*appsv1.Deployment
+	var pods []*apiv1.Pod
+
+	if err = s.base.Order(ctx, input, deployments); err != nil {
+		return err
+	}
+
+	if input.TestId == "" {
+		return nil
+	}
+
+	if claimed, err = s.k8sClient.ListDeployments(ctx, map[string]string{LabelTestId: K8sNameString(input.TestId)}); err != nil {
+		return fmt.Errorf("could not list deployments of test: %w", err)
+	}
+
+	if len(claimed) == 0 {
+		return nil
+	}
+
+	if pods, err = s.k8sClient.ListPods(ctx); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+
+	nodeByUid := map[string]string{}
+	for _, pod := range pods {
+		nodeByUid[pod.GetLabels()[LableUid]] = pod.Spec.NodeName
+	}
+
+	testPodsPerNode := map[string]int{}
+	for _, deployment := range claimed {
+		if node := nodeByUid[deployment.GetLabels()[LableUid]]; node != "" {
+			testPodsPerNode[node]++
+		}
+	}
+
+	slices.SortStableFunc(deployments, func(a, b *appsv1.Deployment) int {
+		return testPodsPerNode[nodeByUid[a.GetLabels()[LableUid]]] - testPodsPerNode[nodeByUid[b.GetLabels()[LableUid]]]
+	})
+
+	return nil
+}
+
+// sameZone keeps the order of the base strategy, but moves deployments in the zone of the test runner to the
+// front to avoid cross zone latency and traffic costs.
+type sameZone struct {
+	base        ClaimStrategy
+	k8sClient   *K8sClient
+	topologyKey string
+}
+
+func (s *sameZone) Order(ctx context.Context, input *RunInput, deployments []*appsv1.Deployment) error {
+	var err error
+	var pods []*apiv1.Pod
+	var nodes []*apiv1.Node
+
+	if err = s.base.Order(ctx, input, deployments); err != nil {
+		return err
+	}
+
+	if input.Zone == "" {
+		return nil
+	}
+
+	if nodes, err = s.k8sClient.ListNodes(ctx, map[string]string{s.topologyKey: input.Zone}); err != nil {
+		return fmt.Errorf("could not list nodes of zone %q: %w", input.Zone, err)
+	}
+
+	if pods, err = s.k8sClient.ListPods(ctx); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+
+	zoneNodes := map[string]bool{}
+	for _, node := range nodes {
+		zoneNodes[node.GetName()] = true
+	}
+
+	inZone := map[string]bool{}
+	for _, pod := range pods {
+		if zoneNodes[pod.Spec.NodeName] {
+			inZone[pod.GetLabels()[LableUid]] = true
+		}
+	}
+
+	slices.SortStableFunc(deployments, func(a, b *appsv1.Deployment) int {
+		aInZone := inZone[a.GetLabels()[LableUid]]
+		bInZone := inZone[b.GetLabels()[LableUid]]
+
+		switch {
+		case aInZone == bInZone:
+			return 0
+		case aInZone:
+			return -1
+		default:
+			return 1
+		}
+	})
+
+	return nil
+}
+
+func compareCreation(a, b *appsv1.Deployment) int {
+	return a.CreationTimestamp.Compare(b.CreationTimestamp.Time)
+}
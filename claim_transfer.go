@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TransferInput hands a claim over to another test, e.g. a database seeded by a setup job to the matrix jobs
+// consuming it. An empty team keeps the team of the claim.
+type TransferInput struct {
+	Id       string `uri:"id"`
+	TestId   string `json:"test_id"`
+	TestName string `json:"test_name"`
+	Team     string `json:"team"`
+	PoolKey  string `header:"X-Pool-Key" json:"-"`
+}
+
+type TransferOutput struct {
+	ClaimId        string `json:"claim_id"`
+	PreviousTestId string `json:"previous_test_id"`
+	TestId         string `json:"test_id"`
+	Team           string `json:"team,omitempty"`
+}
+
+// ownerLabels and ownerAnnotations tie a claim to the pipeline and session of the test which claimed it. They
+// are removed on a transfer, so the end of the old pipeline or session doesn't release the transferred claim.
+var (
+	ownerLabels      = []string{LabelCiPipelineId, LabelSessionId}
+	ownerAnnotations = []string{AnnotationCiPipelineId, AnnotationCiJobUrl, AnnotationCiBranch, AnnotationCiCommit}
+)
+
+// TransferClaim moves the claim to the test of the input. The receiving test has to stay within its claim limit
+// and the hand-off is recorded in the claim history. The aliases of the claim are moved along with it.
+func (c *ServicePoolManager) TransferClaim(ctx context.Context, input *TransferInput) (*TransferOutput, error) {
+	var err error
+	var claim *Claim
+
+	if claim, err = c.GetClaim(ctx, input.Id); err != nil {
+		return nil, err
+	}
+
+	if err = c.limiter.Check(ctx, input.TestId); err != nil {
+		return nil, fmt.Errorf("could not transfer claim %q: %w", input.Id, err)
+	}
+
+	labels := claim.Deployment.GetLabels()
+	output := &TransferOutput{
+		ClaimId:        claim.GetId(),
+		PreviousTestId: labels[LabelTestId],
+		TestId:         K8sNameString(input.TestId),
+		Team:           labels[LabelTeam],
+	}
+
+	ops := []string{
+		PatchOp("replace", "labels", LabelTestId, output.TestId),
+		PatchOp("add", "annotations", AnnotationTestName, input.TestName),
+	}
+
+	if input.Team != "" {
+		output.Team = K8sNameString(input.Team)
+		ops = append(ops, PatchOp("add", "labels", LabelTeam, output.Team))
+	}
+
+	if err = c.patchClaim(ctx, claim, ops); err != nil {
+		return nil, fmt.Errorf("could not transfer claim %q: %w", input.Id, err)
+	}
+
+	if err = c.transferAliases(ctx, claim, output.TestId); err != nil {
+		return nil, fmt.Errorf("could not transfer claim %q: %w", input.Id, err)
+	}
+
+	c.logger.Info(ctx, "transferred claim %q from test %q to test %q", output.ClaimId, output.PreviousTestId, output.TestId)
+
+	c.recordHistory(ctx, HistoryRecord{
+		Event:          HistoryEventTransfer,
+		PoolId:         labels[LabelPoolId],
+		TestId:         output.TestId,
+		PreviousTestId: output.PreviousTestId,
+		ComponentType:  claim.Deployment.GetAnnotations()[AnnotationComponentType],
+		ClaimId:        output.ClaimId,
+		Team:           output.Team,
+	})
+
+	return output, nil
+}
+
+func (c *ServicePoolManager) patchClaim(ctx context.Context, claim *Claim, ops []string) error {
+	var err error
+	var deployment *appsv1.Deployment
+	var service *apiv1.Service
+
+	if deployment, err = c.k8sClient.PatchDeployment(ctx, claim.Deployment, slices.Concat(ops, removeOwnerOps(claim.Deployment))); err != nil {
+		return fmt.Errorf("could not patch deployment: %w", err)
+	}
+
+	if service, err = c.k8sClient.PatchService(ctx, claim.Service, slices.Concat(ops, removeOwnerOps(claim.Service))); err != nil {
+		return fmt.Errorf("could not patch service: %w", err)
+	}
+
+	claim.Deployment = deployment
+	claim.Service = service
+
+	return nil
+}
+
+// transferAliases moves the alias services pointing to the claim to the test.
+func (c *ServicePoolManager) transferAliases(ctx context.Context, claim *Claim, testId string) error {
+	var err error
+	var aliases []*apiv1.Service
+
+	if aliases, err = c.k8sClient.ListServices(ctx, map[string]string{LabelAliasOf: claim.GetId()}); err != nil {
+		return fmt.Errorf("could not list aliases: %w", err)
+	}
+
+	for _, alias := range aliases {
+		if _, err = c.k8sClient.PatchService(ctx, alias, []string{PatchOp("add", "labels", LabelTestId, testId)}); err != nil {
+			return fmt.Errorf("could not patch alias %q: %w", alias.GetName(), err)
+		}
+	}
+
+	return nil
+}
+
+func removeOwnerOps(object metav1.Object) []string {
+	ops := make([]string, 0)
+
+	for _, label := range ownerLabels {
+		if _, ok := object.GetLabels()[label]; ok {
+			ops = append(ops, PatchOp("remove", "labels", label, nil))
+		}
+	}
+
+	for _, annotation := range ownerAnnotations {
+		if _, ok := object.GetAnnotations()[annotation]; ok {
+			ops = append(ops, PatchOp("remove", "annotations", annotation, nil))
+		}
+	}
+
+	return ops
+}
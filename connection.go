@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const defaultAwsRegion = "eu-central-1"
+
+type connectionBuilder func(address string, env map[string]string) map[string]string
+
+var connectionBuilders = map[string]connectionBuilder{
+	"ddb": func(address string, env map[string]string) map[string]string {
+		return awsConnection(address, "test", "test")
+	},
+	"localstack": func(address string, env map[string]string) map[string]string {
+		return awsConnection(address, "test", "test")
+	},
+	"mysql": func(address string, env map[string]string) map[string]string {
+		return map[string]string{
+			"dsn":      fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=true", env["MYSQL_USER"], env["MYSQL_PASSWORD"], address, env["MYSQL_DATABASE"]),
+			"username": env["MYSQL_USER"],
+			"password": env["MYSQL_PASSWORD"],
+			"database": env["MYSQL_DATABASE"],
+		}
+	},
+	"redis": func(address string, env map[string]string) map[string]string {
+		return map[string]string{
+			"url": (&url.URL{Scheme: "redis", Host: address, Path: "/0"}).String(),
+		}
+	},
+	"redis-cluster": func(address string, env map[string]string) map[string]string {
+		return map[string]string{
+			"addrs": address,
+		}
+	},
+	"s3": func(address string, env map[string]string) map[string]string {
+		return awsConnection(address, env["MINIO_ACCESS_KEY"], env["MINIO_SECRET_KEY"])
+	},
+	"wiremock": func(address string, env map[string]string) map[string]string {
+		return map[string]string{
+			"base_url":  (&url.URL{Scheme: "http", Host: address}).String(),
+			"admin_url": (&url.URL{Scheme: "http", Host: address, Path: "/__admin"}).String(),
+		}
+	},
+}
+
+// BuildConnection assembles ready to use connection settings for the main port of a claimed component.
+// It returns nil for component types without a known connection format.
+func BuildConnection(componentType string, claim *Claim) map[string]string {
+	var ok bool
+	var builder connectionBuilder
+
+	if builder, ok = connectionBuilders[componentType]; !ok || len(claim.Service.Spec.Ports) == 0 {
+		return nil
+	}
+
+	port := claim.Service.Spec.Ports[0]
+	for _, p := range claim.Service.Spec.Ports {
+		if p.Name == "main" {
+			port = p
+		}
+	}
+
+	host := fmt.Sprintf("%s.%s", claim.Service.GetName(), claim.Service.Namespace)
+	address := net.JoinHostPort(host, fmt.Sprint(port.Port))
+
+	// generated credentials are read by the container from its secret, so they aren't part of the container env
+	env := containerEnv(claim.Deployment.Spec.Template.Spec.Containers)
+	if claim.Credentials != nil {
+		maps.Copy(env, claim.Credentials.Env(componentType))
+	}
+
+	return builder(address, env)
+}
+
+func awsConnection(address string, accessKeyId string, secretAccessKey string) map[string]string {
+	return map[string]string{
+		"endpoint":          (&url.URL{Scheme: "http", Host: address}).String(),
+		"region":            defaultAwsRegion,
+		"access_key_id":     accessKeyId,
+		"secret_access_key": secretAccessKey,
+	}
+}
+
+func containerEnv(containers []apiv1.Container) map[string]string {
+	env := map[string]string{}
+
+	for _, container := range containers {
+		for _, v := range container.Env {
+			env[v.Name] = v.Value
+		}
+	}
+
+	return env
+}
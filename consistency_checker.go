@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	metricInconsistentObjects = "InconsistentObjects"
+	eventReasonInconsistent   = "Inconsistent"
+)
+
+// ConsistencySettings control the check of the labels and annotations of the deployments and services. With
+// repair disabled the inconsistencies are only flagged.
+type ConsistencySettings struct {
+	Enabled bool `cfg:"enabled" default:"true"`
+	Repair  bool `cfg:"repair" default:"true"`
+}
+
+// inconsistency describes labels and annotations of an object which disagree. The patch op repairs it, an
+// inconsistency without one can only be flagged.
+type inconsistency struct {
+	problem string
+	repair  string
+}
+
+// ConsistencyChecker finds deployments and services whose labels and annotations disagree, e.g. an idle
+// deployment carrying the id of a test or a missing expiry, and repairs or flags them. A repaired idle
+// deployment loses its idle label, so the claim path never hands it out.
+type ConsistencyChecker struct {
+	logger      log.Logger
+	clock       clock.Clock
+	metric      metric.Writer
+	k8sClient   *K8sClient
+	poolManager *ServicePoolManager
+	settings    *ConsistencySettings
+}
+
+func NewConsistencyChecker(ctx context.Context, config cfg.Config, logger log.Logger) (*ConsistencyChecker, error) {
+	var err error
+	var k8sClient *K8sClient
+	var poolManager *ServicePoolManager
+
+	settings := &ConsistencySettings{}
+	if err = config.UnmarshalKey("consistency", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal consistency settings: %w", err)
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	return &ConsistencyChecker{
+		logger:      logger.WithChannel("consistency"),
+		clock:       clock.NewRealClock(),
+		metric:      metric.NewWriter(),
+		k8sClient:   k8sClient,
+		poolManager: poolManager,
+		settings:    settings,
+	}, nil
+}
+
+func (c *ConsistencyChecker) Check(ctx context.Context) error {
+	if !c.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	var deployments []*appsv1.Deployment
+	var services []*apiv1.Service
+
+	if deployments, err = c.k8sClient.ListDeployments(ctx); err != nil {
+		return fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	if services, err = c.k8sClient.ListServices(ctx); err != nil {
+		return fmt.Errorf("could not list services: %w", err)
+	}
+
+	for _, deployment := range deployments {
+		c.reconcile(ctx, deployment, func(ops []string) error {
+			_, err := c.k8sClient.PatchDeployment(ctx, deployment, ops)
+
+			return err
+		})
+	}
+
+	for _, service := range services {
+		c.reconcile(ctx, service, func(ops []string) error {
+			_, err := c.k8sClient.PatchService(ctx, service, ops)
+
+			return err
+		})
+	}
+
+	return nil
+}
+
+func (c *ConsistencyChecker) reconcile(ctx context.Context, object Objecter, patch func(ops []string) error) {
+	inconsistencies := c.inspect(object)
+	if len(inconsistencies) == 0 {
+		return
+	}
+
+	problems := make([]string, 0, len(inconsistencies))
+	ops := make([]string, 0, len(inconsistencies))
+
+	for _, inconsistency := range inconsistencies {
+		problems = append(problems, inconsistency.problem)
+
+		if inconsistency.repair != "" {
+			ops = append(ops, inconsistency.repair)
+		}
+	}
+
+	message := strings.Join(problems, ", ")
+
+	c.metric.WriteOne(ctx, claimMetric(metricInconsistentObjects, object))
+	c.poolManager.events.Record(ctx, object, apiv1.EventTypeWarning, eventReasonInconsistent, message)
+	c.logger.Warn(ctx, "%q of pool %q is inconsistent: %s", object.GetName(), object.GetLabels()[LabelPoolId], message)
+
+	if !c.settings.Repair || len(ops) == 0 {
+		return
+	}
+
+	if err := patch(ops); err != nil {
+		c.logger.Warn(ctx, "could not repair %q: %s", object.GetName(), err.Error())
+
+		return
+	}
+
+	c.logger.Info(ctx, "repaired %d of %d inconsistencies of %q", len(ops), len(inconsistencies), object.GetName())
+}
+
+// inspect returns the inconsistencies of an object spawned for a pool. Objects of dependencies, aliases and
+// objects which are being deleted don't carry the claim labels and are skipped.
+func (c *ConsistencyChecker) inspect(object Objecter) []inconsistency {
+	labels := object.GetLabels()
+	annotations := object.GetAnnotations()
+
+	if labels[LableUid] == "" || labels[LabelDependencyOf] != "" {
+		return nil
+	}
+
+	if meta, ok := object.(metav1.Object); ok && meta.GetDeletionTimestamp() != nil {
+		return nil
+	}
+
+	inconsistencies := make([]inconsistency, 0)
+	idle := labels[LableIdle] == "true"
+	testId := labels[LabelTestId]
+
+	switch {
+	case idle && testId != "":
+		inconsistencies = append(inconsistencies, inconsistency{
+			problem: fmt.Sprintf("idle, but claimed by test %q", testId),
+			repair:  PatchOp("remove", "labels", LableIdle, nil),
+		})
+	case !idle && testId == "":
+		inconsistencies = append(inconsistencies, inconsistency{
+			problem: "neither idle nor claimed by a test",
+		})
+	}
+
+	componentType := annotations[AnnotationComponentType]
+	if componentType != "" && K8sNameString(componentType) != labels[LabelComponentType] {
+		inconsistencies = append(inconsistencies, inconsistency{
+			problem: fmt.Sprintf("component type label %q disagrees with the annotation %q", labels[LabelComponentType], componentType),
+		})
+	}
+
+	expireAfter, ok := annotations[AnnotationExpireAfter]
+	if !ok {
+		inconsistencies = append(inconsistencies, inconsistency{
+			problem: "expire after is missing",
+			repair:  PatchOp("add", "annotations", AnnotationExpireAfter, c.expireAt(object, componentType, idle)),
+		})
+
+		return inconsistencies
+	}
+
+	if _, err := time.Parse(time.RFC3339, expireAfter); err != nil {
+		inconsistencies = append(inconsistencies, inconsistency{
+			problem: fmt.Sprintf("expire after %q is invalid", expireAfter),
+			repair:  PatchOp("replace", "annotations", AnnotationExpireAfter, c.expireAt(object, componentType, idle)),
+		})
+	}
+
+	return inconsistencies
+}
+
+// expireAt returns the expiry a repaired object gets: the one of a fresh idle deployment or of a claim without
+// an expire after, counted from now.
+func (c *ConsistencyChecker) expireAt(object Objecter, componentType string, idle bool) string {
+	policy := c.poolManager.retention.For(componentType)
+
+	ttl := policy.DefaultTtl
+	if idle {
+		ttl = policy.IdleTtl
+	}
+
+	createdAt := c.clock.Now()
+	if meta, ok := object.(metav1.Object); ok {
+		createdAt = meta.GetCreationTimestamp().Time
+	}
+
+	return policy.ExpireAt(createdAt, c.clock.Now(), ttl).Format(time.RFC3339)
+}
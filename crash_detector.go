@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	reasonCrashLoopBackOff = "CrashLoopBackOff"
+
+	eventReasonClaimFailed = "ClaimFailed"
+)
+
+type CrashDetectorSettings struct {
+	Enabled      bool `cfg:"enabled" default:"true"`
+	RestartLimit int  `cfg:"restart_limit" default:"5"`
+	// CrashLoopBackOff fails a claim as soon as one of its containers is backed off, regardless of the restart limit.
+	CrashLoopBackOff bool `cfg:"crash_loop_back_off" default:"true"`
+	// Replace releases a failed claim and claims a fresh deployment of the same component type for the test.
+	Replace bool                         `cfg:"replace" default:"false"`
+	Webhook CrashDetectorWebhookSettings `cfg:"webhook"`
+}
+
+type CrashDetectorWebhookSettings struct {
+	Url     string        `cfg:"url"`
+	Timeout time.Duration `cfg:"timeout" default:"5s"`
+}
+
+// ClaimFailedNotification is posted to the configured webhook for every claim marked as failed.
+type ClaimFailedNotification struct {
+	Event         string `json:"event"`
+	PoolId        string `json:"pool_id"`
+	TestId        string `json:"test_id"`
+	ClaimId       string `json:"claim_id"`
+	ComponentType string `json:"component_type"`
+	Reason        string `json:"reason"`
+	ReplacedBy    string `json:"replaced_by,omitempty"`
+}
+
+// CrashDetector marks claims as failed if their container restarts more often than the restart limit
+// of the spec allows or their pod is disrupted, so crash loops and preempted nodes surface in the claim
+// status instead of letting the test time out.
+type CrashDetector struct {
+	logger      log.Logger
+	clock       clock.Clock
+	metric      metric.Writer
+	client      *http.Client
+	k8sClient   *K8sClient
+	poolManager *ServicePoolManager
+	settings    *CrashDetectorSettings
+}
+
+func NewCrashDetector(ctx context.Context, config cfg.Config, logger log.Logger) (*CrashDetector, error) {
+	var err error
+	var k8sClient *K8sClient
+	var poolManager *ServicePoolManager
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	settings := &CrashDetectorSettings{}
+	if err = config.UnmarshalKey("crash_detector", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal crash detector settings: %w", err)
+	}
+
+	return &CrashDetector{
+		logger:      logger.WithChannel("crash-detector"),
+		clock:       clock.NewRealClock(),
+		metric:      metric.NewWriter(),
+		client:      &http.Client{Timeout: settings.Webhook.Timeout},
+		k8sClient:   k8sClient,
+		poolManager: poolManager,
+		settings:    settings,
+	}, nil
+}
+
+func (d *CrashDetector) Check(ctx context.Context) error {
+	if !d.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	var deployments []*appsv1.Deployment
+	var pods []*apiv1.Pod
+
+	if deployments, err = d.k8sClient.ListDeployments(ctx); err != nil {
+		return fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	if pods, err = d.k8sClient.ListPods(ctx); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+
+	podsByUid := map[string][]*apiv1.Pod{}
+	for _, pod := range pods {
+		uid := pod.GetLabels()[LableUid]
+		podsByUid[uid] = append(podsByUid[uid], pod)
+	}
+
+	for _, deployment := range deployments {
+		if !isClaimed(deployment) || deployment.GetAnnotations()[AnnotationClaimStatus] == ClaimStatusFailed {
+			continue
+		}
+
+		reason, crashed := d.crashReason(deployment, podsByUid[deployment.GetLabels()[LableUid]])
+		if !crashed {
+			continue
+		}
+
+		if err = d.markFailed(ctx, deployment, reason); err != nil {
+			return fmt.Errorf("could not mark claim of deployment %q as failed: %w", deployment.GetName(), err)
+		}
+
+		d.poolManager.events.Record(ctx, deployment, apiv1.EventTypeWarning, eventReasonClaimFailed, reason)
+
+		replacedBy := ""
+		if d.settings.Replace {
+			replacedBy = d.replace(ctx, deployment)
+		}
+
+		d.notify(ctx, deployment, reason, replacedBy)
+	}
+
+	return nil
+}
+
+func (d *CrashDetector) crashReason(deployment *appsv1.Deployment, pods []*apiv1.Pod) (string, bool) {
+	limit := d.settings.RestartLimit
+
+	if value, ok := deployment.GetAnnotations()[AnnotationRestartLimit]; ok {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			limit = parsed
+		}
+	}
+
+	for _, pod := range pods {
+		if reason, disrupted := disruptionReason(pod); disrupted {
+			return reason, true
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			if d.settings.CrashLoopBackOff && status.State.Waiting != nil && status.State.Waiting.Reason == reasonCrashLoopBackOff {
+				return fmt.Sprintf("container %q of pod %q is in %s after %d restarts: %s", status.Name, pod.GetName(), reasonCrashLoopBackOff, status.RestartCount, status.State.Waiting.Message), true
+			}
+
+			if int(status.RestartCount) <= limit {
+				continue
+			}
+
+			reason := "restarted"
+			if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+				reason = status.State.Waiting.Reason
+			} else if status.LastTerminationState.Terminated != nil {
+				reason = status.LastTerminationState.Terminated.Reason
+			}
+
+			return fmt.Sprintf("container %q of pod %q exceeded the restart limit of %d with %d restarts: %s", status.Name, pod.GetName(), limit, status.RestartCount, reason), true
+		}
+	}
+
+	return "", false
+}
+
+func (d *CrashDetector) markFailed(ctx context.Context, deployment *appsv1.Deployment, reason string) error {
+	var err error
+	var service *apiv1.Service
+
+	ops := []string{
+		PatchOp("add", "annotations", AnnotationClaimStatus, ClaimStatusFailed),
+		PatchOp("add", "annotations", AnnotationClaimReason, reason),
+	}
+
+	if _, err = d.k8sClient.PatchDeployment(ctx, deployment, ops); err != nil {
+		return fmt.Errorf("could not patch deployment: %w", err)
+	}
+
+	if service, err = d.k8sClient.GetService(ctx, deployment.GetName()); err != nil {
+		return fmt.Errorf("could not get service: %w", err)
+	}
+
+	if _, err = d.k8sClient.PatchService(ctx, service, ops); err != nil {
+		return fmt.Errorf("could not patch service: %w", err)
+	}
+
+	d.metric.WriteOne(ctx, claimMetric(metricClaimFailures, deployment))
+	d.logger.Warn(ctx, "marked claim of deployment %q in pool %q for test %q as failed: %s", deployment.GetName(), deployment.GetLabels()[LabelPoolId], deployment.GetLabels()[LabelTestId], reason)
+
+	return nil
+}
+
+// replace claims a fresh deployment for the test and releases the failed claim. It returns the id of the new
+// claim or an empty string if the claim couldn't be replaced.
+func (d *CrashDetector) replace(ctx context.Context, deployment *appsv1.Deployment) string {
+	claim, err := d.poolManager.ReplaceFailedClaim(ctx, deployment, nil)
+	if err != nil {
+		d.logger.Warn(ctx, "could not replace failed claim of deployment %q: %s", deployment.GetName(), err.Error())
+
+		return ""
+	}
+
+	d.logger.Info(ctx, "replaced failed claim of deployment %q with claim %q", deployment.GetName(), claim.GetId())
+
+	return claim.GetId()
+}
+
+func (d *CrashDetector) notify(ctx context.Context, deployment *appsv1.Deployment, reason string, replacedBy string) {
+	if d.settings.Webhook.Url == "" {
+		return
+	}
+
+	notification := ClaimFailedNotification{
+		Event:         "claim_failed",
+		PoolId:        deployment.GetLabels()[LabelPoolId],
+		TestId:        deployment.GetLabels()[LabelTestId],
+		ClaimId:       deployment.GetLabels()[LableUid],
+		ComponentType: deployment.GetAnnotations()[AnnotationComponentType],
+		Reason:        reason,
+		ReplacedBy:    replacedBy,
+	}
+
+	if err := d.post(ctx, notification); err != nil {
+		d.logger.Warn(ctx, "could not notify about failed claim of deployment %q: %s", deployment.GetName(), err.Error())
+	}
+}
+
+func (d *CrashDetector) post(ctx context.Context, notification ClaimFailedNotification) error {
+	var err error
+	var body []byte
+	var req *http.Request
+	var resp *http.Response
+
+	if body, err = json.Marshal(notification); err != nil {
+		return fmt.Errorf("could not encode notification: %w", err)
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, d.settings.Webhook.Url, bytes.NewReader(body)); err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if resp, err = d.client.Do(req); err != nil {
+		return fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// disruptionReason reports whether the pod is about to be terminated by kubernetes, e.g. because its spot node
+// is preempted or drained. The state of the container is lost then, even if the pod is rescheduled.
+func disruptionReason(pod *apiv1.Pod) (string, bool) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == apiv1.DisruptionTarget && condition.Status == apiv1.ConditionTrue {
+			return fmt.Sprintf("pod %q on node %q is disrupted: %s: %s", pod.GetName(), pod.Spec.NodeName, condition.Reason, condition.Message), true
+		}
+	}
+
+	return "", false
+}
+
+func isClaimed(object Labler) bool {
+	_, ok := object.GetLabels()[LabelTestId]
+
+	return ok
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// CredentialEnvs names the env variables of a component type which receive the generated username and password.
+type CredentialEnvs struct {
+	Username []string
+	Password []string
+}
+
+var credentialEnvs = map[string]CredentialEnvs{
+	"mysql": {
+		Username: []string{"MYSQL_USER"},
+		Password: []string{"MYSQL_PASSWORD", "MYSQL_ROOT_PASSWORD"},
+	},
+	"s3": {
+		Username: []string{"MINIO_ACCESS_KEY"},
+		Password: []string{"MINIO_SECRET_KEY"},
+	},
+}
+
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Env returns the env variables of the component type which receive the credentials.
+func (c *Credentials) Env(componentType string) map[string]string {
+	envs := credentialEnvs[componentType]
+	env := make(map[string]string, len(envs.Username)+len(envs.Password))
+
+	for _, name := range envs.Username {
+		env[name] = c.Username
+	}
+
+	for _, name := range envs.Password {
+		env[name] = c.Password
+	}
+
+	return env
+}
+
+// GenerateCredentials creates a random username and password for the given component type and returns
+// a copy of the spec with the credentials injected into the matching env variables. They are kept in the secret
+// env of the spec, so they don't end up in the manifest of the deployment.
+func GenerateCredentials(componentType string, spec ContainerSpec) (*Credentials, ContainerSpec, error) {
+	var err error
+
+	if _, ok := credentialEnvs[componentType]; !ok {
+		return nil, spec, fmt.Errorf("component type %q doesn't support generated credentials", componentType)
+	}
+
+	credentials := &Credentials{}
+
+	if credentials.Username, err = randomString(10); err != nil {
+		return nil, spec, fmt.Errorf("could not generate username: %w", err)
+	}
+
+	if credentials.Password, err = randomString(24); err != nil {
+		return nil, spec, fmt.Errorf("could not generate password: %w", err)
+	}
+
+	credentials.Username = "u" + strings.ToLower(credentials.Username)
+
+	env := maps.Clone(spec.Env)
+	secretEnv := make(map[string]string, len(spec.SecretEnv))
+	maps.Copy(secretEnv, spec.SecretEnv)
+
+	for name, value := range credentials.Env(componentType) {
+		delete(env, name)
+		secretEnv[name] = value
+	}
+
+	spec.Env = env
+	spec.SecretEnv = secretEnv
+
+	return credentials, spec, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/uuid"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+type DebugSettings struct {
+	Image   string   `cfg:"image" default:"busybox:1.36"`
+	Command []string `cfg:"command"`
+}
+
+type DebugInput struct {
+	Uid     string `uri:"uid"`
+	Image   string `json:"image"`
+	PoolKey string `header:"X-Pool-Key" json:"-"`
+}
+
+type DebugOutput struct {
+	Namespace     string `json:"namespace"`
+	Pod           string `json:"pod"`
+	Container     string `json:"container"`
+	Image         string `json:"image"`
+	AttachCommand string `json:"attach_command"`
+}
+
+type ContainerDebugger struct {
+	logger    log.Logger
+	k8sClient *K8sClient
+	settings  *DebugSettings
+}
+
+func NewContainerDebugger(ctx context.Context, config cfg.Config, logger log.Logger) (*ContainerDebugger, error) {
+	var err error
+	var k8sClient *K8sClient
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	settings := &DebugSettings{}
+	if err = config.UnmarshalKey("debug", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal debug settings: %w", err)
+	}
+
+	return &ContainerDebugger{
+		logger:    logger.WithChannel("debug"),
+		k8sClient: k8sClient,
+		settings:  settings,
+	}, nil
+}
+
+// Attach adds an ephemeral container sharing the process namespace of the main container to the
+// pod of the given deployment uid and returns the information needed to attach to it.
+func (d *ContainerDebugger) Attach(ctx context.Context, input *DebugInput) (*DebugOutput, error) {
+	var err error
+	var pods []*apiv1.Pod
+	var pod *apiv1.Pod
+
+	if pods, err = d.k8sClient.ListPods(ctx, map[string]string{LableUid: input.Uid}); err != nil {
+		return nil, fmt.Errorf("could not list pods: %w", err)
+	}
+
+	for _, p := range pods {
+		if p.Status.Phase == apiv1.PodRunning {
+			pod = p
+
+			break
+		}
+	}
+
+	if pod == nil {
+		return nil, fmt.Errorf("there is no running pod for uid %q", input.Uid)
+	}
+
+	image := d.settings.Image
+	if input.Image != "" {
+		image = input.Image
+	}
+
+	name := K8sNameString("debug", strings.Split(uuid.New().NewV4(), "-")[0])
+	container := apiv1.EphemeralContainer{
+		EphemeralContainerCommon: apiv1.EphemeralContainerCommon{
+			Name:    name,
+			Image:   image,
+			Command: d.settings.Command,
+			Stdin:   true,
+			TTY:     true,
+		},
+		TargetContainerName: "main",
+	}
+
+	if _, err = d.k8sClient.AddEphemeralContainer(ctx, pod, container); err != nil {
+		return nil, fmt.Errorf("could not attach debug container: %w", err)
+	}
+
+	d.logger.Info(ctx, "attached debug container %q with image %q to pod %q", name, image, pod.GetName())
+
+	return &DebugOutput{
+		Namespace:     d.k8sClient.Namespace(),
+		Pod:           pod.GetName(),
+		Container:     name,
+		Image:         image,
+		AttachCommand: fmt.Sprintf("kubectl attach -it -n %s %s -c %s", d.k8sClient.Namespace(), pod.GetName(), name),
+	}, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/justtrackio/gosoline/pkg/mdl"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// CreateDependencies creates the deployments and services of the dependencies of a component. The deployment
+// of the component owns all of them, so they are garbage collected together with it.
+func (f *TestContainerFactory) CreateDependencies(uid string, input SpawnAble, owner *appsv1.Deployment) ([]*appsv1.Deployment, []*apiv1.Service, error) {
+	var err error
+	var spec ContainerSpec
+	var container apiv1.Container
+
+	dependencies := input.GetSpec().Dependencies
+	data := f.templateData(uid, f.objectName(uid, input), input)
+
+	deployments := make([]*appsv1.Deployment, 0, len(dependencies))
+	services := make([]*apiv1.Service, 0, len(dependencies))
+
+	for _, dependency := range dependencies {
+		name := f.dependencyName(uid, dependency.Name)
+		labels := map[string]string{
+			LabelDependencyOf: uid,
+			LabelDependency:   K8sNameString(dependency.Name),
+		}
+
+		if spec, err = RenderSpec(dependency.containerSpec(), data); err != nil {
+			return nil, nil, fmt.Errorf("could not render spec of dependency %q: %w", dependency.Name, err)
+		}
+
+		if container, err = f.mainContainer(spec); err != nil {
+			return nil, nil, fmt.Errorf("invalid dependency %q: %w", dependency.Name, err)
+		}
+
+		deployments = append(deployments, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Labels:          labels,
+				OwnerReferences: ownerReferences(owner),
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: mdl.Box(int32(1)),
+				Selector: &metav1.LabelSelector{
+					MatchLabels: labels,
+				},
+				Template: apiv1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: owner.Spec.Template.GetAnnotations(),
+						Labels:      labels,
+					},
+					Spec: apiv1.PodSpec{
+						InitContainers: f.waitForDependencies(uid, dependencies, dependency.DependsOn),
+						Containers:     []apiv1.Container{container},
+						NodeSelector:   owner.Spec.Template.Spec.NodeSelector,
+						Tolerations:    owner.Spec.Template.Spec.Tolerations,
+						RestartPolicy:  apiv1.RestartPolicyAlways,
+					},
+				},
+			},
+		})
+
+		ports := make([]apiv1.ServicePort, 0)
+		for portName, portConfig := range dependency.PortBindings {
+			ports = append(ports, apiv1.ServicePort{
+				Name:       K8sNameString(portName),
+				Protocol:   apiv1.Protocol(strings.ToUpper(portConfig.Protocol)),
+				Port:       int32(portConfig.ContainerPort),
+				TargetPort: intstr.FromString(K8sNameString(portName)),
+			})
+		}
+
+		services = append(services, &apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Labels:          labels,
+				OwnerReferences: ownerReferences(owner),
+			},
+			Spec: apiv1.ServiceSpec{
+				Selector: labels,
+				Ports:    ports,
+				Type:     apiv1.ServiceTypeClusterIP,
+			},
+		})
+	}
+
+	return deployments, services, nil
+}
+
+// waitForDependencies returns an init container per dependency, which blocks until the service of the
+// dependency accepts connections on its main port.
+func (f *TestContainerFactory) waitForDependencies(uid string, dependencies []DependencySpec, names []string) []apiv1.Container {
+	containers := make([]apiv1.Container, 0, len(names))
+
+	for _, dependency := range dependencies {
+		if !slices.Contains(names, dependency.Name) {
+			continue
+		}
+
+		host := fmt.Sprintf("%s.%s", f.dependencyName(uid, dependency.Name), f.namespace)
+		containers = append(containers, apiv1.Container{
+			Name:    K8sNameString("wait", dependency.Name),
+			Image:   f.settings.Dependencies.WaitImage,
+			Command: []string{"sh", "-c", fmt.Sprintf("until nc -z %s %d; do sleep 1; done", host, dependency.mainPort())},
+		})
+	}
+
+	return containers
+}
+
+func (f *TestContainerFactory) dependencyName(uid string, dependency string) string {
+	return K8sNameString("tc", uid, dependency)
+}
+
+func (s DependencySpec) containerSpec() ContainerSpec {
+	return ContainerSpec{
+		Repository:   s.Repository,
+		Tag:          s.Tag,
+		Env:          s.Env,
+		Cmd:          s.Cmd,
+		PortBindings: s.PortBindings,
+	}
+}
+
+// mainPort returns the port named main or, if there is none, the first port by name.
+func (s DependencySpec) mainPort() int {
+	if port, ok := s.PortBindings["main"]; ok {
+		return port.ContainerPort
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(s.PortBindings)) {
+		return s.PortBindings[name].ContainerPort
+	}
+
+	return 0
+}
+
+func dependencyNames(dependencies []DependencySpec) []string {
+	names := make([]string, 0, len(dependencies))
+	for _, dependency := range dependencies {
+		names = append(names, dependency.Name)
+	}
+
+	return names
+}
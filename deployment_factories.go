@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+)
+
+const DeploymentFactoryTestContainer = "test_container"
+
+// deploymentFactories holds the implementations a pool can be configured with by name.
+var deploymentFactories = map[string]func(config cfg.Config) (DeploymentFactory, error){
+	DeploymentFactoryTestContainer: func(config cfg.Config) (DeploymentFactory, error) {
+		return NewTestContainerFactory(config)
+	},
+}
+
+// DeploymentFactorySettings select the implementation building the objects of a pool. Pools without an entry
+// use the default one.
+type DeploymentFactorySettings struct {
+	Default string            `cfg:"default" default:"test_container"`
+	Pools   map[string]string `cfg:"pools"`
+}
+
+type DeploymentFactories struct {
+	base  DeploymentFactory
+	pools map[string]DeploymentFactory
+}
+
+func NewDeploymentFactories(config cfg.Config) (*DeploymentFactories, error) {
+	var err error
+
+	settings := &DeploymentFactorySettings{}
+	if err = config.UnmarshalKey("deployment_factory", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal deployment factory settings: %w", err)
+	}
+
+	factories := &DeploymentFactories{
+		pools: map[string]DeploymentFactory{},
+	}
+
+	if factories.base, err = newDeploymentFactory(config, "default", settings.Default); err != nil {
+		return nil, err
+	}
+
+	for poolId, name := range settings.Pools {
+		if factories.pools[poolId], err = newDeploymentFactory(config, poolId, name); err != nil {
+			return nil, err
+		}
+	}
+
+	return factories, nil
+}
+
+// For returns the factory of the pool.
+func (f *DeploymentFactories) For(poolId string) DeploymentFactory {
+	if factory, ok := f.pools[poolId]; ok {
+		return factory
+	}
+
+	return f.base
+}
+
+func newDeploymentFactory(config cfg.Config, poolId string, name string) (DeploymentFactory, error) {
+	newFactory, ok := deploymentFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("deployment factory of %q has to be one of %q but is %q", poolId, slices.Sorted(maps.Keys(deploymentFactories)), name)
+	}
+
+	factory, err := newFactory(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create deployment factory %q of %q: %w", name, poolId, err)
+	}
+
+	return factory, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/clock"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+var ErrMainContainerNotFound = errors.New("main container not found")
+
+// UpdateEnvInput sets and removes env variables of the main container of a claim. With a wait the request
+// blocks until the rollout of the new pod finished or the wait elapsed.
+type UpdateEnvInput struct {
+	Uid     string            `uri:"uid"`
+	Env     map[string]string `json:"env"`
+	Remove  []string          `json:"remove"`
+	Wait    time.Duration     `json:"wait"`
+	PoolKey string            `header:"X-Pool-Key" json:"-"`
+}
+
+type UpdateEnvOutput struct {
+	ClaimId string `json:"claim_id"`
+	Status  string `json:"status"`
+}
+
+// UpdateEnv replaces the env of the main container of a claimed deployment, which rolls out a new pod. The
+// deployment surges the new pod and removes the old one only once the new one is available, so the service
+// of the claim keeps answering during the rollout.
+func (c *ServicePoolManager) UpdateEnv(ctx context.Context, input *UpdateEnvInput) (*UpdateEnvOutput, error) {
+	var err error
+	var claim *Claim
+	var deployment *appsv1.Deployment
+	var env []byte
+
+	if claim, err = c.GetClaim(ctx, input.Uid); err != nil {
+		return nil, err
+	}
+
+	ctx = withObjectFields(ctx, claim.Deployment)
+
+	index := mainContainerIndex(claim.Deployment)
+	if index == -1 {
+		return nil, fmt.Errorf("could not update env of claim %q: %w", input.Uid, ErrMainContainerNotFound)
+	}
+
+	if env, err = json.Marshal(updatedEnv(claim.Deployment.Spec.Template.Spec.Containers[index].Env, input.Env, input.Remove)); err != nil {
+		return nil, fmt.Errorf("could not encode env: %w", err)
+	}
+
+	// the test op keeps the patch from changing another container if the containers changed since the read
+	ops := []string{
+		fmt.Sprintf(`{"op": "test", "path": "/spec/template/spec/containers/%d/name", "value": "main"}`, index),
+		fmt.Sprintf(`{"op": "add", "path": "/spec/template/spec/containers/%d/env", "value": %s}`, index, env),
+	}
+
+	if deployment, err = c.k8sClient.PatchDeployment(ctx, claim.Deployment, ops); err != nil {
+		return nil, fmt.Errorf("could not update env of claim %q: %w", input.Uid, err)
+	}
+
+	c.logger.Info(ctx, "updated env of claim %q: set %d and removed %d variables", input.Uid, len(input.Env), len(input.Remove))
+
+	output := &UpdateEnvOutput{
+		ClaimId: input.Uid,
+		Status:  rolloutStatus(deployment),
+	}
+
+	if input.Wait <= 0 {
+		return output, nil
+	}
+
+	if output.Status, err = waitForRollout(ctx, c.clock, c.k8sClient, deployment, input.Wait); err != nil {
+		return nil, fmt.Errorf("could not wait for the rollout of claim %q: %w", input.Uid, err)
+	}
+
+	return output, nil
+}
+
+func mainContainerIndex(deployment *appsv1.Deployment) int {
+	return slices.IndexFunc(deployment.Spec.Template.Spec.Containers, func(container apiv1.Container) bool {
+		return container.Name == "main"
+	})
+}
+
+// updatedEnv sets the values of the given variables, keeping the order of the existing ones, and drops the
+// removed ones. A set variable loses a value it referenced from a secret or config map before.
+func updatedEnv(current []apiv1.EnvVar, set map[string]string, remove []string) []apiv1.EnvVar {
+	env := make([]apiv1.EnvVar, 0, len(current)+len(set))
+	seen := map[string]bool{}
+
+	for _, envVar := range current {
+		if slices.Contains(remove, envVar.Name) {
+			continue
+		}
+
+		if value, ok := set[envVar.Name]; ok {
+			envVar = apiv1.EnvVar{Name: envVar.Name, Value: value}
+		}
+
+		seen[envVar.Name] = true
+		env = append(env, envVar)
+	}
+
+	added := make([]string, 0, len(set))
+	for name := range set {
+		if !seen[name] && !slices.Contains(remove, name) {
+			added = append(added, name)
+		}
+	}
+
+	sort.Strings(added)
+
+	for _, name := range added {
+		env = append(env, apiv1.EnvVar{Name: name, Value: set[name]})
+	}
+
+	return env
+}
+
+// rolloutStatus is ready once the deployment observed its latest spec and all of its pods run it and are
+// available.
+func rolloutStatus(deployment *appsv1.Deployment) string {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	status := deployment.Status
+
+	switch {
+	case status.ObservedGeneration < deployment.GetGeneration():
+		return ClaimStatusPending
+	case status.UpdatedReplicas < replicas, status.Replicas > status.UpdatedReplicas, status.AvailableReplicas < status.UpdatedReplicas:
+		return ClaimStatusPending
+	default:
+		return ClaimStatusReady
+	}
+}
+
+// waitForRollout polls the deployment until its rollout finished or the wait elapsed and returns the last
+// observed status.
+func waitForRollout(ctx context.Context, clk clock.Clock, k8sClient *K8sClient, deployment *appsv1.Deployment, wait time.Duration) (string, error) {
+	var err error
+
+	timer := clk.NewTimer(wait)
+	defer timer.Stop()
+
+	ticker := clk.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		if status := rolloutStatus(deployment); status != ClaimStatusPending {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ClaimStatusPending, nil
+		case <-timer.Chan():
+			return ClaimStatusPending, nil
+		case <-ticker.Chan():
+		}
+
+		if deployment, err = k8sClient.GetDeployment(ctx, deployment.GetName()); err != nil && ctx.Err() != nil {
+			return ClaimStatusPending, nil
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("could not get deployment: %w", err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/appctx"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	eventReasonClaimed  = "Claimed"
+	eventReasonExtended = "Extended"
+	eventReasonExpiring = "Expiring"
+	eventReasonReleased = "Released"
+)
+
+// EventSettings control whether kubrun records its actions as kubernetes events on the deployments and
+// services, so kubectl describe tells operators who claimed an object and until when.
+type EventSettings struct {
+	Enabled bool `cfg:"enabled" default:"true"`
+}
+
+type eventRecorderKey struct{}
+
+func ProvideEventRecorder(ctx context.Context, config cfg.Config, logger log.Logger) (*EventRecorder, error) {
+	return appctx.Provide(ctx, eventRecorderKey{}, func() (*EventRecorder, error) {
+		var err error
+		var k8sClient *K8sClient
+
+		settings := &EventSettings{}
+		if err = config.UnmarshalKey("events", settings); err != nil {
+			return nil, fmt.Errorf("could not unmarshal event settings: %w", err)
+		}
+
+		if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+			return nil, fmt.Errorf("could not create k8s client: %w", err)
+		}
+
+		return &EventRecorder{
+			logger:    logger.WithChannel("events"),
+			clock:     clock.NewRealClock(),
+			k8sClient: k8sClient,
+			settings:  settings,
+		}, nil
+	})
+}
+
+type EventRecorder struct {
+	logger    log.Logger
+	clock     clock.Clock
+	k8sClient *K8sClient
+	settings  *EventSettings
+}
+
+// Record attaches an event to a deployment or service. Events are informational, so a failure to record one
+// is only logged and never fails the action it describes.
+func (r *EventRecorder) Record(ctx context.Context, object Objecter, eventType string, reason string, message string) {
+	if !r.settings.Enabled {
+		return
+	}
+
+	var meta metav1.Object
+	involved := apiv1.ObjectReference{}
+
+	switch typed := object.(type) {
+	case *appsv1.Deployment:
+		meta = typed
+		involved.APIVersion, involved.Kind = "apps/v1", "Deployment"
+	case *apiv1.Service:
+		meta = typed
+		involved.APIVersion, involved.Kind = "v1", "Service"
+	default:
+		r.logger.Warn(ctx, "can't record events on %T %q", object, object.GetName())
+
+		return
+	}
+
+	involved.Name = meta.GetName()
+	involved.Namespace = meta.GetNamespace()
+	involved.UID = meta.GetUID()
+
+	now := metav1.NewTime(r.clock.Now())
+
+	event := &apiv1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s-", meta.GetName()),
+			Namespace:    meta.GetNamespace(),
+		},
+		InvolvedObject: involved,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         apiv1.EventSource{Component: "kubrun"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	if _, err := r.k8sClient.CreateEvent(ctx, event); err != nil {
+		r.logger.Warn(ctx, "could not record %s event on %s %q: %s", reason, involved.Kind, object.GetName(), err.Error())
+	}
+}
+
+// RecordClaim records the claim on the deployment and the service of the claim.
+func (r *EventRecorder) RecordClaim(ctx context.Context, claim *Claim, input *RunInput) {
+	test := input.TestId
+	if input.TestName != "" {
+		test = fmt.Sprintf("%s (%s)", input.TestName, input.TestId)
+	}
+
+	expireAt := r.clock.Now().Add(input.ExpireAfter).Format(time.RFC3339)
+	message := fmt.Sprintf("claimed by test %s of pool %q until %s", test, input.PoolId, expireAt)
+
+	r.Record(ctx, claim.Deployment, apiv1.EventTypeNormal, eventReasonClaimed, message)
+
+	if claim.Service != nil {
+		r.Record(ctx, claim.Service, apiv1.EventTypeNormal, eventReasonClaimed, message)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+const defaultExpiringWithin = 15 * time.Minute
+
+type ExpiringInput struct {
+	Within time.Duration `form:"within"`
+	PoolId string        `form:"pool_id"`
+}
+
+// ExpiringDeployment is a deployment, together with its service, which is reaped by the next expiry runs.
+type ExpiringDeployment struct {
+	Name          string      `json:"name"`
+	Uid           string      `json:"uid"`
+	PoolId        string      `json:"pool_id"`
+	TestId        string      `json:"test_id,omitempty"`
+	ComponentType string      `json:"component_type"`
+	Team          string      `json:"team,omitempty"`
+	Claimed       bool        `json:"claimed"`
+	ExpireAfter   time.Time   `json:"expire_after"`
+	ExpiresIn     string      `json:"expires_in"`
+	Ci            *CiMetadata `json:"ci,omitempty"`
+}
+
+// ListExpiring returns the deployments which expire within the given duration, the ones expiring first come first.
+func (c *ServicePoolManager) ListExpiring(ctx context.Context, input *ExpiringInput) ([]*ExpiringDeployment, error) {
+	var err error
+	var deployments []*appsv1.Deployment
+	var expireAfter time.Time
+
+	selector := map[string]string{}
+	if input.PoolId != "" {
+		selector[LabelPoolId] = K8sNameString(input.PoolId)
+	}
+
+	if deployments, err = c.k8sClient.ListDeployments(ctx, selector); err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	now := c.clock.Now()
+	expiring := make([]*ExpiringDeployment, 0)
+
+	for _, deployment := range deployments {
+		annotations := deployment.GetAnnotations()
+
+		if _, ok := annotations[AnnotationExpireAfter]; !ok {
+			continue
+		}
+
+		if expireAfter, err = time.Parse(time.RFC3339, annotations[AnnotationExpireAfter]); err != nil {
+			return nil, fmt.Errorf("could not parse annotation expire after of deployment %q: %w", deployment.GetName(), err)
+		}
+
+		if expireAfter.After(now.Add(input.Within)) {
+			continue
+		}
+
+		expiresIn := max(expireAfter.Sub(now), 0)
+
+		expiring = append(expiring, &ExpiringDeployment{
+			Name:          deployment.GetName(),
+			Uid:           deployment.GetLabels()[LableUid],
+			PoolId:        deployment.GetLabels()[LabelPoolId],
+			TestId:        deployment.GetLabels()[LabelTestId],
+			ComponentType: annotations[AnnotationComponentType],
+			Team:          deployment.GetLabels()[LabelTeam],
+			Claimed:       isClaimed(deployment),
+			ExpireAfter:   expireAfter,
+			ExpiresIn:     expiresIn.Truncate(time.Second).String(),
+			Ci:            CiMetadataFromAnnotations(deployment),
+		})
+	}
+
+	slices.SortFunc(expiring, func(a, b *ExpiringDeployment) int {
+		return a.ExpireAfter.Compare(b.ExpireAfter)
+	})
+
+	return expiring, nil
+}
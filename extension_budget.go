@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+)
+
+const (
+	ExtensionScopeTest = "test"
+	ExtensionScopeTeam = "team"
+)
+
+// ExtensionBudgetSettings limit the cumulative extension time per test and team within the window.
+// A budget of 0 doesn't limit the extensions.
+type ExtensionBudgetSettings struct {
+	Enabled bool          `cfg:"enabled" default:"false"`
+	Window  time.Duration `cfg:"window" default:"24h"`
+	PerTest time.Duration `cfg:"per_test" default:"3h"`
+	PerTeam time.Duration `cfg:"per_team" default:"0"`
+}
+
+type ExtensionBudgetExceededError struct {
+	Scope     string
+	Id        string
+	Budget    time.Duration
+	Used      time.Duration
+	Requested time.Duration
+}
+
+func (e *ExtensionBudgetExceededError) Error() string {
+	return fmt.Sprintf("extension budget of %s %q exceeded: requested %s with %s of %s already used", e.Scope, e.Id, e.Requested, e.Used, e.Budget)
+}
+
+// ExtensionBudget derives the extension time already used from the extend events in the claim history.
+type ExtensionBudget struct {
+	clock    clock.Clock
+	history  ClaimHistory
+	settings *ExtensionBudgetSettings
+}
+
+func NewExtensionBudget(config cfg.Config, history ClaimHistory) (*ExtensionBudget, error) {
+	settings := &ExtensionBudgetSettings{}
+	if err := config.UnmarshalKey("extension_budget", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal extension budget settings: %w", err)
+	}
+
+	return &ExtensionBudget{
+		clock:    clock.NewRealClock(),
+		history:  history,
+		settings: settings,
+	}, nil
+}
+
+// Check returns an *ExtensionBudgetExceededError if extending the test by the duration exceeds the budget
+// of the test or its team.
+func (b *ExtensionBudget) Check(ctx context.Context, testId string, team string, duration time.Duration) error {
+	if !b.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	var records []HistoryRecord
+
+	if records, err = b.history.List(ctx, b.clock.Now().Add(-b.settings.Window)); err != nil {
+		return fmt.Errorf("could not list claim history: %w", err)
+	}
+
+	var usedByTest, usedByTeam time.Duration
+	for _, record := range records {
+		if record.Event != HistoryEventExtend {
+			continue
+		}
+
+		if record.TestId == testId {
+			usedByTest += record.Duration
+		}
+
+		if team != "" && record.Team == team {
+			usedByTeam += record.Duration
+		}
+	}
+
+	if b.settings.PerTest > 0 && usedByTest+duration > b.settings.PerTest {
+		return &ExtensionBudgetExceededError{
+			Scope:     ExtensionScopeTest,
+			Id:        testId,
+			Budget:    b.settings.PerTest,
+			Used:      usedByTest,
+			Requested: duration,
+		}
+	}
+
+	if b.settings.PerTeam > 0 && team != "" && usedByTeam+duration > b.settings.PerTeam {
+		return &ExtensionBudgetExceededError{
+			Scope:     ExtensionScopeTeam,
+			Id:        team,
+			Budget:    b.settings.PerTeam,
+			Used:      usedByTeam,
+			Requested: duration,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const FinalizerCleanup = "kubrun/cleanup"
+
+// FinalizerSettings add the cleanup finalizer to the spawned deployments. A deployment deleted outside of
+// kubrun, e.g. with kubectl, stays until the finalizer module ran the pre delete hooks and collected the
+// release artifacts. Deployments deleted by kubrun itself are torn down before, their finalizer is removed
+// right before the deletion.
+type FinalizerSettings struct {
+	Enabled  bool          `cfg:"enabled" default:"false"`
+	Interval time.Duration `cfg:"interval" default:"10s"`
+}
+
+func ReadFinalizerSettings(config cfg.Config) (*FinalizerSettings, error) {
+	settings := &FinalizerSettings{}
+	if err := config.UnmarshalKey("finalizers", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal finalizer settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// ReleaseFinalizer removes the cleanup finalizer of the object, if it has one. The test op keeps the patch
+// from removing another finalizer if the list changed since the object was read.
+func (c K8sClient) ReleaseFinalizer(ctx context.Context, object Objecter) error {
+	deployment, ok := object.(*appsv1.Deployment)
+	if !ok {
+		return nil
+	}
+
+	_, err := c.releaseFinalizer(ctx, deployment, false)
+
+	return err
+}
+
+// releaseFinalizer returns the resource version of the deployment after the finalizer was removed. If unchanged
+// is set, it fails with a conflict if the deployment was changed since it was read.
+func (c K8sClient) releaseFinalizer(ctx context.Context, deployment *appsv1.Deployment, unchanged bool) (string, error) {
+	var err error
+
+	index := slices.Index(deployment.GetFinalizers(), FinalizerCleanup)
+	if index == -1 {
+		return deployment.GetResourceVersion(), nil
+	}
+
+	ops := []string{
+		fmt.Sprintf(`{"op": "test", "path": "/metadata/finalizers/%d", "value": %q}`, index, FinalizerCleanup),
+		fmt.Sprintf(`{"op": "remove", "path": "/metadata/finalizers/%d"}`, index),
+	}
+
+	// a patch carrying the resource version is rejected with a conflict if the deployment changed since
+	if unchanged {
+		ops = append(ops, fmt.Sprintf(`{"op": "add", "path": "/metadata/resourceVersion", "value": %q}`, deployment.GetResourceVersion()))
+	}
+
+	if deployment, err = c.PatchDeployment(ctx, deployment, ops); err != nil {
+		return "", fmt.Errorf("could not remove finalizer: %w", err)
+	}
+
+	return deployment.GetResourceVersion(), nil
+}
+
+// FinalizerModule tears down the deployments which were deleted outside of kubrun and lets their deletion
+// finish afterwards. Their pods keep running until then, so the hooks and the log collection still work.
+type FinalizerModule struct {
+	kernel.BackgroundModule
+
+	logger      log.Logger
+	clock       clock.Clock
+	poolManager *ServicePoolManager
+	settings    *FinalizerSettings
+	readOnly    *ReadOnlySettings
+}
+
+func NewFinalizerModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var poolManager *ServicePoolManager
+	var settings *FinalizerSettings
+	var readOnly *ReadOnlySettings
+
+	if settings, err = ReadFinalizerSettings(config); err != nil {
+		return nil, err
+	}
+
+	if readOnly, err = ReadReadOnlySettings(config); err != nil {
+		return nil, err
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	return &FinalizerModule{
+		logger:      logger.WithChannel("finalizers"),
+		clock:       clock.NewRealClock(),
+		poolManager: poolManager,
+		settings:    settings,
+		readOnly:    readOnly,
+	}, nil
+}
+
+func (m *FinalizerModule) Run(ctx context.Context) error {
+	// a read-only instance leaves the teardown to the active one
+	if !m.settings.Enabled || m.readOnly.Enabled {
+		return nil
+	}
+
+	ticker := m.clock.NewTicker(m.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.Chan():
+			if err := m.poolManager.FinalizeDeleted(ctx); err != nil {
+				m.logger.Error(ctx, "could not finalize deleted deployments: %w", err)
+			}
+		}
+	}
+}
+
+// FinalizeDeleted runs the teardown of the deployments which are being deleted and still carry the cleanup
+// finalizer, then removes the finalizer so kubernetes deletes them.
+func (c *ServicePoolManager) FinalizeDeleted(ctx context.Context) error {
+	var err error
+	var deployments []*appsv1.Deployment
+
+	if deployments, err = c.k8sClient.ListDeployments(ctx); err != nil {
+		return fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	finalized := make([]*appsv1.Deployment, 0)
+
+	for _, deployment := range deployments {
+		if deployment.GetDeletionTimestamp() == nil || !slices.Contains(deployment.GetFinalizers(), FinalizerCleanup) {
+			continue
+		}
+
+		c.collector.Collect(ctx, []*appsv1.Deployment{deployment})
+		c.hooks.Run(ctx, deployment)
+		c.events.Record(ctx, deployment, apiv1.EventTypeNormal, eventReasonReleased, "deleted outside of kubrun, cleaned up")
+
+		if err = c.k8sClient.ReleaseFinalizer(ctx, deployment); err != nil {
+			return fmt.Errorf("could not release deployment %q: %w", deployment.GetName(), err)
+		}
+
+		c.logger.Info(ctx, "cleaned up deployment %q deleted outside of kubrun", deployment.GetName())
+		finalized = append(finalized, deployment)
+	}
+
+	c.recordEnded(ctx, HistoryEventRelease, finalized)
+
+	return nil
+}
+
+func finalizers(settings *FinalizerSettings) []string {
+	if !settings.Enabled {
+		return nil
+	}
+
+	return []string{FinalizerCleanup}
+}
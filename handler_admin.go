@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/gosoline-project/httpserver"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+type AdminSettings struct {
+	Token string `cfg:"token"`
+}
+
+// HandlerAdmin serves the operator endpoints. They are authenticated by the shared token in the X-Admin-Token
+// header and disabled as long as no token is configured.
+type HandlerAdmin struct {
+	logger      log.Logger
+	maintenance *Maintenance
+	poolManager *ServicePoolManager
+	specs       *SpecRegistry
+	settings    *AdminSettings
+	loadTest    *LoadTestSettings
+}
+
+func NewHandlerAdmin(ctx context.Context, config cfg.Config, logger log.Logger) (*HandlerAdmin, error) {
+	var err error
+	var maintenance *Maintenance
+	var poolManager *ServicePoolManager
+	var specs *SpecRegistry
+	var loadTest *LoadTestSettings
+
+	settings := &AdminSettings{}
+	if err = config.UnmarshalKey("admin", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal admin settings: %w", err)
+	}
+
+	if maintenance, err = ProvideMaintenance(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create maintenance: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return nil, fmt.Errorf("could not create spec registry: %w", err)
+	}
+
+	if loadTest, err = ReadLoadTestSettings(config); err != nil {
+		return nil, err
+	}
+
+	return &HandlerAdmin{
+		logger:      logger.WithChannel("admin"),
+		maintenance: maintenance,
+		poolManager: poolManager,
+		specs:       specs,
+		settings:    settings,
+		loadTest:    loadTest,
+	}, nil
+}
+
+func (h *HandlerAdmin) HandleSetMaintenance(ctx context.Context, input *MaintenanceInput) (httpserver.Response, error) {
+	if resp := h.authorize(input.Token); resp != nil {
+		return resp, nil
+	}
+
+	if input.Enabled && input.Reason == "" {
+		return newValidationErrorResponse(&ValidationError{Problems: []string{"reason must not be empty when enabling the maintenance mode"}}), nil
+	}
+
+	status, err := h.maintenance.Set(ctx, input.Enabled, input.Reason)
+	if err != nil {
+		return nil, fmt.Errorf("could not set maintenance mode: %w", err)
+	}
+
+	if status.Enabled {
+		h.logger.Info(ctx, "enabled maintenance mode: %s", status.Reason)
+	} else {
+		h.logger.Info(ctx, "disabled maintenance mode")
+	}
+
+	return httpserver.NewJsonResponse(status), nil
+}
+
+func (h *HandlerAdmin) HandleGetMaintenance(ctx context.Context, input *MaintenanceStatusInput) (httpserver.Response, error) {
+	if resp := h.authorize(input.Token); resp != nil {
+		return resp, nil
+	}
+
+	return httpserver.NewJsonResponse(h.maintenance.Status()), nil
+}
+
+// HandleLoadTest runs a simulated claim and release workload against a sandbox pool and reports the latencies and
+// the error rate of the api servers, so the capacity and qps settings can be validated before pipelines move over.
+func (h *HandlerAdmin) HandleLoadTest(ctx context.Context, input *LoadTestInput) (httpserver.Response, error) {
+	var err error
+	var report *LoadTestReport
+
+	if resp := h.authorize(input.Token); resp != nil {
+		return resp, nil
+	}
+
+	problems := make([]string, 0)
+
+	if _, ok := h.specs.Get(h.specs.Resolve(input.ComponentType)); !ok {
+		problems = append(problems, fmt.Sprintf("component_type %q has no registered spec", input.ComponentType))
+	}
+
+	if input.Claims <= 0 || input.Claims > h.loadTest.MaxClaims {
+		problems = append(problems, fmt.Sprintf("claims has to be between 1 and %d", h.loadTest.MaxClaims))
+	}
+
+	if input.Concurrency <= 0 || input.Concurrency > h.loadTest.MaxConcurrency {
+		problems = append(problems, fmt.Sprintf("concurrency has to be between 1 and %d", h.loadTest.MaxConcurrency))
+	}
+
+	if input.WarmUp < 0 || input.WarmUp > h.loadTest.MaxClaims {
+		problems = append(problems, fmt.Sprintf("warm_up has to be between 0 and %d", h.loadTest.MaxClaims))
+	}
+
+	if input.Hold < 0 || input.Hold > h.loadTest.MaxHold {
+		problems = append(problems, fmt.Sprintf("hold has to be between 0s and %s", h.loadTest.MaxHold))
+	}
+
+	if len(problems) > 0 {
+		return newValidationErrorResponse(newValidationError(problems)), nil
+	}
+
+	h.logger.Info(ctx, "starting load test of component type %q with %d claims by %d clients", input.ComponentType, input.Claims, input.Concurrency)
+
+	if report, err = h.poolManager.RunLoadTest(ctx, input); err != nil {
+		return nil, fmt.Errorf("could not run load test: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(report), nil
+}
+
+func (h *HandlerAdmin) authorize(token string) httpserver.Response {
+	if h.settings.Token == "" {
+		return httpserver.NewStatusResponse(http.StatusNotFound)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(h.settings.Token), []byte(token)) != 1 {
+		return httpserver.NewStatusResponse(http.StatusUnauthorized)
+	}
+
+	return nil
+}
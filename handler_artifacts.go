@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gosoline-project/httpserver"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+type ArtifactInput struct {
+	Id string `uri:"id"`
+}
+
+type HandlerArtifacts struct {
+	artifacts *ArtifactStore
+}
+
+func NewHandlerArtifacts(ctx context.Context, config cfg.Config, logger log.Logger) (*HandlerArtifacts, error) {
+	var err error
+	var artifacts *ArtifactStore
+
+	if artifacts, err = ProvideArtifactStore(ctx, config); err != nil {
+		return nil, fmt.Errorf("could not create artifact store: %w", err)
+	}
+
+	return &HandlerArtifacts{
+		artifacts: artifacts,
+	}, nil
+}
+
+func (h *HandlerArtifacts) HandleGet(ctx context.Context, input *ArtifactInput) (httpserver.Response, error) {
+	var err error
+	var artifact *Artifact
+
+	if artifact, err = h.artifacts.Get(input.Id); errors.Is(err, ErrArtifactNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get artifact: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(artifact), nil
+}
+
+func (h *HandlerArtifacts) HandleTest(ctx context.Context, input *TestArtifactsInput) (httpserver.Response, error) {
+	var err error
+	var bundle *ArtifactBundle
+
+	if bundle, err = h.artifacts.Bundle(input.TestId); err != nil {
+		return nil, fmt.Errorf("could not bundle artifacts: %w", err)
+	}
+
+	if len(bundle.Artifacts) == 0 {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	return httpserver.NewJsonResponse(bundle), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gosoline-project/httpserver"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+type HandlerCluster struct {
+	k8sClient *K8sClient
+}
+
+func NewHandlerCluster(ctx context.Context, config cfg.Config, logger log.Logger) (*HandlerCluster, error) {
+	var err error
+	var k8sClient *K8sClient
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	return &HandlerCluster{
+		k8sClient: k8sClient,
+	}, nil
+}
+
+// HandleStatus returns the kube context kubrun currently talks to and whether it failed over to it.
+func (h *HandlerCluster) HandleStatus(ctx context.Context) (httpserver.Response, error) {
+	return httpserver.NewJsonResponse(h.k8sClient.Status()), nil
+}
@@ -2,52 +2,415 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/gosoline-project/httpserver"
 	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/funk"
 	"github.com/justtrackio/gosoline/pkg/log"
 )
 
 type WarmUpInput struct {
 	PoolId     string         `json:"pool_id"`
 	Components map[string]int `json:"components"`
+	Async      bool           `json:"async"`
+	PoolKey    string         `header:"X-Pool-Key" json:"-"`
+}
+
+// Total returns the number of deployments the warm up spawns.
+func (i *WarmUpInput) Total() int {
+	total := 0
+	for _, count := range i.Components {
+		total += count
+	}
+
+	return total
+}
+
+// BulkWarmUpInput carries a single pool key, pools with another key fail with an error in their result.
+type BulkWarmUpInput struct {
+	Pools   []*WarmUpInput `json:"pools"`
+	PoolKey string         `header:"X-Pool-Key" json:"-"`
+}
+
+type WarmUpResult struct {
+	PoolId  string `json:"pool_id"`
+	Status  string `json:"status"`
+	JobId   string `json:"job_id,omitempty"`
+	Spawned int    `json:"spawned"`
+	Error   string `json:"error,omitempty"`
+	PoolKey string `json:"pool_key,omitempty"`
 }
 
 type ShutdownInput struct {
-	PoolId string `json:"pool_id"`
+	PoolId  string `json:"pool_id"`
+	PoolKey string `header:"X-Pool-Key" json:"-"`
+}
+
+type CapacityInput struct {
+	ComponentTypes []string `form:"component_type"`
 }
 
 type HandlerPool struct {
 	poolManager *ServicePoolManager
+	jobQueue    *WarmUpJobQueue
+	validator   *InputValidator
+	poolKeys    *PoolKeys
 }
 
 func NewHandlerPool(ctx context.Context, config cfg.Config, logger log.Logger) (*HandlerPool, error) {
 	var err error
 	var poolManager *ServicePoolManager
+	var jobQueue *WarmUpJobQueue
+	var validator *InputValidator
+	var poolKeys *PoolKeys
 
 	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
 		return nil, fmt.Errorf("could not create service pool manager: %w", err)
 	}
 
+	if jobQueue, err = ProvideWarmUpJobQueue(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create warm up job queue: %w", err)
+	}
+
+	if validator, err = NewInputValidator(config); err != nil {
+		return nil, fmt.Errorf("could not create input validator: %w", err)
+	}
+
+	if poolKeys, err = ProvidePoolKeys(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create pool keys: %w", err)
+	}
+
 	return &HandlerPool{
 		poolManager: poolManager,
+		jobQueue:    jobQueue,
+		validator:   validator,
+		poolKeys:    poolKeys,
 	}, nil
 }
 
+// HandleWarmUp returns the key of the pool in the X-Pool-Key header on the first warm up of the pool.
 func (h *HandlerPool) HandleWarmUp(ctx context.Context, input *WarmUpInput) (httpserver.Response, error) {
-	if err := h.poolManager.WarmUpPool(ctx, input); err != nil {
-		return nil, fmt.Errorf("could not warm up pool: %w", err)
+	var err error
+	var resp httpserver.Response
+	var poolKey string
+	var capacityErr *CapacityExhaustedError
+
+	if err = h.validator.ValidateWarmUp(input); err != nil {
+		return newValidationErrorResponse(err), nil
 	}
 
-	return httpserver.NewStatusResponse(http.StatusOK), nil
+	if resp, err = authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if poolKey, err = h.poolKeys.Issue(ctx, input.PoolId); err != nil {
+		return nil, fmt.Errorf("could not issue pool key: %w", err)
+	}
+
+	options := make([]httpserver.ResponseOption, 0)
+	if poolKey != "" {
+		options = append(options, httpserver.WithHeader(poolKeyHeader, poolKey))
+	}
+
+	if input.Async {
+		if resp, err = h.enqueueWarmUp(input, options...); err != nil {
+			return nil, errors.Join(err, h.revokeIssuedKey(ctx, input.PoolId, poolKey))
+		}
+
+		return resp, nil
+	}
+
+	spawned := 0
+	err = h.poolManager.WarmUpPool(ctx, input, func() {
+		spawned++
+	})
+
+	if errors.As(err, &capacityErr) {
+		if err = h.revokeIssuedKey(ctx, input.PoolId, poolKey); err != nil {
+			return nil, err
+		}
+
+		return newCapacityExhaustedResponse(capacityErr), nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		options = append(options, httpserver.WithStatusCode(http.StatusGatewayTimeout))
+		body := map[string]any{
+			"err":     err.Error(),
+			"spawned": spawned,
+			"total":   input.Total(),
+		}
+
+		return httpserver.NewJsonResponse(body, options...), nil
+	}
+
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("could not warm up pool: %w", err), h.revokeIssuedKey(ctx, input.PoolId, poolKey))
+	}
+
+	return httpserver.NewStatusResponse(http.StatusOK, options...), nil
+}
+
+// revokeIssuedKey removes the key issued by a failed warm up, as its response doesn't carry the key and the pool
+// would otherwise stay locked with a key nobody knows.
+func (h *HandlerPool) revokeIssuedKey(ctx context.Context, poolId string, poolKey string) error {
+	if poolKey == "" {
+		return nil
+	}
+
+	if err := h.poolKeys.Revoke(ctx, poolId); err != nil {
+		return fmt.Errorf("could not revoke the pool key issued by the failed warm up: %w", err)
+	}
+
+	return nil
+}
+
+func (h *HandlerPool) HandleRollout(ctx context.Context, input *RolloutInput) (httpserver.Response, error) {
+	var err error
+	var output *RolloutOutput
+	var capacityErr *CapacityExhaustedError
+
+	if input.ReadyTimeout <= 0 {
+		input.ReadyTimeout = defaultRolloutReadyTimeout
+	}
+
+	if err = h.validator.ValidateRollout(input); err != nil {
+		return newValidationErrorResponse(err), nil
+	}
+
+	if resp, err := authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if output, err = h.poolManager.RolloutPool(ctx, input); errors.As(err, &capacityErr) {
+		return newCapacityExhaustedResponse(capacityErr), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not roll out pool: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(output), nil
+}
+
+func (h *HandlerPool) HandleExport(ctx context.Context, input *PoolExportInput) (httpserver.Response, error) {
+	var err error
+	var export *PoolExport
+
+	if input.PoolId == "" {
+		return newValidationErrorResponse(&ValidationError{Problems: []string{"pool_id must not be empty"}}), nil
+	}
+
+	if resp, err := authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if export, err = h.poolManager.ExportPool(ctx, input.PoolId); err != nil {
+		return nil, fmt.Errorf("could not export pool: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(export), nil
+}
+
+// HandleImport pins the specs of the export and warms up the missing idle deployments in the background.
+func (h *HandlerPool) HandleImport(ctx context.Context, input *PoolExport) (httpserver.Response, error) {
+	var err error
+	var missing map[string]int
+	var differences []string
+
+	if err = h.validator.ValidateImport(input); err != nil {
+		return newValidationErrorResponse(err), nil
+	}
+
+	if resp, err := authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if missing, differences, err = h.poolManager.ImportPool(ctx, input); err != nil {
+		return nil, fmt.Errorf("could not import pool: %w", err)
+	}
+
+	output := &PoolImportOutput{
+		PoolId:           input.PoolId,
+		Pinned:           funk.Keys(input.SpecPins),
+		WarmUp:           missing,
+		QuotaDifferences: differences,
+	}
+	slices.Sort(output.Pinned)
+
+	if len(missing) == 0 {
+		return httpserver.NewJsonResponse(output), nil
+	}
+
+	if output.Job, err = h.jobQueue.Enqueue(&WarmUpInput{PoolId: input.PoolId, Components: missing}); err != nil {
+		return nil, fmt.Errorf("could not enqueue warm up: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(output, httpserver.WithStatusCode(http.StatusAccepted)), nil
+}
+
+// HandleBulkWarmUp warms up several pools concurrently and reports the outcome per pool instead of
+// failing the whole request if a single pool couldn't be warmed up.
+func (h *HandlerPool) HandleBulkWarmUp(ctx context.Context, input *BulkWarmUpInput) (httpserver.Response, error) {
+	wg := sync.WaitGroup{}
+	results := make([]*WarmUpResult, len(input.Pools))
+
+	for i, pool := range input.Pools {
+		results[i] = &WarmUpResult{
+			PoolId: pool.PoolId,
+			Status: JobStatusDone,
+		}
+
+		if err := h.validator.ValidateWarmUp(pool); err != nil {
+			results[i].setOutcome(err)
+
+			continue
+		}
+
+		if err := h.poolKeys.Check(ctx, pool.PoolId, input.PoolKey); err != nil {
+			results[i].setOutcome(err)
+
+			continue
+		}
+
+		poolKey, err := h.poolKeys.Issue(ctx, pool.PoolId)
+		if err != nil {
+			results[i].setOutcome(err)
+
+			continue
+		}
+		results[i].PoolKey = poolKey
+
+		if pool.Async {
+			job, err := h.jobQueue.Enqueue(pool)
+			results[i].setOutcome(err)
+
+			if job != nil {
+				results[i].Status = job.Status
+				results[i].JobId = job.Id
+			}
+
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *WarmUpResult, pool *WarmUpInput) {
+			defer wg.Done()
+
+			result.setOutcome(h.poolManager.WarmUpPool(ctx, pool, func() {
+				result.Spawned++
+			}))
+		}(results[i], pool)
+	}
+
+	wg.Wait()
+
+	return httpserver.NewJsonResponse(results), nil
+}
+
+func (r *WarmUpResult) setOutcome(err error) {
+	if err == nil {
+		return
+	}
+
+	r.Status = JobStatusFailed
+	r.Error = err.Error()
+}
+
+func (h *HandlerPool) HandleGetJob(ctx context.Context, input *JobInput) (httpserver.Response, error) {
+	return newJobResponse(h.jobQueue.Get(input.Id))
+}
+
+func (h *HandlerPool) HandleCancelJob(ctx context.Context, input *JobInput) (httpserver.Response, error) {
+	job, err := h.jobQueue.Get(input.Id)
+	if err != nil {
+		return newJobResponse(job, err)
+	}
+
+	if resp, err := authorizePool(ctx, h.poolKeys, job.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	return newJobResponse(h.jobQueue.Cancel(input.Id))
+}
+
+func (h *HandlerPool) enqueueWarmUp(input *WarmUpInput, options ...httpserver.ResponseOption) (httpserver.Response, error) {
+	var err error
+	var job *WarmUpJob
+
+	if job, err = h.jobQueue.Enqueue(input); err != nil {
+		return nil, fmt.Errorf("could not enqueue warm up job: %w", err)
+	}
+
+	options = append(options, httpserver.WithStatusCode(http.StatusAccepted))
+
+	return httpserver.NewJsonResponse(job, options...), nil
+}
+
+func newJobResponse(job *WarmUpJob, err error) (httpserver.Response, error) {
+	if errors.Is(err, ErrJobNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get job: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(job), nil
 }
 
 func (h *HandlerPool) HandleShutdown(ctx context.Context, input *ShutdownInput) (httpserver.Response, error) {
+	if resp, err := authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
 	if err := h.poolManager.ShutdownPool(ctx, input); err != nil {
 		return nil, fmt.Errorf("could not warm up pool: %w", err)
 	}
 
+	if err := h.poolKeys.Revoke(ctx, input.PoolId); err != nil {
+		return nil, fmt.Errorf("could not revoke pool key: %w", err)
+	}
+
 	return httpserver.NewStatusResponse(http.StatusOK), nil
 }
+
+func (h *HandlerPool) HandleCapacity(ctx context.Context, input *CapacityInput) (httpserver.Response, error) {
+	var err error
+	var estimates []*CapacityEstimate
+
+	if estimates, err = h.poolManager.EstimateCapacity(ctx, input); err != nil {
+		return nil, fmt.Errorf("could not estimate capacity: %w", err)
+	}
+
+	slices.SortFunc(estimates, func(a, b *CapacityEstimate) int {
+		return strings.Compare(a.ComponentType, b.ComponentType)
+	})
+
+	return httpserver.NewJsonResponse(estimates), nil
+}
+
+func (h *HandlerPool) HandleExpiring(ctx context.Context, input *ExpiringInput) (httpserver.Response, error) {
+	var err error
+	var expiring []*ExpiringDeployment
+
+	if input.Within < 0 {
+		return newValidationErrorResponse(newValidationError([]string{"within must not be negative"})), nil
+	}
+
+	if input.Within == 0 {
+		input.Within = defaultExpiringWithin
+	}
+
+	if expiring, err = h.poolManager.ListExpiring(ctx, input); err != nil {
+		return nil, fmt.Errorf("could not list expiring deployments: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(expiring), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gosoline-project/httpserver"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+type HandlerReports struct {
+	clock    clock.Clock
+	history  ClaimHistory
+	sizer    *RightSizer
+	settings *UsageReportSettings
+}
+
+func NewHandlerReports(ctx context.Context, config cfg.Config, logger log.Logger) (*HandlerReports, error) {
+	var err error
+	var history ClaimHistory
+	var sizer *RightSizer
+
+	settings := &UsageReportSettings{}
+	if err = config.UnmarshalKey("usage_reports", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal usage report settings: %w", err)
+	}
+
+	if history, err = ProvideClaimHistory(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create claim history: %w", err)
+	}
+
+	if sizer, err = ProvideRightSizer(ctx, config); err != nil {
+		return nil, fmt.Errorf("could not create right sizer: %w", err)
+	}
+
+	return &HandlerReports{
+		clock:    clock.NewRealClock(),
+		history:  history,
+		sizer:    sizer,
+		settings: settings,
+	}, nil
+}
+
+func (h *HandlerReports) HandleTeams(ctx context.Context, input *TeamReportInput) (httpserver.Response, error) {
+	var err error
+	var records []HistoryRecord
+
+	lookback := input.Lookback
+	if lookback <= 0 {
+		lookback = h.settings.DefaultLookback
+	}
+
+	until := h.clock.Now()
+	since := until.Add(-lookback)
+
+	if records, err = h.history.List(ctx, since.Add(-h.settings.ClaimLookback)); err != nil {
+		return nil, fmt.Errorf("could not list claim history: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(BuildTeamUsageReport(records, since, until)), nil
+}
+
+func (h *HandlerReports) HandleOomKills(ctx context.Context, input *TeamReportInput) (httpserver.Response, error) {
+	var err error
+	var records []HistoryRecord
+
+	lookback := input.Lookback
+	if lookback <= 0 {
+		lookback = h.settings.DefaultLookback
+	}
+
+	until := h.clock.Now()
+	since := until.Add(-lookback)
+
+	if records, err = h.history.List(ctx, since); err != nil {
+		return nil, fmt.Errorf("could not list claim history: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(BuildOomKillReport(records, since, until)), nil
+}
+
+func (h *HandlerReports) HandleRightSizing(ctx context.Context) (httpserver.Response, error) {
+	return httpserver.NewJsonResponse(h.sizer.Report()), nil
+}
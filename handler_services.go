@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gosoline-project/httpserver"
 	"github.com/justtrackio/gosoline/pkg/cfg"
@@ -12,41 +17,273 @@ import (
 )
 
 type HandlerServices struct {
+	logger      log.Logger
 	poolManager *ServicePoolManager
+	debugger    *ContainerDebugger
+	inspector   *ServiceInspector
+	validator   *InputValidator
+	poolKeys    *PoolKeys
 }
 
 func NewHandlerServices(ctx context.Context, config cfg.Config, logger log.Logger) (*HandlerServices, error) {
 	var err error
 	var poolManager *ServicePoolManager
+	var debugger *ContainerDebugger
+	var inspector *ServiceInspector
+	var validator *InputValidator
+	var poolKeys *PoolKeys
 
 	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
 		return nil, fmt.Errorf("could not create service pool manager: %w", err)
 	}
 
+	if debugger, err = NewContainerDebugger(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create container debugger: %w", err)
+	}
+
+	if inspector, err = NewServiceInspector(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service inspector: %w", err)
+	}
+
+	if validator, err = NewInputValidator(config); err != nil {
+		return nil, fmt.Errorf("could not create input validator: %w", err)
+	}
+
+	if poolKeys, err = ProvidePoolKeys(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create pool keys: %w", err)
+	}
+
 	return &HandlerServices{
+		logger:      logger.WithChannel("handler-services"),
 		poolManager: poolManager,
+		debugger:    debugger,
+		inspector:   inspector,
+		validator:   validator,
+		poolKeys:    poolKeys,
 	}, nil
 }
 
 func (h *HandlerServices) HandleRun(ctx context.Context, input *RunInput) (httpserver.Response, error) {
+	return h.run(ctx, input, h.poolManager.FetchService)
+}
+
+// HandleRunLongPoll claims like HandleRun, but holds the request while the cluster has no capacity left
+// until a container can be claimed or the hold of the input is over.
+func (h *HandlerServices) HandleRunLongPoll(ctx context.Context, input *RunInput) (httpserver.Response, error) {
+	return h.run(ctx, input, h.poolManager.FetchServiceLongPoll)
+}
+
+func (h *HandlerServices) run(ctx context.Context, input *RunInput, fetch func(ctx context.Context, input *RunInput) (*Claim, error)) (httpserver.Response, error) {
 	var err error
-	var service *apiv1.Service
+	var claim *Claim
+	var capacityErr *CapacityExhaustedError
+	var limitErr *ClaimLimitExceededError
+	var maintenanceErr *MaintenanceError
+	var unavailableErr *K8sUnavailableError
+
+	h.warnLegacy(ctx, input.PoolId, input.LegacyFields())
+
+	if err = h.validator.ValidateRun(input); err != nil {
+		return newValidationErrorResponse(err), nil
+	}
+
+	if resp, err := authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if claim, err = fetch(ctx, input); errors.As(err, &capacityErr) {
+		return newCapacityExhaustedResponse(capacityErr), nil
+	}
+
+	if errors.As(err, &limitErr) {
+		return newTooManyRequestsResponse(limitErr, limitErr.RetryAfter), nil
+	}
+
+	if errors.As(err, &maintenanceErr) {
+		return httpserver.NewJsonResponse(map[string]any{"err": maintenanceErr.Error(), "reason": maintenanceErr.Reason}, httpserver.WithStatusCode(http.StatusServiceUnavailable)), nil
+	}
+
+	if errors.As(err, &unavailableErr) {
+		return newK8sUnavailableResponse(unavailableErr), nil
+	}
 
-	if service, err = h.poolManager.FetchService(ctx, input); err != nil {
+	if errors.Is(err, ErrSessionNotFound) {
+		return httpserver.NewJsonResponse(map[string]any{"err": err.Error()}, httpserver.WithStatusCode(http.StatusNotFound)), nil
+	}
+
+	if errors.Is(err, ErrNoIdleDeployment) {
+		return httpserver.NewJsonResponse(map[string]any{"err": err.Error()}, httpserver.WithStatusCode(http.StatusServiceUnavailable)), nil
+	}
+
+	if err != nil {
 		return nil, fmt.Errorf("could not fetch service: %w", err)
 	}
 
-	bindings := make(map[string]string)
-	for _, port := range service.Spec.Ports {
-		host := fmt.Sprintf("%s.%s", service.GetName(), service.Namespace)
-		bindings[port.Name] = net.JoinHostPort(host, fmt.Sprint(port.Port))
+	output := RunOutput{
+		Bindings:    serviceBindings(claim.Service),
+		Credentials: claim.Credentials,
+		Hostname:    claim.Hostname,
+		Aliases:     claim.Aliases,
+	}
+
+	if input.ReturnPodIps {
+		output.PodBindings = podBindings(claim)
+	}
+
+	if input.ReturnConnection {
+		output.Connection = BuildConnection(input.ComponentType, claim)
+	}
+
+	output.Nodes = redisClusterNodes(claim)
+
+	// the path is reported as a header, as older clients decode every field of the body as a binding
+	pathHeader := httpserver.WithHeader(claimPathHeader, claim.Path)
+
+	if input.Wait <= 0 && !input.Async {
+		return httpserver.NewJsonResponse(output, pathHeader), nil
+	}
+
+	output.ClaimId = claim.GetId()
+	output.Status = claim.Status
+
+	if claim.Status != ClaimStatusReady {
+		return httpserver.NewJsonResponse(output, httpserver.WithStatusCode(http.StatusAccepted), pathHeader), nil
+	}
+
+	return httpserver.NewJsonResponse(output, pathHeader), nil
+}
+
+// HandlePreflight checks whether a batch of claims would currently succeed, so a pipeline can fail early with
+// a clear message instead of running into a refused claim halfway through its tests.
+func (h *HandlerServices) HandlePreflight(ctx context.Context, input *PreflightInput) (httpserver.Response, error) {
+	var err error
+	var output *PreflightOutput
+	var validationErr *ValidationError
+
+	if len(input.Claims) == 0 {
+		return newValidationErrorResponse(newValidationError([]string{"claims must not be empty"})), nil
+	}
+
+	claims := make([]*PreflightClaim, 0, len(input.Claims))
+	for _, claimInput := range input.Claims {
+		claim := NewPreflightClaim(claimInput)
+
+		if err = h.validator.ValidateRun(claimInput); errors.As(err, &validationErr) {
+			claim.Problems = append(claim.Problems, validationErr.Problems...)
+		}
+
+		claims = append(claims, claim)
+	}
+
+	if output, err = h.poolManager.Preflight(ctx, claims); err != nil {
+		return nil, fmt.Errorf("could not run preflight check: %w", err)
 	}
 
-	return httpserver.NewJsonResponse(bindings), nil
+	return httpserver.NewJsonResponse(output), nil
+}
+
+func (h *HandlerServices) HandleGetClaim(ctx context.Context, input *ClaimInput) (httpserver.Response, error) {
+	var err error
+	var claim *Claim
+
+	var expireAfter string
+
+	if claim, err = h.poolManager.GetClaim(ctx, input.Id); errors.Is(err, ErrClaimNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get claim: %w", err)
+	}
+
+	if resp, err := authorizePool(ctx, h.poolKeys, claim.Deployment.GetLabels()[LabelPoolId], input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if expireAfter, err = h.poolManager.Touch(ctx, claim.Deployment); err != nil {
+		return nil, fmt.Errorf("could not touch claim: %w", err)
+	}
+
+	labels := claim.Deployment.GetLabels()
+	annotations := claim.Deployment.GetAnnotations()
+
+	return httpserver.NewJsonResponse(ClaimOutput{
+		ClaimId:       claim.GetId(),
+		Status:        claim.Status,
+		Reason:        annotations[AnnotationClaimReason],
+		PoolId:        labels[LabelPoolId],
+		TestId:        labels[LabelTestId],
+		ComponentType: annotations[AnnotationComponentType],
+		ComponentName: annotations[AnnotationComponentName],
+		ExpireAfter:   expireAfter,
+		Bindings:      serviceBindings(claim.Service),
+		Ci:            CiMetadataFromAnnotations(claim.Deployment),
+	}), nil
+}
+
+func (h *HandlerServices) HandleHeartbeat(ctx context.Context, input *ClaimInput) (httpserver.Response, error) {
+	var err error
+	var expireAfter string
+
+	if resp, err := authorizeClaim(ctx, h.poolKeys, h.poolManager, input.Id, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if expireAfter, err = h.poolManager.TouchClaim(ctx, input.Id); errors.Is(err, ErrClaimNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not touch claim: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(HeartbeatOutput{
+		ClaimId:     input.Id,
+		ExpireAfter: expireAfter,
+	}), nil
+}
+
+func (h *HandlerServices) HandleTransfer(ctx context.Context, input *TransferInput) (httpserver.Response, error) {
+	var err error
+	var output *TransferOutput
+	var limitErr *ClaimLimitExceededError
+
+	if err = h.validator.ValidateTransfer(input); err != nil {
+		return newValidationErrorResponse(err), nil
+	}
+
+	if resp, err := authorizeClaim(ctx, h.poolKeys, h.poolManager, input.Id, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if output, err = h.poolManager.TransferClaim(ctx, input); errors.Is(err, ErrClaimNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if errors.As(err, &limitErr) {
+		return newTooManyRequestsResponse(limitErr, limitErr.RetryAfter), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not transfer claim: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(output), nil
 }
 
 func (h *HandlerServices) HandleExtend(ctx context.Context, input *ExtendInput) (httpserver.Response, error) {
-	if err := h.poolManager.ExtendServices(ctx, input); err != nil {
+	var budgetErr *ExtensionBudgetExceededError
+
+	if resp, err := authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	err := h.poolManager.ExtendServices(ctx, input)
+	if errors.As(err, &budgetErr) {
+		return httpserver.NewJsonResponse(map[string]any{"err": budgetErr.Error()}, httpserver.WithStatusCode(http.StatusTooManyRequests)), nil
+	}
+
+	if err != nil {
 		return nil, fmt.Errorf("could not extend service: %w", err)
 	}
 
@@ -54,9 +291,199 @@ func (h *HandlerServices) HandleExtend(ctx context.Context, input *ExtendInput)
 }
 
 func (h *HandlerServices) HandleStop(ctx context.Context, input *StopInput) (httpserver.Response, error) {
+	h.warnLegacy(ctx, input.PoolId, input.LegacyFields())
+
+	if resp, err := authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
 	if err := h.poolManager.ReleaseServices(ctx, input); err != nil {
 		return nil, fmt.Errorf("could not fetch service: %w", err)
 	}
 
 	return httpserver.NewStatusResponse(200), nil
 }
+
+func (h *HandlerServices) HandleDetails(ctx context.Context, input *ServiceDetailsInput) (httpserver.Response, error) {
+	var err error
+	var output *ServiceDetails
+
+	if output, err = h.inspector.Describe(ctx, input.Uid); errors.Is(err, ErrServiceNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not describe service: %w", err)
+	}
+
+	if resp, err := authorizePool(ctx, h.poolKeys, output.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if !output.Claimed {
+		return httpserver.NewJsonResponse(output), nil
+	}
+
+	if output.ExpireAfter, err = h.poolManager.TouchClaim(ctx, input.Uid); err != nil {
+		return nil, fmt.Errorf("could not touch claim: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(output), nil
+}
+
+func (h *HandlerServices) HandleUndoStop(ctx context.Context, input *StopInput) (httpserver.Response, error) {
+	var err error
+	var output *RestoreOutput
+
+	h.warnLegacy(ctx, input.PoolId, input.LegacyFields())
+
+	if resp, err := authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if output, err = h.poolManager.RestoreServices(ctx, input); errors.Is(err, ErrNothingToRestore) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not restore services: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(output), nil
+}
+
+func (h *HandlerServices) HandleUpdateEnv(ctx context.Context, input *UpdateEnvInput) (httpserver.Response, error) {
+	var err error
+	var output *UpdateEnvOutput
+
+	if err = h.validator.ValidateUpdateEnv(input); err != nil {
+		return newValidationErrorResponse(err), nil
+	}
+
+	if resp, err := authorizeClaim(ctx, h.poolKeys, h.poolManager, input.Uid, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if output, err = h.poolManager.UpdateEnv(ctx, input); errors.Is(err, ErrClaimNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if errors.Is(err, ErrMainContainerNotFound) {
+		return httpserver.NewJsonResponse(map[string]any{"err": err.Error()}, httpserver.WithStatusCode(http.StatusConflict)), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not update env: %w", err)
+	}
+
+	if _, err = h.poolManager.TouchClaim(ctx, input.Uid); err != nil && !errors.Is(err, ErrClaimNotFound) {
+		return nil, fmt.Errorf("could not touch claim: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(output), nil
+}
+
+func (h *HandlerServices) HandleUpdateImage(ctx context.Context, input *UpdateImageInput) (httpserver.Response, error) {
+	var err error
+	var output *UpdateImageOutput
+
+	if err = h.validator.ValidateUpdateImage(input); err != nil {
+		return newValidationErrorResponse(err), nil
+	}
+
+	if resp, err := authorizeClaim(ctx, h.poolKeys, h.poolManager, input.Uid, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if output, err = h.poolManager.UpdateImage(ctx, input); errors.Is(err, ErrClaimNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if errors.Is(err, ErrMainContainerNotFound) {
+		return httpserver.NewJsonResponse(map[string]any{"err": err.Error()}, httpserver.WithStatusCode(http.StatusConflict)), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not update image: %w", err)
+	}
+
+	if _, err = h.poolManager.TouchClaim(ctx, input.Uid); err != nil && !errors.Is(err, ErrClaimNotFound) {
+		return nil, fmt.Errorf("could not touch claim: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(output), nil
+}
+
+func (h *HandlerServices) HandleDebug(ctx context.Context, input *DebugInput) (httpserver.Response, error) {
+	var err error
+	var output *DebugOutput
+
+	if resp, err := authorizeClaim(ctx, h.poolKeys, h.poolManager, input.Uid, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if output, err = h.debugger.Attach(ctx, input); err != nil {
+		return nil, fmt.Errorf("could not attach debug container: %w", err)
+	}
+
+	if _, err = h.poolManager.TouchClaim(ctx, input.Uid); err != nil && !errors.Is(err, ErrClaimNotFound) {
+		return nil, fmt.Errorf("could not touch claim: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(output), nil
+}
+
+func serviceBindings(service *apiv1.Service) map[string]string {
+	bindings := make(map[string]string)
+
+	for _, port := range service.Spec.Ports {
+		host := fmt.Sprintf("%s.%s", service.GetName(), service.Namespace)
+		bindings[port.Name] = net.JoinHostPort(host, fmt.Sprint(port.Port))
+	}
+
+	return bindings
+}
+
+// podBindings returns the addresses of all pods of the claim which already got an ip assigned, keyed by port name.
+func podBindings(claim *Claim) map[string][]string {
+	bindings := make(map[string][]string)
+
+	for _, port := range claim.Service.Spec.Ports {
+		bindings[port.Name] = make([]string, 0, len(claim.Pods))
+
+		for _, pod := range claim.Pods {
+			if pod.Status.PodIP == "" {
+				continue
+			}
+
+			bindings[port.Name] = append(bindings[port.Name], net.JoinHostPort(pod.Status.PodIP, fmt.Sprint(port.Port)))
+		}
+	}
+
+	return bindings
+}
+
+func newValidationErrorResponse(err error) httpserver.Response {
+	return httpserver.NewJsonResponse(map[string]any{"err": err.Error()}, httpserver.WithStatusCode(http.StatusBadRequest))
+}
+
+func newCapacityExhaustedResponse(err *CapacityExhaustedError) httpserver.Response {
+	return newTooManyRequestsResponse(err, err.RetryAfter)
+}
+
+func newK8sUnavailableResponse(err *K8sUnavailableError) httpserver.Response {
+	return httpserver.NewJsonResponse(
+		map[string]any{"err": err.Error()},
+		httpserver.WithStatusCode(http.StatusServiceUnavailable),
+		httpserver.WithHeader("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds())))),
+	)
+}
+
+// warnLegacy logs the legacy fields a client sent, so the clients which still have to upgrade can be found.
+func (h *HandlerServices) warnLegacy(ctx context.Context, poolId string, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+
+	h.logger.Warn(ctx, "client of pool %q sent the legacy fields %s", poolId, strings.Join(fields, ", "))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gosoline-project/httpserver"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+type HandlerSessions struct {
+	poolManager *ServicePoolManager
+	sessions    *SessionStore
+	validator   *InputValidator
+	poolKeys    *PoolKeys
+}
+
+func NewHandlerSessions(ctx context.Context, config cfg.Config, logger log.Logger) (*HandlerSessions, error) {
+	var err error
+	var poolManager *ServicePoolManager
+	var sessions *SessionStore
+	var validator *InputValidator
+	var poolKeys *PoolKeys
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	if sessions, err = ProvideSessionStore(ctx, config); err != nil {
+		return nil, fmt.Errorf("could not create session store: %w", err)
+	}
+
+	if validator, err = NewInputValidator(config); err != nil {
+		return nil, fmt.Errorf("could not create input validator: %w", err)
+	}
+
+	if poolKeys, err = ProvidePoolKeys(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create pool keys: %w", err)
+	}
+
+	return &HandlerSessions{
+		poolManager: poolManager,
+		sessions:    sessions,
+		validator:   validator,
+		poolKeys:    poolKeys,
+	}, nil
+}
+
+func (h *HandlerSessions) HandleCreate(ctx context.Context, input *CreateSessionInput) (httpserver.Response, error) {
+	if err := h.validator.ValidateSession(input); err != nil {
+		return newValidationErrorResponse(err), nil
+	}
+
+	if resp, err := authorizePool(ctx, h.poolKeys, input.PoolId, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	return httpserver.NewJsonResponse(h.sessions.Create(input), httpserver.WithStatusCode(http.StatusCreated)), nil
+}
+
+func (h *HandlerSessions) HandleGet(ctx context.Context, input *SessionInput) (httpserver.Response, error) {
+	var err error
+	var session *Session
+
+	if resp, err := authorizeSession(ctx, h.poolKeys, h.sessions, input.Id, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if session, err = h.sessions.Get(input.Id); errors.Is(err, ErrSessionNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get session: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(session), nil
+}
+
+func (h *HandlerSessions) HandleHeartbeat(ctx context.Context, input *SessionInput) (httpserver.Response, error) {
+	var err error
+	var session *Session
+
+	if resp, err := authorizeSession(ctx, h.poolKeys, h.sessions, input.Id, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if session, err = h.sessions.Heartbeat(input.Id); errors.Is(err, ErrSessionNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not record heartbeat: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(session), nil
+}
+
+func (h *HandlerSessions) HandleExtend(ctx context.Context, input *ExtendSessionInput) (httpserver.Response, error) {
+	var err error
+	var session *Session
+	var budgetErr *ExtensionBudgetExceededError
+
+	if err = h.validator.ValidateExtendSession(input); err != nil {
+		return newValidationErrorResponse(err), nil
+	}
+
+	if resp, err := authorizeSession(ctx, h.poolKeys, h.sessions, input.Id, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	session, err = h.poolManager.ExtendSession(ctx, input)
+
+	if errors.Is(err, ErrSessionNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if errors.As(err, &budgetErr) {
+		return httpserver.NewJsonResponse(map[string]any{"err": budgetErr.Error()}, httpserver.WithStatusCode(http.StatusTooManyRequests)), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not extend session: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(session), nil
+}
+
+func (h *HandlerSessions) HandleClose(ctx context.Context, input *SessionInput) (httpserver.Response, error) {
+	var err error
+	var output *CloseSessionOutput
+
+	if resp, err := authorizeSession(ctx, h.poolKeys, h.sessions, input.Id, input.PoolKey); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if output, err = h.poolManager.CloseSession(ctx, input.Id); errors.Is(err, ErrSessionNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not close session: %w", err)
+	}
+
+	return httpserver.NewJsonResponse(output), nil
+}
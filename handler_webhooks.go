@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gosoline-project/httpserver"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+const (
+	githubWorkflowRunCompleted = "completed"
+
+	gitlabObjectKindPipeline = "pipeline"
+	gitlabStatusCanceled     = "canceled"
+
+	githubSignatureHeader = "X-Hub-Signature-256"
+	githubSignaturePrefix = "sha256="
+)
+
+// gitlabFinishedStatuses are the pipeline statuses after which no job of the pipeline is running anymore.
+var gitlabFinishedStatuses = []string{"success", "failed", gitlabStatusCanceled, "skipped"}
+
+// WebhookSettings configure a CI webhook. The secret is the secret token of the GitLab webhook or the secret
+// GitHub signs the payloads of its webhook with.
+type WebhookSettings struct {
+	Enabled bool   `cfg:"enabled" default:"false"`
+	Secret  string `cfg:"secret"`
+}
+
+type CiWebhookSettings struct {
+	Github WebhookSettings `cfg:"github"`
+	Gitlab WebhookSettings `cfg:"gitlab"`
+}
+
+// GithubWorkflowRunInput is the payload of the GitHub workflow_run webhook event. Its signature is verified by
+// VerifyGithubSignature before it is bound.
+type GithubWorkflowRunInput struct {
+	Action      string             `json:"action"`
+	WorkflowRun *GithubWorkflowRun `json:"workflow_run"`
+}
+
+type GithubWorkflowRun struct {
+	Id         int64  `json:"id"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+}
+
+// GitlabWebhookInput is the payload of the GitLab pipeline webhook event. GitLab sends the configured secret
+// token in the X-Gitlab-Token header.
+type GitlabWebhookInput struct {
+	Token            string                  `header:"X-Gitlab-Token"`
+	ObjectKind       string                  `json:"object_kind"`
+	ObjectAttributes *GitlabObjectAttributes `json:"object_attributes"`
+}
+
+type GitlabObjectAttributes struct {
+	Id     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+type WebhookOutput struct {
+	PipelineId string `json:"pipeline_id"`
+	Released   int    `json:"released"`
+}
+
+// HandlerWebhooks releases the resources of CI pipelines as soon as the CI reports them as finished. Claims are
+// mapped to a pipeline by the ci.pipeline_id of the run input, which has to be the id of the GitHub workflow run
+// or the GitLab pipeline.
+type HandlerWebhooks struct {
+	logger      log.Logger
+	poolManager *ServicePoolManager
+	settings    *CiWebhookSettings
+}
+
+func NewHandlerWebhooks(ctx context.Context, config cfg.Config, logger log.Logger) (*HandlerWebhooks, error) {
+	var err error
+	var poolManager *ServicePoolManager
+
+	settings := &CiWebhookSettings{}
+	if err = config.UnmarshalKey("ci_webhooks", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal ci webhook settings: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	return &HandlerWebhooks{
+		logger:      logger.WithChannel("ci-webhooks"),
+		poolManager: poolManager,
+		settings:    settings,
+	}, nil
+}
+
+// VerifyGithubSignature checks the X-Hub-Signature-256 header, the hex encoded HMAC-SHA256 of the raw body with
+// the webhook secret, before the body is bound to the input of HandleGithub.
+func (h *HandlerWebhooks) VerifyGithubSignature(ginCtx *gin.Context) {
+	var err error
+	var body []byte
+	var signature []byte
+
+	if !h.settings.Github.Enabled {
+		ginCtx.AbortWithStatus(http.StatusNotFound)
+
+		return
+	}
+
+	if body, err = io.ReadAll(ginCtx.Request.Body); err != nil {
+		ginCtx.AbortWithStatus(http.StatusBadRequest)
+
+		return
+	}
+
+	ginCtx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := ginCtx.GetHeader(githubSignatureHeader)
+	if signature, err = hex.DecodeString(strings.TrimPrefix(header, githubSignaturePrefix)); err != nil || !strings.HasPrefix(header, githubSignaturePrefix) {
+		ginCtx.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	mac := hmac.New(sha256.New, []byte(h.settings.Github.Secret))
+	mac.Write(body)
+
+	if h.settings.Github.Secret == "" || !hmac.Equal(signature, mac.Sum(nil)) {
+		ginCtx.AbortWithStatus(http.StatusUnauthorized)
+
+		return
+	}
+
+	ginCtx.Next()
+}
+
+func (h *HandlerWebhooks) HandleGithub(ctx context.Context, input *GithubWorkflowRunInput) (httpserver.Response, error) {
+	if input.Action != githubWorkflowRunCompleted || input.WorkflowRun == nil {
+		return httpserver.NewStatusResponse(http.StatusNoContent), nil
+	}
+
+	return h.releasePipeline(ctx, strconv.FormatInt(input.WorkflowRun.Id, 10))
+}
+
+// HandleGitlab releases the pipeline as soon as it finished or was canceled. Other events, like the one of a single
+// canceled job, are ignored, as the other jobs of the pipeline may still use their claims.
+func (h *HandlerWebhooks) HandleGitlab(ctx context.Context, input *GitlabWebhookInput) (httpserver.Response, error) {
+	if resp := h.authorize(h.settings.Gitlab, input.Token); resp != nil {
+		return resp, nil
+	}
+
+	if input.ObjectKind != gitlabObjectKindPipeline || input.ObjectAttributes == nil || !slices.Contains(gitlabFinishedStatuses, input.ObjectAttributes.Status) {
+		return httpserver.NewStatusResponse(http.StatusNoContent), nil
+	}
+
+	return h.releasePipeline(ctx, strconv.FormatInt(input.ObjectAttributes.Id, 10))
+}
+
+func (h *HandlerWebhooks) authorize(settings WebhookSettings, token string) httpserver.Response {
+	if !settings.Enabled {
+		return httpserver.NewStatusResponse(http.StatusNotFound)
+	}
+
+	if settings.Secret == "" || subtle.ConstantTimeCompare([]byte(settings.Secret), []byte(token)) != 1 {
+		return httpserver.NewStatusResponse(http.StatusUnauthorized)
+	}
+
+	return nil
+}
+
+func (h *HandlerWebhooks) releasePipeline(ctx context.Context, pipelineId string) (httpserver.Response, error) {
+	var err error
+	var released int
+
+	if released, err = h.poolManager.ReleasePipeline(ctx, pipelineId); err != nil {
+		return nil, fmt.Errorf("could not release pipeline %q: %w", pipelineId, err)
+	}
+
+	h.logger.Info(ctx, "released %d deployments of finished ci pipeline %q", released, pipelineId)
+
+	return httpserver.NewJsonResponse(WebhookOutput{
+		PipelineId: pipelineId,
+		Released:   released,
+	}), nil
+}
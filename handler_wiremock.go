@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+type HandlerWiremock struct {
+	logger log.Logger
+	proxy  *WiremockProxy
+}
+
+func NewHandlerWiremock(ctx context.Context, config cfg.Config, logger log.Logger) (*HandlerWiremock, error) {
+	var err error
+	var proxy *WiremockProxy
+
+	if proxy, err = NewWiremockProxy(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create wiremock proxy: %w", err)
+	}
+
+	return &HandlerWiremock{
+		logger: logger.WithChannel("wiremock-proxy"),
+		proxy:  proxy,
+	}, nil
+}
+
+// HandleAdmin forwards the request to the admin api of the claimed wiremock. The body is streamed in both
+// directions, so it is a plain gin handler instead of a bound one.
+func (h *HandlerWiremock) HandleAdmin(ginCtx *gin.Context) {
+	var err error
+	var target *url.URL
+
+	path := ginCtx.Param("path")
+	if path != wiremockAdminPath && !strings.HasPrefix(path, wiremockAdminPath+"/") {
+		ginCtx.String(http.StatusNotFound, "only the wiremock admin api at %s is proxied", wiremockAdminPath)
+
+		return
+	}
+
+	ctx := ginCtx.Request.Context()
+	target, err = h.proxy.Target(ctx, ginCtx.Param("uid"), ginCtx.GetHeader(poolKeyHeader))
+
+	switch {
+	case errors.Is(err, ErrPoolKeyInvalid):
+		ginCtx.String(http.StatusForbidden, "%s of the pool of service %q", err.Error(), ginCtx.Param("uid"))
+
+		return
+	case errors.Is(err, ErrServiceNotFound):
+		ginCtx.String(http.StatusNotFound, "there is no claimed service %q", ginCtx.Param("uid"))
+
+		return
+	case errors.Is(err, ErrNoWiremock):
+		ginCtx.String(http.StatusBadRequest, "service %q is no wiremock", ginCtx.Param("uid"))
+
+		return
+	case err != nil:
+		h.logger.Error(ctx, "could not resolve wiremock %q: %w", ginCtx.Param("uid"), err)
+		ginCtx.Status(http.StatusInternalServerError)
+
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(req *httputil.ProxyRequest) {
+			req.SetURL(target)
+			req.Out.URL.Path = path
+			req.Out.URL.RawPath = ""
+		},
+		ErrorHandler: func(writer http.ResponseWriter, req *http.Request, err error) {
+			h.logger.Warn(req.Context(), "could not proxy request to wiremock %q: %s", target.Host, err.Error())
+			writer.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	proxy.ServeHTTP(ginCtx.Writer, ginCtx.Request)
+}
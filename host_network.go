@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HostNetworkSettings list the component types an admin approved to use the host network or fixed host
+// ports. Both bypass the isolation of the pod network, so they are refused for every other component type.
+type HostNetworkSettings struct {
+	AllowedComponentTypes []string `cfg:"allowed_component_types"`
+}
+
+func ReadHostNetworkSettings(config cfg.Config) (*HostNetworkSettings, error) {
+	settings := &HostNetworkSettings{}
+	if err := config.UnmarshalKey("testcontainers.default.host_network", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal host network settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+func (s *HostNetworkSettings) Allows(componentType string) bool {
+	return slices.Contains(s.AllowedComponentTypes, componentType)
+}
+
+// UsesHostNetwork reports whether the spec runs in the network namespace of the node or binds a port of it.
+func (s ContainerSpec) UsesHostNetwork() bool {
+	if s.HostNetwork {
+		return true
+	}
+
+	for _, binding := range s.PortBindings {
+		if binding.HostPort != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostNetworkAntiAffinity keeps two pods in the host network off the same node. The scheduler already refuses
+// to place pods with the same host port on one node, but a pod in the host network may bind any other port.
+func hostNetworkAntiAffinity(antiAffinity *apiv1.PodAntiAffinity) *apiv1.PodAntiAffinity {
+	if antiAffinity == nil {
+		antiAffinity = &apiv1.PodAntiAffinity{}
+	}
+
+	antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, apiv1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				LabelHostNetwork: "true",
+			},
+		},
+		TopologyKey: apiv1.LabelHostname,
+	})
+
+	return antiAffinity
+}
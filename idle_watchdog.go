@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const metricIdleReplacements = "IdleReplacements"
+
+type IdleWatchdogSettings struct {
+	Enabled        bool          `cfg:"enabled" default:"true"`
+	UnreadyTimeout time.Duration `cfg:"unready_timeout" default:"5m"`
+	RestartLimit   int           `cfg:"restart_limit" default:"3"`
+}
+
+// IdleWatchdog replaces idle deployments whose pods were evicted or preempted, keep restarting or stay unready,
+// so claims never land on a broken container. Claimed deployments are left to the CrashDetector.
+type IdleWatchdog struct {
+	logger      log.Logger
+	clock       clock.Clock
+	metric      metric.Writer
+	k8sClient   *K8sClient
+	poolManager *ServicePoolManager
+	settings    *IdleWatchdogSettings
+}
+
+func NewIdleWatchdog(ctx context.Context, config cfg.Config, logger log.Logger) (*IdleWatchdog, error) {
+	var err error
+	var k8sClient *K8sClient
+	var poolManager *ServicePoolManager
+
+	settings := &IdleWatchdogSettings{}
+	if err = config.UnmarshalKey("idle_watchdog", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal idle watchdog settings: %w", err)
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	return &IdleWatchdog{
+		logger:      logger.WithChannel("idle-watchdog"),
+		clock:       clock.NewRealClock(),
+		metric:      metric.NewWriter(),
+		k8sClient:   k8sClient,
+		poolManager: poolManager,
+		settings:    settings,
+	}, nil
+}
+
+func (w *IdleWatchdog) Check(ctx context.Context) error {
+	if !w.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	var deployments []*appsv1.Deployment
+	var pods []*apiv1.Pod
+
+	if deployments, err = w.k8sClient.ListDeployments(ctx, map[string]string{LableIdle: "true"}); err != nil {
+		return fmt.Errorf("could not list idle deployments: %w", err)
+	}
+
+	if pods, err = w.k8sClient.ListPods(ctx); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+
+	podsByUid := map[string][]*apiv1.Pod{}
+	for _, pod := range pods {
+		uid := pod.GetLabels()[LableUid]
+		podsByUid[uid] = append(podsByUid[uid], pod)
+	}
+
+	for _, deployment := range deployments {
+		reason, unhealthy := w.unhealthyReason(podsByUid[deployment.GetLabels()[LableUid]])
+		if !unhealthy {
+			continue
+		}
+
+		if err = w.replace(ctx, deployment, reason); err != nil {
+			w.logger.Warn(ctx, "could not replace idle deployment %q: %s", deployment.GetName(), err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (w *IdleWatchdog) unhealthyReason(pods []*apiv1.Pod) (string, bool) {
+	now := w.clock.Now()
+
+	for _, pod := range pods {
+		if reason, disrupted := disruptionReason(pod); disrupted {
+			return reason, true
+		}
+
+		if pod.Status.Phase == apiv1.PodFailed {
+			return fmt.Sprintf("pod %q failed: %s %s", pod.GetName(), pod.Status.Reason, pod.Status.Message), true
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			if int(status.RestartCount) > w.settings.RestartLimit {
+				return fmt.Sprintf("container %q of pod %q restarted %d times", status.Name, pod.GetName(), status.RestartCount), true
+			}
+		}
+
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type != apiv1.PodReady || condition.Status == apiv1.ConditionTrue {
+				continue
+			}
+
+			if unready := now.Sub(condition.LastTransitionTime.Time); unready > w.settings.UnreadyTimeout {
+				return fmt.Sprintf("pod %q is unready for %s: %s", pod.GetName(), unready.Round(time.Second), condition.Reason), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// replace deletes the deployment under the lock of its pool and only if it is unchanged since it was checked, so
+// a deployment claimed in the meantime isn't removed. The replacement is spawned with the spec of the deployment,
+// e.g. the one pinned by a rollout.
+func (w *IdleWatchdog) replace(ctx context.Context, deployment *appsv1.Deployment, reason string) error {
+	var err error
+	var pool *ServicePool
+	var replaced bool
+
+	poolId := deployment.GetLabels()[LabelPoolId]
+
+	if pool, err = w.poolManager.getPool(ctx, poolId); err != nil {
+		return fmt.Errorf("could not get pool: %w", err)
+	}
+
+	replaced, err = pool.ReplaceIdle(ctx, deployment)
+
+	if replaced {
+		w.metric.WriteOne(ctx, claimMetric(metricIdleReplacements, deployment))
+		w.logger.Warn(ctx, "replaced idle deployment %q of pool %q: %s", deployment.GetName(), poolId, reason)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not replace deployment: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// UpdateImageInput swaps the image of the main container of a claim, e.g. to redeploy the application under
+// test with a hotfix build. Without a repository the repository of the current image is kept.
+type UpdateImageInput struct {
+	Uid        string        `uri:"uid"`
+	Repository string        `json:"repository"`
+	Tag        string        `json:"tag"`
+	Wait       time.Duration `json:"wait"`
+	PoolKey    string        `header:"X-Pool-Key" json:"-"`
+}
+
+type UpdateImageOutput struct {
+	ClaimId string `json:"claim_id"`
+	Image   string `json:"image"`
+	Status  string `json:"status"`
+}
+
+// UpdateImage replaces the image of the main container of a claimed deployment. Like an env update it rolls
+// out a new pod behind the same service, the labels and annotations of the claim stay untouched apart from
+// the image tag.
+func (c *ServicePoolManager) UpdateImage(ctx context.Context, input *UpdateImageInput) (*UpdateImageOutput, error) {
+	var err error
+	var claim *Claim
+	var deployment *appsv1.Deployment
+
+	if claim, err = c.GetClaim(ctx, input.Uid); err != nil {
+		return nil, err
+	}
+
+	ctx = withObjectFields(ctx, claim.Deployment)
+
+	index := mainContainerIndex(claim.Deployment)
+	if index == -1 {
+		return nil, fmt.Errorf("could not update image of claim %q: %w", input.Uid, ErrMainContainerNotFound)
+	}
+
+	current := claim.Deployment.Spec.Template.Spec.Containers[index].Image
+	image := fmt.Sprintf("%s:%s", imageRepository(current, input.Repository), input.Tag)
+
+	ops := []string{
+		fmt.Sprintf(`{"op": "test", "path": "/spec/template/spec/containers/%d/name", "value": "main"}`, index),
+		fmt.Sprintf(`{"op": "replace", "path": "/spec/template/spec/containers/%d/image", "value": %q}`, index, image),
+		PatchOp("add", "annotations", AnnotationImageTag, input.Tag),
+	}
+
+	if deployment, err = c.k8sClient.PatchDeployment(ctx, claim.Deployment, ops); err != nil {
+		return nil, fmt.Errorf("could not update image of claim %q: %w", input.Uid, err)
+	}
+
+	c.logger.Info(ctx, "updated image of claim %q from %q to %q", input.Uid, current, image)
+
+	output := &UpdateImageOutput{
+		ClaimId: input.Uid,
+		Image:   image,
+		Status:  rolloutStatus(deployment),
+	}
+
+	if input.Wait <= 0 {
+		return output, nil
+	}
+
+	if output.Status, err = waitForRollout(ctx, c.clock, c.k8sClient, deployment, input.Wait); err != nil {
+		return nil, fmt.Errorf("could not wait for the rollout of claim %q: %w", input.Uid, err)
+	}
+
+	return output, nil
+}
+
+// imageRepository returns the repository of the image, stripping the tag or digest. A colon before the last
+// slash belongs to the port of a registry, e.g. "registry:5000/app".
+func imageRepository(image string, repository string) string {
+	if repository != "" {
+		return repository
+	}
+
+	if at := strings.Index(image, "@"); at != -1 {
+		image = image[:at]
+	}
+
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		image = image[:colon]
+	}
+
+	return image
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+// gkeTokenScopes are the scopes the gke-gcloud-auth-plugin requests.
+var gkeTokenScopes = []string{"https://www.googleapis.com/auth/cloud-platform", "https://www.googleapis.com/auth/userinfo.email"}
+
+const (
+	eksTokenPrefix    = "k8s-aws-v1."
+	eksClusterHeader  = "x-k8s-aws-id"
+	eksTokenLifetime  = 15 * time.Minute
+	eksTokenRefreshed = time.Minute
+)
+
+// EksSettings replace the credentials of the kubeconfig with EKS tokens, which are presigned sts get caller
+// identity requests like the ones of "aws eks get-token". They are created with the default aws credentials, or
+// the ones of the role arn if one is set, and created again once they expire, so kubrun can target EKS clusters
+// from CI runners without static tokens.
+type EksSettings struct {
+	ClusterName string `cfg:"cluster_name"`
+	Region      string `cfg:"region"`
+	RoleArn     string `cfg:"role_arn"`
+}
+
+// GkeSettings replace the credentials of the kubeconfig with the tokens of the application default credentials,
+// like the gke-gcloud-auth-plugin does. With workload identity they are the ones of the kubernetes service account
+// kubrun runs as, the tokens are refreshed like the ones of EKS.
+type GkeSettings struct {
+	Enabled bool `cfg:"enabled" default:"false"`
+}
+
+// withTokenAuth replaces the credentials of the primary context of the kubeconfig with the EKS or GKE tokens, if
+// they are configured.
+func withTokenAuth(settings *KubeSettings, clientConfig *rest.Config) error {
+	var err error
+	var source oauth2.TokenSource
+
+	switch {
+	case settings.Eks.ClusterName != "" && settings.Gke.Enabled:
+		return fmt.Errorf("only one of the eks and gke credentials can be used")
+	case settings.Eks.ClusterName != "":
+		if source, err = newEksTokenSource(settings.Eks); err != nil {
+			return fmt.Errorf("could not create eks token source: %w", err)
+		}
+	case settings.Gke.Enabled:
+		if source, err = google.DefaultTokenSource(context.Background(), gkeTokenScopes...); err != nil {
+			return fmt.Errorf("could not create gke token source: %w", err)
+		}
+	default:
+		return nil
+	}
+
+	withTokenSource(clientConfig, source)
+
+	return nil
+}
+
+// withTokenSource sends the tokens of the source instead of the credentials of the kubeconfig. A token is
+// cached until it expires or the api server rejects it.
+func withTokenSource(clientConfig *rest.Config, source oauth2.TokenSource) {
+	clientConfig.BearerToken = ""
+	clientConfig.BearerTokenFile = ""
+	clientConfig.Username = ""
+	clientConfig.Password = ""
+	clientConfig.AuthProvider = nil
+	clientConfig.ExecProvider = nil
+
+	clientConfig.Wrap(transport.ResettableTokenSourceWrapTransport(transport.NewCachedTokenSource(source)))
+}
+
+type eksTokenSource struct {
+	clusterName string
+	presign     *sts.PresignClient
+}
+
+func newEksTokenSource(settings EksSettings) (*eksTokenSource, error) {
+	var err error
+	var config aws.Config
+
+	if config, err = awsConfig.LoadDefaultConfig(context.Background(), awsConfig.WithRegion(settings.Region)); err != nil {
+		return nil, fmt.Errorf("could not load aws config: %w", err)
+	}
+
+	if settings.RoleArn != "" {
+		config.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(config), settings.RoleArn))
+	}
+
+	return &eksTokenSource{
+		clusterName: settings.ClusterName,
+		presign:     sts.NewPresignClient(sts.NewFromConfig(config)),
+	}, nil
+}
+
+// Token presigns a get caller identity request for the cluster, which the api server sends to sts to verify the
+// identity of kubrun. The api server accepts the token for 15 minutes, it is refreshed a minute before.
+func (s *eksTokenSource) Token() (*oauth2.Token, error) {
+	var err error
+	var request *v4.PresignedHTTPRequest
+
+	createdAt := time.Now()
+
+	if request, err = s.presign.PresignGetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{}, func(options *sts.PresignOptions) {
+		options.ClientOptions = append(options.ClientOptions, func(options *sts.Options) {
+			options.APIOptions = append(options.APIOptions,
+				smithyhttp.AddHeaderValue(eksClusterHeader, s.clusterName),
+				smithyhttp.AddHeaderValue("X-Amz-Expires", "60"),
+			)
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("could not presign get caller identity request: %w", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(request.URL)),
+		TokenType:   "Bearer",
+		Expiry:      createdAt.Add(eksTokenLifetime - eksTokenRefreshed),
+	}, nil
+}
+
+// withAuthErrors logs a clear error if the api server rejects the credentials of a context, which are most
+// likely expired or not refreshable anymore, instead of only failing the call with a bare unauthorized.
+func withAuthErrors(logger log.Logger, contextName string, clientConfig *rest.Config) {
+	clientConfig.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err == nil && resp.StatusCode == http.StatusUnauthorized {
+				logger.Warn(req.Context(), "the credentials of kube context %q were rejected on %s %s, they are expired or can't be refreshed", contextName, req.Method, req.URL.Path)
+			}
+
+			return resp, err
+		})
+	})
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"k8s.io/client-go/rest"
+)
+
+// K8sCircuitBreakerSettings stop calling an api server which keeps erroring or throttling. After the
+// threshold of consecutive failures all calls fail right away for the open duration, then a single call probes
+// whether the api server recovered. The pool module reconciles at the degraded interval meanwhile.
+type K8sCircuitBreakerSettings struct {
+	Enabled          bool          `cfg:"enabled" default:"true"`
+	FailureThreshold int           `cfg:"failure_threshold" default:"5"`
+	OpenDuration     time.Duration `cfg:"open_duration" default:"30s"`
+	DegradedInterval time.Duration `cfg:"degraded_interval" default:"5m"`
+}
+
+type K8sUnavailableError struct {
+	ContextName string
+	RetryAfter  time.Duration
+}
+
+func (e *K8sUnavailableError) Error() string {
+	return fmt.Sprintf("the api server of kube context %q keeps failing, calls are suspended for %s", e.ContextName, e.RetryAfter.Round(time.Second))
+}
+
+type circuitBreaker struct {
+	lck         sync.Mutex
+	logger      log.Logger
+	clock       clock.Clock
+	contextName string
+	settings    K8sCircuitBreakerSettings
+	failures    int
+	openUntil   time.Time
+	probing     bool
+}
+
+func newCircuitBreaker(logger log.Logger, contextName string, settings K8sCircuitBreakerSettings) *circuitBreaker {
+	return &circuitBreaker{
+		logger:      logger,
+		clock:       clock.NewRealClock(),
+		contextName: contextName,
+		settings:    settings,
+	}
+}
+
+// Check returns a *K8sUnavailableError while the circuit is open. Once the open duration passed, the next
+// call is let through to probe the api server.
+func (b *circuitBreaker) Check() error {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+
+	return b.check()
+}
+
+// Open reports whether the calls to the api server are currently suspended or wait for a successful probe.
+func (b *circuitBreaker) Open() bool {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+
+	return b.settings.Enabled && b.failures >= b.settings.FailureThreshold
+}
+
+func (b *circuitBreaker) check() error {
+	if !b.settings.Enabled || b.failures < b.settings.FailureThreshold {
+		return nil
+	}
+
+	now := b.clock.Now()
+	if now.Before(b.openUntil) || b.probing {
+		return &K8sUnavailableError{
+			ContextName: b.contextName,
+			RetryAfter:  max(b.openUntil.Sub(now), minRetryAfter),
+		}
+	}
+
+	return nil
+}
+
+func (b *circuitBreaker) allow() error {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+
+	if err := b.check(); err != nil {
+		return err
+	}
+
+	b.probing = b.settings.Enabled && b.failures >= b.settings.FailureThreshold
+
+	return nil
+}
+
+// release ends a probe without an outcome, so the next call probes again.
+func (b *circuitBreaker) release() {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+
+	b.probing = false
+}
+
+func (b *circuitBreaker) record(ctx context.Context, failed bool) {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+
+	wasOpen := b.failures >= b.settings.FailureThreshold
+	b.probing = false
+
+	if !failed {
+		if wasOpen {
+			b.logger.Info(ctx, "the api server of kube context %q recovered, closing the circuit", b.contextName)
+		}
+
+		b.failures = 0
+
+		return
+	}
+
+	b.failures++
+
+	if b.failures < b.settings.FailureThreshold {
+		return
+	}
+
+	b.openUntil = b.clock.Now().Add(b.settings.OpenDuration)
+
+	if !wasOpen {
+		b.logger.Warn(ctx, "the api server of kube context %q failed %d times in a row, suspending calls for %s", b.contextName, b.failures, b.settings.OpenDuration)
+	}
+}
+
+// withCircuitBreaker counts transport errors, server errors and throttled calls as failures. Calls canceled by
+// the caller, e.g. by a request timeout, tell nothing about the api server and aren't counted.
+func withCircuitBreaker(breaker *circuitBreaker, clientConfig *rest.Config) {
+	if !breaker.settings.Enabled {
+		return
+	}
+
+	clientConfig.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if err := breaker.allow(); err != nil {
+				return nil, err
+			}
+
+			resp, err := next.RoundTrip(req)
+
+			switch {
+			case err != nil && req.Context().Err() != nil:
+				breaker.release()
+			case err != nil:
+				breaker.record(req.Context(), true)
+			default:
+				breaker.record(req.Context(), resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests)
+			}
+
+			return resp, err
+		})
+	})
+}
+
+// CheckCircuit returns a *K8sUnavailableError if the calls to the active api server are suspended.
+func (c K8sClient) CheckCircuit() error {
+	return c.api().breaker.Check()
+}
+
+// CircuitOpen reports whether the active api server is considered failing.
+func (c K8sClient) CircuitOpen() bool {
+	return c.api().breaker.Open()
+}
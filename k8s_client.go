@@ -2,30 +2,53 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 
+	"github.com/justtrackio/gosoline/pkg/appctx"
 	"github.com/justtrackio/gosoline/pkg/cfg"
 	"github.com/justtrackio/gosoline/pkg/exec"
 	"github.com/justtrackio/gosoline/pkg/funk"
 	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	clientApps "k8s.io/client-go/kubernetes/typed/apps/v1"
 	clientCore "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
+type k8sClientKey struct{}
+
+var certificateResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+
+var podMetricsResource = schema.GroupVersionResource{Group: "metrics.k8s.io", Version: "v1beta1", Resource: "pods"}
+
+func ProvideK8sClient(ctx context.Context, config cfg.Config, logger log.Logger) (*K8sClient, error) {
+	return appctx.Provide(ctx, k8sClientKey{}, func() (*K8sClient, error) {
+		return NewK8sClient(config, logger)
+	})
+}
+
 func NewK8sClient(config cfg.Config, logger log.Logger) (*K8sClient, error) {
 	var err error
 	var settings *KubeSettings
-	var clientConfig *rest.Config
 
 	if settings, err = ReadSettings(config); err != nil {
 		return nil, fmt.Errorf("could not read kube local settings: %w", err)
@@ -35,13 +58,63 @@ func NewK8sClient(config cfg.Config, logger log.Logger) (*K8sClient, error) {
 		return newK8sClientInCluster(config, logger, settings)
 	}
 
+	if settings.Kubeconfig != "" && settings.KubeconfigPath != "" {
+		return nil, fmt.Errorf("only one of kubeconfig and kubeconfig_path can be set")
+	}
+
+	if settings.Kubeconfig != "" {
+		return newK8sClientInline(config, logger, settings)
+	}
+
 	rules := clientcmd.NewDefaultClientConfigLoadingRules()
-	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
-		CurrentContext: settings.ContextName,
-	})
+	rules.ExplicitPath = settings.KubeconfigPath
+
+	contextNames := append([]string{settings.ContextName}, settings.FailoverContexts...)
+	clientConfigs := make([]*rest.Config, len(contextNames))
+
+	for i, contextName := range contextNames {
+		overrides := &clientcmd.ConfigOverrides{
+			CurrentContext: contextName,
+		}
+
+		loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+		if clientConfigs[i], err = loader.ClientConfig(); err != nil {
+			return nil, fmt.Errorf("could not load config of context %q: %w", contextName, err)
+		}
+
+		// the failover contexts point to other clusters, which the credentials of the primary one don't fit
+		if i == 0 {
+			if err = withTokenAuth(settings, clientConfigs[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return newK8sClientWithFailover(logger, contextNames, clientConfigs, settings)
+}
 
+func newK8sClientInline(config cfg.Config, logger log.Logger, settings *KubeSettings) (*K8sClient, error) {
+	var err error
+	var data []byte
+	var kubeconfig *clientcmdapi.Config
+	var clientConfig *rest.Config
+
+	if data, err = base64.StdEncoding.DecodeString(settings.Kubeconfig); err != nil {
+		return nil, fmt.Errorf("could not decode inline kubeconfig: %w", err)
+	}
+
+	if kubeconfig, err = clientcmd.Load(data); err != nil {
+		return nil, fmt.Errorf("could not parse inline kubeconfig: %w", err)
+	}
+
+	loader := clientcmd.NewNonInteractiveClientConfig(*kubeconfig, settings.ContextName, &clientcmd.ConfigOverrides{}, nil)
 	if clientConfig, err = loader.ClientConfig(); err != nil {
-		return nil, fmt.Errorf("could not load config: %w", err)
+		return nil, fmt.Errorf("could not load inline config: %w", err)
+	}
+
+	if err = withTokenAuth(settings, clientConfig); err != nil {
+		return nil, err
 	}
 
 	return newK8sClient(config, logger, clientConfig, settings)
@@ -59,91 +132,335 @@ func newK8sClientInCluster(config cfg.Config, logger log.Logger, settings *KubeS
 }
 
 func newK8sClient(config cfg.Config, logger log.Logger, clientConfig *rest.Config, settings *KubeSettings) (*K8sClient, error) {
+	return newK8sClientWithFailover(logger, []string{settings.ContextName}, []*rest.Config{clientConfig}, settings)
+}
+
+// newK8sClientWithFailover creates the apis of all contexts in their order of priority. The first one is used
+// until CheckFailover finds it unreachable.
+func newK8sClientWithFailover(logger log.Logger, contextNames []string, clientConfigs []*rest.Config, settings *KubeSettings) (*K8sClient, error) {
+	var err error
+
+	writer := metric.NewWriter()
+	stats := &K8sCallStats{}
+	apis := make([]*k8sApi, len(clientConfigs))
+
+	for i, clientConfig := range clientConfigs {
+		breaker := newCircuitBreaker(logger.WithChannel("k8s"), contextNames[i], settings.CircuitBreaker)
+
+		withAuthErrors(logger.WithChannel("k8s"), contextNames[i], clientConfig)
+		withMetrics(writer, stats, contextNames[i], clientConfig)
+		withCircuitBreaker(breaker, clientConfig)
+
+		if apis[i], err = newK8sApi(contextNames[i], clientConfig, settings.Namespace); err != nil {
+			return nil, fmt.Errorf("could not create api of context %q: %w", contextNames[i], err)
+		}
+
+		apis[i].breaker = breaker
+	}
+
+	client := &K8sClient{
+		logger:    logger.WithChannel("k8s"),
+		namespace: settings.Namespace,
+		shadow:    settings.Shadow,
+		apis:      apis,
+		active:    &atomic.Int32{},
+		locations: newK8sLocations(),
+		stats:     stats,
+	}
+
+	if settings.Shadow {
+		client.shadowed = newShadowObjects()
+	}
+
+	return client, nil
+}
+
+func newK8sApi(contextName string, clientConfig *rest.Config, namespace string) (*k8sApi, error) {
 	var err error
 	var client *kubernetes.Clientset
+	var dynamicClient *dynamic.DynamicClient
 
 	if client, err = kubernetes.NewForConfig(clientConfig); err != nil {
 		return nil, fmt.Errorf("could not create client: %w", err)
 	}
 
-	return &K8sClient{
-		logger:      logger.WithChannel("k8s"),
-		client:      client,
-		deployments: client.AppsV1().Deployments(settings.Namespace),
-		services:    client.CoreV1().Services(settings.Namespace),
+	if dynamicClient, err = dynamic.NewForConfig(clientConfig); err != nil {
+		return nil, fmt.Errorf("could not create dynamic client: %w", err)
+	}
+
+	return &k8sApi{
+		contextName:    contextName,
+		client:         client,
+		deployments:    client.AppsV1().Deployments(namespace),
+		statefulSets:   client.AppsV1().StatefulSets(namespace),
+		services:       client.CoreV1().Services(namespace),
+		pods:           client.CoreV1().Pods(namespace),
+		events:         client.CoreV1().Events(namespace),
+		configMaps:     client.CoreV1().ConfigMaps(namespace),
+		secrets:        client.CoreV1().Secrets(namespace),
+		certificates:   dynamicClient.Resource(certificateResource).Namespace(namespace),
+		podMetrics:     dynamicClient.Resource(podMetricsResource).Namespace(namespace),
+		resourceQuotas: client.CoreV1().ResourceQuotas(namespace),
+		nodes:          client.CoreV1().Nodes(),
+		namespaces:     client.CoreV1().Namespaces(),
+		clusterPods:    client.CoreV1().Pods(apiv1.NamespaceAll),
 	}, nil
 }
 
 type K8sClient struct {
-	logger log.Logger
-	client *kubernetes.Clientset
+	logger    log.Logger
+	namespace string
+	shadow    bool
+	shadowed  *shadowObjects
+	apis      []*k8sApi
+	active    *atomic.Int32
+	locations *k8sLocations
+	stats     *K8sCallStats
+}
+
+// k8sApi holds the clients of one kube context.
+type k8sApi struct {
+	contextName string
+	client      *kubernetes.Clientset
+	breaker     *circuitBreaker
+
+	deployments    clientApps.DeploymentInterface
+	statefulSets   clientApps.StatefulSetInterface
+	services       clientCore.ServiceInterface
+	pods           clientCore.PodInterface
+	events         clientCore.EventInterface
+	configMaps     clientCore.ConfigMapInterface
+	secrets        clientCore.SecretInterface
+	certificates   dynamic.ResourceInterface
+	podMetrics     dynamic.ResourceInterface
+	resourceQuotas clientCore.ResourceQuotaInterface
+	nodes          clientCore.NodeInterface
+	namespaces     clientCore.NamespaceInterface
+	clusterPods    clientCore.PodInterface
+}
+
+func (c K8sClient) api() *k8sApi {
+	return c.apis[c.active.Load()]
+}
+
+// ContextName returns the name of the kube context new claims currently go to.
+func (c K8sClient) ContextName() string {
+	return c.api().contextName
+}
+
+// CallStats returns the number of calls to the api servers and of the failed ones since kubrun started.
+func (c K8sClient) CallStats() (int64, int64) {
+	return c.stats.Get()
+}
+
+// Degraded reports whether kubrun failed over from its primary context.
+func (c K8sClient) Degraded() bool {
+	return c.active.Load() != 0
+}
 
-	deployments clientApps.DeploymentInterface
-	services    clientCore.ServiceInterface
+// CheckFailover switches to the first reachable context in the order of priority, which is the primary one
+// again as soon as it recovers. Only new claims go to the active context, the existing ones are reached in their
+// cluster as long as it is reachable. It returns whether the active context changed.
+func (c K8sClient) CheckFailover(ctx context.Context) (bool, error) {
+	if len(c.apis) == 1 {
+		return false, nil
+	}
+
+	errs := make([]error, 0, len(c.apis))
+
+	for i, api := range c.apis {
+		if err := api.client.RESTClient().Get().AbsPath("/readyz").Do(ctx).Error(); err != nil {
+			errs = append(errs, fmt.Errorf("context %q is unreachable: %w", api.contextName, err))
+
+			continue
+		}
+
+		previous := c.active.Swap(int32(i))
+
+		return previous != int32(i), nil
+	}
+
+	return false, errors.Join(errs...)
 }
 
 func (c K8sClient) ListDeployments(ctx context.Context, selectors ...map[string]string) ([]*appsv1.Deployment, error) {
+	return listEvery(ctx, c, func(api *k8sApi) ([]*appsv1.Deployment, error) {
+		objects, err := api.deployments.List(ctx, c.getListOptions(selectors...))
+		if err != nil {
+			return nil, fmt.Errorf("could not list deployments: %w", err)
+		}
+
+		return funk.Map(objects.Items, func(obj appsv1.Deployment) *appsv1.Deployment {
+			return &obj
+		}), nil
+	}, func(deployment *appsv1.Deployment) bool {
+		return isClaimed(deployment)
+	})
+}
+
+func (c K8sClient) GetDeployment(ctx context.Context, name string) (*appsv1.Deployment, error) {
 	var err error
-	var objects *appsv1.DeploymentList
+	var deployment *appsv1.Deployment
+	var shadowed bool
 
-	if objects, err = c.deployments.List(ctx, c.getListOptions(selectors...)); err != nil {
-		return nil, fmt.Errorf("could not list deployments: %w", err)
+	if deployment, shadowed, err = shadowGet[appsv1.Deployment](c.shadowed, "deployment", name); shadowed || err != nil {
+		return deployment, err
 	}
 
-	return funk.Map(objects.Items, func(obj appsv1.Deployment) *appsv1.Deployment {
-		return &obj
-	}), nil
+	if deployment, err = onObject(c, name, func(api *k8sApi) (*appsv1.Deployment, error) {
+		return api.deployments.Get(ctx, name, metav1.GetOptions{})
+	}); err != nil {
+		return nil, fmt.Errorf("could not get deployment: %w", err)
+	}
+
+	return deployment, nil
 }
 
 func (c K8sClient) CreateDeployment(ctx context.Context, object *appsv1.Deployment) (*appsv1.Deployment, error) {
 	var err error
 	var deployment *appsv1.Deployment
 
-	if deployment, err = c.deployments.Create(ctx, object, metav1.CreateOptions{}); err != nil {
+	c.recordShadow(ctx, "create", "deployment", object.GetName(), object)
+
+	if deployment, err = c.api().deployments.Create(ctx, object, c.createOptions()); err != nil {
+		return nil, fmt.Errorf("could not create deployment: %w", err)
+	}
+
+	if err = c.shadowed.put("deployment", deployment.GetName(), deployment); err != nil {
 		return nil, fmt.Errorf("could not create deployment: %w", err)
 	}
 
 	return deployment, nil
 }
 
+// DeleteDeployment expects the teardown of the deployment to be done, the cleanup finalizer is removed before.
 func (c K8sClient) DeleteDeployment(ctx context.Context, object Objecter) error {
-	if err := c.deployments.Delete(ctx, object.GetName(), metav1.DeleteOptions{}); err != nil {
+	// a deployment deleted outside of kubrun is left to the finalizer module, which runs its teardown
+	if deployment, ok := object.(*appsv1.Deployment); ok && deployment.GetDeletionTimestamp() != nil {
+		return nil
+	}
+
+	if err := c.ReleaseFinalizer(ctx, object); err != nil {
+		return fmt.Errorf("could not delete deployment: %w", err)
+	}
+
+	return c.deleteDeployment(ctx, object.GetName(), c.deleteOptions())
+}
+
+// DeleteDeploymentIfUnchanged deletes the deployment only if it wasn't changed since it was read, e.g. claimed
+// by another request in the meantime. Otherwise, an error matching k8sErrors.IsConflict is returned.
+func (c K8sClient) DeleteDeploymentIfUnchanged(ctx context.Context, deployment *appsv1.Deployment) error {
+	var err error
+	var resourceVersion string
+
+	if deployment.GetDeletionTimestamp() != nil {
+		return nil
+	}
+
+	if resourceVersion, err = c.releaseFinalizer(ctx, deployment, true); err != nil {
+		return fmt.Errorf("could not delete deployment: %w", err)
+	}
+
+	options := c.deleteOptions()
+	options.Preconditions = &metav1.Preconditions{ResourceVersion: &resourceVersion}
+
+	return c.deleteDeployment(ctx, deployment.GetName(), options)
+}
+
+func (c K8sClient) deleteDeployment(ctx context.Context, name string, options metav1.DeleteOptions) error {
+	c.recordShadow(ctx, "delete", "deployment", name, nil)
+
+	if c.shadowed.delete("deployment", name) {
+		return nil
+	}
+
+	if _, err := onObject(c, name, func(api *k8sApi) (any, error) {
+		return nil, api.deployments.Delete(ctx, name, options)
+	}); err != nil {
 		return fmt.Errorf("could not delete deployment: %w", err)
 	}
 
+	c.locations.forget(name)
+
 	return nil
 }
 
 func (c K8sClient) PatchDeployment(ctx context.Context, object *appsv1.Deployment, ops []string) (*appsv1.Deployment, error) {
 	var err error
 	var deployment *appsv1.Deployment
+	var shadowed bool
 
 	patch := []byte(fmt.Sprintf("[%s]", strings.Join(ops, ",")))
-	if deployment, err = c.deployments.Patch(ctx, object.GetName(), types.JSONPatchType, patch, metav1.PatchOptions{}); err != nil {
+	c.recordShadow(ctx, "patch", "deployment", object.GetName(), json.RawMessage(patch))
+
+	if deployment, shadowed, err = shadowPatch[appsv1.Deployment](c.shadowed, "deployment", object.GetName(), patch); shadowed || err != nil {
+		return deployment, err
+	}
+
+	if deployment, err = onObject(c, object.GetName(), func(api *k8sApi) (*appsv1.Deployment, error) {
+		return api.deployments.Patch(ctx, object.GetName(), types.JSONPatchType, patch, c.patchOptions())
+	}); err != nil {
 		return nil, fmt.Errorf("could not patch the deployment '%s': %w", object.GetName(), err)
 	}
 
 	return deployment, nil
 }
 
-func (c K8sClient) ListServices(ctx context.Context, selectors ...map[string]string) ([]*apiv1.Service, error) {
+func (c K8sClient) ListStatefulSets(ctx context.Context, selectors ...map[string]string) ([]*appsv1.StatefulSet, error) {
+	return listEvery(ctx, c, func(api *k8sApi) ([]*appsv1.StatefulSet, error) {
+		objects, err := api.statefulSets.List(ctx, c.getListOptions(selectors...))
+		if err != nil {
+			return nil, fmt.Errorf("could not list stateful sets: %w", err)
+		}
+
+		return funk.Map(objects.Items, func(obj appsv1.StatefulSet) *appsv1.StatefulSet {
+			return &obj
+		}), nil
+	}, func(*appsv1.StatefulSet) bool {
+		return true
+	})
+}
+
+func (c K8sClient) CreateStatefulSet(ctx context.Context, object *appsv1.StatefulSet) (*appsv1.StatefulSet, error) {
 	var err error
-	var objects *apiv1.ServiceList
+	var statefulSet *appsv1.StatefulSet
+
+	c.recordShadow(ctx, "create", "stateful set", object.GetName(), object)
 
-	if objects, err = c.services.List(ctx, c.getListOptions(selectors...)); err != nil {
-		return nil, fmt.Errorf("could not list services: %w", err)
+	if statefulSet, err = c.api().statefulSets.Create(ctx, object, c.createOptions()); err != nil {
+		return nil, fmt.Errorf("could not create stateful set: %w", err)
 	}
 
-	return funk.Map(objects.Items, func(obj apiv1.Service) *apiv1.Service {
-		return &obj
-	}), nil
+	return statefulSet, nil
+}
+
+func (c K8sClient) ListServices(ctx context.Context, selectors ...map[string]string) ([]*apiv1.Service, error) {
+	return listEvery(ctx, c, func(api *k8sApi) ([]*apiv1.Service, error) {
+		objects, err := api.services.List(ctx, c.getListOptions(selectors...))
+		if err != nil {
+			return nil, fmt.Errorf("could not list services: %w", err)
+		}
+
+		return funk.Map(objects.Items, func(obj apiv1.Service) *apiv1.Service {
+			return &obj
+		}), nil
+	}, func(service *apiv1.Service) bool {
+		return isClaimed(service)
+	})
 }
 
 func (c K8sClient) GetService(ctx context.Context, name string) (*apiv1.Service, error) {
 	var err error
 	var service *apiv1.Service
+	var shadowed bool
+
+	if service, shadowed, err = shadowGet[apiv1.Service](c.shadowed, "service", name); shadowed || err != nil {
+		return service, err
+	}
 
-	if service, err = c.services.Get(ctx, name, metav1.GetOptions{}); err != nil {
+	if service, err = onObject(c, name, func(api *k8sApi) (*apiv1.Service, error) {
+		return api.services.Get(ctx, name, metav1.GetOptions{})
+	}); err != nil {
 		return nil, fmt.Errorf("could not get service: %w", err)
 	}
 
@@ -154,7 +471,13 @@ func (c K8sClient) CreateService(ctx context.Context, object *apiv1.Service) (*a
 	var err error
 	var service *apiv1.Service
 
-	if service, err = c.services.Create(ctx, object, metav1.CreateOptions{}); err != nil {
+	c.recordShadow(ctx, "create", "service", object.GetName(), object)
+
+	if service, err = c.api().services.Create(ctx, object, c.createOptions()); err != nil {
+		return nil, fmt.Errorf("could not create service: %w", err)
+	}
+
+	if err = c.shadowed.put("service", service.GetName(), service); err != nil {
 		return nil, fmt.Errorf("could not create service: %w", err)
 	}
 
@@ -162,25 +485,432 @@ func (c K8sClient) CreateService(ctx context.Context, object *apiv1.Service) (*a
 }
 
 func (c K8sClient) DeleteService(ctx context.Context, object Objecter) error {
-	if err := c.services.Delete(ctx, object.GetName(), metav1.DeleteOptions{}); err != nil {
+	c.recordShadow(ctx, "delete", "service", object.GetName(), nil)
+
+	if c.shadowed.delete("service", object.GetName()) {
+		return nil
+	}
+
+	if _, err := onObject(c, object.GetName(), func(api *k8sApi) (any, error) {
+		return nil, api.services.Delete(ctx, object.GetName(), c.deleteOptions())
+	}); err != nil {
 		return fmt.Errorf("could not delete deployment: %w", err)
 	}
 
+	c.locations.forget(object.GetName())
+
 	return nil
 }
 
 func (c K8sClient) PatchService(ctx context.Context, object *apiv1.Service, ops []string) (*apiv1.Service, error) {
 	var err error
 	var service *apiv1.Service
+	var shadowed bool
 
 	patch := []byte(fmt.Sprintf("[%s]", strings.Join(ops, ",")))
-	if service, err = c.services.Patch(ctx, object.GetName(), types.JSONPatchType, patch, metav1.PatchOptions{}); err != nil {
+	c.recordShadow(ctx, "patch", "service", object.GetName(), json.RawMessage(patch))
+
+	if service, shadowed, err = shadowPatch[apiv1.Service](c.shadowed, "service", object.GetName(), patch); shadowed || err != nil {
+		return service, err
+	}
+
+	if service, err = onObject(c, object.GetName(), func(api *k8sApi) (*apiv1.Service, error) {
+		return api.services.Patch(ctx, object.GetName(), types.JSONPatchType, patch, c.patchOptions())
+	}); err != nil {
 		return nil, fmt.Errorf("could not patch the service '%s': %w", object.GetName(), err)
 	}
 
 	return service, nil
 }
 
+func (c K8sClient) GetNamespace(ctx context.Context) (*apiv1.Namespace, error) {
+	var err error
+	var namespace *apiv1.Namespace
+
+	if namespace, err = c.api().namespaces.Get(ctx, c.namespace, metav1.GetOptions{}); err != nil {
+		return nil, fmt.Errorf("could not get namespace %q: %w", c.namespace, err)
+	}
+
+	return namespace, nil
+}
+
+func (c K8sClient) Namespace() string {
+	return c.namespace
+}
+
+func (c K8sClient) ListPods(ctx context.Context, selectors ...map[string]string) ([]*apiv1.Pod, error) {
+	return listEvery(ctx, c, func(api *k8sApi) ([]*apiv1.Pod, error) {
+		objects, err := api.pods.List(ctx, c.getListOptions(selectors...))
+		if err != nil {
+			return nil, fmt.Errorf("could not list pods: %w", err)
+		}
+
+		return funk.Map(objects.Items, func(obj apiv1.Pod) *apiv1.Pod {
+			return &obj
+		}), nil
+	}, func(*apiv1.Pod) bool {
+		return true
+	})
+}
+
+func (c K8sClient) GetPod(ctx context.Context, name string) (*apiv1.Pod, error) {
+	var err error
+	var pod *apiv1.Pod
+
+	if pod, err = onObject(c, name, func(api *k8sApi) (*apiv1.Pod, error) {
+		return api.pods.Get(ctx, name, metav1.GetOptions{})
+	}); err != nil {
+		return nil, fmt.Errorf("could not get pod: %w", err)
+	}
+
+	return pod, nil
+}
+
+// GetContainerLogs returns the output of the container of the pod, limited to the given number of bytes.
+func (c K8sClient) GetContainerLogs(ctx context.Context, podName string, containerName string, limitBytes int64) ([]byte, error) {
+	var err error
+	var logs []byte
+
+	options := &apiv1.PodLogOptions{
+		Container:  containerName,
+		LimitBytes: &limitBytes,
+	}
+
+	if logs, err = onObject(c, podName, func(api *k8sApi) ([]byte, error) {
+		return api.pods.GetLogs(podName, options).DoRaw(ctx)
+	}); err != nil {
+		return nil, fmt.Errorf("could not get logs of container %q of pod %q: %w", containerName, podName, err)
+	}
+
+	return logs, nil
+}
+
+// ListEvents lists the events of the object with the given kind and name.
+func (c K8sClient) ListEvents(ctx context.Context, kind string, name string) ([]*apiv1.Event, error) {
+	var err error
+	var objects *apiv1.EventList
+
+	options := metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.kind=%s,involvedObject.name=%s", kind, name),
+	}
+
+	if objects, err = c.api().events.List(ctx, options); err != nil {
+		return nil, fmt.Errorf("could not list events of %s %q: %w", kind, name, err)
+	}
+
+	return funk.Map(objects.Items, func(obj apiv1.Event) *apiv1.Event {
+		return &obj
+	}), nil
+}
+
+// CreateEvent records a kubernetes event on a managed deployment or service.
+func (c K8sClient) CreateEvent(ctx context.Context, object *apiv1.Event) (*apiv1.Event, error) {
+	var err error
+	var event *apiv1.Event
+
+	c.recordShadow(ctx, "create", "event", object.GetGenerateName(), object)
+
+	if event, err = c.api().events.Create(ctx, object, c.createOptions()); err != nil {
+		return nil, fmt.Errorf("could not create event: %w", err)
+	}
+
+	return event, nil
+}
+
+// WatchPodEvents watches the events of the pods in the namespace, starting with the events which occur after
+// the call. Events which happened before aren't replayed.
+func (c K8sClient) WatchPodEvents(ctx context.Context) (watch.Interface, error) {
+	var err error
+	var objects *apiv1.EventList
+	var watcher watch.Interface
+
+	options := metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Pod",
+	}
+
+	if objects, err = c.api().events.List(ctx, metav1.ListOptions{FieldSelector: options.FieldSelector, Limit: 1}); err != nil {
+		return nil, fmt.Errorf("could not list pod events: %w", err)
+	}
+
+	options.ResourceVersion = objects.GetResourceVersion()
+
+	if watcher, err = c.api().events.Watch(ctx, options); err != nil {
+		return nil, fmt.Errorf("could not watch pod events: %w", err)
+	}
+
+	return watcher, nil
+}
+
+func (c K8sClient) AddEphemeralContainer(ctx context.Context, pod *apiv1.Pod, container apiv1.EphemeralContainer) (*apiv1.Pod, error) {
+	var err error
+	var updated *apiv1.Pod
+
+	pod = pod.DeepCopy()
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, container)
+
+	c.recordShadow(ctx, "update", "ephemeral containers of pod", pod.GetName(), container)
+
+	if updated, err = onObject(c, pod.GetName(), func(api *k8sApi) (*apiv1.Pod, error) {
+		return api.pods.UpdateEphemeralContainers(ctx, pod.GetName(), pod, c.updateOptions())
+	}); err != nil {
+		return nil, fmt.Errorf("could not add ephemeral container to pod '%s': %w", pod.GetName(), err)
+	}
+
+	return updated, nil
+}
+
+// PodUsage is the current resource usage of the containers of a pod as reported by the metrics server.
+type PodUsage struct {
+	Name       string
+	Labels     map[string]string
+	Containers map[string]apiv1.ResourceList
+}
+
+// ListPodUsages reads the pod metrics of the metrics server. They carry the labels of their pods, so they can
+// be selected like the pods themselves.
+func (c K8sClient) ListPodUsages(ctx context.Context, selectors ...map[string]string) ([]*PodUsage, error) {
+	var err error
+	var objects *unstructured.UnstructuredList
+
+	if objects, err = c.api().podMetrics.List(ctx, c.getListOptions(selectors...)); err != nil {
+		return nil, fmt.Errorf("could not list pod metrics: %w", err)
+	}
+
+	usages := make([]*PodUsage, 0, len(objects.Items))
+	for _, object := range objects.Items {
+		usage := &PodUsage{
+			Name:       object.GetName(),
+			Labels:     object.GetLabels(),
+			Containers: map[string]apiv1.ResourceList{},
+		}
+
+		containers, _, _ := unstructured.NestedSlice(object.Object, "containers")
+		for _, container := range containers {
+			fields, ok := container.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			name, _, _ := unstructured.NestedString(fields, "name")
+			values, _, _ := unstructured.NestedStringMap(fields, "usage")
+
+			resources := apiv1.ResourceList{}
+			for resourceName, value := range values {
+				if quantity, err := resource.ParseQuantity(value); err == nil {
+					resources[apiv1.ResourceName(resourceName)] = quantity
+				}
+			}
+
+			usage.Containers[name] = resources
+		}
+
+		usages = append(usages, usage)
+	}
+
+	return usages, nil
+}
+
+func (c K8sClient) CreateCertificate(ctx context.Context, object *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	var err error
+	var certificate *unstructured.Unstructured
+
+	c.recordShadow(ctx, "create", "certificate", object.GetName(), object)
+
+	if certificate, err = c.api().certificates.Create(ctx, object, c.createOptions()); err != nil {
+		return nil, fmt.Errorf("could not create certificate: %w", err)
+	}
+
+	return certificate, nil
+}
+
+func (c K8sClient) CreateConfigMap(ctx context.Context, object *apiv1.ConfigMap) (*apiv1.ConfigMap, error) {
+	var err error
+	var configMap *apiv1.ConfigMap
+
+	c.recordShadow(ctx, "create", "config map", object.GetName(), object)
+
+	if configMap, err = c.api().configMaps.Create(ctx, object, c.createOptions()); err != nil {
+		return nil, fmt.Errorf("could not create config map: %w", err)
+	}
+
+	return configMap, nil
+}
+
+func (c K8sClient) GetConfigMap(ctx context.Context, name string) (*apiv1.ConfigMap, error) {
+	var err error
+	var configMap *apiv1.ConfigMap
+
+	if configMap, err = onObject(c, name, func(api *k8sApi) (*apiv1.ConfigMap, error) {
+		return api.configMaps.Get(ctx, name, metav1.GetOptions{})
+	}); err != nil {
+		return nil, fmt.Errorf("could not get config map: %w", err)
+	}
+
+	return configMap, nil
+}
+
+func (c K8sClient) UpdateConfigMap(ctx context.Context, object *apiv1.ConfigMap) (*apiv1.ConfigMap, error) {
+	var err error
+	var configMap *apiv1.ConfigMap
+
+	c.recordShadow(ctx, "update", "config map", object.GetName(), object)
+
+	if configMap, err = onObject(c, object.GetName(), func(api *k8sApi) (*apiv1.ConfigMap, error) {
+		return api.configMaps.Update(ctx, object, c.updateOptions())
+	}); err != nil {
+		return nil, fmt.Errorf("could not update config map: %w", err)
+	}
+
+	return configMap, nil
+}
+
+func (c K8sClient) GetSecret(ctx context.Context, name string) (*apiv1.Secret, error) {
+	var err error
+	var secret *apiv1.Secret
+
+	if secret, err = onObject(c, name, func(api *k8sApi) (*apiv1.Secret, error) {
+		return api.secrets.Get(ctx, name, metav1.GetOptions{})
+	}); err != nil {
+		return nil, fmt.Errorf("could not get secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+func (c K8sClient) CreateSecret(ctx context.Context, object *apiv1.Secret) (*apiv1.Secret, error) {
+	var err error
+	var secret *apiv1.Secret
+
+	c.recordShadow(ctx, "create", "secret", object.GetName(), nil)
+
+	if secret, err = c.api().secrets.Create(ctx, object, c.createOptions()); err != nil {
+		return nil, fmt.Errorf("could not create secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+func (c K8sClient) DeleteSecret(ctx context.Context, name string) error {
+	c.recordShadow(ctx, "delete", "secret", name, nil)
+
+	if _, err := onObject(c, name, func(api *k8sApi) (any, error) {
+		return nil, api.secrets.Delete(ctx, name, c.deleteOptions())
+	}); err != nil {
+		return fmt.Errorf("could not delete secret: %w", err)
+	}
+
+	c.locations.forget(name)
+
+	return nil
+}
+
+func (c K8sClient) ListResourceQuotas(ctx context.Context) ([]*apiv1.ResourceQuota, error) {
+	var err error
+	var objects *apiv1.ResourceQuotaList
+
+	if objects, err = c.api().resourceQuotas.List(ctx, metav1.ListOptions{}); err != nil {
+		return nil, fmt.Errorf("could not list resource quotas: %w", err)
+	}
+
+	return funk.Map(objects.Items, func(obj apiv1.ResourceQuota) *apiv1.ResourceQuota {
+		return &obj
+	}), nil
+}
+
+func (c K8sClient) ListNodes(ctx context.Context, selectors ...map[string]string) ([]*apiv1.Node, error) {
+	var err error
+	var objects *apiv1.NodeList
+
+	if objects, err = c.api().nodes.List(ctx, c.getListOptions(selectors...)); err != nil {
+		return nil, fmt.Errorf("could not list nodes: %w", err)
+	}
+
+	return funk.Map(objects.Items, func(obj apiv1.Node) *apiv1.Node {
+		return &obj
+	}), nil
+}
+
+// ListClusterPods lists the pods of all namespaces which are not yet terminated. It is used to
+// calculate how much of the allocatable node resources is already requested.
+func (c K8sClient) ListClusterPods(ctx context.Context) ([]*apiv1.Pod, error) {
+	var err error
+	var objects *apiv1.PodList
+
+	options := metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	}
+
+	if objects, err = c.api().clusterPods.List(ctx, options); err != nil {
+		return nil, fmt.Errorf("could not list cluster pods: %w", err)
+	}
+
+	return funk.Map(objects.Items, func(obj apiv1.Pod) *apiv1.Pod {
+		return &obj
+	}), nil
+}
+
+// recordShadow logs a write in shadow mode together with the manifest or patch it would have sent. The
+// contents of secrets and the values of env variables are never logged.
+func (c K8sClient) recordShadow(ctx context.Context, verb string, kind string, name string, body any) {
+	var err error
+	var encoded []byte
+	var decoded any
+
+	if !c.shadow {
+		return
+	}
+
+	if body == nil {
+		c.logger.Info(ctx, "shadow: would %s %s %q", verb, kind, name)
+
+		return
+	}
+
+	if encoded, err = json.Marshal(body); err == nil {
+		err = json.Unmarshal(encoded, &decoded)
+	}
+
+	if err == nil {
+		encoded, err = json.Marshal(redactEnv(decoded))
+	}
+
+	if err != nil {
+		c.logger.Warn(ctx, "shadow: could not encode the %s of %s %q: %s", verb, kind, name, err.Error())
+
+		return
+	}
+
+	c.logger.Info(ctx, "shadow: would %s %s %q: %s", verb, kind, name, encoded)
+}
+
+func (c K8sClient) createOptions() metav1.CreateOptions {
+	return metav1.CreateOptions{DryRun: c.dryRun()}
+}
+
+func (c K8sClient) patchOptions() metav1.PatchOptions {
+	return metav1.PatchOptions{DryRun: c.dryRun()}
+}
+
+func (c K8sClient) updateOptions() metav1.UpdateOptions {
+	return metav1.UpdateOptions{DryRun: c.dryRun()}
+}
+
+// deleteOptions let kubernetes delete the objects owned by the deleted object in the background, e.g. the service,
+// config maps and certificate of a deployment.
+func (c K8sClient) deleteOptions() metav1.DeleteOptions {
+	propagation := metav1.DeletePropagationBackground
+
+	return metav1.DeleteOptions{DryRun: c.dryRun(), PropagationPolicy: &propagation}
+}
+
+func (c K8sClient) dryRun() []string {
+	if !c.shadow {
+		return nil
+	}
+
+	return []string{metav1.DryRunAll}
+}
+
 func (k *K8sClient) getListOptions(selectors ...map[string]string) metav1.ListOptions {
 	set := funk.MergeMaps(selectors...)
 	selector := labels.SelectorFromSet(set)
@@ -190,6 +920,20 @@ func (k *K8sClient) getListOptions(selectors ...map[string]string) metav1.ListOp
 	}
 }
 
+// PatchOp renders a single json patch operation on the metadata of an object, e.g. for the "labels" or
+// "annotations" field. A nil value omits the value of the operation as needed for removals.
+func PatchOp(op string, field string, key string, value any) string {
+	path := fmt.Sprintf("/metadata/%s/%s", field, strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1"))
+
+	if value == nil {
+		return fmt.Sprintf(`{"op": %q, "path": %q}`, op, path)
+	}
+
+	encoded, _ := json.Marshal(value)
+
+	return fmt.Sprintf(`{"op": %q, "path": %q, "value": %s}`, op, path, encoded)
+}
+
 func resourceVersionConflictErrChecker(result any, err error) exec.ErrorType {
 	// Check for Kubernetes conflict error (409) which indicates the object has been modified
 	if k8sErrors.IsConflict(err) {
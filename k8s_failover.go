@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const metricK8sDegraded = "K8sDegraded"
+
+type ClusterStatus struct {
+	ContextName string `json:"context_name"`
+	Degraded    bool   `json:"degraded"`
+	CircuitOpen bool   `json:"circuit_open"`
+}
+
+// K8sFailoverModule probes the api servers of the configured kube contexts and moves new claims to the first
+// reachable one. While kubrun runs against a failover context, the degraded metric is 1.
+type K8sFailoverModule struct {
+	kernel.BackgroundModule
+
+	logger    log.Logger
+	clock     clock.Clock
+	metric    metric.Writer
+	k8sClient *K8sClient
+	settings  *KubeSettings
+}
+
+func NewK8sFailoverModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var settings *KubeSettings
+	var k8sClient *K8sClient
+
+	if settings, err = ReadSettings(config); err != nil {
+		return nil, fmt.Errorf("could not read kube settings: %w", err)
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	return &K8sFailoverModule{
+		logger:    logger.WithChannel("k8s-failover"),
+		clock:     clock.NewRealClock(),
+		metric:    metric.NewWriter(),
+		k8sClient: k8sClient,
+		settings:  settings,
+	}, nil
+}
+
+func (m *K8sFailoverModule) Run(ctx context.Context) error {
+	if len(m.settings.FailoverContexts) == 0 {
+		return nil
+	}
+
+	ticker := m.clock.NewTicker(m.settings.FailoverCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.Chan():
+			m.check(ctx)
+		}
+	}
+}
+
+func (m *K8sFailoverModule) check(ctx context.Context) {
+	switched, err := m.k8sClient.CheckFailover(ctx)
+
+	switch {
+	case err != nil:
+		m.logger.Error(ctx, "no kube context is reachable: %w", err)
+	case switched && m.k8sClient.Degraded():
+		m.logger.Warn(ctx, "failed over to kube context %q", m.k8sClient.ContextName())
+	case switched:
+		m.logger.Info(ctx, "switched back to the primary kube context %q", m.k8sClient.ContextName())
+	}
+
+	degraded := 0.0
+	if m.k8sClient.Degraded() {
+		degraded = 1
+	}
+
+	m.metric.WriteOne(ctx, &metric.Datum{
+		MetricName: metricK8sDegraded,
+		Value:      degraded,
+		Unit:       metric.UnitCount,
+	})
+}
+
+// k8sLocations remembers the contexts of the objects found outside of the active context by their name. The
+// names of the objects of a claim carry its uid, so they are unique across the clusters.
+type k8sLocations struct {
+	lck     sync.Mutex
+	indexes map[string]int32
+}
+
+func newK8sLocations() *k8sLocations {
+	return &k8sLocations{
+		indexes: map[string]int32{},
+	}
+}
+
+func (l *k8sLocations) get(name string) (int32, bool) {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+
+	index, ok := l.indexes[name]
+
+	return index, ok
+}
+
+func (l *k8sLocations) put(name string, index int32) {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+
+	l.indexes[name] = index
+}
+
+func (l *k8sLocations) forget(name string) {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+
+	delete(l.indexes, name)
+}
+
+// onObject runs the call against the context the named object lives in. The claims made before a failover stay
+// in the primary cluster and the ones made during it in the failover cluster, so an object not found in the active
+// context is looked up in the other reachable ones and remembered.
+func onObject[T any](c K8sClient, name string, call func(api *k8sApi) (T, error)) (T, error) {
+	index, located := c.locations.get(name)
+	if !located {
+		index = c.active.Load()
+	}
+
+	result, err := call(c.apis[index])
+	if !k8sErrors.IsNotFound(err) {
+		return result, err
+	}
+
+	c.locations.forget(name)
+
+	for i, api := range c.apis {
+		if int32(i) == index || api.breaker.Open() {
+			continue
+		}
+
+		other, otherErr := call(api)
+		if k8sErrors.IsNotFound(otherErr) {
+			continue
+		}
+
+		if otherErr == nil {
+			c.locations.put(name, int32(i))
+		}
+
+		return other, otherErr
+	}
+
+	return result, err
+}
+
+// listEvery lists the objects of the active context and the kept ones of the other reachable contexts, so the
+// release and the expiry of a claim reach it in whichever cluster it was made. Idle objects of another context
+// aren't kept, new claims only take the ones of the active context.
+func listEvery[T any](ctx context.Context, c K8sClient, list func(api *k8sApi) ([]T, error), keep func(T) bool) ([]T, error) {
+	active := c.active.Load()
+
+	objects, err := list(c.apis[active])
+	if err != nil {
+		return nil, err
+	}
+
+	for i, api := range c.apis {
+		if int32(i) == active || api.breaker.Open() {
+			continue
+		}
+
+		others, err := list(api)
+		if err != nil {
+			c.logger.Debug(ctx, "could not list the objects of the inactive context %q: %s", api.contextName, err.Error())
+
+			continue
+		}
+
+		for _, other := range others {
+			if keep(other) {
+				objects = append(objects, other)
+			}
+		}
+	}
+
+	return objects, nil
+}
+
+func (c K8sClient) Status() *ClusterStatus {
+	return &ClusterStatus{
+		ContextName: c.ContextName(),
+		Degraded:    c.Degraded(),
+		CircuitOpen: c.CircuitOpen(),
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	metricK8sCalls        = "K8sApiCalls"
+	metricK8sCallErrors   = "K8sApiCallErrors"
+	metricK8sCallDuration = "K8sApiCallDuration"
+)
+
+// K8sCallStats counts the calls to the api servers of all contexts and how many of them failed since kubrun
+// started, so a load test can report the error rate of the api servers during its run.
+type K8sCallStats struct {
+	calls  atomic.Int64
+	errors atomic.Int64
+}
+
+func (s *K8sCallStats) Get() (int64, int64) {
+	return s.calls.Load(), s.errors.Load()
+}
+
+// withMetrics writes the duration and the outcome of every call to the api server per verb and resource. It
+// wraps the transport inside of the circuit breaker, so only calls which reached the api server are measured
+// and a slow kubrun can be told apart from a slow api server.
+func withMetrics(writer metric.Writer, stats *K8sCallStats, contextName string, clientConfig *rest.Config) {
+	realClock := clock.NewRealClock()
+
+	clientConfig.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := realClock.Now()
+			resp, err := next.RoundTrip(req)
+			took := realClock.Since(start)
+
+			verb, resource := k8sRequestInfo(req)
+			dimensions := metric.Dimensions{
+				"Context":  contextName,
+				"Verb":     verb,
+				"Resource": resource,
+			}
+
+			data := metric.Data{
+				{
+					MetricName: metricK8sCalls,
+					Dimensions: dimensions,
+					Value:      1,
+					Unit:       metric.UnitCount,
+				},
+				{
+					MetricName: metricK8sCallDuration,
+					Dimensions: dimensions,
+					Value:      float64(took.Milliseconds()),
+					Unit:       metric.UnitMillisecondsAverage,
+				},
+			}
+
+			stats.calls.Add(1)
+
+			if code, failed := k8sCallError(resp, err); failed {
+				stats.errors.Add(1)
+				data = append(data, &metric.Datum{
+					MetricName: metricK8sCallErrors,
+					Dimensions: metric.Dimensions{
+						"Context":  contextName,
+						"Verb":     verb,
+						"Resource": resource,
+						"Code":     code,
+					},
+					Value: 1,
+					Unit:  metric.UnitCount,
+				})
+			}
+
+			writer.Write(req.Context(), data)
+
+			return resp, err
+		})
+	})
+}
+
+// k8sCallError reports whether a call failed and how: with the status code of the response, or as a transport
+// error. Not found and conflicts are answers kubrun expects and handles, they don't count as errors.
+func k8sCallError(resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		return "transport", true
+	}
+
+	switch {
+	case resp.StatusCode < http.StatusBadRequest:
+		return "", false
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusConflict:
+		return "", false
+	default:
+		return strconv.Itoa(resp.StatusCode), true
+	}
+}
+
+// k8sRequestInfo derives the verb and resource of a call from its path, e.g. a GET of
+// /apis/apps/v1/namespaces/kubrun/deployments is a list of deployments. Subresources like the logs of a pod are
+// reported as "pods/log".
+func k8sRequestInfo(req *http.Request) (string, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return strings.ToLower(req.Method), "other"
+	}
+
+	// namespaced resources, a namespace itself is addressed as namespaces/<name>
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+
+	resource := parts[0]
+	named := len(parts) > 1
+
+	if len(parts) > 2 {
+		resource = resource + "/" + parts[2]
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		if named {
+			return "get", resource
+		}
+
+		if req.URL.Query().Get("watch") == "true" {
+			return "watch", resource
+		}
+
+		return "list", resource
+	case http.MethodPost:
+		return "create", resource
+	case http.MethodPut:
+		return "update", resource
+	case http.MethodPatch:
+		return "patch", resource
+	case http.MethodDelete:
+		if !named {
+			return "deletecollection", resource
+		}
+
+		return "delete", resource
+	default:
+		return strings.ToLower(req.Method), resource
+	}
+}
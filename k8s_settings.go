@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/justtrackio/gosoline/pkg/cfg"
 	"github.com/justtrackio/gosoline/pkg/exec"
@@ -15,9 +16,25 @@ const (
 type KubeSettings struct {
 	ClientMode  string `cfg:"client_mode" default:"in-cluster"`
 	ContextName string `cfg:"context_name"`
-	Namespace   string `cfg:"namespace" default:"justdev"`
+	// KubeconfigPath and Kubeconfig replace the default loading rules of the kube-config mode, e.g. for a
+	// kubeconfig mounted at a non-standard location. Kubeconfig holds the whole file base64 encoded.
+	KubeconfigPath string `cfg:"kubeconfig_path"`
+	Kubeconfig     string `cfg:"kubeconfig"`
+	Namespace      string `cfg:"namespace" default:"justdev"`
+	// FailoverContexts of the kubeconfig are used in their order if the api server of the context before is
+	// unreachable. They are only supported by the kube-config mode with a kubeconfig file.
+	FailoverContexts      []string      `cfg:"failover_contexts"`
+	FailoverCheckInterval time.Duration `cfg:"failover_check_interval" default:"10s"`
+	// Shadow sends all writes as server side dry run, so the manifests are validated and logged but nothing
+	// is changed in the namespace. The created deployments and services are kept in memory, so a claim can read
+	// and patch them. Claims of a shadow instance never become ready, it is meant to be fed with mirrored traffic
+	// to validate a new version or config change.
+	Shadow bool `cfg:"shadow" default:"false"`
 
-	Backoff exec.BackoffSettings `cfg:"backoff"`
+	Eks            EksSettings               `cfg:"eks"`
+	Gke            GkeSettings               `cfg:"gke"`
+	CircuitBreaker K8sCircuitBreakerSettings `cfg:"circuit_breaker"`
+	Backoff        exec.BackoffSettings      `cfg:"backoff"`
 }
 
 func ReadSettings(config cfg.Config) (*KubeSettings, error) {
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+)
+
+// shadowObjectsMax bounds the objects kept by a shadow instance, it never removes the objects of released claims.
+const shadowObjectsMax = 1000
+
+// shadowObjects keeps the deployments and services a shadow instance created with a dry run. They never reach
+// the namespace, so the reads, patches and deletes following their creation, e.g. by the claim of a cold spawned
+// deployment, are answered from here instead of failing with not found. A nil store keeps nothing.
+type shadowObjects struct {
+	lck     sync.Mutex
+	keys    []string
+	objects map[string][]byte
+}
+
+func newShadowObjects() *shadowObjects {
+	return &shadowObjects{
+		objects: map[string][]byte{},
+	}
+}
+
+func (s *shadowObjects) put(kind string, name string, object any) error {
+	if s == nil {
+		return nil
+	}
+
+	encoded, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("could not encode shadow %s %q: %w", kind, name, err)
+	}
+
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	key := shadowObjectKey(kind, name)
+	if _, ok := s.objects[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+
+	s.objects[key] = encoded
+
+	if len(s.keys) > shadowObjectsMax {
+		delete(s.objects, s.keys[0])
+		s.keys = s.keys[1:]
+	}
+
+	return nil
+}
+
+func (s *shadowObjects) delete(kind string, name string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	key := shadowObjectKey(kind, name)
+	if _, ok := s.objects[key]; !ok {
+		return false
+	}
+
+	delete(s.objects, key)
+	s.keys = slices.DeleteFunc(s.keys, func(existing string) bool {
+		return existing == key
+	})
+
+	return true
+}
+
+// shadowGet returns a copy of the shadow object, if there is one.
+func shadowGet[T any](s *shadowObjects, kind string, name string) (*T, bool, error) {
+	if s == nil {
+		return nil, false, nil
+	}
+
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	encoded, ok := s.objects[shadowObjectKey(kind, name)]
+	if !ok {
+		return nil, false, nil
+	}
+
+	object := new(T)
+	if err := json.Unmarshal(encoded, object); err != nil {
+		return nil, true, fmt.Errorf("could not decode shadow %s %q: %w", kind, name, err)
+	}
+
+	return object, true, nil
+}
+
+// shadowPatch applies the json patch to the shadow object, if there is one, and returns the patched object.
+func shadowPatch[T any](s *shadowObjects, kind string, name string, patch []byte) (*T, bool, error) {
+	var err error
+	var decoded jsonpatch.Patch
+
+	if s == nil {
+		return nil, false, nil
+	}
+
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	key := shadowObjectKey(kind, name)
+	encoded, ok := s.objects[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if decoded, err = jsonpatch.DecodePatch(patch); err != nil {
+		return nil, true, fmt.Errorf("could not decode the patch of shadow %s %q: %w", kind, name, err)
+	}
+
+	if encoded, err = decoded.Apply(encoded); err != nil {
+		return nil, true, fmt.Errorf("could not patch shadow %s %q: %w", kind, name, err)
+	}
+
+	object := new(T)
+	if err = json.Unmarshal(encoded, object); err != nil {
+		return nil, true, fmt.Errorf("could not decode shadow %s %q: %w", kind, name, err)
+	}
+
+	s.objects[key] = encoded
+
+	return object, true, nil
+}
+
+func shadowObjectKey(kind string, name string) string {
+	return fmt.Sprintf("%s/%s", kind, name)
+}
+
+// redactEnv replaces the values of all env variables in a decoded manifest or json patch, as they carry the
+// generated credentials of a claim in plain text.
+func redactEnv(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		path, _ := typed["path"].(string)
+
+		for key, nested := range typed {
+			if key == "env" || (key == "value" && strings.Contains(path, "/env")) {
+				typed[key] = redactEnvVars(nested)
+
+				continue
+			}
+
+			typed[key] = redactEnv(nested)
+		}
+	case []any:
+		for i, nested := range typed {
+			typed[i] = redactEnv(nested)
+		}
+	}
+
+	return value
+}
+
+// redactEnvVars handles a list of env variables as well as a single one added by a patch.
+func redactEnvVars(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		if _, ok := typed["value"]; ok {
+			typed["value"] = redacted
+		}
+	case []any:
+		for i, envVar := range typed {
+			typed[i] = redactEnvVars(envVar)
+		}
+	}
+
+	return value
+}
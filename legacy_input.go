@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// legacyDuration accepts a duration in nanoseconds as well as a duration string like "10m", which older
+// clients send for the expiry, wait and hold of a claim.
+type legacyDuration time.Duration
+
+func (d *legacyDuration) UnmarshalJSON(data []byte) error {
+	var nanos int64
+	var value string
+
+	if err := json.Unmarshal(data, &nanos); err == nil {
+		*d = legacyDuration(nanos)
+
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("a duration has to be given in nanoseconds or as a string like \"10m\": %w", err)
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", value, err)
+	}
+
+	*d = legacyDuration(duration)
+
+	return nil
+}
+
+// legacyRunInput holds the camel case fields older clients send instead of the current ones.
+type legacyRunInput struct {
+	PoolId        string         `json:"poolId"`
+	TestId        string         `json:"testId"`
+	TestName      string         `json:"testName"`
+	ComponentType string         `json:"componentType"`
+	ComponentName string         `json:"componentName"`
+	ContainerName string         `json:"containerName"`
+	ExpireAfter   legacyDuration `json:"expireAfter"`
+	SessionId     string         `json:"sessionId"`
+}
+
+type legacyStopInput struct {
+	PoolId string `json:"poolId"`
+	TestId string `json:"testId"`
+}
+
+// UnmarshalJSON decodes the current fields of a run input and maps the fields of older clients onto them, so
+// clients don't have to upgrade the moment the api changes. A current field takes precedence over its legacy
+// counterpart.
+func (i *RunInput) UnmarshalJSON(data []byte) error {
+	// the alias drops the methods of RunInput, so decoding into it doesn't call this method again
+	type runInput RunInput
+
+	current := &struct {
+		*runInput
+		ExpireAfter legacyDuration `json:"expire_after"`
+		Wait        legacyDuration `json:"wait"`
+		Hold        legacyDuration `json:"hold"`
+	}{
+		runInput: (*runInput)(i),
+	}
+
+	if err := json.Unmarshal(data, current); err != nil {
+		return err
+	}
+
+	i.ExpireAfter = time.Duration(current.ExpireAfter)
+	i.Wait = time.Duration(current.Wait)
+	i.Hold = time.Duration(current.Hold)
+
+	legacy := &legacyRunInput{}
+	if err := json.Unmarshal(data, legacy); err != nil {
+		return err
+	}
+
+	i.legacyFields = nil
+	i.legacyFields = adaptLegacy(i.legacyFields, "poolId", &i.PoolId, legacy.PoolId)
+	i.legacyFields = adaptLegacy(i.legacyFields, "testId", &i.TestId, legacy.TestId)
+	i.legacyFields = adaptLegacy(i.legacyFields, "testName", &i.TestName, legacy.TestName)
+	i.legacyFields = adaptLegacy(i.legacyFields, "componentType", &i.ComponentType, legacy.ComponentType)
+	i.legacyFields = adaptLegacy(i.legacyFields, "componentName", &i.ComponentName, legacy.ComponentName)
+	i.legacyFields = adaptLegacy(i.legacyFields, "containerName", &i.ContainerName, legacy.ContainerName)
+	i.legacyFields = adaptLegacy(i.legacyFields, "expireAfter", &i.ExpireAfter, time.Duration(legacy.ExpireAfter))
+	i.legacyFields = adaptLegacy(i.legacyFields, "sessionId", &i.SessionId, legacy.SessionId)
+
+	return nil
+}
+
+func (i *StopInput) UnmarshalJSON(data []byte) error {
+	type stopInput StopInput
+
+	if err := json.Unmarshal(data, (*stopInput)(i)); err != nil {
+		return err
+	}
+
+	legacy := &legacyStopInput{}
+	if err := json.Unmarshal(data, legacy); err != nil {
+		return err
+	}
+
+	i.legacyFields = nil
+	i.legacyFields = adaptLegacy(i.legacyFields, "poolId", &i.PoolId, legacy.PoolId)
+	i.legacyFields = adaptLegacy(i.legacyFields, "testId", &i.TestId, legacy.TestId)
+
+	return nil
+}
+
+// LegacyFields returns the legacy fields the client sent, so their use can be logged until the clients upgraded.
+func (i RunInput) LegacyFields() []string {
+	return sortedFields(i.legacyFields)
+}
+
+func (i StopInput) LegacyFields() []string {
+	return sortedFields(i.legacyFields)
+}
+
+// adaptLegacy sets the current field to the legacy value if only the legacy field was sent and records the
+// legacy field.
+func adaptLegacy[T comparable](fields []string, name string, current *T, legacy T) []string {
+	var zero T
+
+	if legacy == zero {
+		return fields
+	}
+
+	if *current == zero {
+		*current = legacy
+	}
+
+	return append(fields, name)
+}
+
+func sortedFields(fields []string) []string {
+	sorted := append([]string{}, fields...)
+	sort.Strings(sorted)
+
+	return sorted
+}
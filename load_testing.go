@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+)
+
+const (
+	loadTestPoolId    = "kubrun-load-test"
+	loadTestMaxErrors = 10
+)
+
+// LoadTestSettings cap the workload an operator can start, so a typo doesn't flood the cluster.
+type LoadTestSettings struct {
+	MaxClaims      int           `cfg:"max_claims" default:"500"`
+	MaxConcurrency int           `cfg:"max_concurrency" default:"20"`
+	MaxHold        time.Duration `cfg:"max_hold" default:"1m"`
+}
+
+func ReadLoadTestSettings(config cfg.Config) (*LoadTestSettings, error) {
+	settings := &LoadTestSettings{}
+	if err := config.UnmarshalKey("load_test", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal load test settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// LoadTestInput describes the simulated workload: the pool is warmed up with the given number of idle
+// deployments, then the claims are made by the given number of concurrent clients, each held for the hold
+// before it is released.
+type LoadTestInput struct {
+	Token         string        `header:"X-Admin-Token"`
+	ComponentType string        `json:"component_type"`
+	WarmUp        int           `json:"warm_up"`
+	Claims        int           `json:"claims"`
+	Concurrency   int           `json:"concurrency"`
+	Hold          time.Duration `json:"hold"`
+}
+
+// LoadTestReport holds the latencies of the claims and releases and the error rate of the api servers during the
+// run. The api calls are those of the whole replica, so other traffic during the run is included.
+type LoadTestReport struct {
+	PoolId         string             `json:"pool_id"`
+	ComponentType  string             `json:"component_type"`
+	Claims         int                `json:"claims"`
+	Failed         int                `json:"failed"`
+	Errors         []string           `json:"errors,omitempty"`
+	ClaimLatency   LatencyPercentiles `json:"claim_latency"`
+	ReleaseLatency LatencyPercentiles `json:"release_latency"`
+	ApiCalls       int64              `json:"api_calls"`
+	ApiErrors      int64              `json:"api_errors"`
+	ApiErrorRate   float64            `json:"api_error_rate"`
+	Duration       time.Duration      `json:"duration"`
+}
+
+type LatencyPercentiles struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// loadTestRecorder collects the outcome of the concurrent clients.
+type loadTestRecorder struct {
+	lck      sync.Mutex
+	claims   []time.Duration
+	releases []time.Duration
+	failed   int
+	errors   []string
+}
+
+func (r *loadTestRecorder) fail(err error) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	r.failed++
+
+	if msg := err.Error(); len(r.errors) < loadTestMaxErrors && !slices.Contains(r.errors, msg) {
+		r.errors = append(r.errors, msg)
+	}
+}
+
+func (r *loadTestRecorder) claimed(took time.Duration) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	r.claims = append(r.claims, took)
+}
+
+func (r *loadTestRecorder) released(took time.Duration) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	r.releases = append(r.releases, took)
+}
+
+// RunLoadTest simulates the workload against a sandbox pool and removes everything it spawned afterward, also if
+// the request is canceled. The claims bypass the claim limits, the history and the events of the manager, so
+// neither the limits nor the usage reports of real tests are affected.
+func (c *ServicePoolManager) RunLoadTest(ctx context.Context, input *LoadTestInput) (*LoadTestReport, error) {
+	var err error
+	var pool *ServicePool
+
+	componentType := c.specs.Resolve(input.ComponentType)
+
+	if pool, err = c.getPool(ctx, loadTestPoolId); err != nil {
+		return nil, fmt.Errorf("could not get pool: %w", err)
+	}
+
+	defer func() {
+		if _, err := pool.Shutdown(context.WithoutCancel(ctx)); err != nil {
+			c.logger.Warn(ctx, "could not remove the deployments of the load test: %s", err.Error())
+		}
+	}()
+
+	if input.WarmUp > 0 {
+		warmUp := &WarmUpInput{
+			PoolId:     loadTestPoolId,
+			Components: map[string]int{componentType: input.WarmUp},
+		}
+
+		if err = pool.WarmUp(ctx, warmUp, nil); err != nil {
+			return nil, fmt.Errorf("could not warm up the load test pool: %w", err)
+		}
+	}
+
+	callsBefore, errorsBefore := c.k8sClient.CallStats()
+	started := c.clock.Now()
+
+	recorder := &loadTestRecorder{}
+	claims := make(chan int, input.Claims)
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < input.Claims; i++ {
+		claims <- i
+	}
+	close(claims)
+
+	for i := 0; i < input.Concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range claims {
+				if ctx.Err() != nil {
+					return
+				}
+
+				c.loadTestClaim(ctx, pool, componentType, i, input.Hold, recorder)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	callsAfter, errorsAfter := c.k8sClient.CallStats()
+
+	report := &LoadTestReport{
+		PoolId:         loadTestPoolId,
+		ComponentType:  componentType,
+		Claims:         len(recorder.claims),
+		Failed:         recorder.failed,
+		Errors:         recorder.errors,
+		ClaimLatency:   latencyPercentiles(recorder.claims),
+		ReleaseLatency: latencyPercentiles(recorder.releases),
+		ApiCalls:       callsAfter - callsBefore,
+		ApiErrors:      errorsAfter - errorsBefore,
+		Duration:       c.clock.Since(started),
+	}
+
+	if report.ApiCalls > 0 {
+		report.ApiErrorRate = float64(report.ApiErrors) / float64(report.ApiCalls)
+	}
+
+	c.logger.Info(ctx, "load test of component type %q finished after %s: %d claims, %d failed, p99 of %s, %d of %d api calls failed",
+		componentType, report.Duration, report.Claims, report.Failed, report.ClaimLatency.P99, report.ApiErrors, report.ApiCalls)
+
+	return report, nil
+}
+
+func (c *ServicePoolManager) loadTestClaim(ctx context.Context, pool *ServicePool, componentType string, i int, hold time.Duration, recorder *loadTestRecorder) {
+	var err error
+
+	input := &RunInput{
+		PoolId:        loadTestPoolId,
+		TestId:        fmt.Sprintf("load-test-%d", i),
+		TestName:      "load test",
+		ComponentType: componentType,
+		ContainerName: "main",
+		ExpireAfter:   c.retention.For(componentType).DefaultTtl,
+	}
+
+	start := c.clock.Now()
+	if _, err = pool.ClaimService(ctx, input, false); err != nil {
+		recorder.fail(fmt.Errorf("could not claim: %w", err))
+
+		return
+	}
+	recorder.claimed(c.clock.Since(start))
+
+	if hold > 0 {
+		timer := c.clock.NewTimer(hold)
+
+		select {
+		case <-ctx.Done():
+		case <-timer.Chan():
+		}
+
+		timer.Stop()
+	}
+
+	release := StopInput{PoolId: input.PoolId, TestId: input.TestId}
+
+	start = c.clock.Now()
+	if _, err = pool.ReleaseServices(context.WithoutCancel(ctx), release.GetLabels()); err != nil {
+		recorder.fail(fmt.Errorf("could not release: %w", err))
+
+		return
+	}
+	recorder.released(c.clock.Since(start))
+}
+
+func latencyPercentiles(samples []time.Duration) LatencyPercentiles {
+	if len(samples) == 0 {
+		return LatencyPercentiles{}
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	percentile := func(p float64) time.Duration {
+		index := int(math.Ceil(p*float64(len(sorted)))) - 1
+
+		return sorted[max(0, min(index, len(sorted)-1))]
+	}
+
+	return LatencyPercentiles{
+		P50: percentile(0.5),
+		P90: percentile(0.9),
+		P99: percentile(0.99),
+		Max: sorted[len(sorted)-1],
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+// withTestFields adds the pool, test and component type of a request to the log fields of the context, so every
+// log line of a claim, extension or release can be found by the test id.
+func withTestFields(ctx context.Context, poolId string, testId string, componentType string) context.Context {
+	return log.AppendContextFields(ctx, nonEmptyFields(log.Fields{
+		"pool-id":        poolId,
+		"test-id":        testId,
+		"component-type": componentType,
+	}))
+}
+
+// withObjectFields adds the fields of a deployment or service to the log fields of the context. An idle
+// deployment carries no test id yet, so a test id already in the context is kept.
+func withObjectFields(ctx context.Context, object Objecter) context.Context {
+	labels := object.GetLabels()
+
+	componentType := object.GetAnnotations()[AnnotationComponentType]
+	if componentType == "" {
+		componentType = labels[LabelComponentType]
+	}
+
+	return log.AppendContextFields(ctx, nonEmptyFields(log.Fields{
+		"pool-id":        labels[LabelPoolId],
+		"test-id":        labels[LabelTestId],
+		"uid":            labels[LableUid],
+		"component-type": componentType,
+		"object":         object.GetName(),
+	}))
+}
+
+func nonEmptyFields(fields log.Fields) log.Fields {
+	for key, value := range fields {
+		if value == "" {
+			delete(fields, key)
+		}
+	}
+
+	return fields
+}
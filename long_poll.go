@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// LongPollSettings control the claims of /run/long-poll. While the cluster has no capacity left, the claim is
+// retried at the interval, or earlier if the capacity check expects capacity to be freed, until the hold of
+// the request is over. A request without a hold is held for the max hold, which has to stay well below the long
+// request timeout, so the claim made at the end of the hold still gets its response out.
+type LongPollSettings struct {
+	Interval time.Duration `cfg:"interval" default:"5s"`
+	MaxHold  time.Duration `cfg:"max_hold" default:"2m"`
+}
+
+// FetchServiceLongPoll claims a service like FetchService, but retries the claim as long as the capacity is
+// exhausted. Once the hold is over, the last *CapacityExhaustedError is returned.
+func (c *ServicePoolManager) FetchServiceLongPoll(ctx context.Context, input *RunInput) (*Claim, error) {
+	var err error
+	var claim *Claim
+	var capacityErr *CapacityExhaustedError
+
+	hold := c.longPoll.MaxHold
+	if input.Hold > 0 && input.Hold < hold {
+		hold = input.Hold
+	}
+
+	timer := c.clock.NewTimer(hold)
+	defer timer.Stop()
+
+	for {
+		if claim, err = c.FetchService(ctx, input); !errors.As(err, &capacityErr) {
+			return claim, err
+		}
+
+		retryIn := min(c.longPoll.Interval, capacityErr.RetryAfter)
+		c.logger.Info(ctx, "no capacity left to claim %s for test %q, retrying in %s", input.ComponentType, input.TestId, retryIn)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-timer.Chan():
+			return nil, err
+		case <-c.clock.After(retryIn):
+		}
+	}
+}
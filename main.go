@@ -8,5 +8,15 @@ import (
 func main() {
 	httpserver.RunDefaultServer(NewRouter, []application.Option{
 		application.WithModuleFactory("pool-manager", NewPoolModule),
+		application.WithModuleFactory("warmup-jobs", NewWarmUpJobModule),
+		application.WithModuleFactory("predictive-warmup", NewPredictiveWarmUpModule),
+		application.WithModuleFactory("metric-remote-write", NewRemoteWriteModule),
+		application.WithModuleFactory("pod-events", NewPodEventModule),
+		application.WithModuleFactory("sessions", NewSessionModule),
+		application.WithModuleFactory("canary", NewCanaryModule),
+		application.WithModuleFactory("soak-test", NewSoakTestModule),
+		application.WithModuleFactory("right-sizing", NewRightSizingModule),
+		application.WithModuleFactory("k8s-failover", NewK8sFailoverModule),
+		application.WithModuleFactory("finalizers", NewFinalizerModule),
 	}...)
 }
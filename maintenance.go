@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/appctx"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+)
+
+const maintenanceState = "maintenance"
+
+// MaintenanceError is returned for new claims while the maintenance mode is on.
+type MaintenanceError struct {
+	Reason string
+}
+
+func (e *MaintenanceError) Error() string {
+	return fmt.Sprintf("kubrun is in maintenance mode: %s", e.Reason)
+}
+
+type MaintenanceInput struct {
+	Token   string `header:"X-Admin-Token"`
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+type MaintenanceStatusInput struct {
+	Token string `header:"X-Admin-Token"`
+}
+
+type MaintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+type maintenanceKey struct{}
+
+func ProvideMaintenance(ctx context.Context, config cfg.Config, logger log.Logger) (*Maintenance, error) {
+	return appctx.Provide(ctx, maintenanceKey{}, func() (*Maintenance, error) {
+		var err error
+		var store *StateStore
+
+		if store, err = ProvideStateStore(ctx, config, logger); err != nil {
+			return nil, fmt.Errorf("could not create state store: %w", err)
+		}
+
+		maintenance := &Maintenance{
+			clock: clock.NewRealClock(),
+			store: store,
+		}
+
+		if _, err = store.Load(ctx, maintenanceState, &maintenance.status); err != nil {
+			return nil, fmt.Errorf("could not load maintenance status: %w", err)
+		}
+
+		return maintenance, nil
+	})
+}
+
+// Maintenance rejects new claims while it is enabled, e.g. before a cluster upgrade. Extends, stops and the
+// expiry of claims continue as usual. The switch is stored in a config map and read on startup, so it survives a
+// restart. Other running replicas pick it up on their next restart.
+type Maintenance struct {
+	lck    sync.RWMutex
+	clock  clock.Clock
+	store  *StateStore
+	status MaintenanceStatus
+}
+
+func (m *Maintenance) Set(ctx context.Context, enabled bool, reason string) (MaintenanceStatus, error) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+
+	status := MaintenanceStatus{}
+
+	if enabled {
+		status = m.status
+		status.Enabled = true
+		status.Reason = reason
+
+		if !m.status.Enabled {
+			now := m.clock.Now()
+			status.Since = &now
+		}
+	}
+
+	if err := m.store.Save(ctx, maintenanceState, status); err != nil {
+		return m.status, fmt.Errorf("could not store maintenance status: %w", err)
+	}
+
+	m.status = status
+
+	return m.status, nil
+}
+
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.lck.RLock()
+	defer m.lck.RUnlock()
+
+	return m.status
+}
+
+// Check returns a *MaintenanceError if the maintenance mode is on.
+func (m *Maintenance) Check() error {
+	m.lck.RLock()
+	defer m.lck.RUnlock()
+
+	if !m.status.Enabled {
+		return nil
+	}
+
+	return &MaintenanceError{
+		Reason: m.status.Reason,
+	}
+}
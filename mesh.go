@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MeshProviderIstio   = "istio"
+	MeshProviderLinkerd = "linkerd"
+
+	MeshProxyMesh    = "mesh"
+	MeshProxyExclude = "exclude"
+
+	annotationIstioInject              = "sidecar.istio.io/inject"
+	annotationIstioExcludeInboundPorts = "traffic.sidecar.istio.io/excludeInboundPorts"
+	annotationLinkerdInject            = "linkerd.io/inject"
+	annotationLinkerdOpaquePorts       = "config.linkerd.io/opaque-ports"
+	annotationLinkerdSkipInboundPorts  = "config.linkerd.io/skip-inbound-ports"
+)
+
+// TestContainerMeshSettings control the sidecar injection of a service mesh on the spawned pods. Test databases
+// usually have to opt out, as the sidecar breaks protocols where the server speaks first. The setting of a
+// component type takes precedence over the one of the pool, which takes precedence over the default.
+//
+// The wiremock proxy and the journals of the release artifacts talk to the claimed pods directly. With the
+// proxy mode "mesh" kubrun has to run with a sidecar itself, which adds the mTLS to its requests. With the mode
+// "exclude" the proxied ports of injected pods bypass their sidecar, so kubrun can stay outside of the mesh
+// even if the namespace enforces strict mTLS.
+type TestContainerMeshSettings struct {
+	Provider       string          `cfg:"provider"`
+	Inject         bool            `cfg:"inject" default:"false"`
+	ComponentTypes map[string]bool `cfg:"component_types"`
+	Pools          map[string]bool `cfg:"pools"`
+	Proxy          string          `cfg:"proxy" default:"mesh"`
+	ProxiedPorts   []string        `cfg:"proxied_ports"`
+}
+
+func (s TestContainerMeshSettings) validate() error {
+	switch s.Provider {
+	case "", MeshProviderIstio, MeshProviderLinkerd:
+	default:
+		return fmt.Errorf("unknown mesh provider %q, it has to be %s or %s", s.Provider, MeshProviderIstio, MeshProviderLinkerd)
+	}
+
+	switch s.Proxy {
+	case MeshProxyMesh, MeshProxyExclude:
+		return nil
+	default:
+		return fmt.Errorf("unknown mesh proxy mode %q, it has to be %s or %s", s.Proxy, MeshProxyMesh, MeshProxyExclude)
+	}
+}
+
+func (f *TestContainerFactory) meshInject(input SpawnAble) bool {
+	inject := f.settings.Mesh.Inject
+
+	if poolInject, ok := f.settings.Mesh.Pools[input.GetPoolId()]; ok {
+		inject = poolInject
+	}
+
+	if componentInject, ok := f.settings.Mesh.ComponentTypes[input.GetComponentType()]; ok {
+		inject = componentInject
+	}
+
+	return inject
+}
+
+// meshAnnotations returns the pod annotations which enable or disable the sidecar injection. Linkerd can't
+// detect the protocol of server speaks first protocols like mysql, so all ports which aren't http are marked
+// as opaque.
+func (f *TestContainerFactory) meshAnnotations(input SpawnAble) map[string]string {
+	inject := f.meshInject(input)
+
+	switch f.settings.Mesh.Provider {
+	case MeshProviderIstio:
+		annotations := map[string]string{
+			annotationIstioInject: strconv.FormatBool(inject),
+		}
+
+		if excluded := f.meshExcludedPorts(input); inject && len(excluded) > 0 {
+			annotations[annotationIstioExcludeInboundPorts] = strings.Join(excluded, ",")
+		}
+
+		return annotations
+	case MeshProviderLinkerd:
+		if !inject {
+			return map[string]string{
+				annotationLinkerdInject: "disabled",
+			}
+		}
+
+		annotations := map[string]string{
+			annotationLinkerdInject: "enabled",
+		}
+
+		opaquePorts := make([]string, 0)
+		for portName, binding := range input.GetSpec().PortBindings {
+			if appProtocol(portName) == "tcp" {
+				opaquePorts = append(opaquePorts, strconv.Itoa(binding.ContainerPort))
+			}
+		}
+
+		if len(opaquePorts) > 0 {
+			sort.Strings(opaquePorts)
+			annotations[annotationLinkerdOpaquePorts] = strings.Join(opaquePorts, ",")
+		}
+
+		if excluded := f.meshExcludedPorts(input); len(excluded) > 0 {
+			annotations[annotationLinkerdSkipInboundPorts] = strings.Join(excluded, ",")
+		}
+
+		return annotations
+	default:
+		return map[string]string{}
+	}
+}
+
+// meshExcludedPorts returns the container ports kubrun talks to directly if they should bypass the sidecar.
+func (f *TestContainerFactory) meshExcludedPorts(input SpawnAble) []string {
+	if f.settings.Mesh.Proxy != MeshProxyExclude {
+		return nil
+	}
+
+	excluded := make([]string, 0)
+	for portName, binding := range input.GetSpec().PortBindings {
+		if slices.Contains(f.settings.Mesh.ProxiedPorts, portName) {
+			excluded = append(excluded, strconv.Itoa(binding.ContainerPort))
+		}
+	}
+	sort.Strings(excluded)
+
+	return excluded
+}
+
+// meshAppProtocol sets the app protocol of a service port, which the meshes use instead of the istio port
+// naming convention, so the port names of the specs stay unchanged.
+func (f *TestContainerFactory) meshAppProtocol(portName string) *string {
+	if f.settings.Mesh.Provider == "" {
+		return nil
+	}
+
+	protocol := appProtocol(portName)
+
+	return &protocol
+}
+
+// appProtocol derives the protocol from the port name following the istio convention, e.g. "http" or
+// "grpc-web". All other ports are treated as plain tcp.
+func appProtocol(portName string) string {
+	name := strings.ToLower(portName)
+
+	for _, protocol := range []string{"http2", "https", "http", "grpc-web", "grpc"} {
+		if name == protocol || strings.HasPrefix(name, protocol+"-") {
+			return protocol
+		}
+	}
+
+	return "tcp"
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/justtrackio/gosoline/pkg/metric"
+)
+
+const (
+	metricClaims        = "Claims"
+	metricClaimFailures = "ClaimFailures"
+	metricReleases      = "Releases"
+	metricExpirations   = "Expirations"
+
+	metricPoolIdle    = "PoolIdleDeployments"
+	metricPoolClaimed = "PoolClaimedDeployments"
+	metricPoolFailed  = "PoolFailedClaims"
+)
+
+// claimMetric counts a claim event of the deployment per component type and image tag, so a canary tag
+// can be compared with the stable one.
+func claimMetric(name string, object AnnotationsAware) *metric.Datum {
+	annotations := object.GetAnnotations()
+
+	return &metric.Datum{
+		MetricName: name,
+		Dimensions: metric.Dimensions{
+			"ComponentType": annotations[AnnotationComponentType],
+			"ImageTag":      annotations[AnnotationImageTag],
+		},
+		Value: 1,
+		Unit:  metric.UnitCount,
+	}
+}
+
+// poolGaugeMetrics converts the pool gauges into metrics with the pool and component type as dimensions.
+func poolGaugeMetrics(gauges []*PoolGauge) metric.Data {
+	data := make(metric.Data, 0, len(gauges)*3)
+
+	for _, gauge := range gauges {
+		dimensions := metric.Dimensions{
+			"PoolId":        gauge.PoolId,
+			"ComponentType": gauge.ComponentType,
+		}
+
+		for name, value := range map[string]int{
+			metricPoolIdle:    gauge.Idle,
+			metricPoolClaimed: gauge.Claimed,
+			metricPoolFailed:  gauge.Failed,
+		} {
+			data = append(data, &metric.Datum{
+				MetricName: name,
+				Dimensions: dimensions,
+				Value:      float64(value),
+				Unit:       metric.UnitCountMaximum,
+			})
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// aliasHashLength is the length of the test hash scoping an alias, the alias itself has to leave room for it
+// within a dns label.
+const aliasHashLength = 8
+
+// CreateAlias creates an ExternalName service, so the claimed service can be reached under a fixed hostname the
+// configs of the other components of the test are built with, e.g. "db" of the test as "db-1a2b3c4d". The claimed
+// deployment owns the alias, so it is garbage collected together with it.
+func (f *TestContainerFactory) CreateAlias(alias string, testId string, service *apiv1.Service, owner *appsv1.Deployment) *apiv1.Service {
+	return &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: AliasName(alias, testId),
+			Labels: map[string]string{
+				LabelAlias:   alias,
+				LabelAliasOf: owner.GetLabels()[LableUid],
+				LabelTestId:  K8sNameString(testId),
+			},
+			OwnerReferences: ownerReferences(owner),
+		},
+		Spec: apiv1.ServiceSpec{
+			Type:         apiv1.ServiceTypeExternalName,
+			ExternalName: fmt.Sprintf("%s.%s.svc.cluster.local", service.GetName(), f.namespace),
+			Ports:        service.Spec.Ports,
+		},
+	}
+}
+
+// AliasName scopes the alias to the test, so the same alias of concurrent tests doesn't collide in the namespace.
+func AliasName(alias string, testId string) string {
+	sum := sha256.Sum256([]byte(K8sNameString(testId)))
+
+	return fmt.Sprintf("%s-%s", alias, hex.EncodeToString(sum[:])[:aliasHashLength])
+}
+
+// createAliases points the aliases to the claimed service and returns their service names by alias. An alias the
+// test created for an earlier claim is moved over to the new one.
+func (c *ServicePool) createAliases(ctx context.Context, claim *Claim, input *RunInput) (map[string]string, error) {
+	var err error
+	var services []*apiv1.Service
+
+	names := make(map[string]string, len(input.Aliases))
+
+	for _, alias := range input.Aliases {
+		if services, err = c.k8sClient.ListServices(ctx, map[string]string{LabelAlias: alias, LabelTestId: K8sNameString(input.TestId)}); err != nil {
+			return nil, fmt.Errorf("could not list services of alias %q: %w", alias, err)
+		}
+
+		for _, service := range services {
+			if err = c.k8sClient.DeleteService(ctx, service); err != nil {
+				return nil, fmt.Errorf("could not delete previous alias %q: %w", alias, err)
+			}
+		}
+
+		if _, err = c.k8sClient.CreateService(ctx, c.factory.CreateAlias(alias, input.TestId, claim.Service, claim.Deployment)); err != nil {
+			return nil, fmt.Errorf("could not create alias %q: %w", alias, err)
+		}
+
+		names[alias] = AliasName(alias, input.TestId)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	reasonOomKilled = "OOMKilled"
+
+	metricOomKills = "OomKills"
+)
+
+type OomDetectorSettings struct {
+	Enabled bool `cfg:"enabled" default:"true"`
+	// Retry releases an OOM killed claim and claims a fresh deployment with the memory multiplied by the factor
+	// as both its request and limit, as long as it stays within the max memory.
+	Retry     bool    `cfg:"retry" default:"false"`
+	Factor    float64 `cfg:"factor" default:"2"`
+	MaxMemory string  `cfg:"max_memory" default:"4Gi"`
+}
+
+// OomDetector records every OOM kill of a claimed container in the claim history, so the defaults of the
+// component types can be fixed, and optionally retries the claim with more memory.
+type OomDetector struct {
+	logger      log.Logger
+	metric      metric.Writer
+	k8sClient   *K8sClient
+	poolManager *ServicePoolManager
+	settings    *OomDetectorSettings
+	maxMemory   resource.Quantity
+}
+
+func NewOomDetector(ctx context.Context, config cfg.Config, logger log.Logger) (*OomDetector, error) {
+	var err error
+	var k8sClient *K8sClient
+	var poolManager *ServicePoolManager
+	var maxMemory resource.Quantity
+
+	settings := &OomDetectorSettings{}
+	if err = config.UnmarshalKey("oom_detector", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal oom detector settings: %w", err)
+	}
+
+	if maxMemory, err = resource.ParseQuantity(settings.MaxMemory); err != nil {
+		return nil, fmt.Errorf("invalid max memory %q: %w", settings.MaxMemory, err)
+	}
+
+	if settings.Factor <= 1 {
+		return nil, fmt.Errorf("the memory factor has to be greater than 1 but is %g", settings.Factor)
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	return &OomDetector{
+		logger:      logger.WithChannel("oom-detector"),
+		metric:      metric.NewWriter(),
+		k8sClient:   k8sClient,
+		poolManager: poolManager,
+		settings:    settings,
+		maxMemory:   maxMemory,
+	}, nil
+}
+
+func (d *OomDetector) Check(ctx context.Context) error {
+	if !d.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	var deployments []*appsv1.Deployment
+	var pods []*apiv1.Pod
+
+	if deployments, err = d.k8sClient.ListDeployments(ctx); err != nil {
+		return fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	if pods, err = d.k8sClient.ListPods(ctx); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+
+	podsByUid := map[string][]*apiv1.Pod{}
+	for _, pod := range pods {
+		uid := pod.GetLabels()[LableUid]
+		podsByUid[uid] = append(podsByUid[uid], pod)
+	}
+
+	for _, deployment := range deployments {
+		if !isClaimed(deployment) || deployment.GetAnnotations()[AnnotationClaimStatus] == ClaimStatusFailed {
+			continue
+		}
+
+		restarts, killed := d.oomKilled(deployment, podsByUid[deployment.GetLabels()[LableUid]])
+		if !killed {
+			continue
+		}
+
+		if err = d.record(ctx, deployment, restarts); err != nil {
+			return fmt.Errorf("could not record oom kill of deployment %q: %w", deployment.GetName(), err)
+		}
+
+		if d.settings.Retry {
+			d.retry(ctx, deployment, podsByUid[deployment.GetLabels()[LableUid]])
+		}
+	}
+
+	return nil
+}
+
+// oomKilled reports whether a container of the deployment was OOM killed since the last recorded kill and
+// returns the restart count the kill is recorded with.
+func (d *OomDetector) oomKilled(deployment *appsv1.Deployment, pods []*apiv1.Pod) (int, bool) {
+	recorded, _ := strconv.Atoi(deployment.GetAnnotations()[AnnotationOomRecordedRestarts])
+
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if int(status.RestartCount) <= recorded {
+				continue
+			}
+
+			if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.Reason == reasonOomKilled {
+				return int(status.RestartCount), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func (d *OomDetector) record(ctx context.Context, deployment *appsv1.Deployment, restarts int) error {
+	ops := []string{
+		PatchOp("add", "annotations", AnnotationOomRecordedRestarts, strconv.Itoa(restarts)),
+	}
+
+	if _, err := d.k8sClient.PatchDeployment(ctx, deployment, ops); err != nil {
+		return fmt.Errorf("could not patch deployment: %w", err)
+	}
+
+	d.poolManager.recordHistory(ctx, HistoryRecord{
+		Event:         HistoryEventOomKill,
+		PoolId:        deployment.GetLabels()[LabelPoolId],
+		TestId:        deployment.GetLabels()[LabelTestId],
+		ComponentType: deployment.GetAnnotations()[AnnotationComponentType],
+		ClaimId:       deployment.GetLabels()[LableUid],
+		Team:          deployment.GetLabels()[LabelTeam],
+	})
+
+	d.metric.WriteOne(ctx, claimMetric(metricOomKills, deployment))
+	d.logger.Warn(ctx, "container of deployment %q in pool %q for test %q was OOM killed", deployment.GetName(), deployment.GetLabels()[LabelPoolId], deployment.GetLabels()[LabelTestId])
+
+	return nil
+}
+
+// retry claims a fresh deployment with the boosted memory as its request and limit. Claims already at the max
+// memory are left to the crash detector.
+func (d *OomDetector) retry(ctx context.Context, deployment *appsv1.Deployment, pods []*apiv1.Pod) {
+	var err error
+	var claim *Claim
+
+	memory, ok := d.boostedMemory(deployment, pods)
+	if !ok {
+		d.logger.Info(ctx, "not retrying deployment %q as its memory reached the max memory of %s", deployment.GetName(), d.settings.MaxMemory)
+
+		return
+	}
+
+	resources := &ResourceSpec{
+		Memory:      memory.String(),
+		MemoryLimit: memory.String(),
+	}
+
+	if claim, err = d.poolManager.ReplaceFailedClaim(ctx, deployment, resources); err != nil {
+		d.logger.Warn(ctx, "could not retry OOM killed deployment %q: %s", deployment.GetName(), err.Error())
+
+		return
+	}
+
+	d.poolManager.recordHistory(ctx, HistoryRecord{
+		Event:         HistoryEventOomRetry,
+		PoolId:        deployment.GetLabels()[LabelPoolId],
+		TestId:        deployment.GetLabels()[LabelTestId],
+		ComponentType: deployment.GetAnnotations()[AnnotationComponentType],
+		ClaimId:       claim.GetId(),
+		Team:          deployment.GetLabels()[LabelTeam],
+	})
+
+	d.logger.Info(ctx, "retried OOM killed deployment %q with %s memory as claim %q", deployment.GetName(), resources.Memory, claim.GetId())
+}
+
+// boostedMemory multiplies the memory the main container was killed at, which is its limit or, without one,
+// its request. The pods are checked as well, as they carry the limits defaulted by the namespace.
+func (d *OomDetector) boostedMemory(deployment *appsv1.Deployment, pods []*apiv1.Pod) (resource.Quantity, bool) {
+	var current resource.Quantity
+
+	podSpecs := []apiv1.PodSpec{deployment.Spec.Template.Spec}
+	for _, pod := range pods {
+		podSpecs = append(podSpecs, pod.Spec)
+	}
+
+	for _, podSpec := range podSpecs {
+		for _, container := range podSpec.Containers {
+			if container.Name != "main" {
+				continue
+			}
+
+			for _, memory := range []resource.Quantity{container.Resources.Requests[apiv1.ResourceMemory], container.Resources.Limits[apiv1.ResourceMemory]} {
+				if memory.Cmp(current) > 0 {
+					current = memory
+				}
+			}
+		}
+	}
+
+	if current.Cmp(d.maxMemory) >= 0 {
+		return current, false
+	}
+
+	boosted := resource.NewQuantity(int64(float64(current.Value())*d.settings.Factor), resource.BinarySI)
+	if boosted.Cmp(d.maxMemory) > 0 {
+		return d.maxMemory, true
+	}
+
+	return *boosted, true
+}
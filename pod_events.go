@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+type PodEventSettings struct {
+	Enabled    bool          `cfg:"enabled" default:"true"`
+	RetryDelay time.Duration `cfg:"retry_delay" default:"10s"`
+}
+
+// PodEventModule logs the warning events of spawned pods, like failing image pulls, back-offs after OOM kills
+// or evictions, with the pool and test of the claim as fields, so the cause shows up next to the claim.
+type PodEventModule struct {
+	kernel.BackgroundModule
+
+	logger    log.Logger
+	clock     clock.Clock
+	k8sClient *K8sClient
+	settings  *PodEventSettings
+}
+
+func NewPodEventModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var k8sClient *K8sClient
+
+	settings := &PodEventSettings{}
+	if err = config.UnmarshalKey("pod_events", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal pod event settings: %w", err)
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	return &PodEventModule{
+		logger:    logger.WithChannel("pod-events"),
+		clock:     clock.NewRealClock(),
+		k8sClient: k8sClient,
+		settings:  settings,
+	}, nil
+}
+
+func (m *PodEventModule) Run(ctx context.Context) error {
+	if !m.settings.Enabled {
+		return nil
+	}
+
+	for {
+		// the api server closes watches after a while, so the watch is restarted until the module stops
+		if err := m.watch(ctx); err != nil {
+			m.logger.Warn(ctx, "could not watch pod events: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-m.clock.After(m.settings.RetryDelay):
+		}
+	}
+}
+
+func (m *PodEventModule) watch(ctx context.Context) error {
+	var err error
+	var watcher watch.Interface
+
+	if watcher, err = m.k8sClient.WatchPodEvents(ctx); err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case result, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+
+			if event, isEvent := result.Object.(*apiv1.Event); isEvent && result.Type != watch.Deleted {
+				m.logEvent(ctx, event)
+			}
+		}
+	}
+}
+
+func (m *PodEventModule) logEvent(ctx context.Context, event *apiv1.Event) {
+	if event.Type != apiv1.EventTypeWarning {
+		return
+	}
+
+	pod, err := m.k8sClient.GetPod(ctx, event.InvolvedObject.Name)
+	if err != nil {
+		m.logger.Debug(ctx, "could not get pod %q of event %q: %s", event.InvolvedObject.Name, event.Reason, err.Error())
+
+		return
+	}
+
+	labels := pod.GetLabels()
+	if _, ok := labels[LabelPoolId]; !ok {
+		return
+	}
+
+	logger := m.logger.WithFields(log.Fields{
+		"pool-id":        labels[LabelPoolId],
+		"test-id":        m.testId(ctx, pod),
+		"uid":            labels[LableUid],
+		"component-type": labels[LabelComponentType],
+		"pod":            pod.GetName(),
+		"reason":         event.Reason,
+	})
+
+	message := event.Message
+	if terminated := lastTerminationReason(pod); terminated != "" {
+		message = fmt.Sprintf("%s (last termination: %s)", message, terminated)
+	}
+
+	logger.Warn(ctx, "pod %q: %s: %s", pod.GetName(), event.Reason, message)
+}
+
+// testId returns the test id of the deployment owning the pod. The pods of a warm deployment keep the labels of
+// their template, so only the deployment is labeled with the test id once it is claimed.
+func (m *PodEventModule) testId(ctx context.Context, pod *apiv1.Pod) string {
+	var err error
+	var deployments []*appsv1.Deployment
+
+	if deployments, err = m.k8sClient.ListDeployments(ctx, map[string]string{LableUid: pod.GetLabels()[LableUid]}); err != nil {
+		m.logger.Debug(ctx, "could not list the deployment of pod %q: %s", pod.GetName(), err.Error())
+
+		return ""
+	}
+
+	for _, deployment := range deployments {
+		if testId := deployment.GetLabels()[LabelTestId]; testId != "" {
+			return testId
+		}
+	}
+
+	return ""
+}
+
+// lastTerminationReason returns the reason a container of the pod was terminated with the last time, e.g.
+// OOMKilled, as the back-off events don't tell why the container restarted.
+func lastTerminationReason(pod *apiv1.Pod) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.Reason != "" {
+			return fmt.Sprintf("container %q %s with exit code %d", status.Name, terminated.Reason, terminated.ExitCode)
+		}
+	}
+
+	return ""
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"slices"
 	"sort"
 	"strings"
@@ -16,6 +19,8 @@ import (
 	"github.com/justtrackio/gosoline/pkg/uuid"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 var specs = map[string]ContainerSpec{
@@ -29,6 +34,43 @@ var specs = map[string]ContainerSpec{
 			},
 		},
 	},
+	"keycloak": {
+		Repository: "quay.io/keycloak/keycloak",
+		Tag:        "26.0",
+		Cmd:        []string{"start-dev"},
+		Env: map[string]string{
+			"KC_DB":                       "postgres",
+			"KC_DB_URL":                   "jdbc:postgresql://{{ index .Dependencies \"postgres\" }}:5432/keycloak",
+			"KC_DB_USERNAME":              "keycloak",
+			"KC_DB_PASSWORD":              "keycloak",
+			"KC_BOOTSTRAP_ADMIN_USERNAME": "admin",
+			"KC_BOOTSTRAP_ADMIN_PASSWORD": "admin",
+		},
+		PortBindings: map[string]PortBinding{
+			"main": {
+				ContainerPort: 8080,
+				Protocol:      "tcp",
+			},
+		},
+		Dependencies: []DependencySpec{
+			{
+				Name:       "postgres",
+				Repository: "postgres",
+				Tag:        "16-alpine",
+				Env: map[string]string{
+					"POSTGRES_DB":       "keycloak",
+					"POSTGRES_USER":     "keycloak",
+					"POSTGRES_PASSWORD": "keycloak",
+				},
+				PortBindings: map[string]PortBinding{
+					"main": {
+						ContainerPort: 5432,
+						Protocol:      "tcp",
+					},
+				},
+			},
+		},
+	},
 	"localstack": {
 		Repository: "localstack/localstack",
 		Tag:        "4.1.0",
@@ -67,6 +109,26 @@ var specs = map[string]ContainerSpec{
 			},
 		},
 	},
+	"redis-cluster": {
+		Repository: "redis",
+		Tag:        "7",
+		Cmd: []string{
+			"redis-server",
+			"--cluster-enabled", "yes",
+			"--cluster-config-file", "nodes.conf",
+			"--cluster-node-timeout", "5000",
+			"--appendonly", "no",
+		},
+		PortBindings: map[string]PortBinding{
+			"main": {
+				ContainerPort: 6379,
+				Protocol:      "tcp",
+			},
+		},
+		RedisCluster: &RedisClusterSpec{
+			Nodes: 3,
+		},
+	},
 	"s3": {
 		Repository: "minio/minio",
 		Tag:        "RELEASE.2024-02-17T01-15-57Z",
@@ -102,71 +164,280 @@ type ServicePool struct {
 	lck       sync.RWMutex
 	logger    log.Logger
 	k8sClient *K8sClient
-	factory   *TestContainerFactory
+	factory   DeploymentFactory
+	capacity  *CapacityChecker
+	specs     *SpecRegistry
+	retention *RetentionPolicies
+	hooks     *PreDeleteHooks
+	sizer     *RightSizer
+	events    *EventRecorder
+	store     *StateStore
+	limiter   *ClaimLimiter
+	strategy  ClaimStrategy
+	fallbacks *ClaimFallbacks
 	id        string
 	clock     clock.Clock
+
+	// pinnedSpecs are set by a rollout and hold the spec to warm up and claim per component type. They are kept
+	// in the state store, so they survive a restart.
+	pinnedSpecs map[string]ContainerSpec
 }
 
-func NewServicePool(config cfg.Config, logger log.Logger, k8sClient *K8sClient, id string) (*ServicePool, error) {
+func NewServicePool(config cfg.Config, logger log.Logger, k8sClient *K8sClient, capacity *CapacityChecker, hooks *PreDeleteHooks, sizer *RightSizer, events *EventRecorder, store *StateStore, limiter *ClaimLimiter, id string) (*ServicePool, error) {
 	var err error
-	var factory *TestContainerFactory
+	var factories *DeploymentFactories
+	var specs *SpecRegistry
+	var retention *RetentionPolicies
+	var strategy ClaimStrategy
+	var fallbacks *ClaimFallbacks
+
+	if factories, err = NewDeploymentFactories(config); err != nil {
+		return nil, fmt.Errorf("could not create deployment factories: %w", err)
+	}
 
-	if factory, err = NewTestContainerFactory(config); err != nil {
-		return nil, fmt.Errorf("could not create test container factory: %w", err)
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return nil, fmt.Errorf("could not create spec registry: %w", err)
+	}
+
+	if retention, err = NewRetentionPolicies(config); err != nil {
+		return nil, fmt.Errorf("could not create retention policies: %w", err)
+	}
+
+	if strategy, err = NewClaimStrategy(config, k8sClient); err != nil {
+		return nil, fmt.Errorf("could not create claim strategy: %w", err)
+	}
+
+	if fallbacks, err = NewClaimFallbacks(config); err != nil {
+		return nil, fmt.Errorf("could not create claim fallbacks: %w", err)
 	}
 
 	return &ServicePool{
 		logger:    logger.WithChannel("pool").WithFields(log.Fields{"pool-id": id}),
 		k8sClient: k8sClient,
-		factory:   factory,
+		factory:   factories.For(id),
+		capacity:  capacity,
+		specs:     specs,
+		retention: retention,
+		hooks:     hooks,
+		sizer:     sizer,
+		events:    events,
+		store:     store,
+		limiter:   limiter,
+		strategy:  strategy,
+		fallbacks: fallbacks,
 		id:        id,
 		clock:     clock.NewRealClock(),
+
+		pinnedSpecs: map[string]ContainerSpec{},
 	}, nil
 }
 
-func (c *ServicePool) WarmUp(ctx context.Context, input *WarmUpInput) error {
+// WarmUp spawns the requested number of idle deployments per component type. The optional progress
+// callback is invoked after every spawned deployment.
+func (c *ServicePool) WarmUp(ctx context.Context, input *WarmUpInput, progress func()) error {
 	var ok bool
 	var spec ContainerSpec
 
 	for componentType, count := range input.Components {
-		if spec, ok = specs[componentType]; !ok {
+		if _, ok = c.specs.Get(componentType); !ok {
 			c.logger.Info(ctx, "no warm up spec found for component type %q: skipping", componentType)
 
 			continue
 		}
 
-		warmUp := &WarmUpDeployment{
-			PoolId:        input.PoolId,
-			ComponentType: componentType,
-			ContainerName: "main",
-			Spec:          spec,
-		}
-
 		for i := 0; i < count; i++ {
+			// the spec is picked per deployment, so a canary tag is spawned for the configured share of the deployments
+			spec = c.warmUpSpec(componentType)
+			warmUp := &WarmUpDeployment{
+				PoolId:        input.PoolId,
+				ComponentType: componentType,
+				ContainerName: "main",
+				Spec:          spec,
+			}
+
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("warm up was stopped: %w", err)
+			}
+
 			if _, err := c.spawnDeployment(ctx, warmUp); err != nil {
 				return fmt.Errorf("could not spawn warm up deployment: %w", err)
 			}
+
+			if progress != nil {
+				progress()
+			}
 		}
 	}
 
 	return nil
 }
 
-func (c *ServicePool) Shutdown(ctx context.Context) error {
+func (c *ServicePool) warmUpSpec(componentType string) ContainerSpec {
+	c.lck.RLock()
+	defer c.lck.RUnlock()
+
+	spec, _ := c.pickSpec(componentType)
+
+	return spec
+}
+
+// pickSpec returns the spec pinned by a rollout or picks one of the registry. The caller has to hold the lock of
+// the pool.
+func (c *ServicePool) pickSpec(componentType string) (ContainerSpec, bool) {
+	if pinned, ok := c.pinnedSpecs[componentType]; ok {
+		return pinned, true
+	}
+
+	return c.specs.Pick(componentType)
+}
+
+// claimSpec returns the spec to spawn a deployment for the claim with. A claim without a repository takes the
+// spec a warm up of its component type would use, with the env and the redis cluster of the claim merged in. The
+// caller has to hold the lock of the pool.
+func (c *ServicePool) claimSpec(input *RunInput) ContainerSpec {
+	if input.Spec.Repository != "" {
+		return input.Spec
+	}
+
+	spec, _ := c.pickSpec(input.ComponentType)
+
+	env := maps.Clone(spec.Env)
+	if env == nil {
+		env = map[string]string{}
+	}
+
+	maps.Copy(env, input.Spec.Env)
+	spec.Env = env
+
+	if input.Spec.RedisCluster != nil {
+		spec.RedisCluster = input.Spec.RedisCluster
+	}
+
+	return spec
+}
+
+func (c *ServicePool) Shutdown(ctx context.Context) ([]*appsv1.Deployment, error) {
 	return c.ReleaseServices(ctx, map[string]string{LabelPoolId: c.id})
 }
 
-func (c *ServicePool) ClaimService(ctx context.Context, input *RunInput) (*apiv1.Service, error) {
+// ClaimService claims a deployment for the input. If the pool has no matching idle deployment, the fallback
+// policy of the pool decides whether to spawn one, to wait for one or to fail. The pool is only locked per
+// attempt, so the claims of other tests and the replacements they spawn aren't blocked while waiting.
+func (c *ServicePool) ClaimService(ctx context.Context, input *RunInput, limited bool) (*Claim, error) {
+	var err error
+	var claim *Claim
+
+	if claim, err = c.claimWithFallback(ctx, input, limited); err != nil {
+		return nil, err
+	}
+
+	// the aliases are created outside of the pool lock, which the release of a claim failing on them takes
+	if claim.Aliases, err = c.createAliases(ctx, claim, input); err != nil {
+		if _, releaseErr := c.ReleaseServices(ctx, map[string]string{LabelPoolId: c.id, LableUid: claim.GetId()}); releaseErr != nil {
+			c.logger.Warn(ctx, "could not release claim %q after its aliases failed: %s", claim.GetId(), releaseErr.Error())
+		}
+
+		return nil, fmt.Errorf("could not create aliases: %w", err)
+	}
+
+	if !input.ReturnPodIps {
+		return claim, nil
+	}
+
+	if claim.Pods, err = c.k8sClient.ListPods(ctx, map[string]string{LableUid: claim.GetId()}); err != nil {
+		return nil, fmt.Errorf("could not list pods of claimed deployment: %w", err)
+	}
+
+	return claim, nil
+}
+
+func (c *ServicePool) claimWithFallback(ctx context.Context, input *RunInput, limited bool) (*Claim, error) {
+	var err error
+	var claim *Claim
+
+	policy := c.fallbacks.For(c.id)
+	if policy.Policy != ClaimFallbackWait {
+		return c.claimService(ctx, input, policy.Policy, limited)
+	}
+
+	timer := c.clock.NewTimer(policy.Wait)
+	defer timer.Stop()
+
+	ticker := c.clock.NewTicker(policy.Interval)
+	defer ticker.Stop()
+
+	for waited := false; ; waited = true {
+		if claim, err = c.claimService(ctx, input, ClaimFallbackFail, limited); !errors.Is(err, ErrNoIdleDeployment) {
+			if err == nil && waited {
+				claim.Path = ClaimPathWaited
+			}
+
+			return claim, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stopped waiting for an idle deployment: %w", ctx.Err())
+		case <-timer.Chan():
+			c.logger.Info(ctx, "no idle deployment of component type %q became available within %s: spawning", input.ComponentType, policy.Wait)
+
+			return c.claimService(ctx, input, ClaimFallbackSpawn, limited)
+		case <-ticker.Chan():
+		}
+	}
+}
+
+// claimService claims a deployment under the lock of the pool. A limited claim checks the claim limit of the test
+// under the lock as well, so concurrent claims of the test can't exceed it together.
+func (c *ServicePool) claimService(ctx context.Context, input *RunInput, fallback string, limited bool) (*Claim, error) {
 	c.lck.Lock()
 	defer c.lck.Unlock()
 
 	var err error
+	var claim *Claim
+	var hostname string
+
+	if limited {
+		if err = c.limiter.Check(ctx, input.TestId); err != nil {
+			return nil, err
+		}
+	}
+
+	// validate the dns name upfront to not leave a half claimed deployment behind
+	if input.DnsName != "" {
+		if _, hostname, err = c.factory.ExternalDnsAnnotations(input.DnsName); err != nil {
+			return nil, fmt.Errorf("invalid dns name: %w", err)
+		}
+	}
+
+	// the spec is resolved once, every deployment spawned for the claim is spawned from it
+	spec := c.claimSpec(input)
+
+	if input.GenerateCredentials {
+		claim, err = c.claimWithCredentials(ctx, input, spec)
+	} else if spec.NeedsDedicatedDeployment() || input.Spec.RedisCluster != nil {
+		// a claim asking for a redis cluster of its own size doesn't take a warm cluster either
+		claim, err = c.claimDedicated(ctx, input, spec)
+	} else {
+		claim, err = c.claimIdle(ctx, input, spec, fallback)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	claim.Hostname = hostname
+
+	return claim, nil
+}
+
+func (c *ServicePool) claimIdle(ctx context.Context, input *RunInput, spec ContainerSpec, fallback string) (*Claim, error) {
+	var err error
+	var deployment *appsv1.Deployment
 	var deployments []*appsv1.Deployment
 	var service *apiv1.Service
 
-	if _, err = c.spawnDeployment(ctx, input); err != nil {
-		return nil, fmt.Errorf("could not spawn deployment: %w", err)
-	}
+	var capacityErr *CapacityExhaustedError
 
 	labels := map[string]string{
 		LabelPoolId:        K8sNameString(c.id),
@@ -179,78 +450,242 @@ func (c *ServicePool) ClaimService(ctx context.Context, input *RunInput) (*apiv1
 		return nil, fmt.Errorf("failed to list deployments: %w", err)
 	}
 
-	slices.SortFunc(deployments, func(a, b *appsv1.Deployment) int {
-		if a.CreationTimestamp.Before(&b.CreationTimestamp) {
-			return -1
-		}
+	// an idle deployment carrying a test id is inconsistent and left to the consistency checker
+	deployments = slices.DeleteFunc(deployments, func(deployment *appsv1.Deployment) bool {
+		return deployment.GetLabels()[LabelTestId] != ""
+	})
 
-		return 1
+	// a claim only takes deployments spawned from the very spec it would spawn one with, as neither the tag nor the
+	// env, cmd or resources of a running container can be changed. This also keeps deployments of the spec replaced
+	// by a rollout from being handed out.
+	idle := len(deployments)
+	specVersion := SpecVersion(spec)
+
+	deployments = slices.DeleteFunc(deployments, func(deployment *appsv1.Deployment) bool {
+		return deployment.GetLabels()[LabelSpecVersion] != specVersion
 	})
 
+	if len(deployments) == 0 && idle > 0 {
+		c.logger.Info(ctx, "none of %d idle deployments of component type %q matches the spec of the claim", idle, input.ComponentType)
+	}
+
+	if len(deployments) == 0 && fallback == ClaimFallbackFail {
+		return nil, fmt.Errorf("could not claim component type %q: %w", input.ComponentType, ErrNoIdleDeployment)
+	}
+
+	// a cold spawned deployment is created with the ttl of the claim right away
+	if len(deployments) == 0 {
+		spawnInput := *input
+		spawnInput.Spec = spec
+
+		if deployment, err = c.spawnDeployment(ctx, &spawnInput); err != nil {
+			return nil, fmt.Errorf("could not spawn deployment: %w", err)
+		}
+
+		if service, err = c.claimDeployment(ctx, deployment, input); err != nil {
+			return nil, fmt.Errorf("could not claim deployment: %w", err)
+		}
+
+		return &Claim{
+			Deployment: deployment,
+			Service:    service,
+			Path:       ClaimPathSpawned,
+		}, nil
+	}
+
+	if err = c.strategy.Order(ctx, input, deployments); err != nil {
+		return nil, fmt.Errorf("could not order idle deployments: %w", err)
+	}
+
 	if service, err = c.claimDeployment(ctx, deployments[0], input); err != nil {
 		return nil, fmt.Errorf("could not claim deployment: %w", err)
 	}
 
-	return service, nil
+	// the claimed deployment is replaced with an idle one of the warm up spec, as the spec of the claim may carry
+	// test specific content. A missing capacity doesn't fail the claim anymore.
+	if replacementSpec, ok := c.pickSpec(input.ComponentType); ok {
+		replacement := &WarmUpDeployment{
+			PoolId:        input.PoolId,
+			ComponentType: input.ComponentType,
+			ContainerName: input.ContainerName,
+			Spec:          replacementSpec,
+		}
+
+		if _, err = c.spawnDeployment(ctx, replacement); err != nil && !errors.As(err, &capacityErr) {
+			return nil, fmt.Errorf("could not spawn deployment: %w", err)
+		}
+	}
+
+	return &Claim{
+		Deployment: deployments[0],
+		Service:    service,
+		Path:       ClaimPathIdle,
+	}, nil
 }
 
-func (c *ServicePool) ExtendServices(ctx context.Context, input *ExtendInput) error {
+// claimWithCredentials spawns a dedicated deployment with freshly generated credentials, as the
+// credentials of warm deployments are already baked into their running containers.
+func (c *ServicePool) claimWithCredentials(ctx context.Context, input *RunInput, spec ContainerSpec) (*Claim, error) {
+	var err error
+	var credentials *Credentials
+	var deployment *appsv1.Deployment
+	var service *apiv1.Service
+
+	spawnInput := *input
+	if credentials, spawnInput.Spec, err = GenerateCredentials(input.ComponentType, spec); err != nil {
+		return nil, fmt.Errorf("could not generate credentials: %w", err)
+	}
+
+	if deployment, err = c.spawnDeployment(ctx, &spawnInput); err != nil {
+		return nil, fmt.Errorf("could not spawn deployment: %w", err)
+	}
+
+	if service, err = c.claimDeployment(ctx, deployment, input); err != nil {
+		return nil, fmt.Errorf("could not claim deployment: %w", err)
+	}
+
+	return &Claim{
+		Deployment:  deployment,
+		Service:     service,
+		Credentials: credentials,
+		Path:        ClaimPathDedicated,
+	}, nil
+}
+
+// claimDedicated spawns a deployment for the claim alone, as the request specific content of the spec, e.g.
+// localstack init scripts or wiremock stub mappings, can't be added to a warm deployment anymore.
+func (c *ServicePool) claimDedicated(ctx context.Context, input *RunInput, spec ContainerSpec) (*Claim, error) {
+	var err error
+	var deployment *appsv1.Deployment
+	var service *apiv1.Service
+
+	spawnInput := *input
+	spawnInput.Spec = spec
+
+	if deployment, err = c.spawnDeployment(ctx, &spawnInput); err != nil {
+		return nil, fmt.Errorf("could not spawn deployment: %w", err)
+	}
+
+	if service, err = c.claimDeployment(ctx, deployment, input); err != nil {
+		return nil, fmt.Errorf("could not claim deployment: %w", err)
+	}
+
+	return &Claim{
+		Deployment: deployment,
+		Service:    service,
+		Path:       ClaimPathDedicated,
+	}, nil
+}
+
+// ExtendServices moves the expiry of all deployments and services matching the labels to now plus the duration.
+func (c *ServicePool) ExtendServices(ctx context.Context, labels map[string]string, duration time.Duration) error {
 	var err error
 	var deployments []*appsv1.Deployment
 	var services []*apiv1.Service
 
-	expireAfter := c.clock.Now().Add(input.Duration).Format(time.RFC3339)
-	ops := []string{
-		fmt.Sprintf(`{"op": "replace", "path": "/metadata/annotations/%s", "value": "%s"}`, strings.ReplaceAll(AnnotationExpireAfter, "/", "~1"), expireAfter),
-	}
+	now := c.clock.Now()
+	expireAfter := now.Add(duration).Format(time.RFC3339)
+	expireAfterByName := map[string]string{}
 
-	if deployments, err = c.k8sClient.ListDeployments(ctx, input.GetLabels()); err != nil {
+	if deployments, err = c.k8sClient.ListDeployments(ctx, labels); err != nil {
 		return fmt.Errorf("could not list deployments: %w", err)
 	}
 
 	for _, deployment := range deployments {
+		ctx := withObjectFields(ctx, deployment)
+
+		// the max lifetime of the retention policy caps the extension per deployment
+		policy := c.retention.For(deployment.GetAnnotations()[AnnotationComponentType])
+		expireAfterByName[deployment.GetName()] = policy.ExpireAt(deployment.GetCreationTimestamp().Time, now, duration).Format(time.RFC3339)
+
+		ops := []string{
+			PatchOp("replace", "annotations", AnnotationExpireAfter, expireAfterByName[deployment.GetName()]),
+		}
+
 		if deployment, err = c.k8sClient.PatchDeployment(ctx, deployment, ops); err != nil {
 			return fmt.Errorf("could not patch deployment: %w", err)
 		}
+
+		c.events.Record(ctx, deployment, apiv1.EventTypeNormal, eventReasonExtended, fmt.Sprintf("extended until %s", expireAfterByName[deployment.GetName()]))
 	}
 
-	if services, err = c.k8sClient.ListServices(ctx, input.GetLabels()); err != nil {
+	if services, err = c.k8sClient.ListServices(ctx, labels); err != nil {
 		return fmt.Errorf("could not list services: %w", err)
 	}
 
 	for _, service := range services {
+		ctx := withObjectFields(ctx, service)
+
+		serviceExpireAfter := expireAfter
+		if capped, ok := expireAfterByName[service.GetName()]; ok {
+			serviceExpireAfter = capped
+		}
+
+		ops := []string{
+			PatchOp("replace", "annotations", AnnotationExpireAfter, serviceExpireAfter),
+		}
+
 		if service, err = c.k8sClient.PatchService(ctx, service, ops); err != nil {
 			return fmt.Errorf("could not patch service: %w", err)
 		}
+
+		c.events.Record(ctx, service, apiv1.EventTypeNormal, eventReasonExtended, fmt.Sprintf("extended until %s", serviceExpireAfter))
 	}
 
 	return nil
 }
 
-func (c *ServicePool) ReleaseServices(ctx context.Context, labels map[string]string) error {
+// ReleaseServices deletes all deployments and services matching the labels and returns the deleted deployments.
+// The release is detached from the context of the request, so a request timing out while a pre delete hook runs
+// doesn't leave deployments behind.
+func (c *ServicePool) ReleaseServices(ctx context.Context, labels map[string]string) ([]*appsv1.Deployment, error) {
 	var err error
+	var deleted bool
 	var deployments []*appsv1.Deployment
 	var services []*apiv1.Service
 
+	ctx = context.WithoutCancel(ctx)
+
 	if deployments, err = c.k8sClient.ListDeployments(ctx, labels); err != nil {
-		return fmt.Errorf("could not list deployments: %w", err)
+		return nil, fmt.Errorf("could not list deployments: %w", err)
 	}
 
+	released := make([]*appsv1.Deployment, 0, len(deployments))
+
 	for _, d := range deployments {
-		if err = c.k8sClient.DeleteDeployment(ctx, d); err != nil {
-			return fmt.Errorf("could not delete deployment: %w", err)
+		ctx := withObjectFields(ctx, d)
+
+		c.hooks.Run(ctx, d)
+
+		if deleted, err = c.deleteMatching(ctx, d, labels); err != nil {
+			return nil, fmt.Errorf("could not delete deployment: %w", err)
+		}
+
+		if !deleted {
+			continue
 		}
+
+		c.events.Record(ctx, d, apiv1.EventTypeNormal, eventReasonReleased, "released and deleted")
+		released = append(released, d)
 	}
 
 	if services, err = c.k8sClient.ListServices(ctx, labels); err != nil {
-		return fmt.Errorf("could not list services: %w", err)
+		return nil, fmt.Errorf("could not list services: %w", err)
 	}
 
 	for _, s := range services {
-		if err = c.k8sClient.DeleteService(ctx, s); err != nil {
-			return fmt.Errorf("could not delete service: %w", err)
+		ctx := withObjectFields(ctx, s)
+
+		c.events.Record(ctx, s, apiv1.EventTypeNormal, eventReasonReleased, "released and deleted")
+
+		// the service is garbage collected together with its deployment
+		if ownedByDeployment(s) {
+			continue
 		}
 
+		if err = c.k8sClient.DeleteService(ctx, s); err != nil {
+			return nil, fmt.Errorf("could not delete service: %w", err)
+		}
 	}
 
 	keys := funk.Keys(labels)
@@ -263,21 +698,133 @@ func (c *ServicePool) ReleaseServices(ctx context.Context, labels map[string]str
 
 	c.logger.Info(ctx, "released test resources %q", strings.Join(ids, ", "))
 
-	return nil
+	return released, nil
+}
+
+// ReplaceIdle deletes the idle deployment as it was read by the caller and spawns one of the same spec in its
+// place. A deployment claimed since it was read isn't deleted. Deployments spawned without the warm up spec
+// annotation are replaced with the current warm up spec of their component type.
+func (c *ServicePool) ReplaceIdle(ctx context.Context, deployment *appsv1.Deployment) (bool, error) {
+	var err error
+	var deleted bool
+
+	ctx = withObjectFields(context.WithoutCancel(ctx), deployment)
+
+	c.hooks.Run(ctx, deployment)
+
+	if deleted, err = c.deleteMatching(ctx, deployment, map[string]string{LableIdle: "true"}); err != nil {
+		return false, fmt.Errorf("could not delete deployment: %w", err)
+	}
+
+	if !deleted {
+		return false, nil
+	}
+
+	c.events.Record(ctx, deployment, apiv1.EventTypeNormal, eventReasonReleased, "released and deleted")
+
+	componentType := deployment.GetAnnotations()[AnnotationComponentType]
+	replacement := &WarmUpDeployment{
+		PoolId:        c.id,
+		ComponentType: componentType,
+		ContainerName: deployment.GetAnnotations()[AnnotationContainerName],
+	}
+
+	if encodedSpec, ok := deployment.GetAnnotations()[AnnotationWarmUpSpec]; !ok || json.Unmarshal([]byte(encodedSpec), &replacement.Spec) != nil {
+		replacement.Spec = c.warmUpSpec(componentType)
+	}
+
+	if _, err = c.spawnDeployment(ctx, replacement); err != nil {
+		return true, fmt.Errorf("could not spawn replacement: %w", err)
+	}
+
+	return true, nil
+}
+
+// deleteMatching deletes the deployment under the lock of the pool, so no claim takes it at the same time. A
+// deployment changed since it was listed, e.g. an idle one claimed in the meantime, is only deleted if it still
+// matches the selector.
+func (c *ServicePool) deleteMatching(ctx context.Context, deployment *appsv1.Deployment, selector map[string]string) (bool, error) {
+	var err error
+
+	for {
+		c.lck.Lock()
+		err = c.k8sClient.DeleteDeploymentIfUnchanged(ctx, deployment)
+		c.lck.Unlock()
+
+		if !k8sErrors.IsConflict(err) {
+			return err == nil, err
+		}
+
+		if deployment, err = c.k8sClient.GetDeployment(ctx, deployment.GetName()); k8sErrors.IsNotFound(err) {
+			return false, nil
+		}
+
+		if err != nil {
+			return false, fmt.Errorf("could not get changed deployment: %w", err)
+		}
+
+		if !labels.SelectorFromSet(selector).Matches(labels.Set(deployment.GetLabels())) {
+			c.logger.Info(ctx, "kept deployment %q as it doesn't match the release anymore", deployment.GetName())
+
+			return false, nil
+		}
+	}
 }
 
 func (c *ServicePool) spawnDeployment(ctx context.Context, input SpawnAble) (*appsv1.Deployment, error) {
 	var err error
 	uid := uuid.New().NewV4()
 
-	deployment := c.factory.CreateDeployment(uid, input)
+	var deployment *appsv1.Deployment
+	var dependencies []*appsv1.Deployment
+	var dependencyServices []*apiv1.Service
+
+	if deployment, err = c.factory.CreateDeployment(uid, input); err != nil {
+		return nil, fmt.Errorf("could not create deployment definition: %w", err)
+	}
+
+	c.sizer.Apply(deployment, input.GetComponentType(), input.GetSpec())
+
+	// an idle deployment is replaced with one of its own spec, which may be pinned by a rollout since
+	if warmUp, ok := input.(*WarmUpDeployment); ok {
+		var encodedSpec []byte
+
+		if encodedSpec, err = json.Marshal(warmUp.Spec); err != nil {
+			return nil, fmt.Errorf("could not encode warm up spec: %w", err)
+		}
+
+		deployment.Annotations[AnnotationWarmUpSpec] = string(encodedSpec)
+	}
+
+	traceId := TraceIdFromContext(ctx)
+	if traceId != "" {
+		deployment.Annotations[AnnotationTraceId] = traceId
+	}
+
+	// the dependencies are defined before the capacity check, as they need room just like the component
+	if spec := input.GetSpec(); len(spec.Dependencies) > 0 {
+		if dependencies, dependencyServices, err = c.factory.CreateDependencies(uid, input, deployment); err != nil {
+			return nil, fmt.Errorf("could not create dependency definitions: %w", err)
+		}
+	}
+
+	if err = c.capacity.CheckDeployment(ctx, deployment, dependencies...); err != nil {
+		return nil, fmt.Errorf("could not spawn deployment for component %q: %w", input.GetComponentType(), err)
+	}
+
 	if deployment, err = c.k8sClient.CreateDeployment(ctx, deployment); err != nil {
 		return nil, fmt.Errorf("could not create deployment: %w", err)
 	}
 
-	service := c.factory.CreateService(uid, input)
-	if service, err = c.k8sClient.CreateService(ctx, service); err != nil {
-		return nil, fmt.Errorf("could not create service: %w", err)
+	ctx = withObjectFields(ctx, deployment)
+
+	// all other objects are owned by the deployment, deleting it on a failure garbage collects the ones already created
+	if err = c.spawnOwned(ctx, uid, input, deployment, dependencies, dependencyServices, traceId); err != nil {
+		if deleteErr := c.k8sClient.DeleteDeployment(ctx, deployment); deleteErr != nil {
+			c.logger.Error(ctx, "could not delete partially spawned deployment %q: %w", deployment.GetName(), deleteErr)
+		}
+
+		return nil, err
 	}
 
 	c.logger.Info(ctx, "spawned deployment %q", deployment.Name)
@@ -285,11 +832,89 @@ func (c *ServicePool) spawnDeployment(ctx context.Context, input SpawnAble) (*ap
 	return deployment, nil
 }
 
+func (c *ServicePool) spawnOwned(ctx context.Context, uid string, input SpawnAble, deployment *appsv1.Deployment, dependencies []*appsv1.Deployment, dependencyServices []*apiv1.Service, traceId string) error {
+	var err error
+
+	if spec := input.GetSpec(); spec.Tls != nil && spec.Tls.Enabled {
+		if _, err = c.k8sClient.CreateCertificate(ctx, c.factory.CreateCertificate(uid, input, deployment)); err != nil {
+			return fmt.Errorf("could not create certificate: %w", err)
+		}
+	}
+
+	if spec := input.GetSpec(); spec.RedisCluster != nil {
+		var statefulSet *appsv1.StatefulSet
+
+		if statefulSet, err = c.factory.CreateStatefulSet(uid, input, deployment); err != nil {
+			return fmt.Errorf("could not create stateful set definition: %w", err)
+		}
+
+		if _, err = c.k8sClient.CreateStatefulSet(ctx, statefulSet); err != nil {
+			return fmt.Errorf("could not create stateful set: %w", err)
+		}
+	}
+
+	if err = c.spawnDependencies(ctx, deployment, dependencies, dependencyServices); err != nil {
+		return fmt.Errorf("could not spawn dependencies: %w", err)
+	}
+
+	for _, configMap := range c.factory.CreateConfigMaps(uid, input, deployment) {
+		if _, err = c.k8sClient.CreateConfigMap(ctx, configMap); err != nil {
+			return fmt.Errorf("could not create config map %q: %w", configMap.GetName(), err)
+		}
+	}
+
+	for _, secret := range c.factory.CreateSecrets(uid, input, deployment) {
+		if _, err = c.k8sClient.CreateSecret(ctx, secret); err != nil {
+			return fmt.Errorf("could not create secret %q: %w", secret.GetName(), err)
+		}
+	}
+
+	service := c.factory.CreateService(uid, input, deployment)
+	if traceId != "" {
+		service.Annotations[AnnotationTraceId] = traceId
+	}
+
+	if _, err = c.k8sClient.CreateService(ctx, service); err != nil {
+		return fmt.Errorf("could not create service: %w", err)
+	}
+
+	return nil
+}
+
+// spawnDependencies creates the dependencies of a component. The component waits for them on its own, so
+// they are created after the deployment of the component which owns them. The definitions were made before
+// the owner existed and get its owner references only now.
+func (c *ServicePool) spawnDependencies(ctx context.Context, owner *appsv1.Deployment, deployments []*appsv1.Deployment, services []*apiv1.Service) error {
+	var err error
+
+	for _, deployment := range deployments {
+		deployment.SetOwnerReferences(ownerReferences(owner))
+
+		if _, err = c.k8sClient.CreateDeployment(ctx, deployment); err != nil {
+			return fmt.Errorf("could not create deployment of dependency %q: %w", deployment.GetLabels()[LabelDependency], err)
+		}
+	}
+
+	for _, service := range services {
+		service.SetOwnerReferences(ownerReferences(owner))
+
+		if _, err = c.k8sClient.CreateService(ctx, service); err != nil {
+			return fmt.Errorf("could not create service of dependency %q: %w", service.GetLabels()[LabelDependency], err)
+		}
+	}
+
+	return nil
+}
+
 func (c *ServicePool) claimDeployment(ctx context.Context, deployment *appsv1.Deployment, input *RunInput) (*apiv1.Service, error) {
 	var err error
+	var encodedInput []byte
 	var service *apiv1.Service
 
-	expireAfter := c.clock.Now().Add(input.ExpireAfter).Format(time.RFC3339)
+	ctx = withObjectFields(ctx, deployment)
+
+	policy := c.retention.For(input.GetComponentType())
+	expireAfter := policy.ExpireAt(deployment.GetCreationTimestamp().Time, c.clock.Now(), input.ExpireAfter).Format(time.RFC3339)
 	ops := []string{
 		fmt.Sprintf(`{"op": "remove", "path": "/metadata/labels/%s"}`, strings.ReplaceAll(LableIdle, "/", "~1")),
 		fmt.Sprintf(`{"op": "add", "path": "/metadata/labels/%s", "value": "%s"}`, strings.ReplaceAll(LabelTestId, "/", "~1"), K8sNameString(input.TestId)),
@@ -300,7 +925,34 @@ func (c *ServicePool) claimDeployment(ctx context.Context, deployment *appsv1.De
 		fmt.Sprintf(`{"op": "add", "path": "/metadata/annotations/%s", "value": "%s"}`, strings.ReplaceAll(AnnotationTestName, "/", "~1"), input.TestName),
 	}
 
-	if deployment, err = c.k8sClient.PatchDeployment(ctx, deployment, ops); err != nil {
+	if input.Team != "" {
+		ops = append(ops, PatchOp("add", "labels", LabelTeam, K8sNameString(input.Team)))
+	}
+
+	if traceId := TraceIdFromContext(ctx); traceId != "" {
+		ops = append(ops, PatchOp("add", "annotations", AnnotationTraceId, traceId))
+	}
+
+	for key, value := range input.Ci.GetAnnotations() {
+		ops = append(ops, PatchOp("add", "annotations", key, value))
+	}
+
+	if input.Ci != nil && input.Ci.PipelineId != "" {
+		ops = append(ops, PatchOp("add", "labels", LabelCiPipelineId, K8sNameString(input.Ci.PipelineId)))
+	}
+
+	if input.SessionId != "" {
+		ops = append(ops, PatchOp("add", "labels", LabelSessionId, input.SessionId))
+	}
+
+	// the request is kept with the deployment only, a replacement of the claim is claimed from it
+	if encodedInput, err = json.Marshal(input); err != nil {
+		return nil, fmt.Errorf("could not encode the run input: %w", err)
+	}
+
+	deploymentOps := slices.Concat(ops, []string{PatchOp("add", "annotations", AnnotationRunInput, string(encodedInput))})
+
+	if deployment, err = c.k8sClient.PatchDeployment(ctx, deployment, deploymentOps); err != nil {
 		return nil, fmt.Errorf("could not patch deployment: %w", err)
 	}
 
@@ -308,6 +960,18 @@ func (c *ServicePool) claimDeployment(ctx context.Context, deployment *appsv1.De
 		return nil, fmt.Errorf("could not get service: %w", err)
 	}
 
+	if input.DnsName != "" {
+		var dnsAnnotations map[string]string
+
+		if dnsAnnotations, _, err = c.factory.ExternalDnsAnnotations(input.DnsName); err != nil {
+			return nil, fmt.Errorf("could not build external dns annotations: %w", err)
+		}
+
+		for key, value := range dnsAnnotations {
+			ops = append(ops, PatchOp("add", "annotations", key, value))
+		}
+	}
+
 	if service, err = c.k8sClient.PatchService(ctx, service, ops); err != nil {
 		return nil, fmt.Errorf("could not patch service: %w", err)
 	}
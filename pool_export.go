@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/funk"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+type PoolExportInput struct {
+	PoolId  string `form:"pool_id"`
+	PoolKey string `header:"X-Pool-Key"`
+}
+
+// PoolExport describes the configuration of a pool, so it can be recreated on another kubrun instance. The
+// warm targets are the number of idle deployments per component type at the time of the export.
+type PoolExport struct {
+	PoolId      string                   `json:"pool_id"`
+	WarmTargets map[string]int           `json:"warm_targets"`
+	SpecPins    map[string]ContainerSpec `json:"spec_pins"`
+	Quotas      *PoolQuotas              `json:"quotas,omitempty"`
+	PoolKey     string                   `header:"X-Pool-Key" json:"-"`
+}
+
+// PoolQuotas are the limits the claims of the pool run into on the exporting instance. They are configured per
+// instance and namespace, so an import reports the ones differing on the importing instance instead of applying them.
+type PoolQuotas struct {
+	MaxClaimsPerTest       int                           `json:"max_claims_per_test"`
+	ExtensionBudgetPerTest time.Duration                 `json:"extension_budget_per_test"`
+	ExtensionBudgetPerTeam time.Duration                 `json:"extension_budget_per_team"`
+	ResourceQuotas         map[string]apiv1.ResourceList `json:"resource_quotas"`
+}
+
+// Differences describes every quota of q which is set differently in the other quotas.
+func (q *PoolQuotas) Differences(other *PoolQuotas) []string {
+	differences := make([]string, 0)
+
+	if q.MaxClaimsPerTest != other.MaxClaimsPerTest {
+		differences = append(differences, fmt.Sprintf("max claims per test is %d instead of %d", other.MaxClaimsPerTest, q.MaxClaimsPerTest))
+	}
+
+	if q.ExtensionBudgetPerTest != other.ExtensionBudgetPerTest {
+		differences = append(differences, fmt.Sprintf("extension budget per test is %s instead of %s", other.ExtensionBudgetPerTest, q.ExtensionBudgetPerTest))
+	}
+
+	if q.ExtensionBudgetPerTeam != other.ExtensionBudgetPerTeam {
+		differences = append(differences, fmt.Sprintf("extension budget per team is %s instead of %s", other.ExtensionBudgetPerTeam, q.ExtensionBudgetPerTeam))
+	}
+
+	names := funk.Keys(q.ResourceQuotas)
+	slices.Sort(names)
+
+	for _, name := range names {
+		for resourceName, hard := range q.ResourceQuotas[name] {
+			actual, ok := other.ResourceQuotas[name][resourceName]
+
+			switch {
+			case !ok:
+				differences = append(differences, fmt.Sprintf("resource quota %s/%s is missing instead of %s", name, resourceName, hard.String()))
+			case actual.Cmp(hard) != 0:
+				differences = append(differences, fmt.Sprintf("resource quota %s/%s is %s instead of %s", name, resourceName, actual.String(), hard.String()))
+			}
+		}
+	}
+
+	return differences
+}
+
+type PoolImportOutput struct {
+	PoolId           string         `json:"pool_id"`
+	Pinned           []string       `json:"pinned"`
+	WarmUp           map[string]int `json:"warm_up"`
+	QuotaDifferences []string       `json:"quota_differences,omitempty"`
+	Job              *WarmUpJob     `json:"job,omitempty"`
+}
+
+func (c *ServicePool) Export(ctx context.Context) (*PoolExport, error) {
+	var err error
+	var idle []*appsv1.Deployment
+
+	if idle, err = c.k8sClient.ListDeployments(ctx, map[string]string{LabelPoolId: K8sNameString(c.id), LableIdle: "true"}); err != nil {
+		return nil, fmt.Errorf("could not list idle deployments: %w", err)
+	}
+
+	export := &PoolExport{
+		PoolId:      c.id,
+		WarmTargets: map[string]int{},
+		SpecPins:    map[string]ContainerSpec{},
+	}
+
+	for _, deployment := range idle {
+		export.WarmTargets[deployment.GetAnnotations()[AnnotationComponentType]]++
+	}
+
+	c.lck.RLock()
+	defer c.lck.RUnlock()
+
+	for componentType, spec := range c.pinnedSpecs {
+		export.SpecPins[componentType] = spec
+	}
+
+	return export, nil
+}
+
+// Pin makes the pool warm up and hand out deployments of the spec for the component type from now on.
+func (c *ServicePool) Pin(ctx context.Context, componentType string, spec ContainerSpec) error {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	pinned := maps.Clone(c.pinnedSpecs)
+	if pinned == nil {
+		pinned = map[string]ContainerSpec{}
+	}
+
+	pinned[componentType] = spec
+
+	if err := c.store.Save(ctx, pinsState(c.id), pinned); err != nil {
+		return fmt.Errorf("could not store pinned specs: %w", err)
+	}
+
+	c.pinnedSpecs = pinned
+
+	return nil
+}
+
+// LoadPins reads the specs pinned by earlier rollouts of the pool.
+func (c *ServicePool) LoadPins(ctx context.Context) error {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	pinned := map[string]ContainerSpec{}
+
+	if _, err := c.store.Load(ctx, pinsState(c.id), &pinned); err != nil {
+		return fmt.Errorf("could not load pinned specs: %w", err)
+	}
+
+	c.pinnedSpecs = pinned
+
+	return nil
+}
+
+func pinsState(poolId string) string {
+	return fmt.Sprintf("pins-%s", poolId)
+}
+
+// MissingIdle returns how many idle deployments per component type are missing to reach the targets.
+func (c *ServicePool) MissingIdle(ctx context.Context, targets map[string]int) (map[string]int, error) {
+	var err error
+	var idle []*appsv1.Deployment
+
+	if idle, err = c.k8sClient.ListDeployments(ctx, map[string]string{LabelPoolId: K8sNameString(c.id), LableIdle: "true"}); err != nil {
+		return nil, fmt.Errorf("could not list idle deployments: %w", err)
+	}
+
+	counts := map[string]int{}
+	for _, deployment := range idle {
+		counts[deployment.GetAnnotations()[AnnotationComponentType]]++
+	}
+
+	missing := map[string]int{}
+	for componentType, target := range targets {
+		if count := target - counts[componentType]; count > 0 {
+			missing[componentType] = count
+		}
+	}
+
+	return missing, nil
+}
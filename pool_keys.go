@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gosoline-project/httpserver"
+	"github.com/justtrackio/gosoline/pkg/appctx"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	apiv1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	poolKeyHeader    = "X-Pool-Key"
+	poolKeyLength    = 32
+	poolKeySecretKey = "sha256"
+)
+
+var ErrPoolKeyInvalid = errors.New("pool key is missing or invalid")
+
+type PoolKeySettings struct {
+	Enabled bool `cfg:"enabled" default:"false"`
+}
+
+type poolKeysKey struct{}
+
+func ProvidePoolKeys(ctx context.Context, config cfg.Config, logger log.Logger) (*PoolKeys, error) {
+	return appctx.Provide(ctx, poolKeysKey{}, func() (*PoolKeys, error) {
+		var err error
+		var k8sClient *K8sClient
+
+		settings := &PoolKeySettings{}
+		if err = config.UnmarshalKey("pool_keys", settings); err != nil {
+			return nil, fmt.Errorf("could not unmarshal pool key settings: %w", err)
+		}
+
+		if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+			return nil, fmt.Errorf("could not create k8s client: %w", err)
+		}
+
+		return &PoolKeys{
+			k8sClient: k8sClient,
+			settings:  settings,
+			hashes:    map[string]string{},
+		}, nil
+	})
+}
+
+// PoolKeys issues a key per pool on its first warm up, which all later operations on the pool have to send in
+// the X-Pool-Key header. Only the hash of a key is kept in a secret, so the key is returned exactly once.
+// Pools without a key stay open, which keeps pools warmed up before enabling the keys usable.
+type PoolKeys struct {
+	lck       sync.Mutex
+	k8sClient *K8sClient
+	settings  *PoolKeySettings
+	hashes    map[string]string
+}
+
+// Issue returns a new key if the pool has none yet and an empty string otherwise.
+func (k *PoolKeys) Issue(ctx context.Context, poolId string) (string, error) {
+	if !k.settings.Enabled {
+		return "", nil
+	}
+
+	k.lck.Lock()
+	defer k.lck.Unlock()
+
+	var err error
+	var hash, key string
+
+	if hash, err = k.load(ctx, poolId); err != nil {
+		return "", err
+	}
+
+	if hash != "" {
+		return "", nil
+	}
+
+	if key, err = randomString(poolKeyLength); err != nil {
+		return "", fmt.Errorf("could not generate pool key: %w", err)
+	}
+
+	hash = hashPoolKey(key)
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: poolKeySecretName(poolId),
+			Labels: map[string]string{
+				LabelPoolId: K8sNameString(poolId),
+			},
+		},
+		StringData: map[string]string{
+			poolKeySecretKey: hash,
+		},
+	}
+
+	if _, err = k.k8sClient.CreateSecret(ctx, secret); err != nil {
+		return "", fmt.Errorf("could not store pool key: %w", err)
+	}
+
+	k.hashes[K8sNameString(poolId)] = hash
+
+	return key, nil
+}
+
+// Check returns ErrPoolKeyInvalid if the pool has a key and the given one doesn't match it.
+func (k *PoolKeys) Check(ctx context.Context, poolId string, key string) error {
+	if !k.settings.Enabled {
+		return nil
+	}
+
+	k.lck.Lock()
+	defer k.lck.Unlock()
+
+	hash, err := k.load(ctx, poolId)
+	if err != nil {
+		return err
+	}
+
+	if hash == "" {
+		return nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashPoolKey(key))) != 1 {
+		return ErrPoolKeyInvalid
+	}
+
+	return nil
+}
+
+// Revoke removes the key of a pool which was shut down, so the next warm up issues a new one.
+func (k *PoolKeys) Revoke(ctx context.Context, poolId string) error {
+	if !k.settings.Enabled {
+		return nil
+	}
+
+	k.lck.Lock()
+	defer k.lck.Unlock()
+
+	delete(k.hashes, K8sNameString(poolId))
+
+	if err := k.k8sClient.DeleteSecret(ctx, poolKeySecretName(poolId)); err != nil && !k8sErrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete pool key: %w", err)
+	}
+
+	return nil
+}
+
+// load returns the hash of the key of the pool. The hashes are cached by the k8s name of the pool, as the pool of
+// a claim is only known by its label.
+func (k *PoolKeys) load(ctx context.Context, poolId string) (string, error) {
+	if hash, ok := k.hashes[K8sNameString(poolId)]; ok {
+		return hash, nil
+	}
+
+	secret, err := k.k8sClient.GetSecret(ctx, poolKeySecretName(poolId))
+	if k8sErrors.IsNotFound(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not load pool key: %w", err)
+	}
+
+	k.hashes[K8sNameString(poolId)] = string(secret.Data[poolKeySecretKey])
+
+	return k.hashes[K8sNameString(poolId)], nil
+}
+
+// authorizePool returns a forbidden response if the key doesn't match the key of the pool.
+func authorizePool(ctx context.Context, keys *PoolKeys, poolId string, key string) (httpserver.Response, error) {
+	err := keys.Check(ctx, poolId, key)
+	if errors.Is(err, ErrPoolKeyInvalid) {
+		return httpserver.NewJsonResponse(map[string]any{"err": fmt.Sprintf("%s of pool %q", err.Error(), poolId)}, httpserver.WithStatusCode(http.StatusForbidden)), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not check pool key: %w", err)
+	}
+
+	return nil, nil
+}
+
+// authorizeClaim returns a not found response if there is no such claim and a forbidden response if the key
+// doesn't match the key of the pool of the claim.
+func authorizeClaim(ctx context.Context, keys *PoolKeys, poolManager *ServicePoolManager, claimId string, key string) (httpserver.Response, error) {
+	var err error
+	var claim *Claim
+
+	if claim, err = poolManager.GetClaim(ctx, claimId); errors.Is(err, ErrClaimNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get claim: %w", err)
+	}
+
+	return authorizePool(ctx, keys, claim.Deployment.GetLabels()[LabelPoolId], key)
+}
+
+// authorizeSession returns a not found response if there is no such session and a forbidden response if the key
+// doesn't match the key of the pool of the session.
+func authorizeSession(ctx context.Context, keys *PoolKeys, sessions *SessionStore, sessionId string, key string) (httpserver.Response, error) {
+	var err error
+	var session *Session
+
+	if session, err = sessions.Get(sessionId); errors.Is(err, ErrSessionNotFound) {
+		return httpserver.NewStatusResponse(http.StatusNotFound), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get session: %w", err)
+	}
+
+	return authorizePool(ctx, keys, session.PoolId, key)
+}
+
+func poolKeySecretName(poolId string) string {
+	return K8sNameString("kubrun-pool-key", poolId)
+}
+
+func hashPoolKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/justtrackio/gosoline/pkg/appctx"
 	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
 	"github.com/justtrackio/gosoline/pkg/funk"
 	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
 )
 
@@ -19,18 +25,129 @@ func ProvideServicePoolManager(ctx context.Context, config cfg.Config, logger lo
 	return appctx.Provide(ctx, servicePoolManagerKey{}, func() (*ServicePoolManager, error) {
 		var err error
 		var k8sClient *K8sClient
+		var capacity *CapacityChecker
+		var factories *DeploymentFactories
+		var history ClaimHistory
+		var budget *ExtensionBudget
+		var limiter *ClaimLimiter
+		var specs *SpecRegistry
+		var retention *RetentionPolicies
+		var toucher *AutoToucher
+		var hooks *PreDeleteHooks
+		var collector *ReleaseArtifactCollector
+		var sessions *SessionStore
+		var maintenance *Maintenance
+		var sizer *RightSizer
+		var events *EventRecorder
+		var store *StateStore
+		var timeouts *RequestTimeoutSettings
+
+		softDelete := &SoftDeleteSettings{}
+		if err = config.UnmarshalKey("soft_delete", softDelete); err != nil {
+			return nil, fmt.Errorf("could not unmarshal soft delete settings: %w", err)
+		}
+
+		longPoll := &LongPollSettings{}
+		if err = config.UnmarshalKey("long_poll", longPoll); err != nil {
+			return nil, fmt.Errorf("could not unmarshal long poll settings: %w", err)
+		}
+
+		if timeouts, err = ReadRequestTimeoutSettings(config); err != nil {
+			return nil, err
+		}
 
-		if k8sClient, err = NewK8sClient(config, logger); err != nil {
+		if longPoll.MaxHold > timeouts.Long/2 {
+			return nil, fmt.Errorf("the max hold of long polls of %s has to be at most half of the long request timeout of %s", longPoll.MaxHold, timeouts.Long)
+		}
+
+		if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
 			return nil, fmt.Errorf("could not create k8s client: %w", err)
 		}
 
+		if capacity, err = NewCapacityChecker(config, logger, k8sClient); err != nil {
+			return nil, fmt.Errorf("could not create capacity checker: %w", err)
+		}
+
+		if factories, err = NewDeploymentFactories(config); err != nil {
+			return nil, fmt.Errorf("could not create deployment factories: %w", err)
+		}
+
+		if history, err = ProvideClaimHistory(ctx, config, logger); err != nil {
+			return nil, fmt.Errorf("could not create claim history: %w", err)
+		}
+
+		if budget, err = NewExtensionBudget(config, history); err != nil {
+			return nil, fmt.Errorf("could not create extension budget: %w", err)
+		}
+
+		if limiter, err = NewClaimLimiter(config, k8sClient); err != nil {
+			return nil, fmt.Errorf("could not create claim limiter: %w", err)
+		}
+
+		if specs, err = NewSpecRegistry(config); err != nil {
+			return nil, fmt.Errorf("could not create spec registry: %w", err)
+		}
+
+		if retention, err = NewRetentionPolicies(config); err != nil {
+			return nil, fmt.Errorf("could not create retention policies: %w", err)
+		}
+
+		if toucher, err = NewAutoToucher(config, k8sClient); err != nil {
+			return nil, fmt.Errorf("could not create auto toucher: %w", err)
+		}
+
+		if hooks, err = NewPreDeleteHooks(ctx, config, logger, k8sClient); err != nil {
+			return nil, fmt.Errorf("could not create pre delete hooks: %w", err)
+		}
+
+		if collector, err = NewReleaseArtifactCollector(ctx, config, logger, k8sClient); err != nil {
+			return nil, fmt.Errorf("could not create release artifact collector: %w", err)
+		}
+
+		if sessions, err = ProvideSessionStore(ctx, config); err != nil {
+			return nil, fmt.Errorf("could not create session store: %w", err)
+		}
+
+		if maintenance, err = ProvideMaintenance(ctx, config, logger); err != nil {
+			return nil, fmt.Errorf("could not create maintenance: %w", err)
+		}
+
+		if sizer, err = ProvideRightSizer(ctx, config); err != nil {
+			return nil, fmt.Errorf("could not create right sizer: %w", err)
+		}
+
+		if events, err = ProvideEventRecorder(ctx, config, logger); err != nil {
+			return nil, fmt.Errorf("could not create event recorder: %w", err)
+		}
+
+		if store, err = ProvideStateStore(ctx, config, logger); err != nil {
+			return nil, fmt.Errorf("could not create state store: %w", err)
+		}
+
 		poolFactory := func(id string) (*ServicePool, error) {
-			return NewServicePool(config, logger, k8sClient, id)
+			return NewServicePool(config, logger, k8sClient, capacity, hooks, sizer, events, store, limiter, id)
 		}
 
 		return &ServicePoolManager{
 			logger:      logger.WithChannel("pool-manager"),
 			k8sClient:   k8sClient,
+			clock:       clock.NewRealClock(),
+			capacity:    capacity,
+			factories:   factories,
+			history:     history,
+			budget:      budget,
+			limiter:     limiter,
+			specs:       specs,
+			retention:   retention,
+			toucher:     toucher,
+			softDelete:  softDelete,
+			longPoll:    longPoll,
+			hooks:       hooks,
+			collector:   collector,
+			sessions:    sessions,
+			maintenance: maintenance,
+			events:      events,
+			metric:      metric.NewWriter(),
 			poolFactory: poolFactory,
 			pools:       map[string]*ServicePool{},
 		}, nil
@@ -41,11 +158,28 @@ type ServicePoolManager struct {
 	lck         sync.RWMutex
 	logger      log.Logger
 	k8sClient   *K8sClient
+	clock       clock.Clock
+	capacity    *CapacityChecker
+	factories   *DeploymentFactories
+	history     ClaimHistory
+	budget      *ExtensionBudget
+	limiter     *ClaimLimiter
+	specs       *SpecRegistry
+	retention   *RetentionPolicies
+	toucher     *AutoToucher
+	softDelete  *SoftDeleteSettings
+	longPoll    *LongPollSettings
+	hooks       *PreDeleteHooks
+	collector   *ReleaseArtifactCollector
+	sessions    *SessionStore
+	maintenance *Maintenance
+	events      *EventRecorder
+	metric      metric.Writer
 	poolFactory func(id string) (*ServicePool, error)
 	pools       map[string]*ServicePool
 }
 
-func (c *ServicePoolManager) WarmUpPool(ctx context.Context, input *WarmUpInput) error {
+func (c *ServicePoolManager) WarmUpPool(ctx context.Context, input *WarmUpInput, progress func()) error {
 	var err error
 	var pool *ServicePool
 
@@ -53,67 +187,585 @@ func (c *ServicePoolManager) WarmUpPool(ctx context.Context, input *WarmUpInput)
 		return fmt.Errorf("could not get pool: %w", err)
 	}
 
-	return pool.WarmUp(ctx, input)
+	resolved := *input
+	resolved.Components = c.specs.ResolveComponents(input.Components)
+
+	spawned := 0
+	err = pool.WarmUp(ctx, &resolved, func() {
+		spawned++
+
+		if progress != nil {
+			progress()
+		}
+	})
+
+	c.recordHistory(ctx, HistoryRecord{
+		Event:  HistoryEventWarmUp,
+		PoolId: input.PoolId,
+		Count:  spawned,
+	})
+
+	return err
+}
+
+// RolloutPool replaces the idle deployments of a component type with deployments of the given spec or, if no
+// spec is given, of the spec currently registered for the component type.
+func (c *ServicePoolManager) RolloutPool(ctx context.Context, input *RolloutInput) (*RolloutOutput, error) {
+	var err error
+	var ok bool
+	var pool *ServicePool
+	var spec ContainerSpec
+
+	input.ComponentType = c.specs.Resolve(input.ComponentType)
+
+	if input.Spec != nil {
+		spec = *input.Spec
+	} else if spec, ok = c.specs.Get(input.ComponentType); !ok {
+		return nil, fmt.Errorf("there is no spec for component type %q", input.ComponentType)
+	}
+
+	if pool, err = c.getPool(ctx, input.PoolId); err != nil {
+		return nil, fmt.Errorf("could not get pool: %w", err)
+	}
+
+	return pool.Rollout(ctx, input, spec)
+}
+
+func (c *ServicePoolManager) ExportPool(ctx context.Context, poolId string) (*PoolExport, error) {
+	var err error
+	var pool *ServicePool
+	var export *PoolExport
+
+	if pool, err = c.getPool(ctx, poolId); err != nil {
+		return nil, fmt.Errorf("could not get pool: %w", err)
+	}
+
+	if export, err = pool.Export(ctx); err != nil {
+		return nil, err
+	}
+
+	if export.Quotas, err = c.quotas(ctx); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// ImportPool applies the spec pins of the export to the pool and returns the number of idle deployments
+// per component type which are missing to reach the warm targets and the quotas of the export which differ
+// on this instance.
+func (c *ServicePoolManager) ImportPool(ctx context.Context, export *PoolExport) (map[string]int, []string, error) {
+	var err error
+	var pool *ServicePool
+	var quotas *PoolQuotas
+	var missing map[string]int
+
+	if pool, err = c.getPool(ctx, export.PoolId); err != nil {
+		return nil, nil, fmt.Errorf("could not get pool: %w", err)
+	}
+
+	for componentType, spec := range export.SpecPins {
+		if err = pool.Pin(ctx, c.specs.Resolve(componentType), spec); err != nil {
+			return nil, nil, fmt.Errorf("could not pin spec of component type %q: %w", componentType, err)
+		}
+	}
+
+	if missing, err = pool.MissingIdle(ctx, c.specs.ResolveComponents(export.WarmTargets)); err != nil {
+		return nil, nil, err
+	}
+
+	if export.Quotas == nil {
+		return missing, nil, nil
+	}
+
+	if quotas, err = c.quotas(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	return missing, export.Quotas.Differences(quotas), nil
+}
+
+// quotas returns the limits the claims of every pool of this instance run into.
+func (c *ServicePoolManager) quotas(ctx context.Context) (*PoolQuotas, error) {
+	var err error
+	var resourceQuotas []*apiv1.ResourceQuota
+
+	if resourceQuotas, err = c.k8sClient.ListResourceQuotas(ctx); err != nil {
+		return nil, fmt.Errorf("could not list resource quotas: %w", err)
+	}
+
+	quotas := &PoolQuotas{
+		MaxClaimsPerTest: c.limiter.settings.MaxPerTest,
+		ResourceQuotas:   map[string]apiv1.ResourceList{},
+	}
+
+	if c.budget.settings.Enabled {
+		quotas.ExtensionBudgetPerTest = c.budget.settings.PerTest
+		quotas.ExtensionBudgetPerTeam = c.budget.settings.PerTeam
+	}
+
+	for _, resourceQuota := range resourceQuotas {
+		quotas.ResourceQuotas[resourceQuota.GetName()] = resourceQuota.Spec.Hard
+	}
+
+	return quotas, nil
 }
 
 func (c *ServicePoolManager) ShutdownPool(ctx context.Context, input *ShutdownInput) error {
 	var err error
 	var pool *ServicePool
+	var released []*appsv1.Deployment
 
 	if pool, err = c.getPool(ctx, input.PoolId); err != nil {
 		return fmt.Errorf("could not get pool: %w", err)
 	}
 
-	return pool.Shutdown(ctx)
+	if released, err = pool.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	c.recordEnded(ctx, HistoryEventRelease, released)
+
+	c.recordHistory(ctx, HistoryRecord{
+		Event:  HistoryEventShutdown,
+		PoolId: input.PoolId,
+	})
+
+	return nil
+}
+
+func (c *ServicePoolManager) FetchService(ctx context.Context, input *RunInput) (*Claim, error) {
+	return c.fetchService(ctx, input, true)
 }
 
-func (c *ServicePoolManager) FetchService(ctx context.Context, input *RunInput) (*apiv1.Service, error) {
+func (c *ServicePoolManager) fetchService(ctx context.Context, input *RunInput, limited bool) (*Claim, error) {
 	var err error
 	var pool *ServicePool
-	var service *apiv1.Service
+	var claim *Claim
+
+	if err = c.maintenance.Check(); err != nil {
+		return nil, fmt.Errorf("could not claim service: %w", err)
+	}
+
+	if err = c.k8sClient.CheckCircuit(); err != nil {
+		return nil, fmt.Errorf("could not claim service: %w", err)
+	}
+
+	input.ComponentType = c.specs.Resolve(input.ComponentType)
+	ctx = withTestFields(ctx, input.PoolId, input.TestId, input.ComponentType)
+
+	if input.ExpireAfter == 0 {
+		input.ExpireAfter = c.retention.For(input.ComponentType).DefaultTtl
+	}
+
+	if input.SessionId != "" {
+		var session *Session
+
+		if session, err = c.sessions.Get(input.SessionId); err != nil {
+			return nil, fmt.Errorf("could not claim service: %w", err)
+		}
+
+		// the key of the pool guards the session, so claims of other pools can't join it
+		if session.PoolId != input.PoolId {
+			return nil, fmt.Errorf("could not claim service: session %q belongs to another pool: %w", input.SessionId, ErrSessionNotFound)
+		}
+	}
 
 	if pool, err = c.getPool(ctx, input.PoolId); err != nil {
 		return nil, fmt.Errorf("could not get pool: %w", err)
 	}
 
-	if service, err = pool.ClaimService(ctx, input); err != nil {
+	if claim, err = pool.ClaimService(ctx, input, limited); err != nil {
 		return nil, fmt.Errorf("could not claim service: %w", err)
 	}
 
-	return service, nil
+	ctx = withObjectFields(ctx, claim.Deployment)
+
+	c.recordHistory(ctx, HistoryRecord{
+		Event:         HistoryEventClaim,
+		PoolId:        input.PoolId,
+		TestId:        input.TestId,
+		ComponentType: input.ComponentType,
+		ClaimId:       claim.GetId(),
+		Team:          K8sNameString(input.Team),
+		Ci:            input.Ci,
+	})
+	c.metric.WriteOne(ctx, claimMetric(metricClaims, claim.Deployment))
+	c.events.RecordClaim(ctx, claim, input)
+
+	if input.Async {
+		claim.Status = DeploymentClaimStatus(claim.Deployment)
+
+		return claim, nil
+	}
+
+	if input.Wait <= 0 {
+		return claim, nil
+	}
+
+	if claim.Status, err = waitForDeployment(ctx, c.clock, c.k8sClient, claim.Deployment.GetName(), input.Wait); err != nil {
+		return nil, fmt.Errorf("could not wait for claim %q: %w", claim.GetId(), err)
+	}
+
+	return claim, nil
 }
 
+// ReplaceFailedClaim claims a fresh deployment for the test from the request of a failed claim and releases the
+// failed claim afterward, the replacement expires when the failed claim would have. If the replacement can't be
+// claimed, the failed claim is kept. The given resources override the ones of the spec.
+func (c *ServicePoolManager) ReplaceFailedClaim(ctx context.Context, deployment *appsv1.Deployment, resources *ResourceSpec) (*Claim, error) {
+	var err error
+	var expireAt time.Time
+	var pool *ServicePool
+	var claim *Claim
+	var input *RunInput
+	var released []*appsv1.Deployment
+
+	labels := deployment.GetLabels()
+	annotations := deployment.GetAnnotations()
+
+	if input, err = c.failedClaimInput(deployment); err != nil {
+		return nil, err
+	}
+
+	if expireAt, err = time.Parse(time.RFC3339, annotations[AnnotationExpireAfter]); err != nil {
+		return nil, fmt.Errorf("could not parse expiry of the claim: %w", err)
+	}
+
+	if input.ExpireAfter = expireAt.Sub(c.clock.Now()); input.ExpireAfter <= 0 {
+		return nil, fmt.Errorf("the claim expired already")
+	}
+
+	if pool, err = c.getPool(ctx, input.PoolId); err != nil {
+		return nil, fmt.Errorf("could not get pool: %w", err)
+	}
+
+	if resources != nil {
+		input.Spec = pool.claimSpec(input)
+
+		merged := ResourceSpec{}
+		if input.Spec.Resources != nil {
+			merged = *input.Spec.Resources
+		}
+
+		if resources.Cpu != "" {
+			merged.Cpu = resources.Cpu
+		}
+
+		if resources.Memory != "" {
+			merged.Memory = resources.Memory
+		}
+
+		if resources.MemoryLimit != "" {
+			merged.MemoryLimit = resources.MemoryLimit
+		}
+
+		input.Spec.Resources = &merged
+	}
+
+	// the replacement takes the place of the failed claim, so it doesn't count against the claim limit
+	if claim, err = c.fetchService(ctx, input, false); err != nil {
+		return nil, fmt.Errorf("could not claim replacement: %w", err)
+	}
+
+	// the failed claim is left to expire if it can't be released, the test already got its replacement
+	if released, err = pool.ReleaseServices(ctx, map[string]string{LabelPoolId: input.PoolId, LableUid: labels[LableUid]}); err != nil {
+		c.logger.Warn(ctx, "could not release failed claim of deployment %q: %s", deployment.GetName(), err.Error())
+	}
+
+	c.recordEnded(ctx, HistoryEventRelease, released)
+
+	return claim, nil
+}
+
+// failedClaimInput returns the request a deployment was claimed with. The test, session and CI metadata are
+// taken from the deployment, as a transfer of the claim changes them. Deployments claimed before the request
+// was kept with them are replaced with the spec of their component type.
+func (c *ServicePoolManager) failedClaimInput(deployment *appsv1.Deployment) (*RunInput, error) {
+	var ok bool
+	var err error
+
+	labels := deployment.GetLabels()
+	annotations := deployment.GetAnnotations()
+	input := &RunInput{}
+
+	if encoded, stored := annotations[AnnotationRunInput]; stored {
+		if err = json.Unmarshal([]byte(encoded), input); err != nil {
+			return nil, fmt.Errorf("could not decode the run input of the claim: %w", err)
+		}
+	} else {
+		input = &RunInput{
+			PoolId:        labels[LabelPoolId],
+			TestName:      annotations[AnnotationTestName],
+			Team:          labels[LabelTeam],
+			ComponentType: annotations[AnnotationComponentType],
+			ComponentName: annotations[AnnotationComponentName],
+			ContainerName: annotations[AnnotationContainerName],
+		}
+
+		if input.Spec, ok = c.specs.Pick(input.ComponentType); !ok {
+			return nil, fmt.Errorf("there is no spec for component type %q", input.ComponentType)
+		}
+	}
+
+	input.TestId = labels[LabelTestId]
+	input.SessionId = labels[LabelSessionId]
+	input.Ci = CiMetadataFromAnnotations(deployment)
+	input.Wait = 0
+	input.Hold = 0
+	input.Async = true
+
+	return input, nil
+}
+
+// GetClaim looks up a claimed deployment and its service by the claim id, which is the uid of the deployment.
+func (c *ServicePoolManager) GetClaim(ctx context.Context, id string) (*Claim, error) {
+	var err error
+	var deployments []*appsv1.Deployment
+	var service *apiv1.Service
+
+	if deployments, err = c.k8sClient.ListDeployments(ctx, map[string]string{LableUid: K8sNameString(id)}); err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	if len(deployments) == 0 || !isClaimed(deployments[0]) {
+		return nil, fmt.Errorf("there is no claim with id %q: %w", id, ErrClaimNotFound)
+	}
+
+	if service, err = c.k8sClient.GetService(ctx, deployments[0].GetName()); err != nil {
+		return nil, fmt.Errorf("could not get service: %w", err)
+	}
+
+	return &Claim{
+		Deployment: deployments[0],
+		Service:    service,
+		Status:     DeploymentClaimStatus(deployments[0]),
+	}, nil
+}
+
+// TouchClaim extends the claim by the auto touch window if auto touch is enabled and returns its expiry.
+func (c *ServicePoolManager) TouchClaim(ctx context.Context, id string) (string, error) {
+	var err error
+	var claim *Claim
+	var expireAfter string
+
+	if claim, err = c.GetClaim(ctx, id); err != nil {
+		return "", err
+	}
+
+	if expireAfter, err = c.Touch(ctx, claim.Deployment); err != nil {
+		return "", fmt.Errorf("could not touch claim %q: %w", id, err)
+	}
+
+	return expireAfter, nil
+}
+
+// Touch extends the claimed deployment by the auto touch window if auto touch is enabled and returns its expiry.
+// The extension is charged against the extension budget of the test, a test which used up its budget isn't
+// touched anymore.
+func (c *ServicePoolManager) Touch(ctx context.Context, deployment *appsv1.Deployment) (string, error) {
+	var budgetErr *ExtensionBudgetExceededError
+
+	labels := deployment.GetLabels()
+
+	expireAfter, extension, err := c.toucher.Touch(ctx, deployment, func(extension time.Duration) error {
+		return c.budget.Check(ctx, labels[LabelTestId], labels[LabelTeam], extension)
+	})
+
+	if extension > 0 {
+		c.recordHistory(ctx, HistoryRecord{
+			Event:    HistoryEventExtend,
+			PoolId:   labels[LabelPoolId],
+			TestId:   labels[LabelTestId],
+			Team:     labels[LabelTeam],
+			Duration: extension,
+		})
+	}
+
+	if errors.As(err, &budgetErr) {
+		c.logger.Info(ctx, "not touching deployment %q: %s", deployment.GetName(), budgetErr.Error())
+
+		return expireAfter, nil
+	}
+
+	return expireAfter, err
+}
+
+// ExtendServices extends the expiry of all resources of the test. It returns an *ExtensionBudgetExceededError
+// if the test or its team already used up their extension budget.
 func (c *ServicePoolManager) ExtendServices(ctx context.Context, input *ExtendInput) error {
 	var err error
 	var pool *ServicePool
+	var deployments []*appsv1.Deployment
+
+	ctx = withTestFields(ctx, input.PoolId, input.TestId, "")
 
 	if pool, err = c.getPool(ctx, input.PoolId); err != nil {
 		return fmt.Errorf("could not get pool: %w", err)
 	}
 
-	return pool.ExtendServices(ctx, input)
+	if deployments, err = c.k8sClient.ListDeployments(ctx, input.GetLabels()); err != nil {
+		return fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	team := ""
+	if len(deployments) > 0 {
+		team = deployments[0].GetLabels()[LabelTeam]
+	}
+
+	if err = c.budget.Check(ctx, input.TestId, team, input.Duration); err != nil {
+		return fmt.Errorf("could not extend test %q: %w", input.TestId, err)
+	}
+
+	if err = pool.ExtendServices(ctx, input.GetLabels(), input.Duration); err != nil {
+		return err
+	}
+
+	c.recordHistory(ctx, HistoryRecord{
+		Event:    HistoryEventExtend,
+		PoolId:   input.PoolId,
+		TestId:   input.TestId,
+		Team:     team,
+		Duration: input.Duration,
+	})
+
+	return nil
 }
 
 func (c *ServicePoolManager) ReleaseServices(ctx context.Context, input *StopInput) error {
 	var err error
 	var pool *ServicePool
+	var deployments, released []*appsv1.Deployment
+
+	ctx = withTestFields(ctx, input.PoolId, input.TestId, "")
 
 	if pool, err = c.getPool(ctx, input.PoolId); err != nil {
 		return fmt.Errorf("could not get pool: %w", err)
 	}
 
-	return pool.ReleaseServices(ctx, input.GetLabels())
+	if deployments, err = c.k8sClient.ListDeployments(ctx, input.GetLabels()); err != nil {
+		return fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	// the artifacts are collected next to the release, so a slow upload neither delays nor aborts it. The pods
+	// keep running through their termination grace period, their logs stay readable until then.
+	go c.collector.Collect(context.WithoutCancel(ctx), deployments)
+
+	if c.softDelete.Enabled {
+		released, err = pool.SoftReleaseServices(ctx, input.GetLabels(), c.softDelete.Window)
+	} else {
+		released, err = pool.ReleaseServices(ctx, input.GetLabels())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.recordEnded(ctx, HistoryEventRelease, released)
+
+	return nil
+}
+
+// ReleasePipeline releases the resources of all pools which were claimed by the given CI pipeline
+// and returns the number of released deployments.
+func (c *ServicePoolManager) ReleasePipeline(ctx context.Context, pipelineId string) (int, error) {
+	return c.releaseMatching(ctx, LabelCiPipelineId, K8sNameString(pipelineId))
+}
+
+// releaseMatching releases the resources with the label in all pools and returns the number of released
+// deployments.
+func (c *ServicePoolManager) releaseMatching(ctx context.Context, label string, value string) (int, error) {
+	var err error
+	var pool *ServicePool
+	var deployments, released []*appsv1.Deployment
+
+	selector := map[string]string{label: value}
+	if deployments, err = c.k8sClient.ListDeployments(ctx, selector); err != nil {
+		return 0, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	poolIds := funk.Uniq(funk.Map(deployments, func(deployment *appsv1.Deployment) string {
+		return deployment.GetLabels()[LabelPoolId]
+	}))
+
+	count := 0
+	for _, poolId := range poolIds {
+		if pool, err = c.getPool(ctx, poolId); err != nil {
+			return count, fmt.Errorf("could not get pool: %w", err)
+		}
+
+		labels := map[string]string{
+			LabelPoolId: poolId,
+			label:       value,
+		}
+
+		if released, err = pool.ReleaseServices(ctx, labels); err != nil {
+			return count, fmt.Errorf("could not release %s=%s in pool %q: %w", label, value, poolId, err)
+		}
+
+		c.recordEnded(ctx, HistoryEventRelease, released)
+		count += len(released)
+	}
+
+	return count, nil
+}
+
+func (c *ServicePoolManager) EstimateCapacity(ctx context.Context, input *CapacityInput) ([]*CapacityEstimate, error) {
+	var err error
+	deployments := map[string]*appsv1.Deployment{}
+
+	componentTypes := funk.Map(input.ComponentTypes, c.specs.Resolve)
+
+	for componentType, spec := range c.specs.All() {
+		if len(componentTypes) > 0 && !slices.Contains(componentTypes, componentType) {
+			continue
+		}
+
+		warmUp := &WarmUpDeployment{
+			ComponentType: componentType,
+			ContainerName: "main",
+			Spec:          spec,
+		}
+
+		if deployments[componentType], err = c.factories.For(warmUp.PoolId).CreateDeployment("capacity", warmUp); err != nil {
+			return nil, fmt.Errorf("could not create deployment definition for component type %q: %w", componentType, err)
+		}
+	}
+
+	return c.capacity.Estimate(ctx, deployments)
 }
 
 func (c *ServicePoolManager) ExpireServices(ctx context.Context) error {
 	var err error
 	var services []*apiv1.Service
+	var expired []*appsv1.Deployment
 
-	if err = expireObjects(ctx, c.logger, c.k8sClient.ListDeployments, c.k8sClient.DeleteDeployment, "deployment"); err != nil {
+	deleteDeployment := func(ctx context.Context, object Objecter) error {
+		c.hooks.Run(ctx, object)
+		c.recordExpiring(ctx, object)
+
+		return c.k8sClient.DeleteDeployment(ctx, object)
+	}
+
+	deleteService := func(ctx context.Context, object Objecter) error {
+		c.recordExpiring(ctx, object)
+
+		// the service is garbage collected together with its deployment, which expires at the same time
+		if service, ok := object.(*apiv1.Service); ok && ownedByDeployment(service) {
+			return nil
+		}
+
+		return c.k8sClient.DeleteService(ctx, object)
+	}
+
+	if expired, err = expireObjects(ctx, c.logger, c.k8sClient.ListDeployments, deleteDeployment, "deployment"); err != nil {
 		return fmt.Errorf("could not expire deployments: %w", err)
 	}
 
-	if err = expireObjects(ctx, c.logger, c.k8sClient.ListServices, c.k8sClient.DeleteService, "service"); err != nil {
+	c.recordEnded(ctx, HistoryEventExpire, expired)
+	c.recycle(ctx, expired)
+
+	if _, err = expireObjects(ctx, c.logger, c.k8sClient.ListServices, deleteService, "service"); err != nil {
 		return fmt.Errorf("could not expire services: %w", err)
 	}
 
@@ -136,6 +788,73 @@ func (c *ServicePoolManager) ExpireServices(ctx context.Context) error {
 	return nil
 }
 
+func (c *ServicePoolManager) recordExpiring(ctx context.Context, object Objecter) {
+	message := fmt.Sprintf("expired at %s and is deleted", object.GetAnnotations()[AnnotationExpireAfter])
+	c.events.Record(ctx, object, apiv1.EventTypeNormal, eventReasonExpiring, message)
+}
+
+// recycle spawns a fresh idle deployment for every expired deployment whose retention policy asks for it.
+func (c *ServicePoolManager) recycle(ctx context.Context, expired []*appsv1.Deployment) {
+	for _, deployment := range expired {
+		componentType := deployment.GetAnnotations()[AnnotationComponentType]
+		if c.retention.For(componentType).OnExpire != RetentionOnExpireRecycle {
+			continue
+		}
+
+		poolId := deployment.GetLabels()[LabelPoolId]
+		input := &WarmUpInput{
+			PoolId:     poolId,
+			Components: map[string]int{componentType: 1},
+		}
+
+		if err := c.WarmUpPool(ctx, input, nil); err != nil {
+			c.logger.Warn(ctx, "could not recycle expired deployment %q of pool %q: %s", deployment.GetName(), poolId, err.Error())
+		}
+	}
+}
+
+// recordHistory stores the record in the claim history. A failing history must not fail the operation itself.
+// Records without a trace id get the one of the current request.
+func (c *ServicePoolManager) recordHistory(ctx context.Context, record HistoryRecord) {
+	record.CreatedAt = c.clock.Now()
+
+	if record.TraceId == "" {
+		record.TraceId = TraceIdFromContext(ctx)
+	}
+
+	if err := c.history.Record(ctx, record); err != nil {
+		c.logger.Warn(ctx, "could not record %q event of pool %q: %s", record.Event, record.PoolId, err.Error())
+	}
+}
+
+// recordEnded records the end of every claimed deployment, so the usage of a claim can be derived from the history.
+func (c *ServicePoolManager) recordEnded(ctx context.Context, event string, deployments []*appsv1.Deployment) {
+	metricName := metricReleases
+	if event == HistoryEventExpire {
+		metricName = metricExpirations
+	}
+
+	for _, deployment := range deployments {
+		// the end of a soft deleted deployment was already recorded when it was stopped
+		if !isClaimed(deployment) || isSoftDeleted(deployment) {
+			continue
+		}
+
+		c.metric.WriteOne(ctx, claimMetric(metricName, deployment))
+
+		c.recordHistory(ctx, HistoryRecord{
+			Event:         event,
+			PoolId:        deployment.GetLabels()[LabelPoolId],
+			TestId:        deployment.GetLabels()[LabelTestId],
+			ComponentType: deployment.GetAnnotations()[AnnotationComponentType],
+			ClaimId:       deployment.GetLabels()[LableUid],
+			Team:          deployment.GetLabels()[LabelTeam],
+			Ci:            CiMetadataFromAnnotations(deployment),
+			TraceId:       deployment.GetAnnotations()[AnnotationTraceId],
+		})
+	}
+}
+
 func (c *ServicePoolManager) getPool(ctx context.Context, poolId string) (*ServicePool, error) {
 	c.lck.Lock()
 	defer c.lck.Unlock()
@@ -153,10 +872,18 @@ func (c *ServicePoolManager) getPool(ctx context.Context, poolId string) (*Servi
 func (c *ServicePoolManager) addPool(ctx context.Context, poolId string) (*ServicePool, error) {
 	var err error
 
-	if c.pools[poolId], err = c.poolFactory(poolId); err != nil {
+	var pool *ServicePool
+
+	if pool, err = c.poolFactory(poolId); err != nil {
 		return nil, fmt.Errorf("could not create pool %q: %w", poolId, err)
 	}
 
+	if err = pool.LoadPins(ctx); err != nil {
+		return nil, fmt.Errorf("could not load the pins of pool %q: %w", poolId, err)
+	}
+
+	c.pools[poolId] = pool
+
 	c.logger.Info(ctx, "created new pool %q", poolId)
 
 	return c.pools[poolId], nil
@@ -168,13 +895,15 @@ func expireObjects[T Objecter](
 	lister func(ctx context.Context, selectors ...map[string]string) ([]T, error),
 	deleter func(ctx context.Context, object Objecter) error,
 	objectType string,
-) error {
+) ([]T, error) {
 	var err error
 	var objects []T
 	var expireAfter time.Time
 
+	expired := make([]T, 0)
+
 	if objects, err = lister(ctx, map[string]string{}); err != nil {
-		return fmt.Errorf("failed to list services: %w", err)
+		return nil, fmt.Errorf("failed to list services: %w", err)
 	}
 
 	for _, o := range objects {
@@ -185,19 +914,29 @@ func expireObjects[T Objecter](
 		}
 
 		if expireAfter, err = time.Parse(time.RFC3339, annotations[AnnotationExpireAfter]); err != nil {
-			return fmt.Errorf("could not parse annotation expire after: %w", err)
+			return expired, fmt.Errorf("could not parse annotation expire after: %w", err)
 		}
 
 		if expireAfter.After(time.Now()) {
 			continue
 		}
 
+		ctx := withObjectFields(ctx, o)
+
 		if err = deleter(ctx, o); err != nil {
-			return fmt.Errorf("could not delete service: %w", err)
+			return expired, fmt.Errorf("could not delete service: %w", err)
+		}
+
+		expired = append(expired, o)
+
+		if ci := CiMetadataFromAnnotations(o); ci != nil {
+			logger.Info(ctx, "expired %q %q in pool %q claimed by ci pipeline %q (%s)", objectType, o.GetName(), o.GetLabels()[LabelPoolId], ci.PipelineId, ci.JobUrl)
+
+			continue
 		}
 
 		logger.Info(ctx, "expired %q %q in pool %q", objectType, o.GetName(), o.GetLabels()[LabelPoolId])
 	}
 
-	return nil
+	return expired, nil
 }
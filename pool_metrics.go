@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// PoolGauge is the number of deployments per pool and component type at the time of collection.
+type PoolGauge struct {
+	PoolId        string
+	ComponentType string
+	Idle          int
+	Claimed       int
+	Failed        int
+}
+
+// CollectPoolGauges counts the idle, claimed and failed deployments of all pools.
+func CollectPoolGauges(ctx context.Context, k8sClient *K8sClient) ([]*PoolGauge, error) {
+	var err error
+	var deployments []*appsv1.Deployment
+
+	if deployments, err = k8sClient.ListDeployments(ctx); err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	gauges := map[string]*PoolGauge{}
+	for _, deployment := range deployments {
+		poolId := deployment.GetLabels()[LabelPoolId]
+		componentType := deployment.GetAnnotations()[AnnotationComponentType]
+		key := poolId + "/" + componentType
+
+		if _, ok := gauges[key]; !ok {
+			gauges[key] = &PoolGauge{
+				PoolId:        poolId,
+				ComponentType: componentType,
+			}
+		}
+
+		switch {
+		case !isClaimed(deployment):
+			gauges[key].Idle++
+		case DeploymentClaimStatus(deployment) == ClaimStatusFailed:
+			gauges[key].Failed++
+		default:
+			gauges[key].Claimed++
+		}
+	}
+
+	result := make([]*PoolGauge, 0, len(gauges))
+	for _, gauge := range gauges {
+		result = append(result, gauge)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].PoolId != result[j].PoolId {
+			return result[i].PoolId < result[j].PoolId
+		}
+
+		return result[i].ComponentType < result[j].ComponentType
+	})
+
+	return result, nil
+}
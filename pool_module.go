@@ -9,44 +9,160 @@ import (
 	"github.com/justtrackio/gosoline/pkg/clock"
 	"github.com/justtrackio/gosoline/pkg/kernel"
 	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
 )
 
 func NewPoolModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
 	var err error
 	var poolManager *ServicePoolManager
+	var crashDetector *CrashDetector
+	var oomDetector *OomDetector
+	var idleWatchdog *IdleWatchdog
+	var serviceSweeper *ServiceSweeper
+	var consistencyChecker *ConsistencyChecker
+	var schemaMigrator *SchemaMigrator
+	var readOnly *ReadOnlySettings
+	var kubeSettings *KubeSettings
+
+	// the pool module is always started, so a broken configuration stops kubrun on boot
+	if err = CheckStartup(ctx, config, logger); err != nil {
+		return nil, err
+	}
 
 	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
 		return nil, fmt.Errorf("could not create service pool manager: %w", err)
 	}
 
+	if crashDetector, err = NewCrashDetector(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create crash detector: %w", err)
+	}
+
+	if oomDetector, err = NewOomDetector(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create oom detector: %w", err)
+	}
+
+	if idleWatchdog, err = NewIdleWatchdog(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create idle watchdog: %w", err)
+	}
+
+	if serviceSweeper, err = NewServiceSweeper(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service sweeper: %w", err)
+	}
+
+	if consistencyChecker, err = NewConsistencyChecker(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create consistency checker: %w", err)
+	}
+
+	if schemaMigrator, err = NewSchemaMigrator(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create schema migrator: %w", err)
+	}
+
+	if readOnly, err = ReadReadOnlySettings(config); err != nil {
+		return nil, err
+	}
+
+	if kubeSettings, err = ReadSettings(config); err != nil {
+		return nil, fmt.Errorf("could not read kube settings: %w", err)
+	}
+
 	return &PoolModule{
-		logger:      logger.WithChannel("pool-module"),
-		poolManager: poolManager,
-		ticker:      clock.NewRealTicker(time.Minute),
+		logger:             logger.WithChannel("pool-module"),
+		metric:             metric.NewWriter(),
+		poolManager:        poolManager,
+		crashDetector:      crashDetector,
+		oomDetector:        oomDetector,
+		idleWatchdog:       idleWatchdog,
+		serviceSweeper:     serviceSweeper,
+		consistencyChecker: consistencyChecker,
+		schemaMigrator:     schemaMigrator,
+		readOnly:           readOnly,
+		clock:              clock.NewRealClock(),
+		ticker:             clock.NewRealTicker(time.Minute),
+		degraded:           kubeSettings.CircuitBreaker.DegradedInterval,
 	}, nil
 }
 
 type PoolModule struct {
-	logger      log.Logger
-	poolManager *ServicePoolManager
-	ticker      clock.Ticker
+	logger             log.Logger
+	metric             metric.Writer
+	poolManager        *ServicePoolManager
+	crashDetector      *CrashDetector
+	oomDetector        *OomDetector
+	idleWatchdog       *IdleWatchdog
+	serviceSweeper     *ServiceSweeper
+	consistencyChecker *ConsistencyChecker
+	schemaMigrator     *SchemaMigrator
+	readOnly           *ReadOnlySettings
+	clock              clock.Clock
+	ticker             clock.Ticker
+	degraded           time.Duration
 }
 
 func (p PoolModule) Run(ctx context.Context) error {
-	if err := p.poolManager.ExpireServices(ctx); err != nil {
-		p.logger.Error(ctx, "could not expire services: %w", err)
-	}
+	p.reconcile(ctx)
+	last := p.clock.Now()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		case <-p.ticker.Chan():
-			if err := p.poolManager.ExpireServices(ctx); err != nil {
-				p.logger.Error(ctx, "could not expire services: %w", err)
+			// a failing api server isn't flooded with the calls of a whole reconcile every minute
+			if p.poolManager.k8sClient.CircuitOpen() && p.clock.Since(last) < p.degraded {
+				continue
 			}
+
+			p.reconcile(ctx)
+			last = p.clock.Now()
 		}
 	}
+}
 
-	return nil
+func (p PoolModule) reconcile(ctx context.Context) {
+	// a read-only instance only reports the gauges, expiring and marking claims is left to the active one
+	if !p.readOnly.Enabled {
+		p.mutate(ctx)
+	}
+
+	gauges, err := CollectPoolGauges(ctx, p.poolManager.k8sClient)
+	if err != nil {
+		p.logger.Error(ctx, "could not collect pool gauges: %w", err)
+
+		return
+	}
+
+	p.metric.Write(ctx, poolGaugeMetrics(gauges))
+}
+
+func (p PoolModule) mutate(ctx context.Context) {
+	// the checks below expect the objects in the current schema
+	if err := p.schemaMigrator.Migrate(ctx); err != nil {
+		p.logger.Error(ctx, "could not migrate the objects to the current schema: %w", err)
+	}
+
+	// a missing or invalid expiry is repaired first, otherwise the expiry fails on the object
+	if err := p.consistencyChecker.Check(ctx); err != nil {
+		p.logger.Error(ctx, "could not check the consistency of the labels: %w", err)
+	}
+
+	if err := p.poolManager.ExpireServices(ctx); err != nil {
+		p.logger.Error(ctx, "could not expire services: %w", err)
+	}
+
+	// OOM kills are checked first, so a retry with more memory takes precedence over the crash detector
+	if err := p.oomDetector.Check(ctx); err != nil {
+		p.logger.Error(ctx, "could not check for OOM killed containers: %w", err)
+	}
+
+	if err := p.crashDetector.Check(ctx); err != nil {
+		p.logger.Error(ctx, "could not check for crashed containers: %w", err)
+	}
+
+	if err := p.idleWatchdog.Check(ctx); err != nil {
+		p.logger.Error(ctx, "could not check idle deployments: %w", err)
+	}
+
+	if err := p.serviceSweeper.Check(ctx); err != nil {
+		p.logger.Error(ctx, "could not sweep dangling services: %w", err)
+	}
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+const defaultRolloutReadyTimeout = 5 * time.Minute
+
+type RolloutInput struct {
+	PoolId        string         `json:"pool_id"`
+	ComponentType string         `json:"component_type"`
+	Count         int            `json:"count"`
+	Spec          *ContainerSpec `json:"spec"`
+	ReadyTimeout  time.Duration  `json:"ready_timeout"`
+	PoolKey       string         `header:"X-Pool-Key" json:"-"`
+}
+
+type RolloutOutput struct {
+	PoolId        string `json:"pool_id"`
+	ComponentType string `json:"component_type"`
+	Version       string `json:"version"`
+	Spawned       int    `json:"spawned"`
+	Drained       int    `json:"drained"`
+}
+
+// SpecVersion identifies a spec by the hash of its definition, so deployments of different spec versions can
+// be told apart within a pool. The spec is normalized first, so specs spawning the same deployment share their
+// version, no matter whether a client sends an empty env or none at all.
+func SpecVersion(spec ContainerSpec) string {
+	encoded, _ := json.Marshal(normalizedSpec(spec))
+	sum := sha256.Sum256(encoded)
+
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// normalizedSpec replaces empty maps, slices and sections with nil, applies the defaults of the restart policy
+// and the port protocols and sorts the dependencies. The spec itself isn't modified.
+func normalizedSpec(spec ContainerSpec) ContainerSpec {
+	spec.Env = nilIfEmptyMap(spec.Env)
+	spec.Cmd = nilIfEmptySlice(spec.Cmd)
+	spec.PortBindings = normalizedPorts(spec.PortBindings)
+
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = RestartPolicyAlways
+	}
+
+	if spec.Lifecycle != nil && len(spec.Lifecycle.PostStart) == 0 && len(spec.Lifecycle.PreStop) == 0 {
+		spec.Lifecycle = nil
+	}
+
+	if spec.Tls != nil && !spec.Tls.Enabled {
+		spec.Tls = nil
+	}
+
+	if spec.Localstack != nil && !spec.Localstack.Persistence && len(spec.Localstack.InitScripts) == 0 {
+		spec.Localstack = nil
+	}
+
+	if spec.Wiremock != nil && len(spec.Wiremock.Mappings) == 0 {
+		spec.Wiremock = nil
+	}
+
+	if spec.Resources != nil && spec.Resources.Cpu == "" && spec.Resources.Memory == "" && spec.Resources.MemoryLimit == "" {
+		spec.Resources = nil
+	}
+
+	if len(spec.Dependencies) == 0 {
+		spec.Dependencies = nil
+
+		return spec
+	}
+
+	dependencies := make([]DependencySpec, 0, len(spec.Dependencies))
+	for _, dependency := range spec.Dependencies {
+		dependency.Env = nilIfEmptyMap(dependency.Env)
+		dependency.Cmd = nilIfEmptySlice(dependency.Cmd)
+		dependency.PortBindings = normalizedPorts(dependency.PortBindings)
+		dependency.DependsOn = nilIfEmptySlice(slices.Sorted(slices.Values(dependency.DependsOn)))
+
+		dependencies = append(dependencies, dependency)
+	}
+
+	// the order of the dependencies is given by their depends on, not by their order in the spec
+	slices.SortFunc(dependencies, func(a, b DependencySpec) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	spec.Dependencies = dependencies
+
+	return spec
+}
+
+func normalizedPorts(ports map[string]PortBinding) map[string]PortBinding {
+	if len(ports) == 0 {
+		return nil
+	}
+
+	normalized := make(map[string]PortBinding, len(ports))
+	for name, port := range ports {
+		// kubernetes defaults a missing protocol to tcp
+		if port.Protocol = strings.ToLower(port.Protocol); port.Protocol == "" {
+			port.Protocol = "tcp"
+		}
+
+		normalized[name] = port
+	}
+
+	return normalized
+}
+
+func nilIfEmptyMap[M ~map[K]V, K comparable, V any](m M) M {
+	if len(m) == 0 {
+		return nil
+	}
+
+	return m
+}
+
+func nilIfEmptySlice[S ~[]E, E any](s S) S {
+	if len(s) == 0 {
+		return nil
+	}
+
+	return s
+}
+
+// Rollout replaces the idle deployments of a component type blue/green style: it spawns a parallel set of idle
+// deployments with the new spec, switches claims over once all of them are ready and drains the old idle
+// deployments afterward. Claimed deployments of the old version stay until they are released.
+func (c *ServicePool) Rollout(ctx context.Context, input *RolloutInput, spec ContainerSpec) (*RolloutOutput, error) {
+	var err error
+	var status string
+	var deployment *appsv1.Deployment
+	var idle, drained []*appsv1.Deployment
+
+	version := SpecVersion(spec)
+	output := &RolloutOutput{
+		PoolId:        input.PoolId,
+		ComponentType: input.ComponentType,
+		Version:       version,
+	}
+
+	warmUp := &WarmUpDeployment{
+		PoolId:        input.PoolId,
+		ComponentType: input.ComponentType,
+		ContainerName: "main",
+		Spec:          spec,
+	}
+
+	spawned := make([]*appsv1.Deployment, 0, input.Count)
+	for i := 0; i < input.Count; i++ {
+		if deployment, err = c.spawnDeployment(ctx, warmUp); err != nil {
+			return nil, c.abortRollout(ctx, version, spawned, fmt.Errorf("could not spawn deployment: %w", err))
+		}
+
+		spawned = append(spawned, deployment)
+		output.Spawned++
+	}
+
+	for _, deployment = range spawned {
+		if status, err = waitForDeployment(ctx, c.clock, c.k8sClient, deployment.GetName(), input.ReadyTimeout); err != nil {
+			return nil, c.abortRollout(ctx, version, spawned, fmt.Errorf("could not wait for deployment %q: %w", deployment.GetName(), err))
+		}
+
+		if status != ClaimStatusReady {
+			return nil, c.abortRollout(ctx, version, spawned, fmt.Errorf("deployment %q is %s instead of ready after %s", deployment.GetName(), status, input.ReadyTimeout))
+		}
+	}
+
+	if err = c.Pin(ctx, input.ComponentType, spec); err != nil {
+		return nil, c.abortRollout(ctx, version, spawned, fmt.Errorf("could not pin spec version %s: %w", version, err))
+	}
+
+	c.logger.Info(ctx, "switched claims of component type %q to spec version %s", input.ComponentType, version)
+
+	labels := map[string]string{
+		LabelPoolId:        K8sNameString(c.id),
+		LabelComponentType: K8sNameString(input.ComponentType),
+		LableIdle:          "true",
+	}
+
+	if idle, err = c.k8sClient.ListDeployments(ctx, labels); err != nil {
+		return nil, fmt.Errorf("could not list idle deployments: %w", err)
+	}
+
+	for _, deployment = range idle {
+		if deployment.GetLabels()[LabelSpecVersion] == version {
+			continue
+		}
+
+		// the idle label makes sure a deployment claimed in the meantime isn't drained
+		drainLabels := map[string]string{
+			LabelPoolId: K8sNameString(c.id),
+			LableUid:    deployment.GetLabels()[LableUid],
+			LableIdle:   "true",
+		}
+
+		if drained, err = c.ReleaseServices(ctx, drainLabels); err != nil {
+			return nil, fmt.Errorf("could not drain deployment %q: %w", deployment.GetName(), err)
+		}
+
+		output.Drained += len(drained)
+	}
+
+	return output, nil
+}
+
+// abortRollout removes the spawned deployments which weren't claimed yet, so a failed rollout doesn't leave
+// a second pool behind.
+func (c *ServicePool) abortRollout(ctx context.Context, version string, spawned []*appsv1.Deployment, reason error) error {
+	for _, deployment := range spawned {
+		labels := map[string]string{
+			LabelPoolId: K8sNameString(c.id),
+			LableUid:    deployment.GetLabels()[LableUid],
+			LableIdle:   "true",
+		}
+
+		if _, err := c.ReleaseServices(ctx, labels); err != nil {
+			return fmt.Errorf("rollout of spec version %s aborted: %w: could not remove deployment %q: %w", version, reason, deployment.GetName(), err)
+		}
+	}
+
+	return fmt.Errorf("rollout of spec version %s aborted: %w", version, reason)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/uuid"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+type PreDeleteHookSettings struct {
+	Timeout time.Duration                `cfg:"timeout" default:"2m"`
+	Hooks   map[string]PreDeleteHookSpec `cfg:"hooks"`
+}
+
+// PreDeleteHookSpec is run in an ephemeral container next to the main container of a claimed deployment before
+// it is deleted. It shares the network and process namespace and the env of the main container. If an artifact
+// name is set, the output of the command is kept as artifact of the test.
+type PreDeleteHookSpec struct {
+	Image    string   `cfg:"image"`
+	Command  []string `cfg:"command"`
+	Artifact string   `cfg:"artifact"`
+}
+
+// PreDeleteHooks runs the hook of the component type, e.g. a database dump, right before a claimed deployment
+// is deleted. A failing hook is logged but never stops the deletion.
+type PreDeleteHooks struct {
+	logger    log.Logger
+	clock     clock.Clock
+	k8sClient *K8sClient
+	artifacts *ArtifactStore
+	specs     *SpecRegistry
+	settings  *PreDeleteHookSettings
+}
+
+func NewPreDeleteHooks(ctx context.Context, config cfg.Config, logger log.Logger, k8sClient *K8sClient) (*PreDeleteHooks, error) {
+	var err error
+	var artifacts *ArtifactStore
+	var specs *SpecRegistry
+
+	settings := &PreDeleteHookSettings{}
+	if err = config.UnmarshalKey("pre_delete_hooks", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal pre delete hook settings: %w", err)
+	}
+
+	if artifacts, err = ProvideArtifactStore(ctx, config); err != nil {
+		return nil, fmt.Errorf("could not create artifact store: %w", err)
+	}
+
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return nil, fmt.Errorf("could not create spec registry: %w", err)
+	}
+
+	hooks := map[string]PreDeleteHookSpec{}
+	for componentType, hook := range settings.Hooks {
+		if hook.Image == "" || len(hook.Command) == 0 {
+			return nil, fmt.Errorf("pre delete hook of component type %q needs an image and a command", componentType)
+		}
+
+		hooks[specs.Resolve(componentType)] = hook
+	}
+	settings.Hooks = hooks
+
+	return &PreDeleteHooks{
+		logger:    logger.WithChannel("pre-delete-hooks"),
+		clock:     clock.NewRealClock(),
+		k8sClient: k8sClient,
+		artifacts: artifacts,
+		specs:     specs,
+		settings:  settings,
+	}, nil
+}
+
+// Run runs the hook of the object's component type in the running pods of the object, if it is claimed.
+func (h *PreDeleteHooks) Run(ctx context.Context, object Objecter) {
+	componentType := h.specs.Resolve(object.GetAnnotations()[AnnotationComponentType])
+
+	hook, ok := h.settings.Hooks[componentType]
+	if !ok || object.GetLabels()[LableIdle] == "true" {
+		return
+	}
+
+	if err := h.run(ctx, object, componentType, hook); err != nil {
+		h.logger.Warn(ctx, "could not run pre delete hook of %q: %s", object.GetName(), err.Error())
+	}
+}
+
+func (h *PreDeleteHooks) run(ctx context.Context, object Objecter, componentType string, hook PreDeleteHookSpec) error {
+	var err error
+	var pods []*apiv1.Pod
+	var logs []byte
+
+	if pods, err = h.k8sClient.ListPods(ctx, map[string]string{LableUid: object.GetLabels()[LableUid]}); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+
+	for _, pod := range pods {
+		if pod.Status.Phase != apiv1.PodRunning {
+			continue
+		}
+
+		name := K8sNameString("pre-delete", strings.Split(uuid.New().NewV4(), "-")[0])
+		container := apiv1.EphemeralContainer{
+			EphemeralContainerCommon: apiv1.EphemeralContainerCommon{
+				Name:    name,
+				Image:   hook.Image,
+				Command: hook.Command,
+			},
+			TargetContainerName: "main",
+		}
+
+		for _, main := range pod.Spec.Containers {
+			if main.Name == "main" {
+				container.Env = main.Env
+			}
+		}
+
+		if _, err = h.k8sClient.AddEphemeralContainer(ctx, pod, container); err != nil {
+			return fmt.Errorf("could not add hook container to pod %q: %w", pod.GetName(), err)
+		}
+
+		if err = h.waitForTermination(ctx, pod.GetName(), name); err != nil {
+			return fmt.Errorf("hook container of pod %q didn't finish: %w", pod.GetName(), err)
+		}
+
+		h.logger.Info(ctx, "ran pre delete hook in pod %q", pod.GetName())
+
+		if hook.Artifact == "" {
+			continue
+		}
+
+		if logs, err = h.k8sClient.GetContainerLogs(ctx, pod.GetName(), name, h.artifacts.MaxSize()); err != nil {
+			return fmt.Errorf("could not get output of hook container of pod %q: %w", pod.GetName(), err)
+		}
+
+		h.artifacts.Put(&Artifact{
+			Id:            uuid.New().NewV4(),
+			PoolId:        object.GetLabels()[LabelPoolId],
+			TestId:        object.GetLabels()[LabelTestId],
+			ClaimId:       object.GetLabels()[LableUid],
+			ComponentType: componentType,
+			Name:          hook.Artifact,
+			Content:       logs,
+		})
+	}
+
+	return nil
+}
+
+func (h *PreDeleteHooks) waitForTermination(ctx context.Context, podName string, containerName string) error {
+	var err error
+	var pod *apiv1.Pod
+
+	timer := h.clock.NewTimer(h.settings.Timeout)
+	defer timer.Stop()
+
+	ticker := h.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		if pod, err = h.k8sClient.GetPod(ctx, podName); err != nil {
+			return err
+		}
+
+		for _, status := range pod.Status.EphemeralContainerStatuses {
+			if status.Name == containerName && status.State.Terminated != nil {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.Chan():
+			return fmt.Errorf("timed out after %s", h.settings.Timeout)
+		case <-ticker.Chan():
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+type PredictiveWarmUpSettings struct {
+	Enabled         bool          `cfg:"enabled" default:"false"`
+	Interval        time.Duration `cfg:"interval" default:"10m"`
+	Lookback        time.Duration `cfg:"lookback" default:"168h"`
+	Headroom        float64       `cfg:"headroom" default:"1.2"`
+	MaxPerComponent int           `cfg:"max_per_component" default:"20"`
+}
+
+// PredictiveWarmUpModule keeps enough idle deployments per pool and component type to serve the claims
+// which were made on average at the current hour of the day during the lookback period.
+type PredictiveWarmUpModule struct {
+	kernel.BackgroundModule
+
+	logger      log.Logger
+	clock       clock.Clock
+	settings    *PredictiveWarmUpSettings
+	history     ClaimHistory
+	k8sClient   *K8sClient
+	poolManager *ServicePoolManager
+	readOnly    *ReadOnlySettings
+}
+
+func NewPredictiveWarmUpModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var history ClaimHistory
+	var k8sClient *K8sClient
+	var poolManager *ServicePoolManager
+	var readOnly *ReadOnlySettings
+
+	settings := &PredictiveWarmUpSettings{}
+	if err = config.UnmarshalKey("predictive_warmup", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal predictive warm up settings: %w", err)
+	}
+
+	if history, err = ProvideClaimHistory(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create claim history: %w", err)
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	if readOnly, err = ReadReadOnlySettings(config); err != nil {
+		return nil, err
+	}
+
+	return &PredictiveWarmUpModule{
+		logger:      logger.WithChannel("predictive-warmup"),
+		clock:       clock.NewRealClock(),
+		settings:    settings,
+		history:     history,
+		k8sClient:   k8sClient,
+		poolManager: poolManager,
+		readOnly:    readOnly,
+	}, nil
+}
+
+func (m *PredictiveWarmUpModule) Run(ctx context.Context) error {
+	if !m.settings.Enabled || m.readOnly.Enabled {
+		return nil
+	}
+
+	ticker := m.clock.NewTicker(m.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.warmUp(ctx); err != nil {
+			m.logger.Error(ctx, "could not run predictive warm up: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.Chan():
+		}
+	}
+}
+
+func (m *PredictiveWarmUpModule) warmUp(ctx context.Context) error {
+	var err error
+	var demand map[string]map[string]int
+	var idle []*appsv1.Deployment
+
+	if demand, err = m.predictDemand(ctx); err != nil {
+		return fmt.Errorf("could not predict demand: %w", err)
+	}
+
+	for poolId, components := range demand {
+		input := &WarmUpInput{
+			PoolId:     poolId,
+			Components: map[string]int{},
+		}
+
+		for componentType, target := range components {
+			selector := map[string]string{
+				LabelPoolId:        K8sNameString(poolId),
+				LabelComponentType: K8sNameString(componentType),
+				LableIdle:          "true",
+			}
+
+			if idle, err = m.k8sClient.ListDeployments(ctx, selector); err != nil {
+				return fmt.Errorf("could not list idle deployments: %w", err)
+			}
+
+			if missing := target - len(idle); missing > 0 {
+				input.Components[componentType] = missing
+			}
+		}
+
+		if len(input.Components) == 0 {
+			continue
+		}
+
+		m.logger.Info(ctx, "predictively warming up pool %q with %v", poolId, input.Components)
+
+		if err = m.poolManager.WarmUpPool(ctx, input, nil); err != nil {
+			return fmt.Errorf("could not warm up pool %q: %w", poolId, err)
+		}
+	}
+
+	return nil
+}
+
+// predictDemand returns the expected number of claims per pool and component type for the current hour of the day.
+func (m *PredictiveWarmUpModule) predictDemand(ctx context.Context) (map[string]map[string]int, error) {
+	var err error
+	var records []HistoryRecord
+
+	now := m.clock.Now()
+	if records, err = m.history.List(ctx, now.Add(-m.settings.Lookback)); err != nil {
+		return nil, fmt.Errorf("could not list claim history: %w", err)
+	}
+
+	counts := map[string]map[string]int{}
+	for _, record := range records {
+		if record.Event != HistoryEventClaim || record.CreatedAt.Hour() != now.Hour() {
+			continue
+		}
+
+		if _, ok := counts[record.PoolId]; !ok {
+			counts[record.PoolId] = map[string]int{}
+		}
+
+		counts[record.PoolId][record.ComponentType]++
+	}
+
+	days := math.Max(1, m.settings.Lookback.Hours()/24)
+	demand := map[string]map[string]int{}
+
+	for poolId, components := range counts {
+		demand[poolId] = map[string]int{}
+
+		for componentType, count := range components {
+			expected := int(math.Ceil(float64(count) / days * m.settings.Headroom))
+			demand[poolId][componentType] = min(expected, m.settings.MaxPerComponent)
+		}
+	}
+
+	return demand, nil
+}
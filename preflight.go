@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+type PreflightInput struct {
+	Claims []*RunInput `json:"claims"`
+}
+
+type PreflightOutput struct {
+	Ok     bool              `json:"ok"`
+	Claims []*PreflightClaim `json:"claims"`
+}
+
+// PreflightClaim is the outcome of a single claim of a preflight check. Idle is the number of warm deployments
+// the claim could take, Schedulable the number of deployments which could be spawned if none is left.
+type PreflightClaim struct {
+	ComponentType string   `json:"component_type"`
+	ComponentName string   `json:"component_name"`
+	ContainerName string   `json:"container_name"`
+	Ok            bool     `json:"ok"`
+	Problems      []string `json:"problems,omitempty"`
+	Idle          int      `json:"idle"`
+	Schedulable   int64    `json:"schedulable"`
+
+	input *RunInput
+}
+
+func NewPreflightClaim(input *RunInput) *PreflightClaim {
+	return &PreflightClaim{
+		ComponentType: input.ComponentType,
+		ComponentName: input.ComponentName,
+		ContainerName: input.ContainerName,
+		Problems:      make([]string, 0),
+		input:         input,
+	}
+}
+
+// Preflight checks whether the claims would currently succeed without claiming anything. Claims which already
+// have problems, e.g. an invalid input, are only checked against the maintenance mode and the claim limits.
+// The claims of the batch compete with each other for the idle deployments and the free capacity.
+func (c *ServicePoolManager) Preflight(ctx context.Context, claims []*PreflightClaim) (*PreflightOutput, error) {
+	var err error
+	var remaining int
+	var deployments []*appsv1.Deployment
+	var estimates []*CapacityEstimate
+
+	maintenanceErr := c.maintenance.Check()
+	limits := map[string]int{}
+	idle := map[string]int{}
+	definitions := map[string]*appsv1.Deployment{}
+	spawning := make([]*PreflightClaim, 0)
+
+	for _, claim := range claims {
+		input := claim.input
+		valid := len(claim.Problems) == 0
+
+		if maintenanceErr != nil {
+			claim.Problems = append(claim.Problems, maintenanceErr.Error())
+		}
+
+		if _, ok := limits[input.TestId]; !ok {
+			if limits[input.TestId], err = c.limiter.Remaining(ctx, input.TestId); err != nil {
+				return nil, fmt.Errorf("could not check the claim limit of test %q: %w", input.TestId, err)
+			}
+		}
+
+		if remaining = limits[input.TestId]; remaining == 0 {
+			claim.Problems = append(claim.Problems, fmt.Sprintf("test %q would exceed the maximum of %d claimed components", input.TestId, c.limiter.settings.MaxPerTest))
+		} else if remaining > 0 {
+			limits[input.TestId] = remaining - 1
+		}
+
+		if !valid {
+			continue
+		}
+
+		componentType := c.specs.Resolve(input.ComponentType)
+		spec := input.Spec
+
+		if spec.Repository == "" {
+			spec, _ = c.specs.Get(componentType)
+		}
+
+		key := K8sNameString(input.PoolId, componentType, input.ContainerName)
+		if _, ok := idle[key]; !ok {
+			labels := map[string]string{
+				LabelPoolId:        K8sNameString(input.PoolId),
+				LabelComponentType: K8sNameString(componentType),
+				LabelContainerName: K8sNameString(input.ContainerName),
+				LableIdle:          "true",
+			}
+
+			if deployments, err = c.k8sClient.ListDeployments(ctx, labels); err != nil {
+				return nil, fmt.Errorf("could not list deployments: %w", err)
+			}
+
+			idle[key] = len(deployments)
+		}
+
+		claim.Idle = idle[key]
+
+		if claim.Idle > 0 && !input.GenerateCredentials && !spec.NeedsDedicatedDeployment() && input.Spec.RedisCluster == nil {
+			idle[key]--
+
+			continue
+		}
+
+		if _, ok := definitions[componentType]; !ok {
+			warmUp := &WarmUpDeployment{
+				PoolId:        input.PoolId,
+				ComponentType: componentType,
+				ContainerName: input.ContainerName,
+				Spec:          spec,
+			}
+
+			if definitions[componentType], err = c.factories.For(warmUp.PoolId).CreateDeployment("preflight", warmUp); err != nil {
+				claim.Problems = append(claim.Problems, fmt.Sprintf("could not create deployment definition: %s", err))
+
+				continue
+			}
+		}
+
+		spawning = append(spawning, claim)
+	}
+
+	if len(spawning) > 0 {
+		if estimates, err = c.capacity.Estimate(ctx, definitions); err != nil {
+			return nil, fmt.Errorf("could not estimate capacity: %w", err)
+		}
+	}
+
+	schedulable := map[string]int64{}
+	for _, estimate := range estimates {
+		schedulable[estimate.ComponentType] = estimate.Schedulable
+	}
+
+	for _, claim := range spawning {
+		componentType := c.specs.Resolve(claim.input.ComponentType)
+		claim.Schedulable = schedulable[componentType]
+
+		if claim.Schedulable <= 0 {
+			claim.Problems = append(claim.Problems, fmt.Sprintf("there is no idle deployment left and no capacity to spawn another %s", componentType))
+
+			continue
+		}
+
+		schedulable[componentType]--
+	}
+
+	output := &PreflightOutput{
+		Ok:     true,
+		Claims: claims,
+	}
+
+	for _, claim := range claims {
+		claim.Ok = len(claim.Problems) == 0
+		output.Ok = output.Ok && claim.Ok
+	}
+
+	return output, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+)
+
+// ReadOnlySettings turn kubrun into an observer of the namespace, e.g. for a standby replica or a dashboard
+// instance: listings, stats and metrics stay available while nothing is spawned, changed or removed.
+type ReadOnlySettings struct {
+	Enabled bool `cfg:"enabled" default:"false"`
+}
+
+func ReadReadOnlySettings(config cfg.Config) (*ReadOnlySettings, error) {
+	settings := &ReadOnlySettings{}
+	if err := config.UnmarshalKey("read_only", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal read only settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// mutating guards the routes which change the namespace. In read-only mode they are answered with 403 before
+// the bound handler is called.
+func mutating(settings *ReadOnlySettings) gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		if !settings.Enabled {
+			ginCtx.Next()
+
+			return
+		}
+
+		ginCtx.AbortWithStatusJSON(http.StatusForbidden, map[string]string{"err": "kubrun runs in read-only mode"})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/justtrackio/gosoline/pkg/mdl"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultRedisClusterPort = 6379
+
+// redisClusterBootstrapScript creates the cluster as soon as all nodes resolve and keeps the container running
+// afterward, so the readiness of the deployment reflects the state of the cluster.
+const redisClusterBootstrapScript = `hosts="%s"
+until redis-cli -h %s -p %d cluster info 2>/dev/null | grep -q cluster_state:ok; do
+  nodes=""
+  for host in $hosts; do
+    ip=$(getent hosts "$host" | awk '{print $1}')
+    [ -n "$ip" ] && nodes="$nodes $ip:%d"
+  done
+  [ "$(echo $nodes | wc -w)" -eq %d ] && redis-cli --cluster create $nodes --cluster-replicas 0 --cluster-yes
+  sleep 2
+done
+exec sleep infinity`
+
+// CreateStatefulSet creates the stateful set running the nodes of a redis cluster. The nodes get stable dns
+// names through the headless service of the deployment, which owns the stateful set, so it is garbage
+// collected together with it.
+func (f *TestContainerFactory) CreateStatefulSet(uid string, input SpawnAble, owner *appsv1.Deployment) (*appsv1.StatefulSet, error) {
+	var err error
+	var spec ContainerSpec
+	var container apiv1.Container
+
+	name := f.objectName(uid, input)
+	if spec, err = RenderSpec(input.GetSpec(), f.templateData(uid, name, input)); err != nil {
+		return nil, fmt.Errorf("could not render spec: %w", err)
+	}
+
+	if container, err = f.mainContainer(spec); err != nil {
+		return nil, err
+	}
+
+	// the nodes don't carry the uid, pool and component labels of the deployment, so they are never mistaken for
+	// its pods
+	labels := map[string]string{
+		LabelClusterNodeOf: uid,
+	}
+
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            redisClusterName(uid),
+			Labels:          labels,
+			OwnerReferences: ownerReferences(owner),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:            mdl.Box(int32(spec.RedisCluster.Nodes)),
+			ServiceName:         name,
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: owner.Spec.Template.GetAnnotations(),
+					Labels:      labels,
+				},
+				Spec: apiv1.PodSpec{
+					Containers:    []apiv1.Container{container},
+					NodeSelector:  owner.Spec.Template.Spec.NodeSelector,
+					Tolerations:   owner.Spec.Template.Spec.Tolerations,
+					RestartPolicy: apiv1.RestartPolicyAlways,
+				},
+			},
+		},
+	}
+
+	return statefulSet, nil
+}
+
+// redisClusterBootstrapContainer turns the main container into the one creating the cluster out of the nodes.
+func (f *TestContainerFactory) redisClusterBootstrapContainer(container apiv1.Container, uid string, serviceName string, spec *RedisClusterSpec) apiv1.Container {
+	port := redisClusterPort(container)
+	hosts := f.redisClusterHosts(uid, serviceName, spec.Nodes)
+
+	container.Args = []string{"sh", "-c", fmt.Sprintf(redisClusterBootstrapScript, strings.Join(hosts, " "), hosts[0], port, port, spec.Nodes)}
+	container.Ports = nil
+	container.Lifecycle = nil
+	container.ReadinessProbe = &apiv1.Probe{
+		ProbeHandler: apiv1.ProbeHandler{
+			Exec: &apiv1.ExecAction{
+				Command: []string{"sh", "-c", fmt.Sprintf("redis-cli -h %s -p %d cluster info | grep -q cluster_state:ok", hosts[0], port)},
+			},
+		},
+		PeriodSeconds: 2,
+	}
+
+	return container
+}
+
+func (f *TestContainerFactory) redisClusterHosts(uid string, serviceName string, nodes int) []string {
+	hosts := make([]string, 0, nodes)
+	for i := 0; i < nodes; i++ {
+		hosts = append(hosts, fmt.Sprintf("%s-%d.%s.%s.svc", redisClusterName(uid), i, serviceName, f.namespace))
+	}
+
+	return hosts
+}
+
+// redisClusterName is shorter than the object name, as the pods of a stateful set add a suffix to it and the
+// revision label limits the name of a stateful set to 52 characters.
+func redisClusterName(uid string) string {
+	return K8sNameString("rc", uid)
+}
+
+// redisClusterNodes returns the addresses of all nodes of a claimed redis cluster or nil for any other claim.
+func redisClusterNodes(claim *Claim) []string {
+	count, err := strconv.Atoi(claim.Deployment.GetAnnotations()[AnnotationClusterNodes])
+	if err != nil || count == 0 {
+		return nil
+	}
+
+	port := int32(defaultRedisClusterPort)
+	for _, p := range claim.Service.Spec.Ports {
+		if p.Name == "main" {
+			port = p.Port
+		}
+	}
+
+	nodes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		host := fmt.Sprintf("%s-%d.%s.%s", redisClusterName(claim.GetId()), i, claim.Service.GetName(), claim.Service.Namespace)
+		nodes = append(nodes, net.JoinHostPort(host, fmt.Sprint(port)))
+	}
+
+	return nodes
+}
+
+func redisClusterPort(container apiv1.Container) int32 {
+	for _, port := range container.Ports {
+		if port.Name == "main" {
+			return port.ContainerPort
+		}
+	}
+
+	return defaultRedisClusterPort
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/uuid"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// defaultJournals are the request journals collected per component type, the settings can add more.
+var defaultJournals = map[string]JournalSettings{
+	"wiremock": {
+		Port: "main",
+		Path: "/__admin/requests",
+	},
+}
+
+// ReleaseArtifactSettings make /stop collect the container logs and request journals of the claimed deployments
+// of the test as artifacts, together with the outputs of the pre delete hooks.
+type ReleaseArtifactSettings struct {
+	Enabled  bool                       `cfg:"enabled" default:"false"`
+	Logs     bool                       `cfg:"logs" default:"true"`
+	Timeout  time.Duration              `cfg:"timeout" default:"10s"`
+	Journals map[string]JournalSettings `cfg:"journals"`
+}
+
+type JournalSettings struct {
+	Port string `cfg:"port"`
+	Path string `cfg:"path"`
+}
+
+type TestArtifactsInput struct {
+	TestId string `uri:"id"`
+}
+
+type ReleaseArtifactCollector struct {
+	logger    log.Logger
+	client    *http.Client
+	k8sClient *K8sClient
+	artifacts *ArtifactStore
+	specs     *SpecRegistry
+	settings  *ReleaseArtifactSettings
+}
+
+func NewReleaseArtifactCollector(ctx context.Context, config cfg.Config, logger log.Logger, k8sClient *K8sClient) (*ReleaseArtifactCollector, error) {
+	var err error
+	var artifacts *ArtifactStore
+	var specs *SpecRegistry
+
+	settings := &ReleaseArtifactSettings{}
+	if err = config.UnmarshalKey("release_artifacts", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal release artifact settings: %w", err)
+	}
+
+	if artifacts, err = ProvideArtifactStore(ctx, config); err != nil {
+		return nil, fmt.Errorf("could not create artifact store: %w", err)
+	}
+
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return nil, fmt.Errorf("could not create spec registry: %w", err)
+	}
+
+	journals := map[string]JournalSettings{}
+	for componentType, journal := range defaultJournals {
+		journals[componentType] = journal
+	}
+
+	for componentType, journal := range settings.Journals {
+		journals[specs.Resolve(componentType)] = journal
+	}
+	settings.Journals = journals
+
+	return &ReleaseArtifactCollector{
+		logger:    logger.WithChannel("release-artifacts"),
+		client:    &http.Client{Timeout: settings.Timeout},
+		k8sClient: k8sClient,
+		artifacts: artifacts,
+		specs:     specs,
+		settings:  settings,
+	}, nil
+}
+
+// Collect stores the logs and request journals of the claimed deployments as artifacts. Missing artifacts are
+// logged, they never stop the release. The collection of every deployment is limited by the timeout.
+func (c *ReleaseArtifactCollector) Collect(ctx context.Context, deployments []*appsv1.Deployment) {
+	if !c.settings.Enabled {
+		return
+	}
+
+	for _, deployment := range deployments {
+		if !isClaimed(deployment) {
+			continue
+		}
+
+		c.collect(ctx, deployment)
+	}
+}
+
+func (c *ReleaseArtifactCollector) collect(ctx context.Context, deployment *appsv1.Deployment) {
+	ctx, cancel := context.WithTimeout(ctx, c.settings.Timeout)
+	defer cancel()
+
+	if c.settings.Logs {
+		if err := c.collectLogs(ctx, deployment); err != nil {
+			c.logger.Warn(ctx, "could not collect logs of %q: %s", deployment.GetName(), err.Error())
+		}
+	}
+
+	if journal, ok := c.settings.Journals[c.specs.Resolve(deployment.GetAnnotations()[AnnotationComponentType])]; ok {
+		if err := c.collectJournal(ctx, deployment, journal); err != nil {
+			c.logger.Warn(ctx, "could not collect request journal of %q: %s", deployment.GetName(), err.Error())
+		}
+	}
+}
+
+func (c *ReleaseArtifactCollector) collectLogs(ctx context.Context, deployment *appsv1.Deployment) error {
+	var err error
+	var pods []*apiv1.Pod
+	var logs []byte
+
+	if pods, err = c.k8sClient.ListPods(ctx, map[string]string{LableUid: deployment.GetLabels()[LableUid]}); err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+
+	for _, pod := range pods {
+		if logs, err = c.k8sClient.GetContainerLogs(ctx, pod.GetName(), "main", c.artifacts.MaxSize()); err != nil {
+			return err
+		}
+
+		c.artifacts.Put(c.newArtifact(deployment, fmt.Sprintf("%s.log", pod.GetName()), logs))
+	}
+
+	return nil
+}
+
+func (c *ReleaseArtifactCollector) collectJournal(ctx context.Context, deployment *appsv1.Deployment, journal JournalSettings) error {
+	var err error
+	var service *apiv1.Service
+	var req *http.Request
+	var resp *http.Response
+	var body []byte
+
+	if service, err = c.k8sClient.GetService(ctx, deployment.GetName()); err != nil {
+		return fmt.Errorf("could not get service: %w", err)
+	}
+
+	address := ""
+	for _, port := range service.Spec.Ports {
+		if port.Name == journal.Port {
+			address = net.JoinHostPort(fmt.Sprintf("%s.%s", service.GetName(), service.Namespace), fmt.Sprint(port.Port))
+		}
+	}
+
+	if address == "" {
+		return fmt.Errorf("service has no port %q", journal.Port)
+	}
+
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s%s", address, journal.Path), nil); err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	if resp, err = c.client.Do(req); err != nil {
+		return fmt.Errorf("could not request journal: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if body, err = io.ReadAll(io.LimitReader(resp.Body, c.artifacts.MaxSize())); err != nil {
+		return fmt.Errorf("could not read journal: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("journal responded with status %d", resp.StatusCode)
+	}
+
+	c.artifacts.Put(c.newArtifact(deployment, fmt.Sprintf("%s-journal.json", deployment.GetName()), body))
+
+	return nil
+}
+
+func (c *ReleaseArtifactCollector) newArtifact(deployment *appsv1.Deployment, name string, content []byte) *Artifact {
+	return &Artifact{
+		Id:            uuid.New().NewV4(),
+		PoolId:        deployment.GetLabels()[LabelPoolId],
+		TestId:        deployment.GetLabels()[LabelTestId],
+		ClaimId:       deployment.GetLabels()[LableUid],
+		ComponentType: deployment.GetAnnotations()[AnnotationComponentType],
+		Name:          name,
+		Content:       content,
+	}
+}
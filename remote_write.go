@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type RemoteWriteSettings struct {
+	Enabled  bool          `cfg:"enabled" default:"false"`
+	Url      string        `cfg:"url"`
+	Interval time.Duration `cfg:"interval" default:"30s"`
+	Timeout  time.Duration `cfg:"timeout" default:"10s"`
+	Username string        `cfg:"username"`
+	Password string        `cfg:"password"`
+	Job      string        `cfg:"job" default:"kubrun"`
+}
+
+type remoteWriteSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// RemoteWriteModule pushes the pool gauges via the Prometheus remote write protocol, for clusters in which
+// the kubrun namespace isn't scraped.
+type RemoteWriteModule struct {
+	kernel.BackgroundModule
+
+	logger    log.Logger
+	clock     clock.Clock
+	client    *http.Client
+	k8sClient *K8sClient
+	settings  *RemoteWriteSettings
+}
+
+func NewRemoteWriteModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var k8sClient *K8sClient
+
+	settings := &RemoteWriteSettings{}
+	if err = config.UnmarshalKey("metric_remote_write", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal remote write settings: %w", err)
+	}
+
+	if settings.Enabled && settings.Url == "" {
+		return nil, fmt.Errorf("remote write is enabled but no url is configured")
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	return &RemoteWriteModule{
+		logger:    logger.WithChannel("remote-write"),
+		clock:     clock.NewRealClock(),
+		client:    &http.Client{Timeout: settings.Timeout},
+		k8sClient: k8sClient,
+		settings:  settings,
+	}, nil
+}
+
+func (m *RemoteWriteModule) Run(ctx context.Context) error {
+	if !m.settings.Enabled {
+		return nil
+	}
+
+	ticker := m.clock.NewTicker(m.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.Chan():
+			if err := m.push(ctx); err != nil {
+				m.logger.Warn(ctx, "could not push pool metrics: %s", err.Error())
+			}
+		}
+	}
+}
+
+func (m *RemoteWriteModule) push(ctx context.Context) error {
+	var err error
+	var gauges []*PoolGauge
+	var req *http.Request
+	var resp *http.Response
+
+	if gauges, err = CollectPoolGauges(ctx, m.k8sClient); err != nil {
+		return fmt.Errorf("could not collect pool gauges: %w", err)
+	}
+
+	samples := make([]remoteWriteSample, 0, len(gauges)*3)
+	for _, gauge := range gauges {
+		for name, value := range map[string]int{
+			"kubrun_pool_idle_deployments":    gauge.Idle,
+			"kubrun_pool_claimed_deployments": gauge.Claimed,
+			"kubrun_pool_failed_claims":       gauge.Failed,
+		} {
+			samples = append(samples, remoteWriteSample{
+				labels: map[string]string{
+					"__name__":       name,
+					"job":            m.settings.Job,
+					"pool_id":        gauge.PoolId,
+					"component_type": gauge.ComponentType,
+				},
+				value: float64(value),
+			})
+		}
+	}
+
+	body := snappy.Encode(nil, encodeWriteRequest(samples, m.clock.Now()))
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, m.settings.Url, bytes.NewReader(body)); err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	if m.settings.Username != "" {
+		req.SetBasicAuth(m.settings.Username, m.settings.Password)
+	}
+
+	if resp, err = m.client.Do(req); err != nil {
+		return fmt.Errorf("could not send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+		return fmt.Errorf("remote write responded with status %d: %s", resp.StatusCode, msg)
+	}
+
+	return nil
+}
+
+// encodeWriteRequest encodes the samples as prometheus.WriteRequest protobuf message. Every sample becomes its
+// own time series, the labels are sorted by name as required by the protocol.
+func encodeWriteRequest(samples []remoteWriteSample, now time.Time) []byte {
+	var request []byte
+
+	for _, sample := range samples {
+		var series []byte
+
+		names := make([]string, 0, len(sample.labels))
+		for name := range sample.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, sample.labels[name])
+
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+
+		var value []byte
+		value = protowire.AppendTag(value, 1, protowire.Fixed64Type)
+		value = protowire.AppendFixed64(value, math.Float64bits(sample.value))
+		value = protowire.AppendTag(value, 2, protowire.VarintType)
+		value = protowire.AppendVarint(value, uint64(now.UnixMilli()))
+
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, value)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+
+	return request
+}
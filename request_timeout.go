@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+)
+
+// RequestTimeoutSettings bound how long a request may run on the server. Long operations like warm ups and
+// claims which wait for their deployment get more time than quick ones like stopping or extending claims.
+// A timeout of 0 disables the limit.
+type RequestTimeoutSettings struct {
+	Quick time.Duration `cfg:"quick" default:"30s"`
+	Long  time.Duration `cfg:"long" default:"5m"`
+}
+
+func ReadRequestTimeoutSettings(config cfg.Config) (*RequestTimeoutSettings, error) {
+	settings := &RequestTimeoutSettings{}
+	if err := config.UnmarshalKey("request_timeouts", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal request timeout settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// withTimeout cancels the context of the request after the timeout. The handlers stop at the next kubernetes
+// call or wait and report what they got done so far.
+func withTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		if timeout <= 0 {
+			ginCtx.Next()
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(ginCtx.Request.Context(), timeout)
+		defer cancel()
+
+		ginCtx.Request = ginCtx.Request.WithContext(ctx)
+		ginCtx.Next()
+	}
+}
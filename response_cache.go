@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+)
+
+// ResponseCacheSettings keep the responses of the listing and stats endpoints for a short time, so dashboards
+// polling every few seconds don't list all deployments and pods of the namespace on every poll. A ttl of 0
+// disables the cache.
+type ResponseCacheSettings struct {
+	Ttl time.Duration `cfg:"ttl" default:"5s"`
+}
+
+func ReadResponseCacheSettings(config cfg.Config) (*ResponseCacheSettings, error) {
+	settings := &ResponseCacheSettings{}
+	if err := config.UnmarshalKey("response_cache", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal response cache settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+type cachedResponse struct {
+	status    int
+	header    http.Header
+	body      []byte
+	createdAt time.Time
+}
+
+type responseCache struct {
+	lck      sync.Mutex
+	clock    clock.Clock
+	settings *ResponseCacheSettings
+	entries  map[string]*cachedResponse
+}
+
+func newResponseCache(settings *ResponseCacheSettings) *responseCache {
+	return &responseCache{
+		clock:    clock.NewRealClock(),
+		settings: settings,
+		entries:  map[string]*cachedResponse{},
+	}
+}
+
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.clock.Since(entry.createdAt) >= c.settings.Ttl {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+func (c *responseCache) set(key string, entry *cachedResponse) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	for existingKey, existing := range c.entries {
+		if c.clock.Since(existing.createdAt) >= c.settings.Ttl {
+			delete(c.entries, existingKey)
+		}
+	}
+
+	c.entries[key] = entry
+}
+
+// recordingWriter keeps a copy of the body written by the handler.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+
+	return w.ResponseWriter.WriteString(data)
+}
+
+// cached answers read requests from the cache, keyed by their path and query. Only successful responses are
+// cached, the age header tells how old a cached response is.
+func cached(cache *responseCache) gin.HandlerFunc {
+	return func(ginCtx *gin.Context) {
+		if cache.settings.Ttl <= 0 {
+			ginCtx.Next()
+
+			return
+		}
+
+		key := ginCtx.Request.URL.RequestURI()
+
+		if entry, ok := cache.get(key); ok {
+			for name, values := range entry.header {
+				ginCtx.Writer.Header()[name] = values
+			}
+
+			ginCtx.Header("Age", strconv.Itoa(int(cache.clock.Since(entry.createdAt).Seconds())))
+			ginCtx.Status(entry.status)
+			_, _ = ginCtx.Writer.Write(entry.body)
+			ginCtx.Abort()
+
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: ginCtx.Writer}
+		ginCtx.Writer = writer
+		ginCtx.Next()
+
+		if writer.Status() != http.StatusOK {
+			return
+		}
+
+		cache.set(key, &cachedResponse{
+			status:    writer.Status(),
+			header:    writer.Header().Clone(),
+			body:      writer.body.Bytes(),
+			createdAt: cache.clock.Now(),
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+)
+
+const (
+	RetentionOnExpireDelete  = "delete"
+	RetentionOnExpireRecycle = "recycle"
+)
+
+// defaultRetentionPolicy is used for every field which is neither configured for the component type nor by default.
+var defaultRetentionPolicy = RetentionPolicy{
+	DefaultTtl: time.Hour,
+	IdleTtl:    time.Hour,
+	OnExpire:   RetentionOnExpireDelete,
+}
+
+type RetentionSettings struct {
+	Default    RetentionPolicy            `cfg:"default"`
+	Components map[string]RetentionPolicy `cfg:"components"`
+}
+
+// RetentionPolicy defines how long deployments of a component type live. DefaultTtl applies to claims without
+// an expire_after, IdleTtl to idle deployments after their spawn. MaxLifetime caps the expiry of claims and
+// extensions relative to the spawn of the deployment, zero means unlimited. With OnExpire set to recycle an
+// expired deployment is replaced with a fresh idle one of the same pool.
+type RetentionPolicy struct {
+	DefaultTtl  time.Duration `cfg:"default_ttl"`
+	IdleTtl     time.Duration `cfg:"idle_ttl"`
+	MaxLifetime time.Duration `cfg:"max_lifetime"`
+	OnExpire    string        `cfg:"on_expire"`
+}
+
+// ExpireAt returns the expiry of a deployment spawned at createdAt after the ttl, capped by the max lifetime.
+func (p RetentionPolicy) ExpireAt(createdAt time.Time, now time.Time, ttl time.Duration) time.Time {
+	expireAt := now.Add(ttl)
+
+	if p.MaxLifetime > 0 && expireAt.After(createdAt.Add(p.MaxLifetime)) {
+		return createdAt.Add(p.MaxLifetime)
+	}
+
+	return expireAt
+}
+
+// withDefaults fills every unset field with the one of the base policy.
+func (p RetentionPolicy) withDefaults(base RetentionPolicy) RetentionPolicy {
+	if p.DefaultTtl == 0 {
+		p.DefaultTtl = base.DefaultTtl
+	}
+
+	if p.IdleTtl == 0 {
+		p.IdleTtl = base.IdleTtl
+	}
+
+	if p.MaxLifetime == 0 {
+		p.MaxLifetime = base.MaxLifetime
+	}
+
+	if p.OnExpire == "" {
+		p.OnExpire = base.OnExpire
+	}
+
+	return p
+}
+
+type RetentionPolicies struct {
+	base       RetentionPolicy
+	components map[string]RetentionPolicy
+	specs      *SpecRegistry
+}
+
+func NewRetentionPolicies(config cfg.Config) (*RetentionPolicies, error) {
+	var err error
+	var specs *SpecRegistry
+
+	settings := &RetentionSettings{}
+	if err = config.UnmarshalKey("retention", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal retention settings: %w", err)
+	}
+
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return nil, fmt.Errorf("could not create spec registry: %w", err)
+	}
+
+	policies := &RetentionPolicies{
+		base:       settings.Default.withDefaults(defaultRetentionPolicy),
+		components: map[string]RetentionPolicy{},
+		specs:      specs,
+	}
+
+	if err = validateRetentionPolicy("default", policies.base); err != nil {
+		return nil, err
+	}
+
+	for componentType, policy := range settings.Components {
+		policy = policy.withDefaults(policies.base)
+
+		if err = validateRetentionPolicy(componentType, policy); err != nil {
+			return nil, err
+		}
+
+		policies.components[specs.Resolve(componentType)] = policy
+	}
+
+	return policies, nil
+}
+
+// For returns the retention policy of the component type, unset fields are taken from the default policy.
+func (r *RetentionPolicies) For(componentType string) RetentionPolicy {
+	if policy, ok := r.components[r.specs.Resolve(componentType)]; ok {
+		return policy
+	}
+
+	return r.base
+}
+
+func validateRetentionPolicy(name string, policy RetentionPolicy) error {
+	if policy.OnExpire != RetentionOnExpireDelete && policy.OnExpire != RetentionOnExpireRecycle {
+		return fmt.Errorf("retention policy %q has to expire with %q or %q but has %q", name, RetentionOnExpireDelete, RetentionOnExpireRecycle, policy.OnExpire)
+	}
+
+	if policy.DefaultTtl < 0 || policy.IdleTtl < 0 || policy.MaxLifetime < 0 {
+		return fmt.Errorf("retention policy %q must not have negative durations", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/appctx"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+type RightSizingSettings struct {
+	Enabled bool `cfg:"enabled" default:"false"`
+	// Apply uses the recommended requests for new deployments whose spec doesn't define resources. It requires
+	// the floors, below which no request is lowered, as the samples only cover the usage of the past claims.
+	Apply          bool          `cfg:"apply" default:"false"`
+	ApplyMinCpu    string        `cfg:"apply_min_cpu"`
+	ApplyMinMemory string        `cfg:"apply_min_memory"`
+	Interval       time.Duration `cfg:"interval" default:"5m"`
+	MaxSamples     int           `cfg:"max_samples" default:"1000"`
+	MinSamples     int           `cfg:"min_samples" default:"20"`
+	Percentile     float64       `cfg:"percentile" default:"0.95"`
+	Headroom       float64       `cfg:"headroom" default:"1.2"`
+	MinCpu         string        `cfg:"min_cpu" default:"10m"`
+	MinMemory      string        `cfg:"min_memory" default:"32Mi"`
+}
+
+type RightSizingReport struct {
+	Apply           bool                         `json:"apply"`
+	Recommendations []*RightSizingRecommendation `json:"recommendations"`
+}
+
+// RightSizingRecommendation holds the requests which cover the configured percentile of the observed usage
+// and the limits which cover the peak usage, both with the configured headroom.
+type RightSizingRecommendation struct {
+	ComponentType string `json:"component_type"`
+	Samples       int    `json:"samples"`
+	CpuRequest    string `json:"cpu_request"`
+	MemoryRequest string `json:"memory_request"`
+	CpuLimit      string `json:"cpu_limit"`
+	MemoryLimit   string `json:"memory_limit"`
+}
+
+type usageSamples struct {
+	milliCpu []int64
+	memory   []int64
+}
+
+type rightSizerKey struct{}
+
+func ProvideRightSizer(ctx context.Context, config cfg.Config) (*RightSizer, error) {
+	return appctx.Provide(ctx, rightSizerKey{}, func() (*RightSizer, error) {
+		var err error
+
+		sizer := &RightSizer{
+			settings: &RightSizingSettings{},
+			samples:  map[string]*usageSamples{},
+		}
+
+		if err = config.UnmarshalKey("right_sizing", sizer.settings); err != nil {
+			return nil, fmt.Errorf("could not unmarshal right sizing settings: %w", err)
+		}
+
+		if sizer.minCpu, err = resource.ParseQuantity(sizer.settings.MinCpu); err != nil {
+			return nil, fmt.Errorf("invalid min cpu %q: %w", sizer.settings.MinCpu, err)
+		}
+
+		if sizer.minMemory, err = resource.ParseQuantity(sizer.settings.MinMemory); err != nil {
+			return nil, fmt.Errorf("invalid min memory %q: %w", sizer.settings.MinMemory, err)
+		}
+
+		if sizer.settings.Percentile <= 0 || sizer.settings.Percentile > 1 {
+			return nil, fmt.Errorf("the percentile has to be between 0 and 1 but is %g", sizer.settings.Percentile)
+		}
+
+		if !sizer.settings.Apply {
+			return sizer, nil
+		}
+
+		if sizer.settings.ApplyMinCpu == "" || sizer.settings.ApplyMinMemory == "" {
+			return nil, fmt.Errorf("applying the recommendations requires the apply_min_cpu and apply_min_memory floors")
+		}
+
+		if sizer.applyMinCpu, err = resource.ParseQuantity(sizer.settings.ApplyMinCpu); err != nil {
+			return nil, fmt.Errorf("invalid apply min cpu %q: %w", sizer.settings.ApplyMinCpu, err)
+		}
+
+		if sizer.applyMinMemory, err = resource.ParseQuantity(sizer.settings.ApplyMinMemory); err != nil {
+			return nil, fmt.Errorf("invalid apply min memory %q: %w", sizer.settings.ApplyMinMemory, err)
+		}
+
+		return sizer, nil
+	})
+}
+
+// RightSizer keeps the most recent usage samples of the main containers per component type in memory and
+// derives the recommended resources from them.
+type RightSizer struct {
+	lck            sync.RWMutex
+	settings       *RightSizingSettings
+	minCpu         resource.Quantity
+	minMemory      resource.Quantity
+	applyMinCpu    resource.Quantity
+	applyMinMemory resource.Quantity
+	samples        map[string]*usageSamples
+}
+
+func (r *RightSizer) Add(componentType string, usage apiv1.ResourceList) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	samples, ok := r.samples[componentType]
+	if !ok {
+		samples = &usageSamples{}
+		r.samples[componentType] = samples
+	}
+
+	cpu := usage[apiv1.ResourceCPU]
+	memory := usage[apiv1.ResourceMemory]
+
+	samples.milliCpu = append(samples.milliCpu, cpu.MilliValue())
+	samples.memory = append(samples.memory, memory.Value())
+
+	if overflow := len(samples.milliCpu) - r.settings.MaxSamples; overflow > 0 {
+		samples.milliCpu = samples.milliCpu[overflow:]
+		samples.memory = samples.memory[overflow:]
+	}
+}
+
+// Recommend returns the recommendation for the component type once enough samples were collected.
+func (r *RightSizer) Recommend(componentType string) (*RightSizingRecommendation, bool) {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+
+	samples, ok := r.samples[componentType]
+	if !ok || len(samples.milliCpu) < r.settings.MinSamples {
+		return nil, false
+	}
+
+	cpuRequest, cpuLimit := r.recommend(samples.milliCpu, r.minCpu.MilliValue())
+	memoryRequest, memoryLimit := r.recommend(samples.memory, r.minMemory.Value())
+
+	return &RightSizingRecommendation{
+		ComponentType: componentType,
+		Samples:       len(samples.milliCpu),
+		CpuRequest:    resource.NewMilliQuantity(cpuRequest, resource.DecimalSI).String(),
+		MemoryRequest: resource.NewQuantity(memoryRequest, resource.BinarySI).String(),
+		CpuLimit:      resource.NewMilliQuantity(cpuLimit, resource.DecimalSI).String(),
+		MemoryLimit:   resource.NewQuantity(memoryLimit, resource.BinarySI).String(),
+	}, true
+}
+
+func (r *RightSizer) Report() *RightSizingReport {
+	r.lck.RLock()
+	componentTypes := make([]string, 0, len(r.samples))
+	for componentType := range r.samples {
+		componentTypes = append(componentTypes, componentType)
+	}
+	r.lck.RUnlock()
+
+	sort.Strings(componentTypes)
+
+	report := &RightSizingReport{
+		Apply:           r.settings.Apply,
+		Recommendations: make([]*RightSizingRecommendation, 0, len(componentTypes)),
+	}
+
+	for _, componentType := range componentTypes {
+		if recommendation, ok := r.Recommend(componentType); ok {
+			report.Recommendations = append(report.Recommendations, recommendation)
+		}
+	}
+
+	return report
+}
+
+// Apply sets the recommended requests on the main container of a new deployment if applying is enabled and
+// the spec doesn't define resources on its own. The requests are raised to the floors and the memory request is
+// kept within the memory limit.
+func (r *RightSizer) Apply(deployment *appsv1.Deployment, componentType string, spec ContainerSpec) {
+	if !r.settings.Apply || spec.Resources != nil {
+		return
+	}
+
+	recommendation, ok := r.Recommend(K8sNameString(componentType))
+	if !ok {
+		return
+	}
+
+	for i, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Name != "main" {
+			continue
+		}
+
+		if container.Resources.Requests == nil {
+			container.Resources.Requests = apiv1.ResourceList{}
+		}
+
+		cpu := resource.MustParse(recommendation.CpuRequest)
+		if cpu.Cmp(r.applyMinCpu) < 0 {
+			cpu = r.applyMinCpu.DeepCopy()
+		}
+
+		memory := resource.MustParse(recommendation.MemoryRequest)
+		if memory.Cmp(r.applyMinMemory) < 0 {
+			memory = r.applyMinMemory.DeepCopy()
+		}
+
+		if limit, ok := container.Resources.Limits[apiv1.ResourceMemory]; ok && memory.Cmp(limit) > 0 {
+			memory = limit.DeepCopy()
+		}
+
+		container.Resources.Requests[apiv1.ResourceCPU] = cpu
+		container.Resources.Requests[apiv1.ResourceMemory] = memory
+		deployment.Spec.Template.Spec.Containers[i] = container
+	}
+}
+
+// recommend returns the percentile and the peak of the samples with the headroom added, at least the minimum.
+func (r *RightSizer) recommend(samples []int64, minimum int64) (int64, int64) {
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	index := int(math.Ceil(r.settings.Percentile*float64(len(sorted)))) - 1
+	index = max(0, min(index, len(sorted)-1))
+
+	request := int64(float64(sorted[index]) * r.settings.Headroom)
+	limit := int64(float64(sorted[len(sorted)-1]) * r.settings.Headroom)
+
+	return max(request, minimum), max(limit, minimum)
+}
+
+// RightSizingModule samples the usage of the claimed containers and logs the recommendations. Idle containers
+// aren't sampled, as they don't run any test and would pull the recommendations down.
+type RightSizingModule struct {
+	kernel.BackgroundModule
+
+	logger    log.Logger
+	clock     clock.Clock
+	k8sClient *K8sClient
+	sizer     *RightSizer
+}
+
+func NewRightSizingModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var k8sClient *K8sClient
+	var sizer *RightSizer
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	if sizer, err = ProvideRightSizer(ctx, config); err != nil {
+		return nil, fmt.Errorf("could not create right sizer: %w", err)
+	}
+
+	return &RightSizingModule{
+		logger:    logger.WithChannel("right-sizing"),
+		clock:     clock.NewRealClock(),
+		k8sClient: k8sClient,
+		sizer:     sizer,
+	}, nil
+}
+
+func (m *RightSizingModule) Run(ctx context.Context) error {
+	if !m.sizer.settings.Enabled {
+		return nil
+	}
+
+	ticker := m.clock.NewTicker(m.sizer.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.Chan():
+			if err := m.sample(ctx); err != nil {
+				m.logger.Warn(ctx, "could not sample container usage: %s", err.Error())
+
+				continue
+			}
+
+			for _, recommendation := range m.sizer.Report().Recommendations {
+				m.logger.Info(ctx, "recommended resources of component type %q from %d samples: requests cpu %s memory %s, limits cpu %s memory %s",
+					recommendation.ComponentType, recommendation.Samples, recommendation.CpuRequest, recommendation.MemoryRequest, recommendation.CpuLimit, recommendation.MemoryLimit)
+			}
+		}
+	}
+}
+
+func (m *RightSizingModule) sample(ctx context.Context) error {
+	var err error
+	var deployments []*appsv1.Deployment
+	var usages []*PodUsage
+
+	if deployments, err = m.k8sClient.ListDeployments(ctx); err != nil {
+		return fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	claimed := map[string]bool{}
+	for _, deployment := range deployments {
+		if isClaimed(deployment) {
+			claimed[deployment.GetLabels()[LableUid]] = true
+		}
+	}
+
+	if usages, err = m.k8sClient.ListPodUsages(ctx); err != nil {
+		return fmt.Errorf("could not list pod usages: %w", err)
+	}
+
+	for _, usage := range usages {
+		componentType, ok := usage.Labels[LabelComponentType]
+		if !ok || !claimed[usage.Labels[LableUid]] || slices.Contains(syntheticPoolIds, usage.Labels[LabelPoolId]) {
+			continue
+		}
+
+		if main, ok := usage.Containers["main"]; ok {
+			m.sizer.Add(componentType, main)
+		}
+	}
+
+	return nil
+}
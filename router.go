@@ -9,15 +9,118 @@ import (
 )
 
 func NewRouter(ctx context.Context, config cfg.Config, logger log.Logger, router *httpserver.Router) error {
+	readOnly, err := ReadReadOnlySettings(config)
+	if err != nil {
+		return err
+	}
+
+	timeouts, err := ReadRequestTimeoutSettings(config)
+	if err != nil {
+		return err
+	}
+
+	cacheSettings, err := ReadResponseCacheSettings(config)
+	if err != nil {
+		return err
+	}
+
+	bodySettings, err := ReadBodyLoggingSettings(config)
+	if err != nil {
+		return err
+	}
+
+	guard := mutating(readOnly)
+	quick := withTimeout(timeouts.Quick)
+	long := withTimeout(timeouts.Long)
+	cache := cached(newResponseCache(cacheSettings))
+	bodies := logBodies(logger, bodySettings)
+
 	router.HandleWith(httpserver.With(NewHandlerServices, func(router *httpserver.Router, handler *HandlerServices) {
-		router.POST("/run", httpserver.Bind(handler.HandleRun))
-		router.POST("/extend", httpserver.Bind(handler.HandleExtend))
-		router.POST("/stop", httpserver.Bind(handler.HandleStop))
+		logged := bodies("services")
+
+		router.POST("/run", logged, guard, long, httpserver.Bind(handler.HandleRun))
+		router.POST("/run/long-poll", logged, guard, long, httpserver.Bind(handler.HandleRunLongPoll))
+		router.POST("/preflight", logged, quick, httpserver.Bind(handler.HandlePreflight))
+		router.POST("/extend", logged, guard, quick, httpserver.Bind(handler.HandleExtend))
+		router.POST("/stop", logged, guard, long, httpserver.Bind(handler.HandleStop))
+		router.POST("/stop/undo", logged, guard, quick, httpserver.Bind(handler.HandleUndoStop))
+		router.GET("/services/:uid", logged, httpserver.Bind(handler.HandleDetails))
+		router.POST("/services/:uid/debug", logged, guard, httpserver.Bind(handler.HandleDebug))
+		router.PATCH("/services/:uid/env", logged, guard, long, httpserver.Bind(handler.HandleUpdateEnv))
+		router.PATCH("/services/:uid/image", logged, guard, long, httpserver.Bind(handler.HandleUpdateImage))
+		router.GET("/claims/:id", logged, httpserver.Bind(handler.HandleGetClaim))
+		router.POST("/claims/:id/heartbeat", logged, guard, quick, httpserver.Bind(handler.HandleHeartbeat))
+		router.POST("/claims/:id/transfer", logged, guard, quick, httpserver.Bind(handler.HandleTransfer))
+	}))
+
+	router.HandleWith(httpserver.With(NewHandlerSessions, func(router *httpserver.Router, handler *HandlerSessions) {
+		logged := bodies("sessions")
+
+		router.POST("/sessions", logged, guard, quick, httpserver.Bind(handler.HandleCreate))
+		router.GET("/sessions/:id", logged, httpserver.Bind(handler.HandleGet))
+		router.POST("/sessions/:id/heartbeat", logged, guard, quick, httpserver.Bind(handler.HandleHeartbeat))
+		router.POST("/sessions/:id/extend", logged, guard, quick, httpserver.Bind(handler.HandleExtend))
+		router.POST("/sessions/:id/close", logged, guard, quick, httpserver.Bind(handler.HandleClose))
 	}))
 
 	router.HandleWith(httpserver.With(NewHandlerPool, func(router *httpserver.Router, handler *HandlerPool) {
-		router.POST("/pool/warmup", httpserver.Bind(handler.HandleWarmUp))
-		router.POST("/pool/shutdown", httpserver.Bind(handler.HandleShutdown))
+		logged := bodies("pool")
+
+		router.POST("/pool/warmup", logged, guard, long, httpserver.Bind(handler.HandleWarmUp))
+		router.POST("/pool/warmup/bulk", logged, guard, long, httpserver.Bind(handler.HandleBulkWarmUp))
+		router.POST("/pool/shutdown", logged, guard, long, httpserver.Bind(handler.HandleShutdown))
+		router.POST("/pool/rollout", logged, guard, long, httpserver.Bind(handler.HandleRollout))
+		router.GET("/pool/export", logged, httpserver.Bind(handler.HandleExport))
+		router.POST("/pool/import", logged, guard, httpserver.Bind(handler.HandleImport))
+		router.GET("/capacity", logged, cache, httpserver.Bind(handler.HandleCapacity))
+		router.GET("/expiring", logged, cache, httpserver.Bind(handler.HandleExpiring))
+		router.GET("/jobs/:id", logged, httpserver.Bind(handler.HandleGetJob))
+		router.POST("/jobs/:id/cancel", logged, guard, httpserver.Bind(handler.HandleCancelJob))
+	}))
+
+	router.HandleWith(httpserver.With(NewHandlerReports, func(router *httpserver.Router, handler *HandlerReports) {
+		logged := bodies("reports")
+
+		router.GET("/reports/teams", logged, cache, httpserver.Bind(handler.HandleTeams))
+		router.GET("/reports/oom-kills", logged, cache, httpserver.Bind(handler.HandleOomKills))
+		router.GET("/reports/right-sizing", logged, cache, httpserver.BindN(handler.HandleRightSizing))
+	}))
+
+	router.HandleWith(httpserver.With(NewHandlerArtifacts, func(router *httpserver.Router, handler *HandlerArtifacts) {
+		logged := bodies("artifacts")
+
+		router.GET("/artifacts/:id", logged, httpserver.Bind(handler.HandleGet))
+		router.GET("/tests/:id/artifacts", logged, httpserver.Bind(handler.HandleTest))
+	}))
+
+	router.HandleWith(httpserver.With(NewHandlerWiremock, func(router *httpserver.Router, handler *HandlerWiremock) {
+		logged := bodies("wiremock")
+
+		router.GET("/services/:uid/wiremock/*path", logged, handler.HandleAdmin)
+		router.POST("/services/:uid/wiremock/*path", logged, guard, handler.HandleAdmin)
+		router.PUT("/services/:uid/wiremock/*path", logged, guard, handler.HandleAdmin)
+		router.DELETE("/services/:uid/wiremock/*path", logged, guard, handler.HandleAdmin)
+	}))
+
+	router.HandleWith(httpserver.With(NewHandlerCluster, func(router *httpserver.Router, handler *HandlerCluster) {
+		logged := bodies("cluster")
+
+		router.GET("/cluster", logged, httpserver.BindN(handler.HandleStatus))
+	}))
+
+	router.HandleWith(httpserver.With(NewHandlerAdmin, func(router *httpserver.Router, handler *HandlerAdmin) {
+		logged := bodies("admin")
+
+		router.GET("/admin/maintenance", logged, httpserver.Bind(handler.HandleGetMaintenance))
+		router.POST("/admin/maintenance", logged, guard, httpserver.Bind(handler.HandleSetMaintenance))
+		router.POST("/admin/load-test", logged, guard, long, httpserver.Bind(handler.HandleLoadTest))
+	}))
+
+	router.HandleWith(httpserver.With(NewHandlerWebhooks, func(router *httpserver.Router, handler *HandlerWebhooks) {
+		logged := bodies("webhooks")
+
+		router.POST("/webhooks/github", logged, guard, handler.VerifyGithubSignature, httpserver.Bind(handler.HandleGithub))
+		router.POST("/webhooks/gitlab", logged, guard, httpserver.Bind(handler.HandleGitlab))
 	}))
 
 	return nil
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// SchemaVersion is the version of the labels and annotations kubrun writes. Objects without the schema version
+// label were spawned before the label was introduced and have version 0.
+const SchemaVersion = 1
+
+type SchemaMigrationSettings struct {
+	Enabled bool `cfg:"enabled" default:"true"`
+}
+
+// schemaMigration returns the patch ops which migrate an object from one schema version to the next.
+type schemaMigration func(ctx context.Context, object Objecter) ([]string, error)
+
+// SchemaMigrator rewrites the deployments and services spawned by older versions of kubrun to the current
+// schema. It runs on every reconcile, so objects spawned by old instances during a rolling upgrade are
+// migrated as well. Objects of a newer schema are left alone, the newer instances take care of them.
+type SchemaMigrator struct {
+	logger     log.Logger
+	k8sClient  *K8sClient
+	settings   *SchemaMigrationSettings
+	migrations []schemaMigration
+}
+
+func NewSchemaMigrator(ctx context.Context, config cfg.Config, logger log.Logger) (*SchemaMigrator, error) {
+	var err error
+	var k8sClient *K8sClient
+
+	settings := &SchemaMigrationSettings{}
+	if err = config.UnmarshalKey("schema_migration", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal schema migration settings: %w", err)
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	migrator := &SchemaMigrator{
+		logger:    logger.WithChannel("schema-migration"),
+		k8sClient: k8sClient,
+		settings:  settings,
+	}
+
+	// the migration at index n migrates from version n to n+1
+	migrator.migrations = []schemaMigration{
+		migrator.ownServices,
+	}
+
+	return migrator, nil
+}
+
+func (m *SchemaMigrator) Migrate(ctx context.Context) error {
+	if !m.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	var deployments []*appsv1.Deployment
+	var services []*apiv1.Service
+
+	if deployments, err = m.k8sClient.ListDeployments(ctx); err != nil {
+		return fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	if services, err = m.k8sClient.ListServices(ctx); err != nil {
+		return fmt.Errorf("could not list services: %w", err)
+	}
+
+	for _, deployment := range deployments {
+		if err = m.migrate(ctx, deployment, func(ops []string) error {
+			_, err := m.k8sClient.PatchDeployment(ctx, deployment, ops)
+
+			return err
+		}); err != nil {
+			m.logger.Warn(ctx, "could not migrate deployment %q: %s", deployment.GetName(), err.Error())
+		}
+	}
+
+	for _, service := range services {
+		if err = m.migrate(ctx, service, func(ops []string) error {
+			_, err := m.k8sClient.PatchService(ctx, service, ops)
+
+			return err
+		}); err != nil {
+			m.logger.Warn(ctx, "could not migrate service %q: %s", service.GetName(), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// migrate applies the migrations from the schema version of the object up to the current one in a single
+// patch, which also stamps the current version.
+func (m *SchemaMigrator) migrate(ctx context.Context, object Objecter, patch func(ops []string) error) error {
+	var err error
+	var version int
+	var migrationOps []string
+
+	// only the objects of the pools are versioned
+	if object.GetLabels()[LableUid] == "" {
+		return nil
+	}
+
+	if version, err = schemaVersion(object); err != nil {
+		return err
+	}
+
+	if version >= SchemaVersion {
+		return nil
+	}
+
+	ops := make([]string, 0)
+	for from := version; from < SchemaVersion; from++ {
+		if migrationOps, err = m.migrations[from](ctx, object); err != nil {
+			return fmt.Errorf("could not migrate from schema version %d: %w", from, err)
+		}
+
+		ops = append(ops, migrationOps...)
+	}
+
+	ops = append(ops, PatchOp("add", "labels", LabelSchemaVersion, strconv.Itoa(SchemaVersion)))
+
+	if err = patch(ops); err != nil {
+		return err
+	}
+
+	m.logger.Info(ctx, "migrated %q from schema version %d to %d", object.GetName(), version, SchemaVersion)
+
+	return nil
+}
+
+// ownServices makes the deployment the owner of its service, so the service is garbage collected with it.
+// Services of version 0 were spawned without the owner reference.
+func (m *SchemaMigrator) ownServices(ctx context.Context, object Objecter) ([]string, error) {
+	var err error
+	var deployment *appsv1.Deployment
+	var references []byte
+
+	service, ok := object.(*apiv1.Service)
+	if !ok || ownedByDeployment(service) {
+		return nil, nil
+	}
+
+	if deployment, err = m.k8sClient.GetDeployment(ctx, service.GetName()); err != nil {
+		return nil, fmt.Errorf("could not get deployment of service: %w", err)
+	}
+
+	if references, err = json.Marshal(append(service.GetOwnerReferences(), ownerReferences(deployment)...)); err != nil {
+		return nil, fmt.Errorf("could not encode owner references: %w", err)
+	}
+
+	return []string{fmt.Sprintf(`{"op": "add", "path": "/metadata/ownerReferences", "value": %s}`, references)}, nil
+}
+
+func schemaVersion(object Objecter) (int, error) {
+	value, ok := object.GetLabels()[LabelSchemaVersion]
+	if !ok {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %w", value, err)
+	}
+
+	return version, nil
+}
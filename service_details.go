@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// maxServiceEvents limits the events of a service detail response to the most recent ones.
+const maxServiceEvents = 20
+
+var ErrServiceNotFound = errors.New("service not found")
+
+type ServiceDetailsInput struct {
+	Uid     string `uri:"uid"`
+	PoolKey string `header:"X-Pool-Key" json:"-"`
+}
+
+type ServiceDetails struct {
+	Uid           string              `json:"uid"`
+	Name          string              `json:"name"`
+	PoolId        string              `json:"pool_id"`
+	TestId        string              `json:"test_id,omitempty"`
+	ComponentType string              `json:"component_type"`
+	Claimed       bool                `json:"claimed"`
+	Status        string              `json:"status"`
+	Reason        string              `json:"reason,omitempty"`
+	ExpireAfter   string              `json:"expire_after"`
+	Deployment    DeploymentDetails   `json:"deployment"`
+	Pods          []PodDetails        `json:"pods"`
+	Bindings      map[string]string   `json:"bindings"`
+	PodBindings   map[string][]string `json:"pod_bindings"`
+	Events        []EventDetails      `json:"events"`
+}
+
+type DeploymentDetails struct {
+	Replicas          int32             `json:"replicas"`
+	ReadyReplicas     int32             `json:"ready_replicas"`
+	AvailableReplicas int32             `json:"available_replicas"`
+	Conditions        map[string]string `json:"conditions"`
+}
+
+type PodDetails struct {
+	Name       string             `json:"name"`
+	Phase      string             `json:"phase"`
+	Reason     string             `json:"reason,omitempty"`
+	PodIp      string             `json:"pod_ip,omitempty"`
+	Node       string             `json:"node,omitempty"`
+	Containers []ContainerDetails `json:"containers"`
+}
+
+type ContainerDetails struct {
+	Name            string `json:"name"`
+	Image           string `json:"image"`
+	Ready           bool   `json:"ready"`
+	RestartCount    int32  `json:"restart_count"`
+	State           string `json:"state"`
+	Reason          string `json:"reason,omitempty"`
+	Message         string `json:"message,omitempty"`
+	LastTermination string `json:"last_termination,omitempty"`
+}
+
+type EventDetails struct {
+	Object   string    `json:"object"`
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message"`
+	Count    int32     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// ServiceInspector collects everything kubernetes knows about a spawned service, so a test author can find out
+// why a container never came up without access to the cluster.
+type ServiceInspector struct {
+	k8sClient *K8sClient
+}
+
+func NewServiceInspector(ctx context.Context, config cfg.Config, logger log.Logger) (*ServiceInspector, error) {
+	var err error
+	var k8sClient *K8sClient
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	return &ServiceInspector{
+		k8sClient: k8sClient,
+	}, nil
+}
+
+func (i *ServiceInspector) Describe(ctx context.Context, uid string) (*ServiceDetails, error) {
+	var err error
+	var deployments []*appsv1.Deployment
+	var service *apiv1.Service
+	var pods []*apiv1.Pod
+	var events []*apiv1.Event
+
+	if deployments, err = i.k8sClient.ListDeployments(ctx, map[string]string{LableUid: uid}); err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	if len(deployments) == 0 {
+		return nil, ErrServiceNotFound
+	}
+
+	deployment := deployments[0]
+	labels := deployment.GetLabels()
+	annotations := deployment.GetAnnotations()
+
+	if service, err = i.k8sClient.GetService(ctx, deployment.GetName()); err != nil {
+		return nil, fmt.Errorf("could not get service: %w", err)
+	}
+
+	if pods, err = i.k8sClient.ListPods(ctx, map[string]string{LableUid: uid}); err != nil {
+		return nil, fmt.Errorf("could not list pods: %w", err)
+	}
+
+	if events, err = i.k8sClient.ListEvents(ctx, "Deployment", deployment.GetName()); err != nil {
+		return nil, fmt.Errorf("could not list deployment events: %w", err)
+	}
+
+	details := &ServiceDetails{
+		Uid:           uid,
+		Name:          deployment.GetName(),
+		PoolId:        labels[LabelPoolId],
+		TestId:        labels[LabelTestId],
+		ComponentType: annotations[AnnotationComponentType],
+		Claimed:       isClaimed(deployment),
+		Status:        DeploymentClaimStatus(deployment),
+		Reason:        annotations[AnnotationClaimReason],
+		ExpireAfter:   annotations[AnnotationExpireAfter],
+		Deployment:    deploymentDetails(deployment),
+		Pods:          make([]PodDetails, 0, len(pods)),
+		Bindings:      serviceBindings(service),
+		PodBindings:   podBindings(&Claim{Service: service, Pods: pods}),
+	}
+
+	for _, pod := range pods {
+		var podEvents []*apiv1.Event
+
+		details.Pods = append(details.Pods, podDetails(pod))
+
+		if podEvents, err = i.k8sClient.ListEvents(ctx, "Pod", pod.GetName()); err != nil {
+			return nil, fmt.Errorf("could not list events of pod %q: %w", pod.GetName(), err)
+		}
+
+		events = append(events, podEvents...)
+	}
+
+	details.Events = recentEvents(events)
+
+	return details, nil
+}
+
+func deploymentDetails(deployment *appsv1.Deployment) DeploymentDetails {
+	details := DeploymentDetails{
+		ReadyReplicas:     deployment.Status.ReadyReplicas,
+		AvailableReplicas: deployment.Status.AvailableReplicas,
+		Conditions:        map[string]string{},
+	}
+
+	if deployment.Spec.Replicas != nil {
+		details.Replicas = *deployment.Spec.Replicas
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		details.Conditions[string(condition.Type)] = string(condition.Status)
+	}
+
+	return details
+}
+
+func podDetails(pod *apiv1.Pod) PodDetails {
+	details := PodDetails{
+		Name:       pod.GetName(),
+		Phase:      string(pod.Status.Phase),
+		Reason:     pod.Status.Reason,
+		PodIp:      pod.Status.PodIP,
+		Node:       pod.Spec.NodeName,
+		Containers: make([]ContainerDetails, 0, len(pod.Status.ContainerStatuses)),
+	}
+
+	images := map[string]string{}
+	for _, container := range pod.Spec.Containers {
+		images[container.Name] = container.Image
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		container := ContainerDetails{
+			Name:         status.Name,
+			Image:        images[status.Name],
+			Ready:        status.Ready,
+			RestartCount: status.RestartCount,
+		}
+
+		switch {
+		case status.State.Running != nil:
+			container.State = "running"
+		case status.State.Waiting != nil:
+			container.State = "waiting"
+			container.Reason = status.State.Waiting.Reason
+			container.Message = status.State.Waiting.Message
+		case status.State.Terminated != nil:
+			container.State = "terminated"
+			container.Reason = status.State.Terminated.Reason
+			container.Message = status.State.Terminated.Message
+		}
+
+		if terminated := status.LastTerminationState.Terminated; terminated != nil {
+			container.LastTermination = fmt.Sprintf("%s with exit code %d", terminated.Reason, terminated.ExitCode)
+		}
+
+		details.Containers = append(details.Containers, container)
+	}
+
+	return details
+}
+
+// recentEvents returns the most recent events first.
+func recentEvents(events []*apiv1.Event) []EventDetails {
+	details := make([]EventDetails, 0, len(events))
+
+	for _, event := range events {
+		lastSeen := event.LastTimestamp.Time
+		if lastSeen.IsZero() {
+			lastSeen = event.EventTime.Time
+		}
+
+		details = append(details, EventDetails{
+			Object:   fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+			Type:     event.Type,
+			Reason:   event.Reason,
+			Message:  event.Message,
+			Count:    event.Count,
+			LastSeen: lastSeen,
+		})
+	}
+
+	sort.Slice(details, func(i, j int) bool {
+		return details[i].LastSeen.After(details[j].LastSeen)
+	})
+
+	if len(details) > maxServiceEvents {
+		details = details[:maxServiceEvents]
+	}
+
+	return details
+}
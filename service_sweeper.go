@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const metricDanglingServices = "DanglingServices"
+
+type ServiceSweeperSettings struct {
+	Enabled bool `cfg:"enabled" default:"true"`
+}
+
+// ServiceSweeper deletes the services of kubrun whose selector matches no deployment or stateful set anymore,
+// e.g. after a spawn failed halfway or a deployment was deleted by hand. Without the sweeper such a service
+// stays until its expiry.
+type ServiceSweeper struct {
+	logger    log.Logger
+	metric    metric.Writer
+	k8sClient *K8sClient
+	settings  *ServiceSweeperSettings
+}
+
+func NewServiceSweeper(ctx context.Context, config cfg.Config, logger log.Logger) (*ServiceSweeper, error) {
+	var err error
+	var k8sClient *K8sClient
+
+	settings := &ServiceSweeperSettings{}
+	if err = config.UnmarshalKey("service_sweeper", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal service sweeper settings: %w", err)
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	return &ServiceSweeper{
+		logger:    logger.WithChannel("service-sweeper"),
+		metric:    metric.NewWriter(),
+		k8sClient: k8sClient,
+		settings:  settings,
+	}, nil
+}
+
+func (s *ServiceSweeper) Check(ctx context.Context) error {
+	if !s.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	var services []*apiv1.Service
+	var deployments []*appsv1.Deployment
+	var statefulSets []*appsv1.StatefulSet
+
+	// the services are listed first: a spawn creates the service after the deployment, so every listed service
+	// whose deployment wasn't deleted meanwhile finds it in the lists below
+	if services, err = s.k8sClient.ListServices(ctx); err != nil {
+		return fmt.Errorf("could not list services: %w", err)
+	}
+
+	if deployments, err = s.k8sClient.ListDeployments(ctx); err != nil {
+		return fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	if statefulSets, err = s.k8sClient.ListStatefulSets(ctx); err != nil {
+		return fmt.Errorf("could not list stateful sets: %w", err)
+	}
+
+	templates := make([]labels.Set, 0, len(deployments)+len(statefulSets))
+	for _, deployment := range deployments {
+		templates = append(templates, deployment.Spec.Template.GetLabels())
+	}
+
+	for _, statefulSet := range statefulSets {
+		templates = append(templates, statefulSet.Spec.Template.GetLabels())
+	}
+
+	for _, service := range services {
+		if !s.dangling(service, templates) {
+			continue
+		}
+
+		if err = s.k8sClient.DeleteService(ctx, service); err != nil {
+			s.logger.Warn(ctx, "could not delete dangling service %q: %s", service.GetName(), err.Error())
+
+			continue
+		}
+
+		s.metric.WriteOne(ctx, claimMetric(metricDanglingServices, service))
+		s.logger.Warn(ctx, "deleted dangling service %q of pool %q", service.GetName(), service.GetLabels()[LabelPoolId])
+	}
+
+	return nil
+}
+
+// dangling reports whether a service spawned by kubrun selects no pods anymore. Aliases have no selector, they
+// are garbage collected together with the deployment owning them.
+func (s *ServiceSweeper) dangling(service *apiv1.Service, templates []labels.Set) bool {
+	serviceLabels := service.GetLabels()
+	if serviceLabels[LableUid] == "" && serviceLabels[LabelDependencyOf] == "" {
+		return false
+	}
+
+	if len(service.Spec.Selector) == 0 {
+		return false
+	}
+
+	selector := labels.SelectorFromSet(service.Spec.Selector)
+	for _, template := range templates {
+		if selector.Matches(template) {
+			return false
+		}
+	}
+
+	return true
+}
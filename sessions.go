@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/appctx"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/funk"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/uuid"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type SessionSettings struct {
+	DefaultTtl       time.Duration `cfg:"default_ttl" default:"1h"`
+	HeartbeatTimeout time.Duration `cfg:"heartbeat_timeout" default:"5m"`
+	CheckInterval    time.Duration `cfg:"check_interval" default:"30s"`
+}
+
+type CreateSessionInput struct {
+	PoolId           string        `json:"pool_id"`
+	Ttl              time.Duration `json:"ttl"`
+	HeartbeatTimeout time.Duration `json:"heartbeat_timeout"`
+	PoolKey          string        `header:"X-Pool-Key" json:"-"`
+}
+
+type SessionInput struct {
+	Id      string `uri:"id"`
+	PoolKey string `header:"X-Pool-Key" json:"-"`
+}
+
+type ExtendSessionInput struct {
+	Id       string        `uri:"id"`
+	Duration time.Duration `json:"duration"`
+	PoolKey  string        `header:"X-Pool-Key" json:"-"`
+}
+
+// Session groups the claims of a test run in a pool. All claims made with its id are extended and released
+// together and the session releases them on its own once its ttl or heartbeat lapses.
+type Session struct {
+	Id               string        `json:"id"`
+	PoolId           string        `json:"pool_id"`
+	CreatedAt        time.Time     `json:"created_at"`
+	ExpiresAt        time.Time     `json:"expires_at"`
+	LastHeartbeat    time.Time     `json:"last_heartbeat"`
+	HeartbeatTimeout time.Duration `json:"heartbeat_timeout"`
+}
+
+func (s *Session) isLapsed(now time.Time) bool {
+	return now.After(s.ExpiresAt) || now.After(s.LastHeartbeat.Add(s.HeartbeatTimeout))
+}
+
+type CloseSessionOutput struct {
+	Id       string `json:"id"`
+	Released int    `json:"released"`
+}
+
+type sessionStoreKey struct{}
+
+func ProvideSessionStore(ctx context.Context, config cfg.Config) (*SessionStore, error) {
+	return appctx.Provide(ctx, sessionStoreKey{}, func() (*SessionStore, error) {
+		settings := &SessionSettings{}
+		if err := config.UnmarshalKey("sessions", settings); err != nil {
+			return nil, fmt.Errorf("could not unmarshal session settings: %w", err)
+		}
+
+		return &SessionStore{
+			clock:    clock.NewRealClock(),
+			settings: settings,
+			sessions: map[string]*Session{},
+		}, nil
+	})
+}
+
+// SessionStore keeps the open sessions in memory. Claims of sessions lost on a restart still expire on their own.
+type SessionStore struct {
+	lck      sync.RWMutex
+	clock    clock.Clock
+	settings *SessionSettings
+	sessions map[string]*Session
+}
+
+func (s *SessionStore) Create(input *CreateSessionInput) *Session {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	ttl := input.Ttl
+	if ttl <= 0 {
+		ttl = s.settings.DefaultTtl
+	}
+
+	heartbeatTimeout := input.HeartbeatTimeout
+	if heartbeatTimeout <= 0 {
+		heartbeatTimeout = s.settings.HeartbeatTimeout
+	}
+
+	now := s.clock.Now()
+	session := &Session{
+		Id:               uuid.New().NewV4(),
+		PoolId:           input.PoolId,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(ttl),
+		LastHeartbeat:    now,
+		HeartbeatTimeout: heartbeatTimeout,
+	}
+	s.sessions[session.Id] = session
+
+	return session
+}
+
+func (s *SessionStore) Get(id string) (*Session, error) {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	copied := *session
+
+	return &copied, nil
+}
+
+func (s *SessionStore) Heartbeat(id string) (*Session, error) {
+	return s.update(id, func(session *Session, now time.Time) {
+		session.LastHeartbeat = now
+	})
+}
+
+// Extend moves the end of the session to now plus the duration, a heartbeat is implied.
+func (s *SessionStore) Extend(id string, duration time.Duration) (*Session, error) {
+	return s.update(id, func(session *Session, now time.Time) {
+		session.LastHeartbeat = now
+		session.ExpiresAt = now.Add(duration)
+	})
+}
+
+func (s *SessionStore) Remove(id string) {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	delete(s.sessions, id)
+}
+
+// Lapsed returns the ids of all sessions whose ttl or heartbeat timeout has passed.
+func (s *SessionStore) Lapsed() []string {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+
+	now := s.clock.Now()
+	ids := make([]string, 0)
+
+	for id, session := range s.sessions {
+		if session.isLapsed(now) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func (s *SessionStore) update(id string, apply func(session *Session, now time.Time)) (*Session, error) {
+	s.lck.Lock()
+	defer s.lck.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	apply(session, s.clock.Now())
+	copied := *session
+
+	return &copied, nil
+}
+
+// ExtendSession extends the session and all claims made under it by the duration. The extension budget of every
+// test owning one of the claims is checked first.
+func (c *ServicePoolManager) ExtendSession(ctx context.Context, input *ExtendSessionInput) (*Session, error) {
+	var err error
+	var pool *ServicePool
+	var deployments []*appsv1.Deployment
+
+	if _, err = c.sessions.Get(input.Id); err != nil {
+		return nil, err
+	}
+
+	selector := map[string]string{LabelSessionId: input.Id}
+	if deployments, err = c.k8sClient.ListDeployments(ctx, selector); err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	tests := map[string]string{}
+	for _, deployment := range deployments {
+		tests[deployment.GetLabels()[LabelTestId]] = deployment.GetLabels()[LabelTeam]
+	}
+
+	for testId, team := range tests {
+		if err = c.budget.Check(ctx, testId, team, input.Duration); err != nil {
+			return nil, fmt.Errorf("could not extend test %q: %w", testId, err)
+		}
+	}
+
+	poolIds := funk.Uniq(funk.Map(deployments, func(deployment *appsv1.Deployment) string {
+		return deployment.GetLabels()[LabelPoolId]
+	}))
+
+	for _, poolId := range poolIds {
+		if pool, err = c.getPool(ctx, poolId); err != nil {
+			return nil, fmt.Errorf("could not get pool: %w", err)
+		}
+
+		labels := map[string]string{
+			LabelPoolId:    poolId,
+			LabelSessionId: input.Id,
+		}
+
+		if err = pool.ExtendServices(ctx, labels, input.Duration); err != nil {
+			return nil, fmt.Errorf("could not extend session %q in pool %q: %w", input.Id, poolId, err)
+		}
+	}
+
+	for testId, team := range tests {
+		c.recordHistory(ctx, HistoryRecord{
+			Event:    HistoryEventExtend,
+			TestId:   testId,
+			Team:     team,
+			Duration: input.Duration,
+		})
+	}
+
+	return c.sessions.Extend(input.Id, input.Duration)
+}
+
+// CloseSession releases all claims made under the session and removes it.
+func (c *ServicePoolManager) CloseSession(ctx context.Context, id string) (*CloseSessionOutput, error) {
+	var err error
+	var released int
+
+	if _, err = c.sessions.Get(id); err != nil {
+		return nil, err
+	}
+
+	if released, err = c.releaseMatching(ctx, LabelSessionId, id); err != nil {
+		return nil, fmt.Errorf("could not release session %q: %w", id, err)
+	}
+
+	c.sessions.Remove(id)
+
+	return &CloseSessionOutput{
+		Id:       id,
+		Released: released,
+	}, nil
+}
+
+// SessionModule closes the sessions whose ttl or heartbeat lapsed, releasing all of their claims.
+type SessionModule struct {
+	kernel.BackgroundModule
+
+	logger      log.Logger
+	clock       clock.Clock
+	sessions    *SessionStore
+	poolManager *ServicePoolManager
+	readOnly    *ReadOnlySettings
+}
+
+func NewSessionModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var sessions *SessionStore
+	var poolManager *ServicePoolManager
+	var readOnly *ReadOnlySettings
+
+	if sessions, err = ProvideSessionStore(ctx, config); err != nil {
+		return nil, fmt.Errorf("could not create session store: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	if readOnly, err = ReadReadOnlySettings(config); err != nil {
+		return nil, err
+	}
+
+	return &SessionModule{
+		logger:      logger.WithChannel("sessions"),
+		clock:       clock.NewRealClock(),
+		sessions:    sessions,
+		poolManager: poolManager,
+		readOnly:    readOnly,
+	}, nil
+}
+
+func (m *SessionModule) Run(ctx context.Context) error {
+	// a read-only instance doesn't release the deployments of lapsed sessions
+	if m.readOnly.Enabled {
+		return nil
+	}
+
+	ticker := m.clock.NewTicker(m.sessions.settings.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.Chan():
+			m.closeLapsed(ctx)
+		}
+	}
+}
+
+func (m *SessionModule) closeLapsed(ctx context.Context) {
+	for _, id := range m.sessions.Lapsed() {
+		output, err := m.poolManager.CloseSession(ctx, id)
+		if err != nil {
+			m.logger.Warn(ctx, "could not close lapsed session %q: %s", id, err.Error())
+
+			continue
+		}
+
+		m.logger.Info(ctx, "closed lapsed session %q and released %d deployments", id, output.Released)
+	}
+}
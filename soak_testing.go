@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/metric"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	soakTestPoolId = "kubrun-soak-test"
+
+	metricSoakTestSucceeded = "SoakTestSucceeded"
+	metricSoakTestFailed    = "SoakTestFailed"
+	metricSoakTestDuration  = "SoakTestDuration"
+)
+
+// syntheticPoolIds are the pools of kubrun's own workloads, whose usage doesn't reflect the one of the tests.
+var syntheticPoolIds = []string{canaryPoolId, loadTestPoolId, soakTestPoolId}
+
+// SoakTestSettings configure the soak test. Checking the ports connects to the service of every claim, which
+// only works if kubrun runs inside of the cluster.
+type SoakTestSettings struct {
+	Enabled        bool          `cfg:"enabled" default:"false"`
+	Interval       time.Duration `cfg:"interval" default:"10m"`
+	ComponentTypes []string      `cfg:"component_types"`
+	Claims         int           `cfg:"claims" default:"1"`
+	Timeout        time.Duration `cfg:"timeout" default:"2m"`
+	CheckPorts     bool          `cfg:"check_ports" default:"false"`
+}
+
+// SoakTestModule claims, health checks and releases a few components of every configured type in a pool of its
+// own over and over. The success and failure counts per component type reveal a regression of the cluster, e.g.
+// a broken image pull or a full node pool, before it fails the pipelines.
+type SoakTestModule struct {
+	kernel.BackgroundModule
+
+	logger      log.Logger
+	clock       clock.Clock
+	metric      metric.Writer
+	poolManager *ServicePoolManager
+	settings    *SoakTestSettings
+	readOnly    *ReadOnlySettings
+}
+
+func NewSoakTestModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var poolManager *ServicePoolManager
+	var specs *SpecRegistry
+	var readOnly *ReadOnlySettings
+
+	settings := &SoakTestSettings{}
+	if err = config.UnmarshalKey("soak_test", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal soak test settings: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return nil, fmt.Errorf("could not create spec registry: %w", err)
+	}
+
+	if readOnly, err = ReadReadOnlySettings(config); err != nil {
+		return nil, err
+	}
+
+	// the soak test claims without a spec, so every component type needs one in the registry
+	if settings.Enabled {
+		for i, componentType := range settings.ComponentTypes {
+			settings.ComponentTypes[i] = specs.Resolve(componentType)
+
+			if _, ok := specs.Get(settings.ComponentTypes[i]); !ok {
+				return nil, fmt.Errorf("component type %q of the soak test has no registered spec", componentType)
+			}
+		}
+	}
+
+	return &SoakTestModule{
+		logger:      logger.WithChannel("soak-test"),
+		clock:       clock.NewRealClock(),
+		metric:      metric.NewWriter(),
+		poolManager: poolManager,
+		settings:    settings,
+		readOnly:    readOnly,
+	}, nil
+}
+
+func (m *SoakTestModule) Run(ctx context.Context) error {
+	// a read-only instance leaves the soak test to the active one
+	if !m.settings.Enabled || m.readOnly.Enabled || len(m.settings.ComponentTypes) == 0 {
+		return nil
+	}
+
+	defer func() {
+		if err := m.poolManager.ShutdownSoakTest(context.WithoutCancel(ctx)); err != nil {
+			m.logger.Warn(ctx, "could not remove the deployments of the soak test: %s", err.Error())
+		}
+	}()
+
+	// the idle deployments let the claims take the same path as the ones of the pipelines, the claims replace them
+	if err := m.poolManager.WarmUpSoakTest(ctx, m.settings); err != nil {
+		m.logger.Warn(ctx, "could not warm up the soak test pool: %s", err.Error())
+	}
+
+	ticker := m.clock.NewTicker(m.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.Chan():
+			for _, componentType := range m.settings.ComponentTypes {
+				m.soak(ctx, componentType)
+			}
+		}
+	}
+}
+
+func (m *SoakTestModule) soak(ctx context.Context, componentType string) {
+	succeeded := 0
+
+	for i := 0; i < m.settings.Claims && ctx.Err() == nil; i++ {
+		started := m.clock.Now()
+		err := m.poolManager.RunSoakTest(ctx, componentType, m.settings)
+		took := m.clock.Since(started)
+
+		dimensions := metric.Dimensions{
+			"ComponentType": componentType,
+		}
+
+		data := metric.Data{
+			{
+				MetricName: metricSoakTestDuration,
+				Dimensions: dimensions,
+				Value:      float64(took.Milliseconds()),
+				Unit:       metric.UnitMillisecondsAverage,
+			},
+		}
+
+		if err != nil {
+			m.logger.Warn(ctx, "soak test of component type %q failed after %s: %s", componentType, took, err.Error())
+
+			data = append(data, &metric.Datum{
+				MetricName: metricSoakTestFailed,
+				Dimensions: dimensions,
+				Value:      1,
+				Unit:       metric.UnitCount,
+			})
+		} else {
+			succeeded++
+
+			data = append(data, &metric.Datum{
+				MetricName: metricSoakTestSucceeded,
+				Dimensions: dimensions,
+				Value:      1,
+				Unit:       metric.UnitCount,
+			})
+		}
+
+		m.metric.Write(ctx, data)
+	}
+
+	m.logger.Info(ctx, "soak test of component type %q: %d of %d claims succeeded", componentType, succeeded, m.settings.Claims)
+}
+
+func (c *ServicePoolManager) WarmUpSoakTest(ctx context.Context, settings *SoakTestSettings) error {
+	input := &WarmUpInput{
+		PoolId:     soakTestPoolId,
+		Components: map[string]int{},
+	}
+
+	for _, componentType := range settings.ComponentTypes {
+		input.Components[componentType] = settings.Claims
+	}
+
+	return c.WarmUpPool(ctx, input, nil)
+}
+
+func (c *ServicePoolManager) ShutdownSoakTest(ctx context.Context) error {
+	var err error
+	var pool *ServicePool
+
+	if pool, err = c.getPool(ctx, soakTestPoolId); err != nil {
+		return fmt.Errorf("could not get pool: %w", err)
+	}
+
+	if _, err = pool.Shutdown(ctx); err != nil {
+		return fmt.Errorf("could not shutdown pool: %w", err)
+	}
+
+	return nil
+}
+
+// RunSoakTest claims a component in the soak test pool, waits for it to become ready and releases it again. Like
+// the load test, the claim bypasses the claim limits, the history and the events of the manager.
+func (c *ServicePoolManager) RunSoakTest(ctx context.Context, componentType string, settings *SoakTestSettings) error {
+	var err error
+	var pool *ServicePool
+	var claim *Claim
+
+	if pool, err = c.getPool(ctx, soakTestPoolId); err != nil {
+		return fmt.Errorf("could not get pool: %w", err)
+	}
+
+	input := &RunInput{
+		PoolId:        soakTestPoolId,
+		TestId:        fmt.Sprintf("soak-test-%s", componentType),
+		TestName:      "soak test",
+		ComponentType: componentType,
+		ContainerName: "main",
+		ExpireAfter:   c.retention.For(componentType).DefaultTtl,
+	}
+
+	if claim, err = pool.ClaimService(ctx, input, false); err != nil {
+		return fmt.Errorf("could not claim: %w", err)
+	}
+
+	err = c.checkSoakTestClaim(ctx, claim, settings)
+
+	release := StopInput{PoolId: input.PoolId, TestId: input.TestId}
+	if _, releaseErr := pool.ReleaseServices(context.WithoutCancel(ctx), release.GetLabels()); releaseErr != nil && err == nil {
+		err = fmt.Errorf("could not release: %w", releaseErr)
+	}
+
+	return err
+}
+
+func (c *ServicePoolManager) checkSoakTestClaim(ctx context.Context, claim *Claim, settings *SoakTestSettings) error {
+	var err error
+	var status string
+
+	if status, err = waitForDeployment(ctx, c.clock, c.k8sClient, claim.Deployment.GetName(), settings.Timeout); err != nil {
+		return fmt.Errorf("could not wait for the claim: %w", err)
+	}
+
+	if status != ClaimStatusReady {
+		return fmt.Errorf("claim is %s instead of ready after %s", status, settings.Timeout)
+	}
+
+	if !settings.CheckPorts {
+		return nil
+	}
+
+	return checkPorts(ctx, claim.Service, settings.Timeout)
+}
+
+// checkPorts connects to every tcp port of the service.
+func checkPorts(ctx context.Context, service *apiv1.Service, timeout time.Duration) error {
+	dialer := &net.Dialer{Timeout: timeout}
+	host := fmt.Sprintf("%s.%s", service.GetName(), service.Namespace)
+
+	for _, port := range service.Spec.Ports {
+		if port.Protocol != "" && port.Protocol != apiv1.ProtocolTCP {
+			continue
+		}
+
+		address := net.JoinHostPort(host, fmt.Sprint(port.Port))
+
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return fmt.Errorf("could not connect to port %q at %s: %w", port.Name, address, err)
+		}
+
+		_ = conn.Close()
+	}
+
+	return nil
+}
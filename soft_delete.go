@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// SoftDeleteSettings make /stop scale the deployments of a test to zero instead of deleting them. They are
+// deleted by the expiry after the window, until then the stop can be undone.
+type SoftDeleteSettings struct {
+	Enabled bool          `cfg:"enabled" default:"false"`
+	Window  time.Duration `cfg:"window" default:"10m"`
+}
+
+var ErrNothingToRestore = errors.New("nothing to restore")
+
+type RestoreOutput struct {
+	Restored int `json:"restored"`
+}
+
+func isSoftDeleted(object AnnotationsAware) bool {
+	_, ok := object.GetAnnotations()[AnnotationSoftDeletedAt]
+
+	return ok
+}
+
+// SoftReleaseServices scales the deployments matching the labels to zero and lets them and their services expire
+// after the window. The previous expiry is kept, so it can be restored. It returns the soft deleted deployments.
+func (c *ServicePool) SoftReleaseServices(ctx context.Context, labels map[string]string, window time.Duration) ([]*appsv1.Deployment, error) {
+	var err error
+	var deployments []*appsv1.Deployment
+	var services []*apiv1.Service
+
+	now := c.clock.Now()
+	released := make([]*appsv1.Deployment, 0)
+
+	if deployments, err = c.k8sClient.ListDeployments(ctx, labels); err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	if services, err = c.k8sClient.ListServices(ctx, labels); err != nil {
+		return nil, fmt.Errorf("could not list services: %w", err)
+	}
+
+	servicesByName := map[string]*apiv1.Service{}
+	for _, service := range services {
+		servicesByName[service.GetName()] = service
+	}
+
+	for _, deployment := range deployments {
+		if isSoftDeleted(deployment) {
+			continue
+		}
+
+		ctx := withObjectFields(ctx, deployment)
+
+		// the pods are gone once the deployment is scaled to zero, so the hooks run when it is stopped
+		c.hooks.Run(ctx, deployment)
+
+		ops := []string{
+			PatchOp("add", "annotations", AnnotationSoftDeletedAt, now.Format(time.RFC3339)),
+			PatchOp("add", "annotations", AnnotationRestoreExpireAfter, deployment.GetAnnotations()[AnnotationExpireAfter]),
+			PatchOp("replace", "annotations", AnnotationExpireAfter, now.Add(window).Format(time.RFC3339)),
+		}
+
+		if _, err = c.k8sClient.PatchDeployment(ctx, deployment, append(ops, `{"op": "replace", "path": "/spec/replicas", "value": 0}`)); err != nil {
+			return nil, fmt.Errorf("could not patch deployment: %w", err)
+		}
+
+		if service, ok := servicesByName[deployment.GetName()]; ok {
+			if _, err = c.k8sClient.PatchService(ctx, service, ops); err != nil {
+				return nil, fmt.Errorf("could not patch service: %w", err)
+			}
+		}
+
+		released = append(released, deployment)
+	}
+
+	c.logger.Info(ctx, "soft deleted %d deployments, they are deleted at %s", len(released), now.Add(window).Format(time.RFC3339))
+
+	return released, nil
+}
+
+// RestoreServices scales the soft deleted deployments matching the labels up again and restores their previous
+// expiry, but keeps them at least for the window. It returns the restored deployments.
+func (c *ServicePool) RestoreServices(ctx context.Context, labels map[string]string, window time.Duration) ([]*appsv1.Deployment, error) {
+	var err error
+	var deployments []*appsv1.Deployment
+	var service *apiv1.Service
+	var expireAfter time.Time
+
+	now := c.clock.Now()
+	restored := make([]*appsv1.Deployment, 0)
+
+	if deployments, err = c.k8sClient.ListDeployments(ctx, labels); err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	for _, deployment := range deployments {
+		if !isSoftDeleted(deployment) {
+			continue
+		}
+
+		if expireAfter, err = time.Parse(time.RFC3339, deployment.GetAnnotations()[AnnotationRestoreExpireAfter]); err != nil || expireAfter.Before(now.Add(window)) {
+			expireAfter = now.Add(window)
+		}
+
+		ops := []string{
+			PatchOp("remove", "annotations", AnnotationSoftDeletedAt, nil),
+			PatchOp("remove", "annotations", AnnotationRestoreExpireAfter, nil),
+			PatchOp("replace", "annotations", AnnotationExpireAfter, expireAfter.Format(time.RFC3339)),
+		}
+
+		if deployment, err = c.k8sClient.PatchDeployment(ctx, deployment, append(ops, `{"op": "replace", "path": "/spec/replicas", "value": 1}`)); err != nil {
+			return nil, fmt.Errorf("could not patch deployment: %w", err)
+		}
+
+		if service, err = c.k8sClient.GetService(ctx, deployment.GetName()); err != nil {
+			return nil, fmt.Errorf("could not get service: %w", err)
+		}
+
+		if _, err = c.k8sClient.PatchService(ctx, service, ops); err != nil {
+			return nil, fmt.Errorf("could not patch service: %w", err)
+		}
+
+		restored = append(restored, deployment)
+	}
+
+	c.logger.Info(ctx, "restored %d soft deleted deployments", len(restored))
+
+	return restored, nil
+}
+
+// RestoreServices undoes a soft deleting stop of the test within the window. It returns ErrNothingToRestore if
+// there is no soft deleted deployment of the test.
+func (c *ServicePoolManager) RestoreServices(ctx context.Context, input *StopInput) (*RestoreOutput, error) {
+	var err error
+	var pool *ServicePool
+	var restored []*appsv1.Deployment
+
+	if pool, err = c.getPool(ctx, input.PoolId); err != nil {
+		return nil, fmt.Errorf("could not get pool: %w", err)
+	}
+
+	if restored, err = pool.RestoreServices(ctx, input.GetLabels(), c.softDelete.Window); err != nil {
+		return nil, err
+	}
+
+	if len(restored) == 0 {
+		return nil, fmt.Errorf("test %q of pool %q: %w", input.TestId, input.PoolId, ErrNothingToRestore)
+	}
+
+	for _, deployment := range restored {
+		c.recordHistory(ctx, HistoryRecord{
+			Event:         HistoryEventRestore,
+			PoolId:        deployment.GetLabels()[LabelPoolId],
+			TestId:        deployment.GetLabels()[LabelTestId],
+			ComponentType: deployment.GetAnnotations()[AnnotationComponentType],
+			ClaimId:       deployment.GetLabels()[LableUid],
+			Team:          deployment.GetLabels()[LabelTeam],
+		})
+	}
+
+	return &RestoreOutput{
+		Restored: len(restored),
+	}, nil
+}
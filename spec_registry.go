@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+)
+
+// defaultSpecAliases map the component type names of other test frameworks to the built-in specs.
+var defaultSpecAliases = map[string]string{
+	"dynamodb": "ddb",
+	"minio":    "s3",
+}
+
+type SpecRegistrySettings struct {
+	Aliases  map[string]string              `cfg:"aliases"`
+	Specs    map[string]SpecOverlaySettings `cfg:"specs"`
+	Canaries map[string]CanarySettings      `cfg:"canaries"`
+}
+
+// CanarySettings spawn the given percentage of new deployments of a component type with the canary tag.
+type CanarySettings struct {
+	Tag        string `cfg:"tag"`
+	Percentage int    `cfg:"percentage" default:"10"`
+}
+
+// SpecOverlaySettings define an additional spec. If it extends another component type, only the fields
+// which are set override the base spec, env variables are merged into the env of the base spec.
+type SpecOverlaySettings struct {
+	Extends    string            `cfg:"extends"`
+	Repository string            `cfg:"repository"`
+	Tag        string            `cfg:"tag"`
+	Env        map[string]string `cfg:"env"`
+	Cmd        []string          `cfg:"cmd"`
+	Cpu        string            `cfg:"cpu"`
+	Memory     string            `cfg:"memory"`
+}
+
+// SpecRegistry resolves component types, including their aliases, to the container specs to spawn.
+type SpecRegistry struct {
+	specs    map[string]ContainerSpec
+	aliases  map[string]string
+	canaries map[string]CanarySettings
+}
+
+func NewSpecRegistry(config cfg.Config) (*SpecRegistry, error) {
+	settings := &SpecRegistrySettings{}
+	if err := config.UnmarshalKey("spec_registry", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal spec registry settings: %w", err)
+	}
+
+	registry := &SpecRegistry{
+		specs:    maps.Clone(specs),
+		aliases:  maps.Clone(defaultSpecAliases),
+		canaries: map[string]CanarySettings{},
+	}
+	maps.Copy(registry.aliases, settings.Aliases)
+
+	for componentType := range settings.Specs {
+		if _, err := registry.resolveOverlay(componentType, settings.Specs, []string{}); err != nil {
+			return nil, fmt.Errorf("could not resolve spec %q: %w", componentType, err)
+		}
+	}
+
+	for alias, componentType := range registry.aliases {
+		if _, ok := registry.specs[componentType]; !ok {
+			return nil, fmt.Errorf("alias %q refers to unknown component type %q", alias, componentType)
+		}
+	}
+
+	for componentType, canary := range settings.Canaries {
+		if _, ok := registry.Get(componentType); !ok {
+			return nil, fmt.Errorf("canary refers to unknown component type %q", componentType)
+		}
+
+		if canary.Tag == "" || canary.Percentage < 0 || canary.Percentage > 100 {
+			return nil, fmt.Errorf("canary of component type %q needs a tag and a percentage between 0 and 100", componentType)
+		}
+
+		registry.canaries[registry.Resolve(componentType)] = canary
+	}
+
+	return registry, nil
+}
+
+// resolveOverlay applies the overlay on top of its resolved base spec and adds the result to the registry.
+func (r *SpecRegistry) resolveOverlay(componentType string, overlays map[string]SpecOverlaySettings, path []string) (ContainerSpec, error) {
+	var err error
+	var spec ContainerSpec
+
+	overlay, isOverlay := overlays[componentType]
+	if !isOverlay {
+		var ok bool
+
+		if spec, ok = r.Get(componentType); !ok {
+			return spec, fmt.Errorf("unknown component type %q", componentType)
+		}
+
+		return spec, nil
+	}
+
+	if slices.Contains(path, componentType) {
+		return spec, fmt.Errorf("circular spec inheritance %s -> %s", strings.Join(path, " -> "), componentType)
+	}
+
+	if overlay.Extends != "" {
+		if spec, err = r.resolveOverlay(r.Resolve(overlay.Extends), overlays, append(path, componentType)); err != nil {
+			return spec, err
+		}
+	} else if overlay.Repository == "" {
+		return spec, fmt.Errorf("spec %q neither extends another spec nor defines a repository", componentType)
+	}
+
+	spec.Env = maps.Clone(spec.Env)
+	if spec.Env == nil {
+		spec.Env = map[string]string{}
+	}
+	maps.Copy(spec.Env, overlay.Env)
+
+	if overlay.Repository != "" {
+		spec.Repository = overlay.Repository
+	}
+
+	if overlay.Tag != "" {
+		spec.Tag = overlay.Tag
+	}
+
+	if len(overlay.Cmd) > 0 {
+		spec.Cmd = overlay.Cmd
+	}
+
+	if overlay.Cpu != "" || overlay.Memory != "" {
+		resources := ResourceSpec{}
+		if spec.Resources != nil {
+			resources = *spec.Resources
+		}
+
+		if overlay.Cpu != "" {
+			resources.Cpu = overlay.Cpu
+		}
+
+		if overlay.Memory != "" {
+			resources.Memory = overlay.Memory
+		}
+
+		if _, err = resourceRequests(&resources); err != nil {
+			return spec, err
+		}
+
+		spec.Resources = &resources
+	}
+
+	r.specs[componentType] = spec
+
+	return spec, nil
+}
+
+// Resolve returns the component type an alias refers to. Other component types are returned unchanged.
+func (r *SpecRegistry) Resolve(componentType string) string {
+	if resolved, ok := r.aliases[componentType]; ok {
+		return resolved
+	}
+
+	return componentType
+}
+
+func (r *SpecRegistry) Get(componentType string) (ContainerSpec, bool) {
+	spec, ok := r.specs[r.Resolve(componentType)]
+
+	return spec, ok
+}
+
+// Pick returns the spec to spawn a new deployment with. If a canary is configured for the component type,
+// the configured percentage of calls returns the spec with the canary tag.
+func (r *SpecRegistry) Pick(componentType string) (ContainerSpec, bool) {
+	spec, ok := r.Get(componentType)
+	if !ok {
+		return spec, false
+	}
+
+	if canary, ok := r.canaries[r.Resolve(componentType)]; ok && rand.IntN(100) < canary.Percentage {
+		spec.Tag = canary.Tag
+	}
+
+	return spec, true
+}
+
+// All returns the specs of all component types without their aliases.
+func (r *SpecRegistry) All() map[string]ContainerSpec {
+	return maps.Clone(r.specs)
+}
+
+// ResolveComponents returns a copy of the components with all aliases resolved. Counts of an alias and the
+// component type it refers to are added up.
+func (r *SpecRegistry) ResolveComponents(components map[string]int) map[string]int {
+	resolved := make(map[string]int, len(components))
+
+	for componentType, count := range components {
+		resolved[r.Resolve(componentType)] += count
+	}
+
+	return resolved
+}
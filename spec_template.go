@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+const secretAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// SpecTemplateData is available inside the templates of the cmd and env values of a spec, e.g.
+// "{{ .Host }}:9092", "{{ index .Dependencies \"postgres\" }}" or "{{ secret \"password\" }}".
+type SpecTemplateData struct {
+	Uid           string
+	PoolId        string
+	ComponentType string
+	ContainerName string
+	ServiceName   string
+	Namespace     string
+	Host          string
+	Dependencies  map[string]string
+}
+
+// RenderSpec resolves the templates in the cmd and env values of a spec. Calls to secret with the same
+// name return the same random value within one spec, so a generated password can be used in several places.
+func RenderSpec(spec ContainerSpec, data SpecTemplateData) (ContainerSpec, error) {
+	var err error
+
+	secrets := map[string]string{}
+	funcs := template.FuncMap{
+		"secret": func(name string) (string, error) {
+			if value, ok := secrets[name]; ok {
+				return value, nil
+			}
+
+			value, err := randomString(16)
+			secrets[name] = value
+
+			return value, err
+		},
+		"random": randomString,
+	}
+
+	render := func(value string) (string, error) {
+		if !strings.Contains(value, "{{") {
+			return value, nil
+		}
+
+		var tmpl *template.Template
+		buf := &bytes.Buffer{}
+
+		if tmpl, err = template.New("spec").Funcs(funcs).Option("missingkey=error").Parse(value); err != nil {
+			return "", fmt.Errorf("could not parse template %q: %w", value, err)
+		}
+
+		if err = tmpl.Execute(buf, data); err != nil {
+			return "", fmt.Errorf("could not execute template %q: %w", value, err)
+		}
+
+		return buf.String(), nil
+	}
+
+	rendered := spec
+	rendered.Cmd = make([]string, len(spec.Cmd))
+	rendered.Env = make(map[string]string, len(spec.Env))
+
+	for i, arg := range spec.Cmd {
+		if rendered.Cmd[i], err = render(arg); err != nil {
+			return spec, fmt.Errorf("could not render cmd: %w", err)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(spec.Env)) {
+		if rendered.Env[key], err = render(spec.Env[key]); err != nil {
+			return spec, fmt.Errorf("could not render env %q: %w", key, err)
+		}
+	}
+
+	return rendered, nil
+}
+
+func randomString(length int) (string, error) {
+	limit := big.NewInt(int64(len(secretAlphabet)))
+	result := make([]byte, length)
+
+	for i := range result {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", fmt.Errorf("could not generate random string: %w", err)
+		}
+
+		result[i] = secretAlphabet[n.Int64()]
+	}
+
+	return string(result), nil
+}
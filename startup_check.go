@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	apiv1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var (
+	validProtocols         = []string{"", string(apiv1.ProtocolTCP), string(apiv1.ProtocolUDP), string(apiv1.ProtocolSCTP)}
+	validRestartPolicies   = []string{"", RestartPolicyAlways, RestartPolicyNever}
+	validTolerationEffects = []string{"", string(apiv1.TaintEffectNoSchedule), string(apiv1.TaintEffectPreferNoSchedule), string(apiv1.TaintEffectNoExecute)}
+)
+
+type StartupCheckSettings struct {
+	Enabled bool `cfg:"enabled" default:"true"`
+}
+
+// ConfigurationError lists all problems found in the configuration on boot at once.
+type ConfigurationError struct {
+	Problems []string
+}
+
+func (e *ConfigurationError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
+
+// CheckStartup validates the configured specs, the node selector and the namespace kubrun is running against,
+// so a broken configuration fails on boot instead of on the first claim.
+func CheckStartup(ctx context.Context, config cfg.Config, logger log.Logger) error {
+	var err error
+	var specs *SpecRegistry
+	var factory *TestContainerFactory
+	var k8sClient *K8sClient
+
+	settings := &StartupCheckSettings{}
+	if err = config.UnmarshalKey("startup_check", settings); err != nil {
+		return fmt.Errorf("could not unmarshal startup check settings: %w", err)
+	}
+
+	if !settings.Enabled {
+		return nil
+	}
+
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return fmt.Errorf("could not create spec registry: %w", err)
+	}
+
+	if factory, err = NewTestContainerFactory(config); err != nil {
+		return fmt.Errorf("could not create test container factory: %w", err)
+	}
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	logger = logger.WithChannel("startup-check")
+	problems := make([]string, 0)
+
+	all := specs.All()
+	componentTypes := make([]string, 0, len(all))
+	for componentType := range all {
+		componentTypes = append(componentTypes, componentType)
+	}
+	sort.Strings(componentTypes)
+
+	for _, componentType := range componentTypes {
+		problems = appendSpecProblems(problems, componentType, all[componentType])
+
+		if all[componentType].UsesHostNetwork() && !factory.hostNetwork.Allows(componentType) {
+			problems = append(problems, fmt.Sprintf("spec %q: uses the host network or host ports but is not in the allowed component types", componentType))
+		}
+	}
+
+	problems = appendSchedulingProblems(problems, factory)
+	problems = appendClusterProblems(ctx, logger, problems, k8sClient, factory.nodeSelector(""))
+
+	if len(problems) > 0 {
+		return &ConfigurationError{
+			Problems: problems,
+		}
+	}
+
+	logger.Info(ctx, "checked %d specs in namespace %q", len(componentTypes), k8sClient.Namespace())
+
+	return nil
+}
+
+// appendSpecProblems checks everything of a spec which would otherwise only be rejected by kubernetes once a
+// deployment or service is created from it.
+func appendSpecProblems(problems []string, componentType string, spec ContainerSpec) []string {
+	prefix := fmt.Sprintf("spec %q", componentType)
+
+	if name := K8sNameString("tc", uidPlaceholder, componentType, "main"); len(name) > maxLabelLength {
+		problems = append(problems, fmt.Sprintf("%s: the name is %d characters too long to build a valid service name", prefix, len(name)-maxLabelLength))
+	}
+
+	if spec.Repository == "" {
+		problems = append(problems, fmt.Sprintf("%s: no repository is defined", prefix))
+	}
+
+	if _, err := resourceRequests(spec.Resources); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %s", prefix, err.Error()))
+	}
+
+	if _, err := resourceLimits(spec.Resources); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %s", prefix, err.Error()))
+	}
+
+	if !slices.Contains(validRestartPolicies, spec.RestartPolicy) {
+		problems = append(problems, fmt.Sprintf("%s: restart policy %q has to be one of %s or %s", prefix, spec.RestartPolicy, RestartPolicyAlways, RestartPolicyNever))
+	}
+
+	for portName, binding := range spec.PortBindings {
+		if errs := validation.IsValidPortName(K8sNameString(portName)); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: port name %q is invalid: %s", prefix, portName, strings.Join(errs, ", ")))
+		}
+
+		if errs := validation.IsValidPortNum(binding.ContainerPort); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: container port %d of port %q is invalid: %s", prefix, binding.ContainerPort, portName, strings.Join(errs, ", ")))
+		}
+
+		if !slices.Contains(validProtocols, strings.ToUpper(binding.Protocol)) {
+			problems = append(problems, fmt.Sprintf("%s: protocol %q of port %q has to be one of TCP, UDP or SCTP", prefix, binding.Protocol, portName))
+		}
+	}
+
+	for _, problem := range appendDependencyProblems(nil, spec.Dependencies) {
+		problems = append(problems, fmt.Sprintf("%s: %s", prefix, problem))
+	}
+
+	return problems
+}
+
+func appendSchedulingProblems(problems []string, factory *TestContainerFactory) []string {
+	problems = appendNodeSelectorProblems(problems, "node selector", factory.nodeSelector(""))
+
+	for componentType := range factory.settings.ComponentTypes {
+		problems = appendNodeSelectorProblems(problems, fmt.Sprintf("node selector of component type %q", componentType), factory.nodeSelector(componentType))
+	}
+
+	problems = appendTolerationProblems(problems, "tolerations", factory.settings.Tolerations)
+	problems = appendTolerationProblems(problems, "spot tolerations", factory.settings.Spot.Tolerations)
+
+	for componentType, settings := range factory.settings.ComponentTypes {
+		problems = appendTolerationProblems(problems, fmt.Sprintf("tolerations of component type %q", componentType), settings.Tolerations)
+	}
+
+	for poolId, settings := range factory.settings.Pools {
+		problems = appendTolerationProblems(problems, fmt.Sprintf("tolerations of pool %q", poolId), settings.Tolerations)
+	}
+
+	return problems
+}
+
+func appendTolerationProblems(problems []string, prefix string, tolerations []TestContainerToleration) []string {
+	for _, toleration := range tolerations {
+		if toleration.Operator != string(apiv1.TolerationOpEqual) && toleration.Operator != string(apiv1.TolerationOpExists) {
+			problems = append(problems, fmt.Sprintf("%s: operator %q of toleration %q has to be Equal or Exists", prefix, toleration.Operator, toleration.Key))
+		}
+
+		if toleration.Operator == string(apiv1.TolerationOpExists) && toleration.Value != "" {
+			problems = append(problems, fmt.Sprintf("%s: toleration %q with operator Exists must not have a value", prefix, toleration.Key))
+		}
+
+		if !slices.Contains(validTolerationEffects, toleration.Effect) {
+			problems = append(problems, fmt.Sprintf("%s: effect %q of toleration %q has to be NoSchedule, PreferNoSchedule or NoExecute", prefix, toleration.Effect, toleration.Key))
+		}
+	}
+
+	return problems
+}
+
+func appendNodeSelectorProblems(problems []string, prefix string, nodeSelector map[string]string) []string {
+	for key, value := range nodeSelector {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: key %q is invalid: %s", prefix, key, strings.Join(errs, ", ")))
+		}
+
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: value %q of key %q is invalid: %s", prefix, value, key, strings.Join(errs, ", ")))
+		}
+	}
+
+	return problems
+}
+
+// appendClusterProblems makes sure the namespace exists and the node selector matches at least one node. If
+// kubrun isn't allowed to read namespaces or nodes, the check is skipped with a warning.
+func appendClusterProblems(ctx context.Context, logger log.Logger, problems []string, k8sClient *K8sClient, nodeSelector map[string]string) []string {
+	var err error
+	var nodes []*apiv1.Node
+
+	if _, err = k8sClient.GetNamespace(ctx); k8sErrors.IsNotFound(err) {
+		problems = append(problems, fmt.Sprintf("namespace %q does not exist", k8sClient.Namespace()))
+	} else if k8sErrors.IsForbidden(err) {
+		logger.Warn(ctx, "could not check if namespace %q exists: %s", k8sClient.Namespace(), err.Error())
+	} else if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(nodeSelector) == 0 {
+		return problems
+	}
+
+	if nodes, err = k8sClient.ListNodes(ctx, nodeSelector); k8sErrors.IsForbidden(err) {
+		logger.Warn(ctx, "could not check the node selector: %s", err.Error())
+	} else if err != nil {
+		problems = append(problems, err.Error())
+	} else if len(nodes) == 0 {
+		problems = append(problems, fmt.Sprintf("node selector %v matches no node of the cluster", nodeSelector))
+	}
+
+	return problems
+}
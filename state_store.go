@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/justtrackio/gosoline/pkg/appctx"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	apiv1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	LabelState = "kubrun/state"
+
+	stateConfigMapKey = "state"
+)
+
+type stateStoreKey struct{}
+
+func ProvideStateStore(ctx context.Context, config cfg.Config, logger log.Logger) (*StateStore, error) {
+	return appctx.Provide(ctx, stateStoreKey{}, func() (*StateStore, error) {
+		var err error
+		var k8sClient *K8sClient
+
+		if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+			return nil, fmt.Errorf("could not create k8s client: %w", err)
+		}
+
+		return &StateStore{
+			k8sClient: k8sClient,
+		}, nil
+	})
+}
+
+// StateStore keeps state set through the api, like the maintenance mode or the specs pinned by a rollout, as json
+// in a config map per state, so it survives a restart of kubrun.
+type StateStore struct {
+	k8sClient *K8sClient
+}
+
+// Load decodes the state into the value and reports whether it was stored before.
+func (s *StateStore) Load(ctx context.Context, name string, value any) (bool, error) {
+	configMap, err := s.k8sClient.GetConfigMap(ctx, stateConfigMapName(name))
+	if k8sErrors.IsNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("could not load state %q: %w", name, err)
+	}
+
+	if err = json.Unmarshal([]byte(configMap.Data[stateConfigMapKey]), value); err != nil {
+		return false, fmt.Errorf("could not decode state %q: %w", name, err)
+	}
+
+	return true, nil
+}
+
+// Save stores the value as the state, replacing the stored one.
+func (s *StateStore) Save(ctx context.Context, name string, value any) error {
+	var err error
+	var encoded []byte
+	var configMap *apiv1.ConfigMap
+
+	if encoded, err = json.Marshal(value); err != nil {
+		return fmt.Errorf("could not encode state %q: %w", name, err)
+	}
+
+	configMap, err = s.k8sClient.GetConfigMap(ctx, stateConfigMapName(name))
+
+	switch {
+	case k8sErrors.IsNotFound(err):
+		configMap = &apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: stateConfigMapName(name),
+				Labels: map[string]string{
+					LabelState: K8sNameString(name),
+				},
+			},
+			Data: map[string]string{
+				stateConfigMapKey: string(encoded),
+			},
+		}
+
+		if _, err = s.k8sClient.CreateConfigMap(ctx, configMap); err != nil {
+			return fmt.Errorf("could not store state %q: %w", name, err)
+		}
+
+		return nil
+	case err != nil:
+		return fmt.Errorf("could not load state %q: %w", name, err)
+	}
+
+	configMap.Data = map[string]string{
+		stateConfigMapKey: string(encoded),
+	}
+
+	if _, err = s.k8sClient.UpdateConfigMap(ctx, configMap); err != nil {
+		return fmt.Errorf("could not store state %q: %w", name, err)
+	}
+
+	return nil
+}
+
+func stateConfigMapName(name string) string {
+	return K8sNameString("kubrun-state", name)
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"maps"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,15 +15,97 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	localstackDataPath = "/var/lib/localstack"
+	localstackInitPath = "/etc/localstack/init/ready.d"
+
+	wiremockMappingsPath = "/home/wiremock/mappings"
 )
 
 type TestContainerSettings struct {
-	Annotations  map[string]string         `cfg:"annotations"`
+	Annotations  map[string]string               `cfg:"annotations"`
+	NodeSelector map[string]string               `cfg:"node_selector"`
+	Tolerations  []TestContainerToleration       `cfg:"tolerations"`
+	Tls          TestContainerTlsSettings        `cfg:"tls"`
+	ExternalDns  ExternalDnsSettings             `cfg:"external_dns"`
+	Dependencies TestContainerDependencySettings `cfg:"dependencies"`
+	Spot         TestContainerSpotSettings       `cfg:"spot"`
+	// ComponentTypes override the defaults per component type, e.g. to run mysql on nodes with local SSDs.
+	ComponentTypes map[string]TestContainerComponentTypeSettings `cfg:"component_types"`
+	// Pools add tolerations per pool, e.g. for pools running on a dedicated tainted node group.
+	Pools map[string]TestContainerPoolSettings `cfg:"pools"`
+	// AntiAffinity keeps the components of a test on different nodes. It only applies to deployments spawned
+	// for a claim, as the pods of warm deployments don't know their test yet.
+	AntiAffinity TestContainerAntiAffinitySettings `cfg:"anti_affinity"`
+	Zones        TestContainerZoneSettings         `cfg:"zones"`
+	Mesh         TestContainerMeshSettings         `cfg:"mesh"`
+}
+
+// TestContainerZoneSettings pin all pods to one availability zone or spread the pods of a component type
+// evenly across the zones. Pods spawned for a claim prefer the zone of the test runner if it is known.
+type TestContainerZoneSettings struct {
+	TopologyKey string `cfg:"topology_key" default:"topology.kubernetes.io/zone"`
+	Pin         string `cfg:"pin"`
+	Spread      bool   `cfg:"spread" default:"false"`
+	MaxSkew     int    `cfg:"max_skew" default:"1"`
+}
+
+type TestContainerAntiAffinitySettings struct {
+	Enabled bool `cfg:"enabled" default:"false"`
+	// Required refuses to schedule a second component of a test on the same node instead of only avoiding it.
+	Required    bool   `cfg:"required" default:"false"`
+	TopologyKey string `cfg:"topology_key" default:"kubernetes.io/hostname"`
+}
+
+type TestContainerComponentTypeSettings struct {
 	NodeSelector map[string]string         `cfg:"node_selector"`
 	Tolerations  []TestContainerToleration `cfg:"tolerations"`
 }
 
+type TestContainerPoolSettings struct {
+	Tolerations []TestContainerToleration `cfg:"tolerations"`
+}
+
+// TestContainerSpotSettings place the deployments of the warm pools on spot nodes. Deployments spawned for a
+// claim right away stay on the regular nodes, preempted deployments are replaced by the idle watchdog and the
+// crash detector.
+type TestContainerSpotSettings struct {
+	Enabled      bool                      `cfg:"enabled" default:"false"`
+	NodeSelector map[string]string         `cfg:"node_selector"`
+	Tolerations  []TestContainerToleration `cfg:"tolerations"`
+}
+
+// TestContainerProfile adjusts the defaults of all spawned containers per environment, e.g. smaller requests and
+// node port services on a local kind cluster. Resources defined by a spec still take precedence.
+type TestContainerProfile struct {
+	Cpu          string            `cfg:"cpu"`
+	Memory       string            `cfg:"memory"`
+	NodeSelector map[string]string `cfg:"node_selector"`
+	ServiceType  string            `cfg:"service_type" default:"ClusterIP"`
+}
+
+type ExternalDnsSettings struct {
+	Enabled       bool   `cfg:"enabled" default:"false"`
+	Domain        string `cfg:"domain"`
+	AnnotationKey string `cfg:"annotation_key" default:"external-dns.alpha.kubernetes.io/internal-hostname"`
+	Ttl           int    `cfg:"ttl" default:"60"`
+}
+
+type TestContainerTlsSettings struct {
+	IssuerName       string `cfg:"issuer_name"`
+	IssuerKind       string `cfg:"issuer_kind" default:"ClusterIssuer"`
+	DefaultMountPath string `cfg:"default_mount_path" default:"/etc/tls"`
+}
+
+type TestContainerDependencySettings struct {
+	WaitImage string `cfg:"wait_image" default:"busybox:1.36"`
+}
+
 type TestContainerToleration struct {
 	Key      string `cfg:"key"`
 	Operator string `cfg:"operator" default:"Equal"`
@@ -28,49 +113,122 @@ type TestContainerToleration struct {
 	Effect   string `cfg:"effect"`
 }
 
+// DeploymentFactory builds the kubernetes objects of a spawned container. The pools only depend on this
+// interface, so a spec feature is implemented once in the factory and applies to every pool.
+type DeploymentFactory interface {
+	CreateDeployment(uid string, input SpawnAble) (*appsv1.Deployment, error)
+	CreateService(uid string, input SpawnAble, owner *appsv1.Deployment) *apiv1.Service
+	CreateStatefulSet(uid string, input SpawnAble, owner *appsv1.Deployment) (*appsv1.StatefulSet, error)
+	CreateDependencies(uid string, input SpawnAble, owner *appsv1.Deployment) ([]*appsv1.Deployment, []*apiv1.Service, error)
+	CreateConfigMaps(uid string, input SpawnAble, owner *appsv1.Deployment) []*apiv1.ConfigMap
+	CreateSecrets(uid string, input SpawnAble, owner *appsv1.Deployment) []*apiv1.Secret
+	CreateCertificate(uid string, input SpawnAble, owner *appsv1.Deployment) *unstructured.Unstructured
+	CreateAlias(alias string, testId string, service *apiv1.Service, owner *appsv1.Deployment) *apiv1.Service
+	ExternalDnsAnnotations(dnsName string) (map[string]string, string, error)
+}
+
+var _ DeploymentFactory = &TestContainerFactory{}
+
 type TestContainerFactory struct {
-	settings *TestContainerSettings
+	settings    *TestContainerSettings
+	hostNetwork *HostNetworkSettings
+	finalizers  *FinalizerSettings
+	profile     *TestContainerProfile
+	retention   *RetentionPolicies
+	specs       *SpecRegistry
+	namespace   string
 }
 
 func NewTestContainerFactory(config cfg.Config) (*TestContainerFactory, error) {
+	var err error
+	var kubeSettings *KubeSettings
+	var retention *RetentionPolicies
+	var hostNetwork *HostNetworkSettings
+	var finalizerSettings *FinalizerSettings
+	var specs *SpecRegistry
+
 	settings := &TestContainerSettings{}
-	if err := config.UnmarshalKey("testcontainers.default", settings); err != nil {
+	if err = config.UnmarshalKey("testcontainers.default", settings); err != nil {
 		return nil, fmt.Errorf("can not unmarshal test container settings: %w", err)
 	}
 
+	if kubeSettings, err = ReadSettings(config); err != nil {
+		return nil, fmt.Errorf("could not read kube settings: %w", err)
+	}
+
+	var env string
+	if env, err = config.GetString("env"); err != nil {
+		return nil, fmt.Errorf("could not read env: %w", err)
+	}
+
+	profile := &TestContainerProfile{}
+	if err = config.UnmarshalKey(fmt.Sprintf("testcontainers.profiles.%s", env), profile); err != nil {
+		return nil, fmt.Errorf("can not unmarshal test container profile of env %q: %w", env, err)
+	}
+
+	if _, err = resourceRequests(&ResourceSpec{Cpu: profile.Cpu, Memory: profile.Memory}); err != nil {
+		return nil, fmt.Errorf("invalid resources in test container profile of env %q: %w", env, err)
+	}
+
+	if retention, err = NewRetentionPolicies(config); err != nil {
+		return nil, fmt.Errorf("could not create retention policies: %w", err)
+	}
+
+	if err = settings.Mesh.validate(); err != nil {
+		return nil, err
+	}
+
+	if hostNetwork, err = ReadHostNetworkSettings(config); err != nil {
+		return nil, err
+	}
+
+	if finalizerSettings, err = ReadFinalizerSettings(config); err != nil {
+		return nil, err
+	}
+
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return nil, fmt.Errorf("could not create spec registry: %w", err)
+	}
+
 	return &TestContainerFactory{
-		settings: settings,
+		settings:    settings,
+		hostNetwork: hostNetwork,
+		finalizers:  finalizerSettings,
+		profile:     profile,
+		retention:   retention,
+		specs:       specs,
+		namespace:   kubeSettings.Namespace,
 	}, nil
 }
 
-func (f *TestContainerFactory) CreateDeployment(uid string, input SpawnAble) *appsv1.Deployment {
-	spec := input.GetSpec()
+func (f *TestContainerFactory) CreateDeployment(uid string, input SpawnAble) (*appsv1.Deployment, error) {
+	var err error
+	var spec ContainerSpec
 
-	container := apiv1.Container{
-		Name:  "main",
-		Image: fmt.Sprintf("%s:%s", spec.Repository, spec.Tag),
-		Args:  spec.Cmd,
-		Env:   []apiv1.EnvVar{},
-		Resources: apiv1.ResourceRequirements{
-			Requests: apiv1.ResourceList{
-				apiv1.ResourceCPU:    resource.MustParse("300m"),
-				apiv1.ResourceMemory: resource.MustParse("300Mi"),
-			},
-		},
+	name := f.objectName(uid, input)
+	if spec, err = RenderSpec(input.GetSpec(), f.templateData(uid, name, input)); err != nil {
+		return nil, fmt.Errorf("could not render spec: %w", err)
 	}
 
-	for k, v := range spec.Env {
-		container.Env = append(container.Env, apiv1.EnvVar{
-			Name:  k,
-			Value: v,
-		})
+	// the host network is allowed per resolved component type, like the validation of the claims checks it
+	if spec.UsesHostNetwork() && !f.hostNetwork.Allows(f.specs.Resolve(input.GetComponentType())) {
+		return nil, fmt.Errorf("component type %q is not allowed to use the host network or host ports", input.GetComponentType())
 	}
 
-	for portName, portConfig := range spec.PortBindings {
-		container.Ports = append(container.Ports, apiv1.ContainerPort{
-			Name:          K8sNameString(portName),
-			Protocol:      apiv1.Protocol(strings.ToUpper(portConfig.Protocol)),
-			ContainerPort: int32(portConfig.ContainerPort),
+	var container apiv1.Container
+	if container, err = f.mainContainer(spec); err != nil {
+		return nil, err
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(spec.SecretEnv)) {
+		container.Env = append(container.Env, apiv1.EnvVar{
+			Name: key,
+			ValueFrom: &apiv1.EnvVarSource{
+				SecretKeyRef: &apiv1.SecretKeySelector{
+					LocalObjectReference: apiv1.LocalObjectReference{Name: f.secretEnvName(name)},
+					Key:                  key,
+				},
+			},
 		})
 	}
 
@@ -80,36 +238,132 @@ func (f *TestContainerFactory) CreateDeployment(uid string, input SpawnAble) *ap
 		annotations[key] = value
 	}
 
-	nodeSelector := map[string]string{}
-	for key, value := range f.settings.NodeSelector {
-		key = strings.ReplaceAll(key, "\\", "")
-		nodeSelector[key] = value
+	for key, value := range f.meshAnnotations(input) {
+		annotations[key] = value
 	}
 
-	tolerations := make([]apiv1.Toleration, 0)
-	for _, t := range f.settings.Tolerations {
-		tolerations = append(tolerations, apiv1.Toleration{
-			Key:    t.Key,
-			Value:  t.Value,
-			Effect: apiv1.TaintEffect(t.Effect),
+	nodeSelector := f.nodeSelector(input.GetComponentType())
+	if f.settings.Zones.Pin != "" {
+		nodeSelector[f.settings.Zones.TopologyKey] = f.settings.Zones.Pin
+	}
+
+	tolerations := f.tolerations(input.GetPoolId(), input.GetComponentType())
+
+	deploymentAnnotations := map[string]string{
+		AnnotationComponentType: input.GetComponentType(),
+		AnnotationContainerName: input.GetContainerName(),
+		AnnotationExpireAfter:   f.expireAt(input).Format(time.RFC3339),
+		AnnotationImageTag:      spec.Tag,
+	}
+
+	if _, isWarmUp := input.(*WarmUpDeployment); isWarmUp && f.settings.Spot.Enabled {
+		for key, value := range f.settings.Spot.NodeSelector {
+			key = strings.ReplaceAll(key, "\\", "")
+			nodeSelector[key] = value
+		}
+
+		tolerations = append(tolerations, k8sTolerations(f.settings.Spot.Tolerations)...)
+		deploymentAnnotations[AnnotationSpot] = "true"
+	}
+
+	if restartLimit, ok := spec.GetRestartLimit(); ok {
+		deploymentAnnotations[AnnotationRestartLimit] = strconv.Itoa(restartLimit)
+	}
+
+	volumes := make([]apiv1.Volume, 0)
+	if spec.Tls != nil && spec.Tls.Enabled {
+		volumes = append(volumes, apiv1.Volume{
+			Name: "tls",
+			VolumeSource: apiv1.VolumeSource{
+				Secret: &apiv1.SecretVolumeSource{
+					SecretName: f.tlsSecretName(name),
+				},
+			},
+		})
+
+		container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+			Name:      "tls",
+			MountPath: f.tlsMountPath(spec.Tls),
+			ReadOnly:  true,
+		})
+	}
+
+	if spec.Localstack != nil && spec.Localstack.Persistence {
+		container.Env = append(container.Env, apiv1.EnvVar{
+			Name:  "PERSISTENCE",
+			Value: "1",
+		})
+
+		volumes = append(volumes, apiv1.Volume{
+			Name: "localstack-data",
+			VolumeSource: apiv1.VolumeSource{
+				EmptyDir: &apiv1.EmptyDirVolumeSource{},
+			},
+		})
+
+		container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+			Name:      "localstack-data",
+			MountPath: localstackDataPath,
+		})
+	}
+
+	if spec.Localstack != nil && len(spec.Localstack.InitScripts) > 0 {
+		volumes = append(volumes, configMapVolume("localstack-init", f.initScriptsName(name), 0o755))
+
+		container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+			Name:      "localstack-init",
+			MountPath: localstackInitPath,
+			ReadOnly:  true,
+		})
+	}
+
+	// the deployment of a redis cluster only bootstraps the cluster, the nodes run in the stateful set it owns
+	if spec.RedisCluster != nil {
+		container = f.redisClusterBootstrapContainer(container, uid, name, spec.RedisCluster)
+		deploymentAnnotations[AnnotationClusterNodes] = strconv.Itoa(spec.RedisCluster.Nodes)
+	}
+
+	if spec.Wiremock != nil && len(spec.Wiremock.Mappings) > 0 {
+		volumes = append(volumes, configMapVolume("wiremock-mappings", f.mappingsName(name), 0o644))
+
+		container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+			Name:      "wiremock-mappings",
+			MountPath: wiremockMappingsPath,
+			ReadOnly:  true,
 		})
 	}
 
+	podLabels := map[string]string{
+		LabelPoolId:        K8sNameString(input.GetPoolId()),
+		LabelComponentType: K8sNameString(input.GetComponentType()),
+		LabelContainerName: K8sNameString(input.GetContainerName()),
+		LableUid:           uid,
+	}
+
+	if testId, ok := f.antiAffinityTestId(input); ok {
+		podLabels[LabelTestId] = K8sNameString(testId)
+	}
+
+	dnsPolicy := apiv1.DNSClusterFirst
+	if spec.HostNetwork {
+		podLabels[LabelHostNetwork] = "true"
+		dnsPolicy = apiv1.DNSClusterFirstWithHostNet
+	}
+
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: K8sNameString("tc", uid, input.GetComponentType(), input.GetContainerName()),
+			Name: name,
 			Labels: map[string]string{
 				LabelPoolId:        K8sNameString(input.GetPoolId()),
 				LableUid:           uid,
 				LabelComponentType: K8sNameString(input.GetComponentType()),
 				LabelContainerName: K8sNameString(input.GetContainerName()),
+				LabelSpecVersion:   SpecVersion(input.GetSpec()),
+				LabelSchemaVersion: strconv.Itoa(SchemaVersion),
 				LableIdle:          "true",
 			},
-			Annotations: map[string]string{
-				AnnotationComponentType: input.GetComponentType(),
-				AnnotationContainerName: input.GetContainerName(),
-				AnnotationExpireAfter:   time.Now().Add(time.Hour).Format(time.RFC3339),
-			},
+			Annotations: deploymentAnnotations,
+			Finalizers:  finalizers(f.finalizers),
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: mdl.Box(int32(1)),
@@ -124,53 +378,495 @@ func (f *TestContainerFactory) CreateDeployment(uid string, input SpawnAble) *ap
 			Template: apiv1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Annotations: annotations,
-					Labels: map[string]string{
-						LabelPoolId:        K8sNameString(input.GetPoolId()),
-						LabelComponentType: K8sNameString(input.GetComponentType()),
-						LabelContainerName: K8sNameString(input.GetContainerName()),
-						LableUid:           uid,
-					},
+					Labels:      podLabels,
 				},
 				Spec: apiv1.PodSpec{
-					Containers:   []apiv1.Container{container},
-					NodeSelector: nodeSelector,
-					Tolerations:  tolerations,
+					InitContainers:            f.waitForDependencies(uid, spec.Dependencies, dependencyNames(spec.Dependencies)),
+					Containers:                []apiv1.Container{container},
+					NodeSelector:              nodeSelector,
+					Tolerations:               tolerations,
+					Affinity:                  f.affinity(input, spec),
+					HostNetwork:               spec.HostNetwork,
+					DNSPolicy:                 dnsPolicy,
+					TopologySpreadConstraints: f.zoneSpread(input),
+					RestartPolicy:             apiv1.RestartPolicyAlways,
+					Volumes:                   volumes,
+				},
+			},
+		},
+	}
+
+	return deployment, nil
+}
+
+func (f *TestContainerFactory) mainContainer(spec ContainerSpec) (apiv1.Container, error) {
+	var err error
+	var requests, limits apiv1.ResourceList
+
+	if requests, err = resourceRequests(&ResourceSpec{Cpu: f.profile.Cpu, Memory: f.profile.Memory}, spec.Resources); err != nil {
+		return apiv1.Container{}, fmt.Errorf("could not parse resources: %w", err)
+	}
+
+	if limits, err = resourceLimits(spec.Resources); err != nil {
+		return apiv1.Container{}, fmt.Errorf("could not parse resources: %w", err)
+	}
+
+	if limit, ok := limits[apiv1.ResourceMemory]; ok && limit.Cmp(requests[apiv1.ResourceMemory]) < 0 {
+		requests[apiv1.ResourceMemory] = limit
+	}
+
+	container := apiv1.Container{
+		Name:  "main",
+		Image: fmt.Sprintf("%s:%s", spec.Repository, spec.Tag),
+		Args:  spec.Cmd,
+		Env:   []apiv1.EnvVar{},
+		Resources: apiv1.ResourceRequirements{
+			Requests: requests,
+			Limits:   limits,
+		},
+	}
+
+	for k, v := range spec.Env {
+		container.Env = append(container.Env, apiv1.EnvVar{
+			Name:  k,
+			Value: v,
+		})
+	}
+
+	if spec.Lifecycle != nil {
+		container.Lifecycle = &apiv1.Lifecycle{
+			PostStart: execHandler(spec.Lifecycle.PostStart),
+			PreStop:   execHandler(spec.Lifecycle.PreStop),
+		}
+	}
+
+	for portName, portConfig := range spec.PortBindings {
+		container.Ports = append(container.Ports, apiv1.ContainerPort{
+			Name:          K8sNameString(portName),
+			Protocol:      apiv1.Protocol(strings.ToUpper(portConfig.Protocol)),
+			ContainerPort: int32(portConfig.ContainerPort),
+			HostPort:      int32(portConfig.HostPort),
+		})
+	}
+
+	return container, nil
+}
+
+// CreateCertificate creates a cert-manager certificate for all dns names of the service of the deployment.
+// The deployment owns the certificate, so it is garbage collected together with it.
+func (f *TestContainerFactory) CreateCertificate(uid string, input SpawnAble, owner *appsv1.Deployment) *unstructured.Unstructured {
+	name := f.objectName(uid, input)
+	dnsNames := []any{
+		name,
+		fmt.Sprintf("%s.%s", name, f.namespace),
+		fmt.Sprintf("%s.%s.svc", name, f.namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", name, f.namespace),
+	}
+
+	certificate := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "Certificate",
+			"spec": map[string]any{
+				"secretName": f.tlsSecretName(name),
+				"dnsNames":   dnsNames,
+				"issuerRef": map[string]any{
+					"name": f.settings.Tls.IssuerName,
+					"kind": f.settings.Tls.IssuerKind,
+				},
+			},
+		},
+	}
+
+	certificate.SetName(name)
+	certificate.SetLabels(map[string]string{
+		LabelPoolId:        K8sNameString(input.GetPoolId()),
+		LableUid:           uid,
+		LabelComponentType: K8sNameString(input.GetComponentType()),
+		LabelContainerName: K8sNameString(input.GetContainerName()),
+	})
+	certificate.SetOwnerReferences(ownerReferences(owner))
+
+	return certificate
+}
+
+// CreateConfigMaps creates the config maps holding the request specific files of the spec, i.e. the localstack
+// init scripts and the wiremock stub mappings. The deployment owns the config maps, so they are garbage
+// collected together with it.
+func (f *TestContainerFactory) CreateConfigMaps(uid string, input SpawnAble, owner *appsv1.Deployment) []*apiv1.ConfigMap {
+	spec := input.GetSpec()
+	name := f.objectName(uid, input)
+	configMaps := make([]*apiv1.ConfigMap, 0)
+
+	if spec.Localstack != nil && len(spec.Localstack.InitScripts) > 0 {
+		configMaps = append(configMaps, f.configMap(uid, input, owner, f.initScriptsName(name), spec.Localstack.InitScripts))
+	}
+
+	if spec.Wiremock != nil && len(spec.Wiremock.Mappings) > 0 {
+		configMaps = append(configMaps, f.configMap(uid, input, owner, f.mappingsName(name), spec.Wiremock.Mappings))
+	}
+
+	return configMaps
+}
+
+// CreateSecrets returns the secret holding the secret env of the spec, if there is any.
+func (f *TestContainerFactory) CreateSecrets(uid string, input SpawnAble, owner *appsv1.Deployment) []*apiv1.Secret {
+	spec := input.GetSpec()
+	if len(spec.SecretEnv) == 0 {
+		return nil
+	}
+
+	return []*apiv1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: f.secretEnvName(f.objectName(uid, input)),
+				Labels: map[string]string{
+					LabelPoolId:        K8sNameString(input.GetPoolId()),
+					LableUid:           uid,
+					LabelComponentType: K8sNameString(input.GetComponentType()),
+					LabelContainerName: K8sNameString(input.GetContainerName()),
 				},
+				OwnerReferences: ownerReferences(owner),
+			},
+			Type:       apiv1.SecretTypeOpaque,
+			StringData: spec.SecretEnv,
+		},
+	}
+}
+
+func (f *TestContainerFactory) configMap(uid string, input SpawnAble, owner *appsv1.Deployment, name string, data map[string]string) *apiv1.ConfigMap {
+	return &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				LabelPoolId:        K8sNameString(input.GetPoolId()),
+				LableUid:           uid,
+				LabelComponentType: K8sNameString(input.GetComponentType()),
+				LabelContainerName: K8sNameString(input.GetContainerName()),
+			},
+			OwnerReferences: ownerReferences(owner),
+		},
+		Data: data,
+	}
+}
+
+// expireAt returns the expiry of a new deployment: a claim spawning its own deployment gets the requested ttl,
+// any other deployment the idle ttl of its retention policy.
+func (f *TestContainerFactory) expireAt(input SpawnAble) time.Time {
+	now := time.Now()
+	policy := f.retention.For(input.GetComponentType())
+
+	if claim, ok := input.(ExpireAware); ok && claim.GetExpireAfter() > 0 {
+		return policy.ExpireAt(now, now, claim.GetExpireAfter())
+	}
+
+	return policy.ExpireAt(now, now, policy.IdleTtl)
+}
+
+// ExternalDnsAnnotations returns the external-dns annotations for a service which should be reachable
+// under the caller provided dns name together with the resulting hostname.
+func (f *TestContainerFactory) ExternalDnsAnnotations(dnsName string) (map[string]string, string, error) {
+	if !f.settings.ExternalDns.Enabled {
+		return nil, "", fmt.Errorf("external dns is not enabled")
+	}
+
+	if errs := validation.IsDNS1123Label(dnsName); len(errs) > 0 {
+		return nil, "", fmt.Errorf("dns name %q is invalid: %s", dnsName, strings.Join(errs, ", "))
+	}
+
+	hostname := fmt.Sprintf("%s.%s", dnsName, strings.Trim(f.settings.ExternalDns.Domain, "."))
+	annotations := map[string]string{
+		f.settings.ExternalDns.AnnotationKey:   hostname,
+		"external-dns.alpha.kubernetes.io/ttl": strconv.Itoa(f.settings.ExternalDns.Ttl),
+	}
+
+	return annotations, hostname, nil
+}
+
+// resourceRequests returns the default requests of 300m cpu and 300Mi memory with the overrides applied in order.
+func resourceRequests(overrides ...*ResourceSpec) (apiv1.ResourceList, error) {
+	var err error
+
+	requests := apiv1.ResourceList{
+		apiv1.ResourceCPU:    resource.MustParse("300m"),
+		apiv1.ResourceMemory: resource.MustParse("300Mi"),
+	}
+
+	for _, resources := range overrides {
+		if resources == nil {
+			continue
+		}
+
+		if resources.Cpu != "" {
+			if requests[apiv1.ResourceCPU], err = resource.ParseQuantity(resources.Cpu); err != nil {
+				return nil, fmt.Errorf("invalid cpu %q: %w", resources.Cpu, err)
+			}
+		}
+
+		if resources.Memory != "" {
+			if requests[apiv1.ResourceMemory], err = resource.ParseQuantity(resources.Memory); err != nil {
+				return nil, fmt.Errorf("invalid memory %q: %w", resources.Memory, err)
+			}
+		}
+	}
+
+	return requests, nil
+}
+
+// resourceLimits returns the memory limit of the last override defining one, or nil if none does.
+func resourceLimits(overrides ...*ResourceSpec) (apiv1.ResourceList, error) {
+	var err error
+	var limits apiv1.ResourceList
+
+	for _, resources := range overrides {
+		if resources == nil || resources.MemoryLimit == "" {
+			continue
+		}
+
+		limits = apiv1.ResourceList{}
+		if limits[apiv1.ResourceMemory], err = resource.ParseQuantity(resources.MemoryLimit); err != nil {
+			return nil, fmt.Errorf("invalid memory limit %q: %w", resources.MemoryLimit, err)
+		}
+	}
+
+	return limits, nil
+}
+
+func k8sTolerations(settings []TestContainerToleration) []apiv1.Toleration {
+	tolerations := make([]apiv1.Toleration, 0, len(settings))
+	for _, t := range settings {
+		tolerations = append(tolerations, apiv1.Toleration{
+			Key:      t.Key,
+			Operator: apiv1.TolerationOperator(t.Operator),
+			Value:    t.Value,
+			Effect:   apiv1.TaintEffect(t.Effect),
+		})
+	}
+
+	return tolerations
+}
+
+// tolerations merges the tolerations of the component type and the pool into the default ones. A toleration
+// with the same key and effect replaces the less specific one.
+func (f *TestContainerFactory) tolerations(poolId string, componentType string) []apiv1.Toleration {
+	settings := slices.Concat(f.settings.Tolerations, f.settings.ComponentTypes[componentType].Tolerations, f.settings.Pools[poolId].Tolerations)
+	tolerations := make([]apiv1.Toleration, 0, len(settings))
+
+	for _, toleration := range k8sTolerations(settings) {
+		index := slices.IndexFunc(tolerations, func(existing apiv1.Toleration) bool {
+			return existing.Key == toleration.Key && existing.Effect == toleration.Effect
+		})
+
+		if index >= 0 {
+			tolerations[index] = toleration
+
+			continue
+		}
+
+		tolerations = append(tolerations, toleration)
+	}
+
+	return tolerations
+}
+
+// affinity prefers the zone of the test runner and keeps the pod away from the nodes running other pods of the
+// same test. Only inputs of a claim know the runner and the test, warm deployments only get the anti affinity
+// of the host network.
+func (f *TestContainerFactory) affinity(input SpawnAble, spec ContainerSpec) *apiv1.Affinity {
+	affinity := &apiv1.Affinity{}
+
+	if run, ok := input.(*RunInput); ok {
+		affinity.NodeAffinity = f.zoneAffinity(run)
+	}
+
+	if testId, ok := f.antiAffinityTestId(input); ok {
+		affinity.PodAntiAffinity = f.antiAffinity(testId)
+	}
+
+	if spec.HostNetwork {
+		affinity.PodAntiAffinity = hostNetworkAntiAffinity(affinity.PodAntiAffinity)
+	}
+
+	if affinity.NodeAffinity == nil && affinity.PodAntiAffinity == nil {
+		return nil
+	}
+
+	return affinity
+}
+
+func (f *TestContainerFactory) zoneAffinity(input *RunInput) *apiv1.NodeAffinity {
+	if input.Zone == "" || f.settings.Zones.Pin != "" {
+		return nil
+	}
+
+	return &apiv1.NodeAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []apiv1.PreferredSchedulingTerm{{
+			Weight: 100,
+			Preference: apiv1.NodeSelectorTerm{
+				MatchExpressions: []apiv1.NodeSelectorRequirement{{
+					Key:      f.settings.Zones.TopologyKey,
+					Operator: apiv1.NodeSelectorOpIn,
+					Values:   []string{input.Zone},
+				}},
+			},
+		}},
+	}
+}
+
+// antiAffinityTestId returns the test whose other pods the pod has to keep away from.
+func (f *TestContainerFactory) antiAffinityTestId(input SpawnAble) (string, bool) {
+	run, ok := input.(*RunInput)
+	if !f.settings.AntiAffinity.Enabled || !ok || run.TestId == "" {
+		return "", false
+	}
+
+	return run.TestId, true
+}
+
+func (f *TestContainerFactory) antiAffinity(testId string) *apiv1.PodAntiAffinity {
+	term := apiv1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				LabelTestId: K8sNameString(testId),
+			},
+		},
+		TopologyKey: f.settings.AntiAffinity.TopologyKey,
+	}
+
+	antiAffinity := &apiv1.PodAntiAffinity{}
+	if f.settings.AntiAffinity.Required {
+		antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = []apiv1.PodAffinityTerm{term}
+	} else {
+		antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []apiv1.WeightedPodAffinityTerm{{
+			Weight:          100,
+			PodAffinityTerm: term,
+		}}
+	}
+
+	return antiAffinity
+}
+
+// zoneSpread spreads the pods of a component type in a pool evenly across the zones. An unbalanced cluster
+// doesn't block the scheduling, it only makes the scheduler prefer the emptier zones.
+func (f *TestContainerFactory) zoneSpread(input SpawnAble) []apiv1.TopologySpreadConstraint {
+	if !f.settings.Zones.Spread || f.settings.Zones.Pin != "" {
+		return nil
+	}
+
+	return []apiv1.TopologySpreadConstraint{{
+		MaxSkew:           int32(f.settings.Zones.MaxSkew),
+		TopologyKey:       f.settings.Zones.TopologyKey,
+		WhenUnsatisfiable: apiv1.ScheduleAnyway,
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				LabelPoolId:        K8sNameString(input.GetPoolId()),
+				LabelComponentType: K8sNameString(input.GetComponentType()),
 			},
 		},
+	}}
+}
+
+// nodeSelector merges the node selector of the profile and the one of the component type into the default
+// one. Dots in the keys have to be escaped in the config, the escaping is removed here.
+func (f *TestContainerFactory) nodeSelector(componentType string) map[string]string {
+	nodeSelector := map[string]string{}
+	for key, value := range f.settings.NodeSelector {
+		key = strings.ReplaceAll(key, "\\", "")
+		nodeSelector[key] = value
+	}
+
+	for key, value := range f.profile.NodeSelector {
+		key = strings.ReplaceAll(key, "\\", "")
+		nodeSelector[key] = value
+	}
+
+	for key, value := range f.settings.ComponentTypes[componentType].NodeSelector {
+		key = strings.ReplaceAll(key, "\\", "")
+		nodeSelector[key] = value
 	}
 
-	return deployment
+	return nodeSelector
 }
 
-func (f *TestContainerFactory) CreateService(uid string, input SpawnAble) *apiv1.Service {
+func (f *TestContainerFactory) objectName(uid string, input SpawnAble) string {
+	return K8sNameString("tc", uid, input.GetComponentType(), input.GetContainerName())
+}
+
+func (f *TestContainerFactory) tlsSecretName(name string) string {
+	return K8sNameString(name, "tls")
+}
+
+func (f *TestContainerFactory) initScriptsName(name string) string {
+	return K8sNameString(name, "init")
+}
+
+func (f *TestContainerFactory) mappingsName(name string) string {
+	return K8sNameString(name, "mappings")
+}
+
+func (f *TestContainerFactory) secretEnvName(name string) string {
+	return K8sNameString(name, "env")
+}
+
+func (f *TestContainerFactory) tlsMountPath(spec *TlsSpec) string {
+	if spec.MountPath != "" {
+		return spec.MountPath
+	}
+
+	return f.settings.Tls.DefaultMountPath
+}
+
+func (f *TestContainerFactory) templateData(uid string, name string, input SpawnAble) SpecTemplateData {
+	dependencies := map[string]string{}
+	for _, dependency := range input.GetSpec().Dependencies {
+		dependencies[dependency.Name] = fmt.Sprintf("%s.%s", f.dependencyName(uid, dependency.Name), f.namespace)
+	}
+
+	return SpecTemplateData{
+		Uid:           uid,
+		PoolId:        input.GetPoolId(),
+		ComponentType: input.GetComponentType(),
+		ContainerName: input.GetContainerName(),
+		ServiceName:   name,
+		Namespace:     f.namespace,
+		Host:          fmt.Sprintf("%s.%s", name, f.namespace),
+		Dependencies:  dependencies,
+	}
+}
+
+// CreateService creates the service of a deployment. The deployment owns the service, so deleting the deployment
+// is enough to garbage collect it.
+func (f *TestContainerFactory) CreateService(uid string, input SpawnAble, owner *appsv1.Deployment) *apiv1.Service {
 	spec := input.GetSpec()
 
 	ports := make([]apiv1.ServicePort, 0)
 	for portName, portConfig := range spec.PortBindings {
 		ports = append(ports, apiv1.ServicePort{
-			Name:       K8sNameString(portName),
-			Protocol:   apiv1.Protocol(strings.ToUpper(portConfig.Protocol)),
-			Port:       int32(portConfig.ContainerPort),
-			TargetPort: intstr.FromString(K8sNameString(portName)),
+			Name:        K8sNameString(portName),
+			Protocol:    apiv1.Protocol(strings.ToUpper(portConfig.Protocol)),
+			AppProtocol: f.meshAppProtocol(portName),
+			Port:        int32(portConfig.ContainerPort),
+			TargetPort:  intstr.FromString(K8sNameString(portName)),
 		})
 	}
 
 	service := &apiv1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: K8sNameString("tc", uid, input.GetComponentType(), input.GetContainerName()),
+			Name: f.objectName(uid, input),
 			Labels: map[string]string{
 				LabelPoolId:        K8sNameString(input.GetPoolId()),
 				LableUid:           uid,
 				LabelComponentType: K8sNameString(input.GetComponentType()),
 				LabelContainerName: K8sNameString(input.GetContainerName()),
+				LabelSchemaVersion: strconv.Itoa(SchemaVersion),
 				LableIdle:          "true",
 			},
 			Annotations: map[string]string{
 				AnnotationComponentType: input.GetComponentType(),
 				AnnotationContainerName: input.GetContainerName(),
-				AnnotationExpireAfter:   time.Now().Add(time.Hour).Format(time.RFC3339),
+				AnnotationExpireAfter:   f.expireAt(input).Format(time.RFC3339),
 			},
+			OwnerReferences: ownerReferences(owner),
 		},
 		Spec: apiv1.ServiceSpec{
 			Selector: map[string]string{
@@ -180,13 +876,73 @@ func (f *TestContainerFactory) CreateService(uid string, input SpawnAble) *apiv1
 				LableUid:           uid,
 			},
 			Ports: ports,
-			Type:  apiv1.ServiceTypeClusterIP,
+			Type:  apiv1.ServiceType(f.profile.ServiceType),
 		},
 	}
 
+	// the service of a redis cluster resolves to its nodes only, not to the pod bootstrapping the cluster
+	if spec.RedisCluster != nil {
+		service.Spec.Selector = map[string]string{
+			LabelClusterNodeOf: uid,
+		}
+	}
+
+	// a headless service resolves directly to the addresses of the pods instead of a virtual ip
+	if spec.Headless || spec.RedisCluster != nil {
+		service.Spec.Type = apiv1.ServiceTypeClusterIP
+		service.Spec.ClusterIP = apiv1.ClusterIPNone
+	}
+
 	return service
 }
 
+// ownerReferences makes the deployment the owner of an object, so kubernetes deletes the object once the
+// deployment is gone.
+func ownerReferences(owner *appsv1.Deployment) []metav1.OwnerReference {
+	return []metav1.OwnerReference{
+		{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       owner.GetName(),
+			UID:        owner.GetUID(),
+		},
+	}
+}
+
+// ownedByDeployment reports whether kubernetes garbage collects the object together with a deployment. Objects
+// created before the owner references were set have to be deleted on their own.
+func ownedByDeployment(object metav1.Object) bool {
+	return slices.ContainsFunc(object.GetOwnerReferences(), func(ref metav1.OwnerReference) bool {
+		return ref.Kind == "Deployment"
+	})
+}
+
+func configMapVolume(volumeName string, configMapName string, mode int32) apiv1.Volume {
+	return apiv1.Volume{
+		Name: volumeName,
+		VolumeSource: apiv1.VolumeSource{
+			ConfigMap: &apiv1.ConfigMapVolumeSource{
+				LocalObjectReference: apiv1.LocalObjectReference{
+					Name: configMapName,
+				},
+				DefaultMode: mdl.Box(mode),
+			},
+		},
+	}
+}
+
+func execHandler(command []string) *apiv1.LifecycleHandler {
+	if len(command) == 0 {
+		return nil
+	}
+
+	return &apiv1.LifecycleHandler{
+		Exec: &apiv1.ExecAction{
+			Command: command,
+		},
+	}
+}
+
 var nonAlphanumericRegex = regexp.MustCompile(`[^-_\.a-z0-9]+`)
 
 func K8sNameString(strs ...string) string {
@@ -1,6 +1,14 @@
 package main
 
-import "time"
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/tracing"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
 
 const (
 	AnnotationComponentType = "kubrun/component-type"
@@ -8,6 +16,29 @@ const (
 	AnnotationContainerName = "kubrun/container-name"
 	AnnotationExpireAfter   = "kubrun/expire-after"
 	AnnotationTestName      = "kubrun/test-name"
+	AnnotationRestartLimit  = "kubrun/restart-limit"
+	AnnotationClaimStatus   = "kubrun/claim-status"
+	AnnotationClaimReason   = "kubrun/claim-reason"
+	AnnotationCiPipelineId  = "kubrun/ci-pipeline-id"
+	AnnotationCiJobUrl      = "kubrun/ci-job-url"
+	AnnotationCiBranch      = "kubrun/ci-branch"
+	AnnotationCiCommit      = "kubrun/ci-commit"
+	AnnotationImageTag      = "kubrun/image-tag"
+	AnnotationTraceId       = "kubrun/trace-id"
+	AnnotationClusterNodes  = "kubrun/cluster-nodes"
+	AnnotationRunInput      = "kubrun/run-input"
+	AnnotationWarmUpSpec    = "kubrun/warm-up-spec"
+
+	AnnotationOomRecordedRestarts = "kubrun/oom-recorded-restarts"
+	AnnotationSpot                = "kubrun/spot"
+
+	AnnotationSoftDeletedAt      = "kubrun/soft-deleted-at"
+	AnnotationRestoreExpireAfter = "kubrun/restore-expire-after"
+
+	ClaimStatusFailed = "failed"
+
+	RestartPolicyAlways = "Always"
+	RestartPolicyNever  = "Never"
 
 	LabelPoolId        = "kubrun/pool-id"
 	LabelTestId        = "kubrun/test-id"
@@ -16,6 +47,17 @@ const (
 	LabelContainerName = "kubrun/container-name"
 	LableIdle          = "kubrun/idle"
 	LableUid           = "kubrun/uid"
+	LabelTeam          = "kubrun/team"
+	LabelCiPipelineId  = "kubrun/ci-pipeline-id"
+	LabelSpecVersion   = "kubrun/spec-version"
+	LabelClusterNodeOf = "kubrun/cluster-node-of"
+	LabelHostNetwork   = "kubrun/host-network"
+	LabelDependencyOf  = "kubrun/dependency-of"
+	LabelDependency    = "kubrun/dependency"
+	LabelAlias         = "kubrun/alias"
+	LabelAliasOf       = "kubrun/alias-of"
+	LabelSessionId     = "kubrun/session-id"
+	LabelSchemaVersion = "kubrun/schema-version"
 )
 
 type Labler interface {
@@ -35,6 +77,11 @@ type SpawnAble interface {
 	GetSpec() ContainerSpec
 }
 
+// ExpireAware is implemented by spawn inputs which request their own ttl instead of the idle ttl.
+type ExpireAware interface {
+	GetExpireAfter() time.Duration
+}
+
 type WarmUpDeployment struct {
 	PoolId        string        `json:"pool_id"`
 	ComponentType string        `json:"component_type"`
@@ -62,11 +109,28 @@ type RunInput struct {
 	PoolId        string        `json:"pool_id"`
 	TestId        string        `json:"test_id"`
 	TestName      string        `json:"test_name"`
+	Team          string        `json:"team"`
 	ComponentType string        `json:"component_type"`
 	ComponentName string        `json:"component_name"`
 	ContainerName string        `json:"container_name"`
 	Spec          ContainerSpec `json:"spec"`
 	ExpireAfter   time.Duration `json:"expire_after"`
+	Wait          time.Duration `json:"wait"`
+	Hold          time.Duration `json:"hold"`
+	Async         bool          `json:"async"`
+	Ci            *CiMetadata   `json:"ci"`
+	SessionId     string        `json:"session_id"`
+	PoolKey       string        `header:"X-Pool-Key" json:"-"`
+
+	GenerateCredentials bool     `json:"generate_credentials"`
+	DnsName             string   `json:"dns_name"`
+	Aliases             []string `json:"aliases"`
+	ReturnPodIps        bool     `json:"return_pod_ips"`
+	ReturnConnection    bool     `json:"return_connection"`
+	// Zone is the availability zone of the test runner, components in the same zone are claimed first.
+	Zone string `json:"zone"`
+
+	legacyFields []string
 }
 
 func (i RunInput) GetPoolId() string {
@@ -106,10 +170,129 @@ func (i RunInput) GetExpireAfter() time.Duration {
 	return i.ExpireAfter
 }
 
+// Claim is the result of claiming a deployment and its service for a test.
+type Claim struct {
+	Deployment  *appsv1.Deployment
+	Service     *apiv1.Service
+	Credentials *Credentials
+	Hostname    string
+	Aliases     map[string]string
+	Pods        []*apiv1.Pod
+	Status      string
+	Path        string
+}
+
+func (c Claim) GetId() string {
+	return c.Deployment.GetLabels()[LableUid]
+}
+
+// RunOutput keeps the port bindings at the top level of the response as older clients decode it into a
+// plain map. Additional fields are only added if the caller requested them.
+type RunOutput struct {
+	Bindings    map[string]string
+	Credentials *Credentials
+	Hostname    string
+	Aliases     map[string]string
+	PodBindings map[string][]string
+	Connection  map[string]string
+	Nodes       []string
+	ClaimId     string
+	Status      string
+}
+
+func (o RunOutput) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]any, len(o.Bindings)+6)
+
+	for name, address := range o.Bindings {
+		fields[name] = address
+	}
+
+	if o.Credentials != nil {
+		fields["credentials"] = o.Credentials
+	}
+
+	if o.Hostname != "" {
+		fields["hostname"] = o.Hostname
+	}
+
+	if len(o.Aliases) > 0 {
+		fields["aliases"] = o.Aliases
+	}
+
+	if o.PodBindings != nil {
+		fields["pod_bindings"] = o.PodBindings
+	}
+
+	if o.Connection != nil {
+		fields["connection"] = o.Connection
+	}
+
+	if o.Nodes != nil {
+		fields["nodes"] = o.Nodes
+	}
+
+	if o.ClaimId != "" {
+		fields["claim_id"] = o.ClaimId
+	}
+
+	if o.Status != "" {
+		fields["status"] = o.Status
+	}
+
+	return json.Marshal(fields)
+}
+
+// CiMetadata describes the CI job which claimed a component, so leftover containers can be traced back to it.
+type CiMetadata struct {
+	PipelineId string `json:"pipeline_id,omitempty"`
+	JobUrl     string `json:"job_url,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+}
+
+func (m *CiMetadata) GetAnnotations() map[string]string {
+	annotations := map[string]string{}
+
+	if m == nil {
+		return annotations
+	}
+
+	for key, value := range map[string]string{
+		AnnotationCiPipelineId: m.PipelineId,
+		AnnotationCiJobUrl:     m.JobUrl,
+		AnnotationCiBranch:     m.Branch,
+		AnnotationCiCommit:     m.Commit,
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+
+	return annotations
+}
+
+// CiMetadataFromAnnotations reads the CI metadata of a claimed object and returns nil if there is none.
+func CiMetadataFromAnnotations(object AnnotationsAware) *CiMetadata {
+	annotations := object.GetAnnotations()
+	metadata := &CiMetadata{
+		PipelineId: annotations[AnnotationCiPipelineId],
+		JobUrl:     annotations[AnnotationCiJobUrl],
+		Branch:     annotations[AnnotationCiBranch],
+		Commit:     annotations[AnnotationCiCommit],
+	}
+
+	if *metadata == (CiMetadata{}) {
+		return nil
+	}
+
+	return metadata
+}
+
 type ExtendInput struct {
 	PoolId   string        `json:"pool_id"`
 	TestId   string        `json:"test_id"`
 	Duration time.Duration `json:"duration"`
+	PoolKey  string        `header:"X-Pool-Key" json:"-"`
 }
 
 func (i ExtendInput) GetLabels() map[string]string {
@@ -120,8 +303,11 @@ func (i ExtendInput) GetLabels() map[string]string {
 }
 
 type StopInput struct {
-	PoolId string `json:"pool_id"`
-	TestId string `json:"test_id"`
+	PoolId  string `json:"pool_id"`
+	TestId  string `json:"test_id"`
+	PoolKey string `header:"X-Pool-Key" json:"-"`
+
+	legacyFields []string
 }
 
 func (i StopInput) GetLabels() map[string]string {
@@ -131,12 +317,108 @@ func (i StopInput) GetLabels() map[string]string {
 	}
 }
 
+// ContainerSpec describes the main container of a deployment. Its restart policy is either Always or Never. The
+// pods of a deployment are always restarted by kubernetes, so Never doesn't reach the pod: like a restart limit of
+// 0, it marks the claim as failed on the first restart.
 type ContainerSpec struct {
+	Repository    string                 `json:"repository"`
+	Tag           string                 `json:"tag"`
+	Env           map[string]string      `json:"env"`
+	Cmd           []string               `json:"cmd"`
+	PortBindings  map[string]PortBinding `json:"port_bindings"`
+	RestartPolicy string                 `json:"restart_policy"`
+	RestartLimit  *int                   `json:"restart_limit"`
+	Lifecycle     *LifecycleHooks        `json:"lifecycle"`
+	Tls           *TlsSpec               `json:"tls"`
+	Localstack    *LocalstackSpec        `json:"localstack"`
+	Wiremock      *WiremockSpec          `json:"wiremock"`
+	RedisCluster  *RedisClusterSpec      `json:"redis_cluster"`
+	Dependencies  []DependencySpec       `json:"dependencies"`
+	Headless      bool                   `json:"headless"`
+	Resources     *ResourceSpec          `json:"resources"`
+	HostNetwork   bool                   `json:"host_network"`
+	// SecretEnv holds env variables which are stored in a secret owned by the deployment instead of its manifest,
+	// e.g. generated credentials. It is set by kubrun only and never part of a request.
+	SecretEnv map[string]string `json:"-"`
+}
+
+// NeedsDedicatedDeployment reports whether the spec carries request specific content which a warm deployment
+// can't have, so the claim has to spawn its own deployment.
+func (s ContainerSpec) NeedsDedicatedDeployment() bool {
+	if s.Localstack != nil && (s.Localstack.Persistence || len(s.Localstack.InitScripts) > 0) {
+		return true
+	}
+
+	return s.Wiremock != nil && len(s.Wiremock.Mappings) > 0
+}
+
+// GetRestartLimit returns how many container restarts are tolerated before a claim is marked as failed.
+// A restart policy of Never doesn't tolerate any restart, otherwise the explicit limit is used if set.
+func (s ContainerSpec) GetRestartLimit() (int, bool) {
+	if s.RestartPolicy == RestartPolicyNever {
+		return 0, true
+	}
+
+	if s.RestartLimit != nil {
+		return *s.RestartLimit, true
+	}
+
+	return 0, false
+}
+
+// LifecycleHooks contains commands which are executed inside the container right after it was started
+// (e.g. creating buckets) or right before it is stopped (e.g. flushing data).
+type LifecycleHooks struct {
+	PostStart []string `json:"post_start"`
+	PreStop   []string `json:"pre_stop"`
+}
+
+// TlsSpec requests a cert-manager certificate for the service of the component, which is mounted
+// as tls.crt, tls.key and ca.crt into the mount path of the container.
+type TlsSpec struct {
+	Enabled   bool   `json:"enabled"`
+	MountPath string `json:"mount_path"`
+}
+
+// LocalstackSpec enables the persistence of localstack and injects the init scripts into
+// /etc/localstack/init/ready.d, so queues, tables and buckets exist before the test calls localstack the
+// first time. The keys of the scripts are their file names, e.g. "01-queues.sh".
+type LocalstackSpec struct {
+	Persistence bool              `json:"persistence"`
+	InitScripts map[string]string `json:"init_scripts"`
+}
+
+// WiremockSpec preloads stub mappings into /home/wiremock/mappings, so the mock is configured the moment it
+// is claimed. The keys of the mappings are their file names, e.g. "users.json", the values the mapping json.
+type WiremockSpec struct {
+	Mappings map[string]string `json:"mappings"`
+}
+
+// RedisClusterSpec spawns the nodes of a redis cluster in a stateful set next to the deployment, which only
+// bootstraps the cluster and is ready once the cluster state is ok.
+type RedisClusterSpec struct {
+	Nodes int `json:"nodes"`
+}
+
+// DependencySpec is an additional pod a component needs, e.g. the postgres of a keycloak. Dependencies are
+// owned by the deployment of the component and expire together with it. The component only starts once all
+// of its dependencies accept connections, a dependency once the dependencies it depends on do.
+type DependencySpec struct {
+	Name         string                 `json:"name"`
 	Repository   string                 `json:"repository"`
 	Tag          string                 `json:"tag"`
 	Env          map[string]string      `json:"env"`
 	Cmd          []string               `json:"cmd"`
 	PortBindings map[string]PortBinding `json:"port_bindings"`
+	DependsOn    []string               `json:"depends_on"`
+}
+
+// ResourceSpec overrides the default cpu and memory requests of the container, e.g. "500m" and "2Gi".
+type ResourceSpec struct {
+	Cpu    string `json:"cpu"`
+	Memory string `json:"memory"`
+	// MemoryLimit caps the memory of the container, the memory isn't limited if it is empty.
+	MemoryLimit string `json:"memory_limit"`
 }
 
 type PortBinding struct {
@@ -148,3 +430,16 @@ type PortBinding struct {
 type AnnotationsAware interface {
 	GetAnnotations() map[string]string
 }
+
+// TraceIdFromContext returns the id of the trace the request belongs to or an empty string if it isn't traced.
+func TraceIdFromContext(ctx context.Context) string {
+	if trace := tracing.GetTraceFromContext(ctx); trace != nil {
+		return trace.GetTraceId()
+	}
+
+	if span := tracing.GetSpanFromContext(ctx); span != nil && span.GetTrace() != nil {
+		return span.GetTrace().GetTraceId()
+	}
+
+	return ""
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const unassignedTeam = "unassigned"
+
+// UsageReportSettings control the lookback of the reports. The history is read ClaimLookback further back than
+// the report, so claims which started before the report but were still running within it are counted as well.
+type UsageReportSettings struct {
+	DefaultLookback time.Duration `cfg:"default_lookback" default:"168h"`
+	ClaimLookback   time.Duration `cfg:"claim_lookback" default:"48h"`
+}
+
+type TeamReportInput struct {
+	Lookback time.Duration `form:"lookback"`
+}
+
+type TeamUsage struct {
+	Team            string  `json:"team"`
+	Claims          int     `json:"claims"`
+	ContainerHours  float64 `json:"container_hours"`
+	PeakConcurrency int     `json:"peak_concurrency"`
+}
+
+type TeamUsageReport struct {
+	Since time.Time    `json:"since"`
+	Until time.Time    `json:"until"`
+	Teams []*TeamUsage `json:"teams"`
+}
+
+type claimUsage struct {
+	team  string
+	start time.Time
+	end   time.Time
+}
+
+// BuildTeamUsageReport pairs every claim in the history with the release or expiry of its deployment and
+// aggregates the resulting usage per team. Claims which did not end yet are counted until the end of the report,
+// claims which started before the report are counted from its start and claims which ended before it are skipped.
+func BuildTeamUsageReport(records []HistoryRecord, since time.Time, until time.Time) *TeamUsageReport {
+	claims := map[string]*claimUsage{}
+
+	for _, record := range records {
+		if record.ClaimId == "" {
+			continue
+		}
+
+		switch record.Event {
+		case HistoryEventClaim:
+			team := record.Team
+			if team == "" {
+				team = unassignedTeam
+			}
+
+			claims[record.ClaimId] = &claimUsage{
+				team:  team,
+				start: record.CreatedAt,
+				end:   until,
+			}
+		case HistoryEventRelease, HistoryEventExpire:
+			if usage, ok := claims[record.ClaimId]; ok && record.CreatedAt.Before(usage.end) {
+				usage.end = record.CreatedAt
+			}
+		}
+	}
+
+	usages := map[string][]*claimUsage{}
+	for _, usage := range claims {
+		if !usage.end.After(since) {
+			continue
+		}
+
+		if usage.start.Before(since) {
+			usage.start = since
+		}
+
+		usages[usage.team] = append(usages[usage.team], usage)
+	}
+
+	report := &TeamUsageReport{
+		Since: since,
+		Until: until,
+		Teams: make([]*TeamUsage, 0, len(usages)),
+	}
+
+	for team, teamUsages := range usages {
+		teamUsage := &TeamUsage{
+			Team:            team,
+			Claims:          len(teamUsages),
+			PeakConcurrency: peakConcurrency(teamUsages),
+		}
+
+		for _, usage := range teamUsages {
+			teamUsage.ContainerHours += usage.end.Sub(usage.start).Hours()
+		}
+
+		report.Teams = append(report.Teams, teamUsage)
+	}
+
+	sort.Slice(report.Teams, func(i, j int) bool {
+		return report.Teams[i].Team < report.Teams[j].Team
+	})
+
+	return report
+}
+
+// peakConcurrency returns the highest number of claims which were active at the same time.
+func peakConcurrency(usages []*claimUsage) int {
+	type change struct {
+		at    time.Time
+		delta int
+	}
+
+	changes := make([]change, 0, len(usages)*2)
+	for _, usage := range usages {
+		changes = append(changes, change{at: usage.start, delta: 1}, change{at: usage.end, delta: -1})
+	}
+
+	// a claim ending at the same time another one starts does not overlap with it
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].at.Equal(changes[j].at) {
+			return changes[i].delta < changes[j].delta
+		}
+
+		return changes[i].at.Before(changes[j].at)
+	})
+
+	current, peak := 0, 0
+	for _, c := range changes {
+		current += c.delta
+		peak = max(peak, current)
+	}
+
+	return peak
+}
+
+type OomKillUsage struct {
+	ComponentType string `json:"component_type"`
+	Claims        int    `json:"claims"`
+	OomKills      int    `json:"oom_kills"`
+	Retries       int    `json:"retries"`
+}
+
+type OomKillReport struct {
+	Since      time.Time       `json:"since"`
+	Until      time.Time       `json:"until"`
+	Components []*OomKillUsage `json:"components"`
+}
+
+// BuildOomKillReport counts the OOM kills and the retries with more memory per component type, next to the
+// number of claims, so component types whose default memory is too low stand out.
+func BuildOomKillReport(records []HistoryRecord, since time.Time, until time.Time) *OomKillReport {
+	components := map[string]*OomKillUsage{}
+
+	for _, record := range records {
+		if record.ComponentType == "" {
+			continue
+		}
+
+		usage, ok := components[record.ComponentType]
+		if !ok {
+			usage = &OomKillUsage{ComponentType: record.ComponentType}
+			components[record.ComponentType] = usage
+		}
+
+		switch record.Event {
+		case HistoryEventClaim:
+			usage.Claims++
+		case HistoryEventOomKill:
+			usage.OomKills++
+		case HistoryEventOomRetry:
+			usage.Retries++
+		}
+	}
+
+	report := &OomKillReport{
+		Since:      since,
+		Until:      until,
+		Components: make([]*OomKillUsage, 0, len(components)),
+	}
+
+	for _, usage := range components {
+		if usage.OomKills == 0 {
+			continue
+		}
+
+		report.Components = append(report.Components, usage)
+	}
+
+	sort.Slice(report.Components, func(i, j int) bool {
+		return report.Components[i].ComponentType < report.Components[j].ComponentType
+	})
+
+	return report
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/funk"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// maxLabelLength is the maximum length of a kubernetes label value and of a service name.
+const maxLabelLength = 63
+
+// a redis cluster needs at least three master nodes, more than nine only slow down the tests
+const (
+	minRedisClusterNodes = 3
+	maxRedisClusterNodes = 9
+)
+
+// uidPlaceholder has the length of the uuids used for the names of spawned deployments and services.
+const uidPlaceholder = "00000000-0000-0000-0000-000000000000"
+
+var dnsLabelRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// imageTagRegex matches the tags allowed by the docker registry api.
+var imageTagRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+
+type ValidationSettings struct {
+	MinExpireAfter time.Duration `cfg:"min_expire_after" default:"1m"`
+	MaxExpireAfter time.Duration `cfg:"max_expire_after" default:"24h"`
+	MaxWarmUpCount int           `cfg:"max_warm_up_count" default:"100"`
+}
+
+// ValidationError lists all problems of an invalid input at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid input: %s", strings.Join(e.Problems, "; "))
+}
+
+type InputValidator struct {
+	settings    *ValidationSettings
+	specs       *SpecRegistry
+	hostNetwork *HostNetworkSettings
+}
+
+func NewInputValidator(config cfg.Config) (*InputValidator, error) {
+	var err error
+	var specs *SpecRegistry
+	var hostNetwork *HostNetworkSettings
+
+	settings := &ValidationSettings{}
+	if err = config.UnmarshalKey("validation", settings); err != nil {
+		return nil, fmt.Errorf("could not unmarshal validation settings: %w", err)
+	}
+
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return nil, fmt.Errorf("could not create spec registry: %w", err)
+	}
+
+	if hostNetwork, err = ReadHostNetworkSettings(config); err != nil {
+		return nil, err
+	}
+
+	return &InputValidator{
+		settings:    settings,
+		specs:       specs,
+		hostNetwork: hostNetwork,
+	}, nil
+}
+
+// ValidateRun returns a *ValidationError if the input would fail later on inside the kubernetes calls.
+func (v *InputValidator) ValidateRun(input *RunInput) error {
+	problems := make([]string, 0)
+
+	problems = appendLabelProblems(problems, "pool_id", input.PoolId, true)
+	problems = appendLabelProblems(problems, "test_id", input.TestId, true)
+	problems = appendLabelProblems(problems, "component_type", input.ComponentType, true)
+	problems = appendLabelProblems(problems, "component_name", input.ComponentName, false)
+	problems = appendLabelProblems(problems, "team", input.Team, false)
+
+	if _, ok := v.specs.Get(input.ComponentType); !ok && input.ComponentType != "" && input.Spec.Repository == "" {
+		problems = append(problems, fmt.Sprintf("component_type %q is unknown and no spec repository is given", input.ComponentType))
+	}
+
+	if input.ContainerName == "" {
+		problems = append(problems, "container_name must not be empty")
+	} else if !dnsLabelRegex.MatchString(input.ContainerName) {
+		problems = append(problems, fmt.Sprintf("container_name %q must consist of lower case alphanumeric characters or '-'", input.ContainerName))
+	}
+
+	if name := K8sNameString("tc", uidPlaceholder, v.specs.Resolve(input.ComponentType), input.ContainerName); len(name) > maxLabelLength {
+		problems = append(problems, fmt.Sprintf("component_type and container_name are %d characters too long to build a valid service name", len(name)-maxLabelLength))
+	}
+
+	// a missing expire_after is replaced by the default ttl of the retention policy
+	if input.ExpireAfter != 0 && (input.ExpireAfter < v.settings.MinExpireAfter || input.ExpireAfter > v.settings.MaxExpireAfter) {
+		problems = append(problems, fmt.Sprintf("expire_after has to be between %s and %s but is %s", v.settings.MinExpireAfter, v.settings.MaxExpireAfter, input.ExpireAfter))
+	}
+
+	if input.Wait < 0 {
+		problems = append(problems, "wait must not be negative")
+	}
+
+	if input.Hold < 0 {
+		problems = append(problems, "hold must not be negative")
+	}
+
+	problems = v.appendSpecProblems(problems, input.ComponentType, input.Spec)
+
+	for i, alias := range input.Aliases {
+		if !dnsLabelRegex.MatchString(alias) || len(AliasName(alias, input.TestId)) > maxLabelLength {
+			problems = append(problems, fmt.Sprintf("alias %q has to be a valid dns label of at most %d characters", alias, maxLabelLength-aliasHashLength-1))
+		}
+
+		if slices.Contains(input.Aliases[:i], alias) {
+			problems = append(problems, fmt.Sprintf("alias %q is given more than once", alias))
+		}
+	}
+
+	return newValidationError(problems)
+}
+
+// appendSpecProblems checks the parts of a spec which a claim or a pin can carry.
+func (v *InputValidator) appendSpecProblems(problems []string, componentType string, spec ContainerSpec) []string {
+	// deployments only support restarting their pods, a policy of Never is enforced by the crash detector instead
+	if !slices.Contains(validRestartPolicies, spec.RestartPolicy) {
+		problems = append(problems, fmt.Sprintf("restart_policy has to be one of %s or %s but is %q", RestartPolicyAlways, RestartPolicyNever, spec.RestartPolicy))
+	}
+
+	if spec.RestartLimit != nil && *spec.RestartLimit < 0 {
+		problems = append(problems, "restart_limit must not be negative")
+	}
+
+	if spec.Localstack != nil {
+		problems = appendLocalstackProblems(problems, v.specs.Resolve(componentType), spec.Localstack)
+	}
+
+	if spec.RedisCluster != nil {
+		problems = appendRedisClusterProblems(problems, v.specs.Resolve(componentType), spec.RedisCluster)
+	}
+
+	problems = appendDependencyProblems(problems, spec.Dependencies)
+
+	if spec.UsesHostNetwork() && !v.hostNetwork.Allows(v.specs.Resolve(componentType)) {
+		problems = append(problems, fmt.Sprintf("component_type %q is not allowed to use the host network or host ports", componentType))
+	}
+
+	if spec.Wiremock != nil {
+		problems = appendWiremockProblems(problems, v.specs.Resolve(componentType), spec.Wiremock)
+	}
+
+	return problems
+}
+
+// ValidateWarmUp returns a *ValidationError if the pool id is invalid or a component can't be warmed up.
+func (v *InputValidator) ValidateWarmUp(input *WarmUpInput) error {
+	problems := make([]string, 0)
+	problems = v.appendWarmUpProblems(problems, input)
+
+	return newValidationError(problems)
+}
+
+// ValidateImport returns a *ValidationError if the warm targets can't be warmed up or a pinned spec couldn't be
+// spawned, so a broken export doesn't replace the specs of the pool.
+func (v *InputValidator) ValidateImport(input *PoolExport) error {
+	problems := make([]string, 0)
+	problems = v.appendWarmUpProblems(problems, &WarmUpInput{
+		PoolId:     input.PoolId,
+		Components: input.WarmTargets,
+	})
+
+	for componentType, spec := range input.SpecPins {
+		pinProblems := make([]string, 0)
+		pinProblems = appendLabelProblems(pinProblems, "component_type", componentType, true)
+
+		if spec.Repository == "" || spec.Tag == "" {
+			pinProblems = append(pinProblems, "repository and tag must not be empty")
+		}
+
+		pinProblems = v.appendSpecProblems(pinProblems, componentType, spec)
+
+		for _, problem := range pinProblems {
+			problems = append(problems, fmt.Sprintf("spec_pins[%s]: %s", componentType, problem))
+		}
+	}
+
+	return newValidationError(problems)
+}
+
+func (v *InputValidator) appendWarmUpProblems(problems []string, input *WarmUpInput) []string {
+	problems = appendLabelProblems(problems, "pool_id", input.PoolId, true)
+
+	for componentType, count := range input.Components {
+		if _, ok := v.specs.Get(componentType); !ok {
+			problems = append(problems, fmt.Sprintf("there is no spec for component type %q", componentType))
+		}
+
+		if count < 0 || count > v.settings.MaxWarmUpCount {
+			problems = append(problems, fmt.Sprintf("count of component type %q has to be between 0 and %d but is %d", componentType, v.settings.MaxWarmUpCount, count))
+		}
+	}
+
+	return problems
+}
+
+// ValidateTransfer returns a *ValidationError if the claim can't be handed over to the test.
+func (v *InputValidator) ValidateTransfer(input *TransferInput) error {
+	problems := make([]string, 0)
+
+	problems = appendLabelProblems(problems, "test_id", input.TestId, true)
+	problems = appendLabelProblems(problems, "team", input.Team, false)
+
+	return newValidationError(problems)
+}
+
+// ValidateSession returns a *ValidationError if the session would outlive the longest allowed claim.
+func (v *InputValidator) ValidateSession(input *CreateSessionInput) error {
+	problems := make([]string, 0)
+	problems = appendLabelProblems(problems, "pool_id", input.PoolId, true)
+
+	if input.Ttl < 0 || input.Ttl > v.settings.MaxExpireAfter {
+		problems = append(problems, fmt.Sprintf("ttl has to be between 0 and %s but is %s", v.settings.MaxExpireAfter, input.Ttl))
+	}
+
+	if input.HeartbeatTimeout < 0 {
+		problems = append(problems, "heartbeat_timeout must not be negative")
+	}
+
+	return newValidationError(problems)
+}
+
+// ValidateExtendSession returns a *ValidationError if the duration isn't a valid expiry of a claim.
+func (v *InputValidator) ValidateExtendSession(input *ExtendSessionInput) error {
+	problems := make([]string, 0)
+
+	if input.Duration < v.settings.MinExpireAfter || input.Duration > v.settings.MaxExpireAfter {
+		problems = append(problems, fmt.Sprintf("duration has to be between %s and %s but is %s", v.settings.MinExpireAfter, v.settings.MaxExpireAfter, input.Duration))
+	}
+
+	return newValidationError(problems)
+}
+
+// ValidateRollout returns a *ValidationError if the rollout can't spawn any deployment.
+func (v *InputValidator) ValidateRollout(input *RolloutInput) error {
+	problems := make([]string, 0)
+
+	problems = appendLabelProblems(problems, "pool_id", input.PoolId, true)
+	problems = appendLabelProblems(problems, "component_type", input.ComponentType, true)
+
+	if _, ok := v.specs.Get(input.ComponentType); !ok && input.ComponentType != "" && (input.Spec == nil || input.Spec.Repository == "") {
+		problems = append(problems, fmt.Sprintf("component_type %q is unknown and no spec repository is given", input.ComponentType))
+	}
+
+	if input.Count < 1 || input.Count > v.settings.MaxWarmUpCount {
+		problems = append(problems, fmt.Sprintf("count has to be between 1 and %d but is %d", v.settings.MaxWarmUpCount, input.Count))
+	}
+
+	return newValidationError(problems)
+}
+
+func appendLabelProblems(problems []string, field string, value string, required bool) []string {
+	if value == "" && required {
+		return append(problems, fmt.Sprintf("%s must not be empty", field))
+	}
+
+	if length := len(K8sNameString(value)); length > maxLabelLength {
+		return append(problems, fmt.Sprintf("%s must not be longer than %d characters but is %d", field, maxLabelLength, length))
+	}
+
+	// the value is stored as label in its k8s name form, which has to start and end with an alphanumeric character
+	if errs := validation.IsValidLabelValue(K8sNameString(value)); len(errs) > 0 {
+		return append(problems, fmt.Sprintf("%s %q is no valid label value: %s", field, value, strings.Join(errs, ", ")))
+	}
+
+	return problems
+}
+
+// appendDependencyProblems makes sure every dependency gets a valid service name and only depends on
+// dependencies listed before it, which rules out circular startup orders.
+func appendDependencyProblems(problems []string, dependencies []DependencySpec) []string {
+	seen := make([]string, 0, len(dependencies))
+
+	for _, dependency := range dependencies {
+		if !dnsLabelRegex.MatchString(dependency.Name) {
+			problems = append(problems, fmt.Sprintf("dependency name %q must consist of lower case alphanumeric characters or '-'", dependency.Name))
+		} else if name := K8sNameString("tc", uidPlaceholder, dependency.Name); len(name) > maxLabelLength {
+			problems = append(problems, fmt.Sprintf("dependency name %q is %d characters too long to build a valid service name", dependency.Name, len(name)-maxLabelLength))
+		}
+
+		if slices.Contains(seen, dependency.Name) {
+			problems = append(problems, fmt.Sprintf("dependency %q is defined more than once", dependency.Name))
+		}
+
+		if dependency.Repository == "" {
+			problems = append(problems, fmt.Sprintf("dependency %q has no repository", dependency.Name))
+		}
+
+		if len(dependency.PortBindings) == 0 {
+			problems = append(problems, fmt.Sprintf("dependency %q needs a port to wait for", dependency.Name))
+		}
+
+		for _, dependsOn := range dependency.DependsOn {
+			if !slices.Contains(seen, dependsOn) {
+				problems = append(problems, fmt.Sprintf("dependency %q can only depend on dependencies defined before it but not on %q", dependency.Name, dependsOn))
+			}
+		}
+
+		seen = append(seen, dependency.Name)
+	}
+
+	return problems
+}
+
+// appendRedisClusterProblems makes sure the cluster is only spawned for the redis cluster image, which is started
+// with cluster mode enabled, and has enough nodes to form a cluster.
+func appendRedisClusterProblems(problems []string, componentType string, spec *RedisClusterSpec) []string {
+	if componentType != "redis-cluster" {
+		return append(problems, fmt.Sprintf("spec.redis_cluster is only supported for component type redis-cluster but not for %q", componentType))
+	}
+
+	if spec.Nodes < minRedisClusterNodes || spec.Nodes > maxRedisClusterNodes {
+		problems = append(problems, fmt.Sprintf("redis_cluster.nodes has to be between %d and %d but is %d", minRedisClusterNodes, maxRedisClusterNodes, spec.Nodes))
+	}
+
+	return problems
+}
+
+// appendLocalstackProblems makes sure the init scripts can be mounted from a config map and are picked up by
+// localstack, which only runs .sh and .py files.
+func appendLocalstackProblems(problems []string, componentType string, spec *LocalstackSpec) []string {
+	if componentType != "localstack" {
+		return append(problems, fmt.Sprintf("spec.localstack is only supported for component type localstack but not for %q", componentType))
+	}
+
+	for name := range spec.InitScripts {
+		if errs := validation.IsConfigMapKey(name); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("init script name %q is invalid: %s", name, strings.Join(errs, ", ")))
+		}
+
+		if !strings.HasSuffix(name, ".sh") && !strings.HasSuffix(name, ".py") {
+			problems = append(problems, fmt.Sprintf("init script %q has to end with .sh or .py", name))
+		}
+	}
+
+	return problems
+}
+
+// appendWiremockProblems makes sure the stub mappings can be mounted from a config map and are loaded by
+// wiremock, which only reads .json files and fails to start on invalid ones.
+func appendWiremockProblems(problems []string, componentType string, spec *WiremockSpec) []string {
+	if componentType != "wiremock" {
+		return append(problems, fmt.Sprintf("spec.wiremock is only supported for component type wiremock but not for %q", componentType))
+	}
+
+	for name, mapping := range spec.Mappings {
+		if errs := validation.IsConfigMapKey(name); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("mapping name %q is invalid: %s", name, strings.Join(errs, ", ")))
+		}
+
+		if !strings.HasSuffix(name, ".json") {
+			problems = append(problems, fmt.Sprintf("mapping %q has to end with .json", name))
+		}
+
+		if !json.Valid([]byte(mapping)) {
+			problems = append(problems, fmt.Sprintf("mapping %q is no valid json", name))
+		}
+	}
+
+	return problems
+}
+
+func newValidationError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{
+		Problems: problems,
+	}
+}
+
+// ValidateUpdateEnv returns a *ValidationError if the env variables can't be set on a container.
+func (v *InputValidator) ValidateUpdateEnv(input *UpdateEnvInput) error {
+	problems := make([]string, 0)
+
+	if len(input.Env) == 0 && len(input.Remove) == 0 {
+		problems = append(problems, "neither env nor remove is given")
+	}
+
+	names := append(funk.Keys(input.Env), input.Remove...)
+	sort.Strings(names)
+
+	for _, name := range names {
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("env variable %q is invalid: %s", name, strings.Join(errs, ", ")))
+		}
+	}
+
+	if input.Wait < 0 {
+		problems = append(problems, "wait must not be negative")
+	}
+
+	return newValidationError(problems)
+}
+
+// ValidateUpdateImage returns a *ValidationError if the tag or repository can't form an image reference.
+func (v *InputValidator) ValidateUpdateImage(input *UpdateImageInput) error {
+	problems := make([]string, 0)
+
+	if !imageTagRegex.MatchString(input.Tag) {
+		problems = append(problems, fmt.Sprintf("tag %q is not a valid image tag", input.Tag))
+	}
+
+	if strings.ContainsAny(input.Repository, "@ ") || strings.HasSuffix(input.Repository, "/") {
+		problems = append(problems, fmt.Sprintf("repository %q is not a valid image repository", input.Repository))
+	}
+
+	if input.Wait < 0 {
+		problems = append(problems, "wait must not be negative")
+	}
+
+	return newValidationError(problems)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/justtrackio/gosoline/pkg/appctx"
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/clock"
+	"github.com/justtrackio/gosoline/pkg/kernel"
+	"github.com/justtrackio/gosoline/pkg/log"
+	"github.com/justtrackio/gosoline/pkg/uuid"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusDone      = "done"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+type WarmUpJobSettings struct {
+	Workers   int           `cfg:"workers" default:"2"`
+	QueueSize int           `cfg:"queue_size" default:"100"`
+	Retention time.Duration `cfg:"retention" default:"1h"`
+}
+
+type JobInput struct {
+	Id      string `uri:"id"`
+	PoolKey string `header:"X-Pool-Key" json:"-"`
+}
+
+type WarmUpJob struct {
+	Id         string     `json:"id"`
+	PoolId     string     `json:"pool_id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Spawned    int        `json:"spawned"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	input  *WarmUpInput
+	cancel context.CancelFunc
+}
+
+func (j *WarmUpJob) isFinished() bool {
+	return j.Status == JobStatusDone || j.Status == JobStatusFailed || j.Status == JobStatusCancelled
+}
+
+type warmUpJobQueueKey struct{}
+
+func ProvideWarmUpJobQueue(ctx context.Context, config cfg.Config, logger log.Logger) (*WarmUpJobQueue, error) {
+	return appctx.Provide(ctx, warmUpJobQueueKey{}, func() (*WarmUpJobQueue, error) {
+		settings := &WarmUpJobSettings{}
+		if err := config.UnmarshalKey("warmup_jobs", settings); err != nil {
+			return nil, fmt.Errorf("could not unmarshal warm up job settings: %w", err)
+		}
+
+		return &WarmUpJobQueue{
+			logger:   logger.WithChannel("warmup-jobs"),
+			clock:    clock.NewRealClock(),
+			settings: settings,
+			jobs:     map[string]*WarmUpJob{},
+			queue:    make(chan *WarmUpJob, settings.QueueSize),
+		}, nil
+	})
+}
+
+// WarmUpJobQueue keeps track of warm ups which are executed in the background by the WarmUpJobModule.
+type WarmUpJobQueue struct {
+	lck      sync.RWMutex
+	logger   log.Logger
+	clock    clock.Clock
+	settings *WarmUpJobSettings
+	jobs     map[string]*WarmUpJob
+	queue    chan *WarmUpJob
+}
+
+func (q *WarmUpJobQueue) Enqueue(input *WarmUpInput) (*WarmUpJob, error) {
+	q.lck.Lock()
+	defer q.lck.Unlock()
+
+	q.prune()
+
+	job := &WarmUpJob{
+		Id:        uuid.New().NewV4(),
+		PoolId:    input.PoolId,
+		Status:    JobStatusQueued,
+		CreatedAt: q.clock.Now(),
+		input:     input,
+	}
+
+	job.Total = input.Total()
+
+	select {
+	case q.queue <- job:
+	default:
+		return nil, fmt.Errorf("the warm up job queue is full")
+	}
+
+	q.jobs[job.Id] = job
+
+	return q.copyJob(job), nil
+}
+
+func (q *WarmUpJobQueue) Get(id string) (*WarmUpJob, error) {
+	q.lck.RLock()
+	defer q.lck.RUnlock()
+
+	if job, ok := q.jobs[id]; ok {
+		return q.copyJob(job), nil
+	}
+
+	return nil, fmt.Errorf("there is no job with id %q: %w", id, ErrJobNotFound)
+}
+
+func (q *WarmUpJobQueue) Cancel(id string) (*WarmUpJob, error) {
+	q.lck.Lock()
+	defer q.lck.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("there is no job with id %q: %w", id, ErrJobNotFound)
+	}
+
+	switch {
+	case job.Status == JobStatusQueued:
+		q.finish(job, JobStatusCancelled, nil)
+	case job.Status == JobStatusRunning && job.cancel != nil:
+		job.cancel()
+	}
+
+	return q.copyJob(job), nil
+}
+
+func (q *WarmUpJobQueue) run(ctx context.Context, poolManager *ServicePoolManager, job *WarmUpJob) {
+	q.lck.Lock()
+	if job.Status != JobStatusQueued {
+		q.lck.Unlock()
+
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	job.Status = JobStatusRunning
+	job.cancel = cancel
+	q.lck.Unlock()
+
+	err := poolManager.WarmUpPool(jobCtx, job.input, func() {
+		q.lck.Lock()
+		defer q.lck.Unlock()
+
+		job.Spawned++
+	})
+
+	q.lck.Lock()
+	defer q.lck.Unlock()
+
+	switch {
+	case err != nil && jobCtx.Err() != nil && ctx.Err() == nil:
+		q.finish(job, JobStatusCancelled, nil)
+	case err != nil:
+		q.finish(job, JobStatusFailed, err)
+	default:
+		q.finish(job, JobStatusDone, nil)
+	}
+
+	q.logger.Info(ctx, "warm up job %q for pool %q finished with status %q after spawning %d of %d deployments", job.Id, job.PoolId, job.Status, job.Spawned, job.Total)
+}
+
+func (q *WarmUpJobQueue) finish(job *WarmUpJob, status string, err error) {
+	now := q.clock.Now()
+
+	job.Status = status
+	job.FinishedAt = &now
+	job.cancel = nil
+
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+// prune removes finished jobs which are older than the configured retention. The lock has to be held by the caller.
+func (q *WarmUpJobQueue) prune() {
+	for id, job := range q.jobs {
+		if job.isFinished() && q.clock.Since(*job.FinishedAt) > q.settings.Retention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *WarmUpJobQueue) copyJob(job *WarmUpJob) *WarmUpJob {
+	cpy := *job
+
+	return &cpy
+}
+
+func NewWarmUpJobModule(ctx context.Context, config cfg.Config, logger log.Logger) (kernel.Module, error) {
+	var err error
+	var queue *WarmUpJobQueue
+	var poolManager *ServicePoolManager
+
+	if queue, err = ProvideWarmUpJobQueue(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create warm up job queue: %w", err)
+	}
+
+	if poolManager, err = ProvideServicePoolManager(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create service pool manager: %w", err)
+	}
+
+	return &WarmUpJobModule{
+		queue:       queue,
+		poolManager: poolManager,
+	}, nil
+}
+
+type WarmUpJobModule struct {
+	queue       *WarmUpJobQueue
+	poolManager *ServicePoolManager
+}
+
+func (m WarmUpJobModule) Run(ctx context.Context) error {
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < m.queue.settings.Workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-m.queue.queue:
+					m.queue.run(ctx, m.poolManager, job)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return nil
+}
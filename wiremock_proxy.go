@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/justtrackio/gosoline/pkg/cfg"
+	"github.com/justtrackio/gosoline/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const wiremockAdminPath = "/__admin"
+
+var ErrNoWiremock = errors.New("service is no wiremock")
+
+// WiremockProxy resolves the admin api of a claimed wiremock, so test code running outside the cluster can
+// program stubs and read the request journal through kubrun.
+type WiremockProxy struct {
+	k8sClient *K8sClient
+	specs     *SpecRegistry
+	poolKeys  *PoolKeys
+}
+
+func NewWiremockProxy(ctx context.Context, config cfg.Config, logger log.Logger) (*WiremockProxy, error) {
+	var err error
+	var k8sClient *K8sClient
+	var specs *SpecRegistry
+	var poolKeys *PoolKeys
+
+	if k8sClient, err = ProvideK8sClient(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create k8s client: %w", err)
+	}
+
+	if specs, err = NewSpecRegistry(config); err != nil {
+		return nil, fmt.Errorf("could not create spec registry: %w", err)
+	}
+
+	if poolKeys, err = ProvidePoolKeys(ctx, config, logger); err != nil {
+		return nil, fmt.Errorf("could not create pool keys: %w", err)
+	}
+
+	return &WiremockProxy{
+		k8sClient: k8sClient,
+		specs:     specs,
+		poolKeys:  poolKeys,
+	}, nil
+}
+
+// Target returns the base url of the main port of the claimed wiremock with the uid. Idle deployments aren't
+// proxied, stubs programmed into them would leak into the test claiming them later on. The key has to match the
+// one of the pool of the wiremock, otherwise ErrPoolKeyInvalid is returned.
+func (p *WiremockProxy) Target(ctx context.Context, uid string, key string) (*url.URL, error) {
+	var err error
+	var deployments []*appsv1.Deployment
+	var service *apiv1.Service
+
+	if deployments, err = p.k8sClient.ListDeployments(ctx, map[string]string{LableUid: uid}); err != nil {
+		return nil, fmt.Errorf("could not list deployments: %w", err)
+	}
+
+	if len(deployments) == 0 || deployments[0].GetLabels()[LableIdle] == "true" {
+		return nil, ErrServiceNotFound
+	}
+
+	deployment := deployments[0]
+	if err = p.poolKeys.Check(ctx, deployment.GetLabels()[LabelPoolId], key); err != nil {
+		return nil, err
+	}
+
+	if p.specs.Resolve(deployment.GetAnnotations()[AnnotationComponentType]) != "wiremock" {
+		return nil, ErrNoWiremock
+	}
+
+	if service, err = p.k8sClient.GetService(ctx, deployment.GetName()); err != nil {
+		return nil, fmt.Errorf("could not get service: %w", err)
+	}
+
+	for _, port := range service.Spec.Ports {
+		if port.Name != "main" {
+			continue
+		}
+
+		return &url.URL{
+			Scheme: "http",
+			Host:   net.JoinHostPort(fmt.Sprintf("%s.%s", service.GetName(), service.Namespace), fmt.Sprint(port.Port)),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("service %q has no main port", service.GetName())
+}